@@ -0,0 +1,92 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"math/rand"
+	"time"
+)
+
+// retryBackoff computes the delay between attempts, doubling it from `initial`
+// up to `max` and randomly spreading it by +/- `jitterFraction` of its value.
+// It is not safe for concurrent use.
+type retryBackoff struct {
+	initial        time.Duration
+	max            time.Duration
+	jitterFraction float64
+
+	current time.Duration
+
+	// random is overridden in tests, it must return a value in [0.0, 1.0)
+	random func() float64
+}
+
+func newRetryBackoff(initial, max time.Duration, jitterFraction float64) *retryBackoff {
+	if max < initial {
+		max = initial
+	}
+	if jitterFraction < 0 {
+		jitterFraction = 0
+	}
+	if jitterFraction > 1 {
+		jitterFraction = 1
+	}
+
+	return &retryBackoff{
+		initial:        initial,
+		max:            max,
+		jitterFraction: jitterFraction,
+		random:         rand.Float64,
+	}
+}
+
+// newConstantRetryBackoff always returns `delay`, without jitter
+func newConstantRetryBackoff(delay time.Duration) *retryBackoff {
+	return newRetryBackoff(delay, delay, 0)
+}
+
+// clone returns a backoff with the same policy, starting from the initial delay
+func (b *retryBackoff) clone() *retryBackoff {
+	return &retryBackoff{
+		initial:        b.initial,
+		max:            b.max,
+		jitterFraction: b.jitterFraction,
+		random:         b.random,
+	}
+}
+
+func (b *retryBackoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	}
+
+	delay := b.current
+	if b.current < b.max {
+		b.current *= 2
+		if b.current > b.max || b.current <= 0 {
+			b.current = b.max
+		}
+	}
+
+	if b.jitterFraction == 0 {
+		return delay
+	}
+	spread := (b.random()*2 - 1) * b.jitterFraction
+	return delay + time.Duration(float64(delay)*spread)
+}
+
+func (b *retryBackoff) reset() {
+	b.current = 0
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name           string
+		backoff        *retryBackoff
+		random         float64
+		expectedDelays []time.Duration
+	}{
+		{
+			name:           "constant",
+			backoff:        newConstantRetryBackoff(4 * time.Second),
+			expectedDelays: []time.Duration{4 * time.Second, 4 * time.Second, 4 * time.Second},
+		},
+		{
+			name:           "exponential up to max",
+			backoff:        newRetryBackoff(100*time.Millisecond, time.Second, 0),
+			expectedDelays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name:           "max lower than initial",
+			backoff:        newRetryBackoff(time.Second, time.Millisecond, 0),
+			expectedDelays: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:           "lowest jitter",
+			backoff:        newRetryBackoff(time.Second, 4*time.Second, 0.25),
+			random:         0,
+			expectedDelays: []time.Duration{750 * time.Millisecond, 1500 * time.Millisecond, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:           "upper jitter",
+			backoff:        newRetryBackoff(time.Second, 4*time.Second, 0.25),
+			random:         0.75,
+			expectedDelays: []time.Duration{1125 * time.Millisecond, 2250 * time.Millisecond, 4500 * time.Millisecond, 4500 * time.Millisecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.backoff.random = func() float64 { return test.random }
+
+			var delays []time.Duration
+			for range test.expectedDelays {
+				delays = append(delays, test.backoff.next())
+			}
+			assert.Equal(t, test.expectedDelays, delays)
+
+			test.backoff.reset()
+			assert.Equal(t, test.expectedDelays[0], test.backoff.next(), "reset goes back to the initial delay")
+		})
+	}
+}
@@ -8,7 +8,13 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// ToProtocol decodes the payload of `blk`, it panics when it cannot, for a
+// header only block for example (see FileSourceWithHeaderOnly)
 func ToProtocol[B proto.Message](blk *pbbstream.Block) B {
+	if blk.IsHeaderOnly() {
+		panic(fmt.Errorf("unable to unmarshal block %s payload: %w", blk.AsRef(), pbbstream.ErrHeaderOnly))
+	}
+
 	var b B
 	value := reflect.New(reflect.TypeOf(b).Elem()).Interface().(B)
 	if err := blk.Payload.UnmarshalTo(value); err != nil {
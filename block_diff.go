@@ -0,0 +1,91 @@
+package bstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// DiffBlocks returns the differences between the fields of `a` and `b`, one
+// "field: a value != b value" line per field, nil when they are equal. The
+// payloads are compared by type, length and SHA-256 of their bytes, without
+// being decoded, so that it works for the blocks of any protocol. The metadata
+// of the blocks is compared too.
+func DiffBlocks(a, b *pbbstream.Block) []string {
+	if a == nil || b == nil {
+		if a == b {
+			return nil
+		}
+		return []string{fmt.Sprintf("block: %s != %s", diffBlockRef(a), diffBlockRef(b))}
+	}
+
+	var out []string
+	diff := func(field string, aValue, bValue interface{}) {
+		if aValue != bValue {
+			out = append(out, fmt.Sprintf("%s: %v != %v", field, aValue, bValue))
+		}
+	}
+
+	diff("number", a.Number, b.Number)
+	diff("id", fmt.Sprintf("%q", a.Id), fmt.Sprintf("%q", b.Id))
+	diff("parent_id", fmt.Sprintf("%q", a.ParentId), fmt.Sprintf("%q", b.ParentId))
+	diff("parent_num", a.ParentNum, b.ParentNum)
+	diff("lib_num", a.LibNum, b.LibNum)
+	diff("head_num", a.HeadNum, b.HeadNum)
+	diff("timestamp", diffTimestamp(a), diffTimestamp(b))
+	diff("payload_kind", a.PayloadKind, b.PayloadKind)
+	diff("payload_version", a.PayloadVersion, b.PayloadVersion)
+	diff("payload_type", fmt.Sprintf("%q", a.Payload.GetTypeUrl()), fmt.Sprintf("%q", b.Payload.GetTypeUrl()))
+	diff("payload", diffPayload(a), diffPayload(b))
+
+	aMeta, bMeta := a.Metadata(), b.Metadata()
+	keys := make(map[string]bool, len(aMeta)+len(bMeta))
+	for key := range aMeta {
+		keys[key] = true
+	}
+	for key := range bMeta {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	for _, key := range sortedKeys {
+		diff(fmt.Sprintf("meta[%q]", key), diffMeta(aMeta, key), diffMeta(bMeta, key))
+	}
+
+	return out
+}
+
+func diffBlockRef(blk *pbbstream.Block) string {
+	if blk == nil {
+		return "nil"
+	}
+	return blk.AsRef().String()
+}
+
+func diffTimestamp(blk *pbbstream.Block) string {
+	if blk.Timestamp == nil {
+		return "none"
+	}
+	return blk.Timestamp.AsTime().Format(time.RFC3339Nano)
+}
+
+func diffPayload(blk *pbbstream.Block) string {
+	payload := blk.PayloadBytes()
+	hash := sha256.Sum256(payload)
+	return fmt.Sprintf("%d bytes (sha256 %s)", len(payload), hex.EncodeToString(hash[:8]))
+}
+
+func diffMeta(meta map[string]string, key string) string {
+	value, found := meta[key]
+	if !found {
+		return "unset"
+	}
+	return fmt.Sprintf("%q", value)
+}
@@ -0,0 +1,93 @@
+package bstream
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDiffBlocks(t *testing.T) {
+	blk := TestBlockWithNumbers("05a", "04a", 5, 4)
+
+	tests := []struct {
+		name     string
+		modify   func(blk *pbbstream.Block)
+		expected []string
+	}{
+		{
+			name: "equal",
+		},
+		{
+			name: "header",
+			modify: func(blk *pbbstream.Block) {
+				blk.Id = "05b"
+				blk.ParentNum = 3
+				blk.LibNum = 2
+			},
+			expected: []string{`id: "05a" != "05b"`, "parent_num: 4 != 3", "lib_num: 0 != 2"},
+		},
+		{
+			name: "timestamp",
+			modify: func(blk *pbbstream.Block) {
+				blk.Timestamp = timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+			},
+			expected: []string{"timestamp: 0001-01-01T00:00:00Z != 2024-01-02T03:04:05Z"},
+		},
+		{
+			name: "payload of the same length",
+			modify: func(blk *pbbstream.Block) {
+				blk.Payload.Value[0] ^= 0x01
+			},
+			expected: []string{"payload: 49 bytes (sha256 ae202d3f856e1235) != 49 bytes (sha256 4256ae84891c65f3)"},
+		},
+		{
+			name: "metadata",
+			modify: func(blk *pbbstream.Block) {
+				blk.SetMeta("peer", "10.0.0.2")
+			},
+			expected: []string{`meta["peer"]: unset != "10.0.0.2"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other := blk.Clone()
+			if test.modify != nil {
+				test.modify(other)
+			}
+			assert.Equal(t, test.expected, DiffBlocks(blk, other))
+		})
+	}
+
+	assert.Nil(t, DiffBlocks(nil, nil))
+	assert.Equal(t, []string{"block: #5 (05a) != nil"}, DiffBlocks(blk, nil))
+}
+
+// recordingTB records the errors of a test instead of failing it
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertBlocksEqual(t *testing.T) {
+	blk := TestBlockWithNumbers("05a", "04a", 5, 4)
+	other := blk.Clone()
+	other.Payload.Value = append(other.Payload.Value, ' ')
+
+	recorder := &recordingTB{TB: t}
+	AssertBlocksEqual(recorder, blk, blk.Clone())
+	assert.Empty(t, recorder.errors)
+
+	AssertBlocksEqual(recorder, blk, other)
+	assert.Equal(t, []string{"blocks differ (want != got):\n  payload: 49 bytes (sha256 ae202d3f856e1235) != 50 bytes (sha256 5ece7bae4b89623b)"}, recorder.errors)
+}
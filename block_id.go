@@ -0,0 +1,62 @@
+package bstream
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// ErrInvalidBlockID is wrapped by the errors of CheckBlockID
+var ErrInvalidBlockID = errors.New("invalid block ID")
+
+// BlockIDValidator returns an error when `id` is not a valid block ID of the
+// chain, see ValidateBlockID
+type BlockIDValidator func(id string) error
+
+// starknetFeltPrime bounds the Starknet field elements, 2^251 + 17*2^192 + 1
+var starknetFeltPrime = new(big.Int).Add(
+	new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 251), new(big.Int).Lsh(big.NewInt(17), 192)),
+	big.NewInt(1),
+)
+
+// StarknetBlockIDValidator accepts the Starknet block hashes, field elements
+// written in hexadecimal with the 0x prefix, leading zeros being optional
+func StarknetBlockIDValidator(id string) error {
+	if len(id) < 3 || id[:2] != "0x" {
+		return fmt.Errorf("expected a 0x prefixed hexadecimal hash")
+	}
+	digits := id[2:]
+	if len(digits) > 64 {
+		return fmt.Errorf("expected at most 64 hexadecimal digits, got %d", len(digits))
+	}
+	for i := 0; i < len(digits); i++ {
+		if !isHexDigit(digits[i]) {
+			return fmt.Errorf("invalid hexadecimal digit %q", digits[i])
+		}
+	}
+
+	value, _ := new(big.Int).SetString(digits, 16)
+	if value.Cmp(starknetFeltPrime) >= 0 {
+		return fmt.Errorf("hash is not a field element, it is above the field prime")
+	}
+	return nil
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// CheckBlockID validates the ID of `blk` with ValidateBlockID, it always
+// passes when ValidateBlockID is nil. The error names the block and its ID.
+func CheckBlockID(blk *pbbstream.Block) error {
+	validate := ValidateBlockID
+	if validate == nil {
+		return nil
+	}
+	if err := validate(blk.Id); err != nil {
+		return fmt.Errorf("%w %q of block #%d: %s", ErrInvalidBlockID, blk.Id, blk.Number, err)
+	}
+	return nil
+}
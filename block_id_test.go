@@ -0,0 +1,126 @@
+package bstream
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withBlockIDValidator sets ValidateBlockID until the end of the test
+func withBlockIDValidator(t *testing.T, validate BlockIDValidator) {
+	previous := ValidateBlockID
+	ValidateBlockID = validate
+	t.Cleanup(func() { ValidateBlockID = previous })
+}
+
+func TestStarknetBlockIDValidator(t *testing.T) {
+	tests := []struct {
+		id          string
+		expectedErr string
+	}{
+		{id: "0x47c3637b57c2b079b93c61539950c17e868a28f46cdef28f88521067f21e943"},
+		{id: "0x047c3637b57c2b079b93c61539950c17e868a28f46cdef28f88521067f21e943"},
+		{id: "0x7E1"},
+		{id: "0x0"},
+		{id: "0x800000000000011000000000000000000000000000000000000000000000000"},
+		{id: "0x800000000000011000000000000000000000000000000000000000000000001", expectedErr: "above the field prime"},
+		{id: "0x0800000000000011000000000000000000000000000000000000000000000001", expectedErr: "above the field prime"},
+		{id: "0x" + strings.Repeat("f", 64), expectedErr: "above the field prime"},
+		{id: "0x0" + strings.Repeat("1", 64), expectedErr: "at most 64 hexadecimal digits, got 65"},
+		{id: "47c3637b57c2b079b93c61539950c17e868a28f46cdef28f88521067f21e943", expectedErr: "0x prefixed"},
+		{id: "0x", expectedErr: "0x prefixed"},
+		{id: "", expectedErr: "0x prefixed"},
+		{id: "0x47c3637g", expectedErr: `invalid hexadecimal digit 'g'`},
+		{id: "0x47c 3637", expectedErr: `invalid hexadecimal digit ' '`},
+		{id: "00000003a", expectedErr: "0x prefixed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.id, func(t *testing.T) {
+			err := StarknetBlockIDValidator(test.id)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+		})
+	}
+}
+
+func TestCheckBlockID_BlockReader(t *testing.T) {
+	withBlockIDValidator(t, StarknetBlockIDValidator)
+	content := testBlocks(
+		TestBlockWithNumbers("0x1a", "0x0a", 1, 0),
+		TestBlockWithNumbers("0x2", "0x1a", 2, 1),
+		TestBlockWithNumbers("0x3a", "0x2", 3, 2),
+	)
+
+	reader, err := NewDBinBlockReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	blk, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), blk.Number)
+
+	_, err = reader.Read()
+	require.NoError(t, err)
+
+	content = testBlocks(
+		TestBlockWithNumbers("0x1a", "0x0a", 1, 0),
+		TestBlockWithNumbers("00000002a", "0x1a", 2, 1),
+	)
+	reader, err = NewDBinBlockReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	_, err = reader.Read()
+	require.NoError(t, err)
+	_, err = reader.Read()
+	require.ErrorIs(t, err, ErrInvalidBlockID)
+	assert.Contains(t, err.Error(), `invalid block ID "00000002a" of block #2`)
+
+	t.Run("skipped", func(t *testing.T) {
+		SkipBlockIDValidation(t)
+		reader, err := NewDBinBlockReader(bytes.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2}, readBlockNums(t, reader))
+	})
+	assert.NotNil(t, ValidateBlockID, "restored after the test")
+}
+
+func TestCheckBlockID_FileSource(t *testing.T) {
+	withBlockIDValidator(t, StarknetBlockIDValidator)
+	bs := dstore.NewMockStore(nil)
+	bs.SetFile(base(0), testBlocks(
+		TestBlockWithNumbers("0x1a", "0x0a", 1, 0),
+		TestBlockWithNumbers("0x2", "0x1a", 2, 1),
+		TestBlockWithNumbers("0x3z", "0x2", 3, 2),
+	))
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithOpenRetries(3))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.ErrorIs(t, fs.Err(), ErrInvalidBlockID)
+	assert.Contains(t, fs.Err().Error(), `invalid block ID "0x3z" of block #3`)
+	assert.NotContains(t, fs.Err().Error(), "attempts", "the file is not read again")
+	assert.NotContains(t, received, uint64(3))
+}
@@ -0,0 +1,281 @@
+package bstream
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// testPayloadBlock returns a block whose payload is a block meta, sized by its ID
+func testPayloadBlock(t testing.TB, idSize int) *pbbstream.Block {
+	payload, err := anypb.New(&pbbstream.BlockMeta{Number: 10, Id: string(bytes.Repeat([]byte("a"), idSize))})
+	require.NoError(t, err)
+	return &pbbstream.Block{Number: 10, Id: "0000000aa", Payload: payload}
+}
+
+func decodeBlockMeta(calls *int32) func([]byte) (interface{}, error) {
+	return func(data []byte) (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		meta := &pbbstream.BlockMeta{}
+		if err := proto.Unmarshal(data, meta); err != nil {
+			return nil, err
+		}
+		return meta, nil
+	}
+}
+
+func TestDecodedBlock(t *testing.T) {
+	blk := pbbstream.NewDecodedBlock(testPayloadBlock(t, 8))
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decoded, err := blk.Decoded(decodeBlockMeta(&calls))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(10), decoded.(*pbbstream.BlockMeta).Number)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+
+	// another block has its own payload
+	_, err := pbbstream.NewDecodedBlock(testPayloadBlock(t, 8)).Decoded(decodeBlockMeta(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls)
+
+	blk.ReleasePayload()
+	decoded, err := blk.Decoded(decodeBlockMeta(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaa", decoded.(*pbbstream.BlockMeta).Id)
+	assert.Equal(t, int32(3), calls)
+
+	// a block which is not the start of its allocation
+	blocks := make([]pbbstream.Block, 2)
+	blocks[1].PayloadBuffer = blk.PayloadBytes()
+	decoded, err = pbbstream.NewDecodedBlock(&blocks[1]).Decoded(decodeBlockMeta(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), decoded.(*pbbstream.BlockMeta).Number)
+}
+
+func TestDecodedBlock_Literal(t *testing.T) {
+	blk := &pbbstream.DecodedBlock{Block: testPayloadBlock(t, 8)}
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			blk.Decoded(decodeBlockMeta(&calls))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls, "the concurrent first calls share the payload")
+
+	decoded, err := blk.Decoded(decodeBlockMeta(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), decoded.(*pbbstream.BlockMeta).Number)
+	assert.Equal(t, int32(1), calls)
+
+	released := &pbbstream.DecodedBlock{Block: testPayloadBlock(t, 8)}
+	released.ReleasePayload()
+	_, err = released.Decoded(decodeBlockMeta(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestDecodedBlock_Error(t *testing.T) {
+	blk := pbbstream.NewDecodedBlock(&pbbstream.Block{Number: 10, Id: "0000000aa", PayloadBuffer: []byte{0xff}})
+	assert.Equal(t, []byte{0xff}, blk.PayloadBytes())
+
+	var calls int32
+	_, err := blk.Decoded(decodeBlockMeta(&calls))
+	require.Error(t, err)
+	_, err = blk.Decoded(decodeBlockMeta(&calls))
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls, "the decoding error is memoized too")
+}
+
+// BenchmarkBlock_Payload decodes the payload of a block for each of three
+// handlers, like a fan-out of the blocks does
+func BenchmarkBlock_Payload(b *testing.B) {
+	const handlers = 3
+
+	b.Run("repeated", func(b *testing.B) {
+		blk := testPayloadBlock(b, 64*1024)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < handlers; j++ {
+				_ = ToProtocol[*pbbstream.BlockMeta](blk)
+			}
+		}
+	})
+
+	b.Run("memoized", func(b *testing.B) {
+		blk := pbbstream.NewDecodedBlock(testPayloadBlock(b, 64*1024))
+		var calls int32
+		decoder := decodeBlockMeta(&calls)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < handlers; j++ {
+				if _, err := blk.Decoded(decoder); err != nil {
+					b.Fatal(err)
+				}
+			}
+			// a new block for the next iteration
+			blk.ReleasePayload()
+		}
+	})
+}
+
+func TestBlock_AsRef(t *testing.T) {
+	blk := TestBlockWithNumbers("0000000aa", "00000009a", 10, 9)
+	ref := blk.AsRef()
+	assert.Equal(t, "0000000aa", ref.ID())
+	assert.Equal(t, uint64(10), ref.Num())
+
+	// readers fill the block after it was created
+	blk.Id = "0000000ab"
+	blk.Number = 11
+	assert.Equal(t, "0000000ab", blk.AsRef().ID())
+	assert.Equal(t, uint64(11), blk.AsRef().Num())
+	assert.Equal(t, "0000000aa", ref.ID(), "a reference already given is untouched")
+
+	assert.True(t, EqualsBlockRefs(BlockRefEmpty, (*pbbstream.Block)(nil).AsRef()))
+}
+
+func BenchmarkBlock_AsRef(b *testing.B) {
+	blk := TestBlockWithNumbers("0000000aa", "00000009a", 10, 9)
+	var ref pbbstream.BasicBlockRef
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ref = blk.AsRef()
+	}
+	if ref.Num() != 10 {
+		b.Fatal("unexpected block ref")
+	}
+}
+
+func TestBlock_Clone(t *testing.T) {
+	blk := testPayloadBlock(t, 8)
+	blk.ParentId = "00000009a"
+	blk.ParentNum = 9
+	blk.LibNum = 8
+	blk.Timestamp = TestBlockWithTimestamp("0000000aa", "00000009a", time.Unix(1000, 0)).Timestamp
+	original := proto.Clone(blk).(*pbbstream.Block)
+
+	clone := blk.Clone()
+	assert.True(t, proto.Equal(original, clone))
+
+	clone.Id = "0000000ab"
+	clone.Timestamp.Seconds = 2000
+	clone.Payload.Value[0] = 0xff
+	clone.Payload.TypeUrl = "type.googleapis.com/sf.patched.v1.Block"
+
+	assert.True(t, proto.Equal(original, blk), "the original block is untouched")
+	assert.Equal(t, original.Payload.Value, blk.PayloadBytes())
+
+	ref := blk.CloneRef()
+	assert.Nil(t, ref.Payload)
+	assert.Equal(t, blk.AsRef(), ref.AsRef())
+	assert.Equal(t, blk.PreviousRef(), ref.PreviousRef())
+	assert.Equal(t, blk.LibNum, ref.LibNum)
+	assert.Equal(t, blk.Time(), ref.Time())
+	ref.Timestamp.Seconds = 3000
+	assert.Equal(t, int64(1000), blk.Timestamp.Seconds)
+
+	assert.Nil(t, (*pbbstream.Block)(nil).Clone())
+	assert.Nil(t, (*pbbstream.Block)(nil).CloneRef())
+}
+
+func TestDBinBlockReader_ReadHeader(t *testing.T) {
+	full := testPayloadBlock(t, 1024)
+	full.ParentId = "00000009a"
+	full.ParentNum = 9
+	full.LibNum = 8
+	full.HeadNum = 12
+	full.Timestamp = TestBlockWithTimestamp("0000000aa", "00000009a", time.Unix(1000, 0)).Timestamp
+	legacy := &pbbstream.Block{Number: 11, Id: "0000000ba", ParentId: "0000000aa", PayloadKind: pbbstream.Protocol_ETH, PayloadBuffer: []byte{0x01, 0x02}}
+
+	reader, err := HeaderOnlyDBinBlockReaderFactory.New(bytes.NewReader(testBlocks(full, legacy)))
+	require.NoError(t, err)
+	blk, err := reader.Read()
+	require.NoError(t, err)
+
+	assert.True(t, blk.IsHeaderOnly())
+	assert.False(t, full.IsHeaderOnly())
+	assert.Equal(t, full.AsRef(), blk.AsRef())
+	assert.Equal(t, full.PreviousRef(), blk.PreviousRef())
+	assert.Equal(t, full.LibNum, blk.LibNum)
+	assert.Equal(t, full.Time(), blk.Time())
+	assert.Empty(t, blk.PayloadBytes())
+	header := proto.Clone(blk).(*pbbstream.Block)
+	header.Payload = nil
+	assert.True(t, proto.Equal(full.CloneRef(), header), "got %s", header)
+
+	var calls int32
+	_, err = pbbstream.NewDecodedBlock(blk).Decoded(decodeBlockMeta(&calls))
+	assert.ErrorIs(t, err, pbbstream.ErrHeaderOnly)
+	assert.Equal(t, int32(0), calls)
+	assert.PanicsWithError(t, "unable to unmarshal block #10 (0000000aa) payload: "+pbbstream.ErrHeaderOnly.Error(), func() {
+		ToProtocol[*pbbstream.BlockMeta](blk)
+	})
+
+	blk, err = reader.Read()
+	require.NoError(t, err)
+	assert.True(t, blk.IsHeaderOnly())
+	assert.Equal(t, uint64(10), blk.ParentNum, "the legacy blocks get their parent number")
+	assert.Equal(t, pbbstream.Protocol_ETH, blk.PayloadKind)
+
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBlock_Metadata(t *testing.T) {
+	blk := testPayloadBlock(t, 8)
+	_, found := blk.GetMeta("peer")
+	assert.False(t, found)
+	assert.Nil(t, blk.Metadata())
+
+	original := proto.Clone(blk).(*pbbstream.Block)
+	blk.SetMeta("peer", "10.0.0.1")
+	blk.SetMeta("receipts", "verified")
+	peer, found := blk.GetMeta("peer")
+	assert.True(t, found)
+	assert.Equal(t, "10.0.0.1", peer)
+	assert.False(t, proto.Equal(original, blk), "the metadata is encoded with the block")
+	assert.Nil(t, blk.UnknownFields(), "the metadata is not an unknown field")
+
+	clone := blk.Clone()
+	assert.Equal(t, map[string]string{"peer": "10.0.0.1", "receipts": "verified"}, clone.Metadata())
+	clone.SetMeta("peer", "10.0.0.2")
+	peer, _ = blk.GetMeta("peer")
+	assert.Equal(t, "10.0.0.1", peer, "the clone has its own metadata")
+	assert.Nil(t, blk.CloneRef().Metadata())
+
+	pool := NewBlockPool()
+	pool.Release(blk)
+	assert.Nil(t, blk.Metadata(), "dropped once released to a pool")
+
+	// held by the block itself, not beside it
+	blocks := make([]pbbstream.Block, 2)
+	blocks[1].SetMeta("peer", "10.0.0.3")
+	peer, _ = blocks[1].GetMeta("peer")
+	assert.Equal(t, "10.0.0.3", peer)
+	assert.Nil(t, blocks[0].Metadata())
+}
@@ -0,0 +1,55 @@
+package bstream
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// ErrInvalidBlockTimestamp is wrapped by the errors of CheckBlockTimestamp
+var ErrInvalidBlockTimestamp = errors.New("invalid block timestamp")
+
+// CheckBlockTimestamp returns an error when `blk` has no time (see HasTime) or
+// is timestamped more than `maxFutureSkew` after `now`, like the blocks of a
+// producer whose clock is ahead
+func CheckBlockTimestamp(blk *pbbstream.Block, now time.Time, maxFutureSkew time.Duration) error {
+	if !blk.HasTime() {
+		return fmt.Errorf("%w: block #%d has no timestamp", ErrInvalidBlockTimestamp, blk.Number)
+	}
+	if skew := -blk.Age(now); skew > maxFutureSkew {
+		return fmt.Errorf("%w: block #%d is timestamped %s, %s in the future, above the allowed %s", ErrInvalidBlockTimestamp, blk.Number, blk.Time().Format(time.RFC3339Nano), skew, maxFutureSkew)
+	}
+	return nil
+}
+
+// TimestampValidatingBlockReader fails reading the blocks rejected by
+// CheckBlockTimestamp, see FileSourceWithTimestampValidation
+type TimestampValidatingBlockReader struct {
+	BlockReader
+
+	maxFutureSkew time.Duration
+	now           func() time.Time
+}
+
+// NewTimestampValidatingBlockReader wraps `reader`, failing on the blocks
+// without timestamp or timestamped more than `maxFutureSkew` ahead of the clock
+func NewTimestampValidatingBlockReader(reader BlockReader, maxFutureSkew time.Duration) *TimestampValidatingBlockReader {
+	return &TimestampValidatingBlockReader{
+		BlockReader:   reader,
+		maxFutureSkew: maxFutureSkew,
+		now:           time.Now,
+	}
+}
+
+func (r *TimestampValidatingBlockReader) Read() (*pbbstream.Block, error) {
+	blk, err := r.BlockReader.Read()
+	if err != nil {
+		return blk, err
+	}
+	if err := CheckBlockTimestamp(blk, r.now(), r.maxFutureSkew); err != nil {
+		return nil, err
+	}
+	return blk, nil
+}
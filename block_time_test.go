@@ -0,0 +1,126 @@
+package bstream
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func timedTestBlock(num uint64, timestamp time.Time) *pbbstream.Block {
+	return TestBlockFromJSON(fmt.Sprintf(`{"id":"%02da","prev":"%02da","num":%d,"time":"%s"}`, num, num-1, num, timestamp.Format(testBlockDateLayout)))
+}
+
+func TestBlock_Age(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		timestamp   *timestamppb.Timestamp
+		expectTime  bool
+		expectedAge time.Duration
+	}{
+		{name: "no timestamp"},
+		{name: "zero time", timestamp: timestamppb.New(time.Time{})},
+		{name: "unix epoch", timestamp: timestamppb.New(time.Unix(0, 0))},
+		{name: "invalid", timestamp: &timestamppb.Timestamp{Seconds: 1, Nanos: -1}},
+		{name: "past", timestamp: timestamppb.New(now.Add(-time.Minute)), expectTime: true, expectedAge: time.Minute},
+		{name: "future", timestamp: timestamppb.New(now.Add(time.Second)), expectTime: true, expectedAge: -time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blk := &pbbstream.Block{Number: 1, Timestamp: test.timestamp}
+			assert.Equal(t, test.expectTime, blk.HasTime())
+			assert.Equal(t, test.expectedAge, blk.Age(now))
+		})
+	}
+
+	assert.False(t, (*pbbstream.Block)(nil).HasTime())
+	assert.Equal(t, time.Duration(0), (*pbbstream.Block)(nil).Age(now))
+}
+
+func TestCheckBlockTimestamp(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		blk           *pbbstream.Block
+		expectedError string
+	}{
+		{name: "zero", blk: TestBlockWithNumbers("01a", "00a", 1, 0), expectedError: "block #1 has no timestamp"},
+		{name: "past", blk: timedTestBlock(1, now.Add(-time.Hour))},
+		{name: "future within skew", blk: timedTestBlock(1, now.Add(2*time.Second))},
+		{name: "future at skew", blk: timedTestBlock(1, now.Add(5*time.Second))},
+		{name: "future above skew", blk: timedTestBlock(1, now.Add(time.Minute)), expectedError: "block #1 is timestamped 2024-01-02T03:05:05Z, 1m0s in the future, above the allowed 5s"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckBlockTimestamp(test.blk, now, 5*time.Second)
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidBlockTimestamp)
+			assert.Contains(t, err.Error(), test.expectedError)
+		})
+	}
+}
+
+func TestTimestampValidatingBlockReader(t *testing.T) {
+	now := time.Now()
+	content := testBlocks(
+		timedTestBlock(1, now.Add(-time.Minute)),
+		timedTestBlock(2, now.Add(time.Hour)),
+	)
+	dbinReader, err := NewDBinBlockReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	reader := NewTimestampValidatingBlockReader(dbinReader, time.Second)
+
+	blk, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), blk.Number)
+
+	_, err = reader.Read()
+	require.ErrorIs(t, err, ErrInvalidBlockTimestamp)
+}
+
+func TestFileSource_TimestampValidation(t *testing.T) {
+	now := time.Now()
+	bs := dstore.NewMockStore(nil)
+	bs.SetFile(base(0), testBlocks(
+		timedTestBlock(1, now.Add(-time.Minute)),
+		timedTestBlock(2, now.Add(-time.Second)),
+		TestBlockWithNumbers("03a", "02a", 3, 2),
+	))
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithTimestampValidation(time.Second), FileSourceWithOpenRetries(3))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.ErrorIs(t, fs.Err(), ErrInvalidBlockTimestamp)
+	assert.Contains(t, fs.Err().Error(), "block #3 has no timestamp")
+	assert.NotContains(t, fs.Err().Error(), "attempts", "the file is not read again")
+	assert.NotContains(t, received, uint64(3))
+}
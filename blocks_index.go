@@ -0,0 +1,199 @@
+package bstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// The index footer of a blocks file, written by NewDBinBlockWriterWithIndex,
+// follows the last block:
+//
+//	[4 zero bytes]                   an empty dbin message, the end of the blocks
+//	[uint64 num][uint64 offset] ...  per block, in file order, the offset of its message
+//	[uint32 count]                   the amount of entries
+//	[8 bytes blocksIndexMagic]
+//
+// Integers are big-endian, like the dbin message lengths.
+var blocksIndexMagic = []byte("bsidx:v1")
+
+const (
+	blocksIndexEntrySize   = 16
+	blocksIndexTrailerSize = 4 + 8
+	endOfBlocksMarkerSize  = 4
+)
+
+// errCorruptBlocksIndex is returned when the index footer of a blocks file
+// cannot be trusted, the file is then read from its first block
+var errCorruptBlocksIndex = errors.New("corrupt blocks index")
+
+type blocksIndexEntry struct {
+	num    uint64
+	offset int64
+}
+
+func encodeBlocksIndex(entries []blocksIndexEntry) []byte {
+	out := make([]byte, endOfBlocksMarkerSize, endOfBlocksMarkerSize+len(entries)*blocksIndexEntrySize+blocksIndexTrailerSize)
+	for _, entry := range entries {
+		out = binary.BigEndian.AppendUint64(out, entry.num)
+		out = binary.BigEndian.AppendUint64(out, uint64(entry.offset))
+	}
+	out = binary.BigEndian.AppendUint32(out, uint32(len(entries)))
+	return append(out, blocksIndexMagic...)
+}
+
+// readBlocksIndex reads the index footer of the blocks file of `seeker`, whose
+// blocks start at `blocksStart`. It returns a nil index without error when the
+// file has no footer, and `end`, the offset of the end of the blocks marker.
+func readBlocksIndex(seeker io.ReadSeeker, blocksStart int64) (index []blocksIndexEntry, end int64, err error) {
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size < blocksStart+endOfBlocksMarkerSize+blocksIndexTrailerSize {
+		return nil, 0, nil
+	}
+
+	trailer := make([]byte, blocksIndexTrailerSize)
+	if err := readAt(seeker, trailer, size-blocksIndexTrailerSize); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(trailer[4:], blocksIndexMagic) {
+		return nil, 0, nil
+	}
+
+	count := int64(binary.BigEndian.Uint32(trailer))
+	end = size - blocksIndexTrailerSize - count*blocksIndexEntrySize - endOfBlocksMarkerSize
+	if end < blocksStart {
+		return nil, 0, fmt.Errorf("%w: %d entries do not fit in a file of %d bytes", errCorruptBlocksIndex, count, size)
+	}
+
+	footer := make([]byte, endOfBlocksMarkerSize+count*blocksIndexEntrySize)
+	if err := readAt(seeker, footer, end); err != nil {
+		return nil, 0, err
+	}
+	if binary.BigEndian.Uint32(footer) != 0 {
+		return nil, 0, fmt.Errorf("%w: no end of blocks marker at offset %d", errCorruptBlocksIndex, end)
+	}
+
+	index = make([]blocksIndexEntry, count)
+	previousOffset := blocksStart - 1
+	for i := range index {
+		entry := footer[endOfBlocksMarkerSize+i*blocksIndexEntrySize:]
+		index[i].num = binary.BigEndian.Uint64(entry)
+		index[i].offset = int64(binary.BigEndian.Uint64(entry[8:]))
+		if index[i].offset <= previousOffset || index[i].offset >= end {
+			return nil, 0, fmt.Errorf("%w: entry %d has offset %d out of the blocks", errCorruptBlocksIndex, i, index[i].offset)
+		}
+		previousOffset = index[i].offset
+	}
+	return index, end, nil
+}
+
+// indexedOffset returns the offset of the first block at or above `fromBlockNum`
+// in file order, found from the index footer of the file. It is the end of the
+// blocks when there is none, `indexed` is false when the file has no footer.
+func indexedOffset(seeker io.ReadSeeker, blocksStart int64, fromBlockNum uint64) (offset int64, indexed bool, err error) {
+	index, end, err := readBlocksIndex(seeker, blocksStart)
+	if err != nil || index == nil {
+		return 0, false, err
+	}
+
+	for _, entry := range index {
+		if entry.num < fromBlockNum {
+			continue
+		}
+
+		// the offsets come from the file itself, the block found there must be the indexed one
+		num, err := blockNumAt(seeker, entry.offset)
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: reading block #%d at offset %d: %s", errCorruptBlocksIndex, entry.num, entry.offset, err)
+		}
+		if num != entry.num {
+			return 0, false, fmt.Errorf("%w: found block #%d at the offset of block #%d", errCorruptBlocksIndex, num, entry.num)
+		}
+		return entry.offset, true, nil
+	}
+	return end, true, nil
+}
+
+// blockNumAt decodes the number of the block whose dbin message is at `offset`
+func blockNumAt(seeker io.ReadSeeker, offset int64) (uint64, error) {
+	length := make([]byte, 4)
+	if err := readAt(seeker, length, offset); err != nil {
+		return 0, err
+	}
+	message := make([]byte, binary.BigEndian.Uint32(length))
+	if _, err := io.ReadFull(seeker, message); err != nil {
+		return 0, err
+	}
+
+	meta := new(pbbstream.BlockMeta)
+	if err := (proto.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(message, meta); err != nil {
+		return 0, err
+	}
+	return meta.Number, nil
+}
+
+func readAt(seeker io.ReadSeeker, buf []byte, offset int64) error {
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(seeker, buf)
+	return err
+}
+
+// SeekableBlockReader is a DBinBlockReader starting at a given block when its
+// blocks file is seekable and has an index footer, see NewDBinBlockWriterWithIndex.
+// Otherwise, it reads the file from its first block: the caller still has to
+// skip the blocks below the requested one.
+type SeekableBlockReader struct {
+	*DBinBlockReader
+
+	// Seeked is true when the reader starts at the first block at or above the
+	// requested one, in file order
+	Seeked bool
+	// IndexErr is why the index footer of the file was not used, it is nil when
+	// the file has none or is not seekable
+	IndexErr error
+}
+
+// NewSeekableBlockReader reads the blocks of `reader` from the first one at or
+// above `fromBlockNum` when `reader` is an io.ReadSeeker over an uncompressed
+// blocks file with an index footer, from the first block of the file otherwise.
+func NewSeekableBlockReader(reader io.Reader, fromBlockNum uint64) (*SeekableBlockReader, error) {
+	return newSeekableBlockReader(reader, fromBlockNum, nil)
+}
+
+func newSeekableBlockReader(reader io.Reader, fromBlockNum uint64, pool *BlockPool) (*SeekableBlockReader, error) {
+	dbinReader, err := NewDBinBlockReaderWithPool(reader, pool)
+	if err != nil {
+		return nil, err
+	}
+	out := &SeekableBlockReader{DBinBlockReader: dbinReader}
+
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		return out, nil
+	}
+
+	blocksStart := int64(len(dbinReader.Header.RawBytes))
+	offset, indexed, err := indexedOffset(seeker, blocksStart, fromBlockNum)
+	if err != nil {
+		out.IndexErr = err
+	}
+	if !indexed {
+		offset = blocksStart
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking blocks file to offset %d: %w", offset, err)
+	}
+	out.offset = offset
+	out.Seeked = indexed
+	return out, nil
+}
@@ -0,0 +1,192 @@
+package bstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// indexedTestBlocks writes `in` as a blocks file with an index footer
+func indexedTestBlocks(t *testing.T, in ...*pbbstream.Block) []byte {
+	buf := &bytes.Buffer{}
+	writer, err := NewDBinBlockWriterWithIndex(buf)
+	require.NoError(t, err)
+	for _, blk := range in {
+		require.NoError(t, writer.Write(blk))
+	}
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func linearTestBlocks(from, to uint64) (out []*pbbstream.Block) {
+	for num := from; num <= to; num++ {
+		out = append(out, TestBlockWithNumbers(fmt.Sprintf("%02da", num), fmt.Sprintf("%02da", num-1), num, 0))
+	}
+	return out
+}
+
+func readBlockNums(t *testing.T, reader BlockReader) (out []uint64) {
+	for {
+		blk, err := reader.Read()
+		if err == io.EOF {
+			return out
+		}
+		require.NoError(t, err)
+		out = append(out, blk.Number)
+	}
+}
+
+func TestSeekableBlockReader(t *testing.T) {
+	indexed := indexedTestBlocks(t, linearTestBlocks(1, 5)...)
+	footerStart := len(indexed) - blocksIndexTrailerSize - 5*blocksIndexEntrySize - endOfBlocksMarkerSize
+
+	withEntryOffset := func(entry int, offset uint64) []byte {
+		out := bytes.Clone(indexed)
+		binary.BigEndian.PutUint64(out[footerStart+endOfBlocksMarkerSize+entry*blocksIndexEntrySize+8:], offset)
+		return out
+	}
+	withCount := func(count uint32) []byte {
+		out := bytes.Clone(indexed)
+		binary.BigEndian.PutUint32(out[len(out)-blocksIndexTrailerSize:], count)
+		return out
+	}
+	secondBlockOffset := binary.BigEndian.Uint64(indexed[footerStart+endOfBlocksMarkerSize+blocksIndexEntrySize+8:])
+
+	tests := []struct {
+		name          string
+		reader        io.Reader
+		fromBlockNum  uint64
+		expectSeeked  bool
+		expectIndexed bool
+		expectBlocks  []uint64
+	}{
+		{
+			name:         "footer present",
+			reader:       bytes.NewReader(indexed),
+			fromBlockNum: 4,
+			expectSeeked: true,
+			expectBlocks: []uint64{4, 5},
+		},
+		{
+			name:         "footer present, from past the last block",
+			reader:       bytes.NewReader(indexed),
+			fromBlockNum: 10,
+			expectSeeked: true,
+		},
+		{
+			name:         "footer absent",
+			reader:       bytes.NewReader(testBlocks(linearTestBlocks(1, 5)...)),
+			fromBlockNum: 4,
+			expectBlocks: []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name:         "not seekable",
+			reader:       io.MultiReader(bytes.NewReader(indexed)),
+			fromBlockNum: 4,
+			expectBlocks: []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name:          "corrupt footer, offset of another block",
+			reader:        bytes.NewReader(withEntryOffset(3, secondBlockOffset)),
+			fromBlockNum:  4,
+			expectIndexed: true,
+			expectBlocks:  []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name:          "corrupt footer, offset out of the blocks",
+			reader:        bytes.NewReader(withEntryOffset(3, uint64(len(indexed)))),
+			fromBlockNum:  4,
+			expectIndexed: true,
+			expectBlocks:  []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name:          "corrupt footer, entries count",
+			reader:        bytes.NewReader(withCount(1000)),
+			fromBlockNum:  4,
+			expectIndexed: true,
+			expectBlocks:  []uint64{1, 2, 3, 4, 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := NewSeekableBlockReader(test.reader, test.fromBlockNum)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectSeeked, reader.Seeked)
+			if test.expectIndexed {
+				assert.ErrorIs(t, reader.IndexErr, errCorruptBlocksIndex)
+			} else {
+				assert.NoError(t, reader.IndexErr)
+			}
+			assert.Equal(t, test.expectBlocks, readBlockNums(t, reader))
+		})
+	}
+}
+
+func TestDBinBlockReader_IndexedFile(t *testing.T) {
+	reader, err := NewDBinBlockReader(bytes.NewReader(indexedTestBlocks(t, linearTestBlocks(1, 3)...)))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, readBlockNums(t, reader), "the footer is not read as blocks")
+}
+
+func TestFileSource_SeekableBundles(t *testing.T) {
+	content := indexedTestBlocks(t, linearTestBlocks(1, 5)...)
+	// blocks below the start block are not decoded when the file is seeked
+	header := len("dbin") + 1 + 2 + len(TestBlockWithNumbers("01", "00", 1, 0).Payload.TypeUrl)
+	content[header+4] = 0xff
+
+	tests := []struct {
+		name           string
+		localBuffering bool
+	}{
+		{name: "seekable local files", localBuffering: true},
+		{name: "streaming reads"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(0), content)
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			options := []FileSourceOption{FileSourceWithStopBlock(5)}
+			if test.localBuffering {
+				options = append(options, FileSourceWithLocalBuffering(t.TempDir(), 1<<20))
+			}
+			fs := NewFileSource(bs, 3, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if !test.localBuffering {
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), "unable to read block proto")
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Equal(t, []uint64{3, 4, 5}, received)
+		})
+	}
+}
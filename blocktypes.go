@@ -14,14 +14,52 @@
 
 package bstream
 
-import pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+import (
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
 
 type incomingBlocksFile struct {
 	baseNum        uint64
+	bundleSize     uint64
 	filename       string // Base filename (%100 of block_num)
 	filteredBlocks []uint64
 	blocks         chan *PreprocessedBlock
 	err            error
+
+	// queuedAt is when the file was queued for download
+	queuedAt time.Time
+
+	// release frees the open files slot of the file once its blocks were consumed
+	release func()
+
+	// afterHole is true when the previous blocks file was skipped or its
+	// blocks were sent from one-block files
+	afterHole bool
+
+	// oneBlockFiles are streamed instead of the blocks file when it is missing
+	oneBlockFiles []*OneBlockFile
+
+	// skipBlocks are the blocks of the file already sent from one-block files,
+	// keyed by oneBlockKey
+	skipBlocks map[string]bool
+
+	// validationErr is set before blocks is closed when the file is incomplete
+	validationErr error
+
+	// preprocessed are the blocks being preprocessed, in file order, until they
+	// are sent to blocks. It is kept across the attempts to read the file and
+	// forwarded is closed once it is closed and all its blocks were sent.
+	preprocessed chan chan *PreprocessedBlock
+	forwarded    chan struct{}
+	// lastBlockRead is the last block sent to preprocessing
+	lastBlockRead BlockRef
+	// ended is true once the blocks were closed by endStream
+	ended bool
+	// failed is set before blocks is closed when the file is skipped after failing,
+	// see FileSourceWithErrorBudget
+	failed bool
 }
 
 // PassesFilter will allow blocks to pass if they are >= than the
@@ -45,9 +83,10 @@ func (i *incomingBlocksFile) PassesFilter(blockNum uint64) bool {
 	}
 }
 
-func newIncomingBlocksFile(baseBlockNum uint64, baseFileName string, filteredBlocks []uint64) *incomingBlocksFile {
+func newIncomingBlocksFile(baseBlockNum, bundleSize uint64, baseFileName string, filteredBlocks []uint64) *incomingBlocksFile {
 	ibf := &incomingBlocksFile{
 		baseNum:        baseBlockNum,
+		bundleSize:     bundleSize,
 		filename:       baseFileName,
 		blocks:         make(chan *PreprocessedBlock, 0),
 		filteredBlocks: filteredBlocks,
@@ -55,6 +94,45 @@ func newIncomingBlocksFile(baseBlockNum uint64, baseFileName string, filteredBlo
 	return ibf
 }
 
+// bundleCoverage tracks the block numbers seen in a blocks file to find holes
+// in the `[baseNum, baseNum+bundleSize)` range it is expected to cover. Many
+// blocks at the same height (forks) count once.
+type bundleCoverage struct {
+	baseNum uint64
+	seen    []bool
+}
+
+func newBundleCoverage(baseNum, bundleSize uint64) *bundleCoverage {
+	return &bundleCoverage{
+		baseNum: baseNum,
+		seen:    make([]bool, bundleSize),
+	}
+}
+
+func (c *bundleCoverage) add(blockNum uint64) {
+	if blockNum < c.baseNum || blockNum >= c.baseNum+uint64(len(c.seen)) {
+		return
+	}
+	c.seen[blockNum-c.baseNum] = true
+}
+
+// missing returns the lowest and highest missing block numbers, blocks below
+// `lowestExpected` may legitimately not exist (before the chain's first streamable block)
+func (c *bundleCoverage) missing(lowestExpected uint64) (from, to uint64, found bool) {
+	for i, seen := range c.seen {
+		blockNum := c.baseNum + uint64(i)
+		if seen || blockNum < lowestExpected {
+			continue
+		}
+		if !found {
+			from = blockNum
+			found = true
+		}
+		to = blockNum
+	}
+	return
+}
+
 type PreprocessedBlock struct {
 	Block *pbbstream.Block
 	Obj   interface{}
@@ -77,3 +77,70 @@ func TestIncomingBlockFile_PassesFilter(t *testing.T) {
 	}
 
 }
+
+func TestBundleCoverage_Missing(t *testing.T) {
+	tests := []struct {
+		name           string
+		baseNum        uint64
+		blocks         []uint64
+		lowestExpected uint64
+		expectFound    bool
+		expectFrom     uint64
+		expectTo       uint64
+	}{
+		{
+			name:    "complete",
+			baseNum: 100,
+			blocks:  []uint64{100, 101, 102, 103, 104},
+		},
+		{
+			name:    "complete with forked blocks",
+			baseNum: 100,
+			blocks:  []uint64{100, 101, 102, 102, 103, 103, 104},
+		},
+		{
+			name:        "truncated",
+			baseNum:     100,
+			blocks:      []uint64{100, 101, 102},
+			expectFound: true,
+			expectFrom:  103,
+			expectTo:    104,
+		},
+		{
+			name:        "hole",
+			baseNum:     100,
+			blocks:      []uint64{100, 101, 101, 103, 104},
+			expectFound: true,
+			expectFrom:  102,
+			expectTo:    102,
+		},
+		{
+			name:           "below first streamable block",
+			baseNum:        0,
+			blocks:         []uint64{2, 3, 4},
+			lowestExpected: 2,
+		},
+		{
+			name:        "outside of range ignored",
+			baseNum:     100,
+			blocks:      []uint64{99, 100, 101, 102, 103, 105},
+			expectFound: true,
+			expectFrom:  104,
+			expectTo:    104,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coverage := newBundleCoverage(test.baseNum, 5)
+			for _, blockNum := range test.blocks {
+				coverage.add(blockNum)
+			}
+
+			from, to, found := coverage.missing(test.lowestExpected)
+			assert.Equal(t, test.expectFound, found)
+			assert.Equal(t, test.expectFrom, from)
+			assert.Equal(t, test.expectTo, to)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// bundleLayoutRegionSize is the amount of blocks listed at once, it matches
+// the 6 first digits of the 10 digits default base filenames.
+const bundleLayoutRegionSize = 10000
+
+// bundleFilenameScheme names the merged blocks files after their base block number
+type bundleFilenameScheme struct {
+	format func(baseBlockNum uint64) string
+	parse  func(name string) (uint64, error)
+}
+
+// defaultFilenameScheme names the merged blocks files with 10 digits
+var defaultFilenameScheme = bundleFilenameScheme{
+	format: func(baseBlockNum uint64) string {
+		return fmt.Sprintf("%010d", baseBlockNum)
+	},
+	parse: func(name string) (uint64, error) {
+		if len(name) < 10 {
+			return 0, fmt.Errorf("merged blocks filename %q is too short", name)
+		}
+		// filenames may carry a suffix like `.zst`
+		return strconv.ParseUint(name[:10], 10, 64)
+	},
+}
+
+// listingPrefix returns the longest prefix shared by the names of the files
+// based between `from` and `to`, to list them.
+func (f *bundleFilenameScheme) listingPrefix(from, to uint64) string {
+	low, high := f.format(from), f.format(to)
+	i := 0
+	for i < len(low) && i < len(high) && low[i] == high[i] {
+		i++
+	}
+	return low[:i]
+}
+
+// bundleLayout discovers the base block numbers of the merged blocks files by
+// listing the store, so that bundles of different sizes can be traversed. The
+// listings are cached by region. Each partition store only provides the files
+// based in its own range. It is not safe for concurrent use.
+type bundleLayout struct {
+	partitions []StorePartition
+	scheme     *bundleFilenameScheme
+	regions    map[uint64][]uint64
+}
+
+func newBundleLayout(partitions []StorePartition, scheme *bundleFilenameScheme) *bundleLayout {
+	return &bundleLayout{
+		partitions: partitions,
+		scheme:     scheme,
+		regions:    make(map[uint64][]uint64),
+	}
+}
+
+// bases returns the sorted base block numbers of the files in the region
+// starting at `region`, listing the store if they are not cached or on `refresh`.
+func (l *bundleLayout) bases(ctx context.Context, region uint64, refresh bool) ([]uint64, error) {
+	if bases, found := l.regions[region]; found && !refresh {
+		return bases, nil
+	}
+
+	prefix := l.scheme.listingPrefix(region, region+bundleLayoutRegionSize-1)
+	var bases []uint64
+	for i, partition := range l.partitions {
+		low, high := partitionRange(l.partitions, i)
+		low, high = max(low, region), min(high, region+bundleLayoutRegionSize)
+		if low >= high {
+			continue
+		}
+
+		err := partition.Store.Walk(ctx, prefix, func(filename string) error {
+			base, err := l.scheme.parse(filename)
+			if err != nil || base < low || base >= high {
+				return nil
+			}
+			bases = append(bases, base)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing merged blocks files with prefix %q: %w", prefix, err)
+		}
+	}
+
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	l.regions[region] = bases
+	return bases, nil
+}
+
+// nextBase returns the lowest base block number above `base`, looking in the
+// region of `base` and the following one.
+func (l *bundleLayout) nextBase(ctx context.Context, base uint64, refresh bool) (next uint64, found bool, err error) {
+	region := lowBoundary(base, bundleLayoutRegionSize)
+	for _, r := range []uint64{region, region + bundleLayoutRegionSize} {
+		bases, err := l.bases(ctx, r, refresh)
+		if err != nil {
+			return 0, false, err
+		}
+		idx := sort.Search(len(bases), func(i int) bool { return bases[i] > base })
+		if idx < len(bases) {
+			return bases[idx], true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// covering returns the highest base block number at or below `blockNum`,
+// looking in the region of `blockNum` and the previous one.
+func (l *bundleLayout) covering(ctx context.Context, blockNum uint64, refresh bool) (base uint64, found bool, err error) {
+	region := lowBoundary(blockNum, bundleLayoutRegionSize)
+	regions := []uint64{region}
+	if region >= bundleLayoutRegionSize {
+		regions = append(regions, region-bundleLayoutRegionSize)
+	}
+
+	for _, r := range regions {
+		bases, err := l.bases(ctx, r, refresh)
+		if err != nil {
+			return 0, false, err
+		}
+		idx := sort.Search(len(bases), func(i int) bool { return bases[i] > blockNum })
+		if idx > 0 {
+			return bases[idx-1], true, nil
+		}
+	}
+	return 0, false, nil
+}
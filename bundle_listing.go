@@ -0,0 +1,108 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"context"
+	"math"
+
+	"github.com/streamingfast/dstore"
+)
+
+// bundleListingWindow is the amount of merged blocks files listed at once
+const bundleListingWindow = 1000
+
+// bundleListing answers the existence checks of the merged blocks files from a
+// listing of the next bundleListingWindow files of the store, listed again once
+// the checks go past them. When less than `minBundlesBehind` bundles are listed
+// ahead of the checked one, new files are appearing and the checks of the next
+// `minBundlesBehind` bundles are left to FileExists. It is not safe for
+// concurrent use.
+type bundleListing struct {
+	scheme           *bundleFilenameScheme
+	minBundlesBehind uint64
+
+	listed bool
+	bases  map[uint64]bool
+	// listedFrom and listedTo are the lowest and highest bases covered by the listing
+	listedFrom uint64
+	listedTo   uint64
+	// statUntil is the base below which FileExists is used, after a listing
+	// close to the highest file
+	statUntil uint64
+}
+
+func newBundleListing(scheme *bundleFilenameScheme, minBundlesBehind uint64) *bundleListing {
+	return &bundleListing{
+		scheme:           scheme,
+		minBundlesBehind: minBundlesBehind,
+	}
+}
+
+// exists tells if the file based at `baseBlockNum` exists in `store`, which
+// owns the files based below `storeEnd`. When `known` is false, FileExists
+// must be used instead.
+func (l *bundleListing) exists(ctx context.Context, store dstore.Store, storeEnd, baseBlockNum, bundleSize uint64) (exists, known bool, err error) {
+	if baseBlockNum < l.statUntil {
+		return false, false, nil
+	}
+
+	if !l.listed || baseBlockNum < l.listedFrom || baseBlockNum > l.listedTo {
+		if err := l.list(ctx, store, storeEnd, baseBlockNum); err != nil {
+			return false, false, err
+		}
+		if ahead := baseBlockNum + l.minBundlesBehind*bundleSize; l.listedTo < ahead {
+			l.statUntil = ahead
+			return false, false, nil
+		}
+	}
+
+	if l.bases[baseBlockNum] {
+		return true, true, nil
+	}
+	// a hole in the listing, the file may have been written since
+	return false, false, nil
+}
+
+func (l *bundleListing) list(ctx context.Context, store dstore.Store, storeEnd, from uint64) error {
+	bases := make(map[uint64]bool)
+	to := from
+	prefix := l.scheme.listingPrefix(from, math.MaxUint64)
+	err := store.WalkFrom(ctx, prefix, l.scheme.format(from), func(filename string) error {
+		base, err := l.scheme.parse(filename)
+		if err != nil || base < from || l.scheme.format(base) != filename {
+			return nil
+		}
+		if base >= storeEnd {
+			return dstore.StopIteration
+		}
+
+		bases[base] = true
+		to = max(to, base)
+		if len(bases) >= bundleListingWindow {
+			return dstore.StopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	l.listed = true
+	l.bases = bases
+	l.listedFrom = from
+	l.listedTo = to
+	return nil
+}
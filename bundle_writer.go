@@ -0,0 +1,146 @@
+package bstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidBundle is wrapped by the errors of WriteBundle for blocks which
+// would not form a valid merged blocks file
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+type WriteBundleOption func(*bundleWriter)
+
+type bundleWriter struct {
+	bundleSize    uint64
+	writerFactory BlockWriterFactory
+	filename      func(baseBlockNum uint64) string
+}
+
+// WriteBundleWithBundleSize sets the amount of block heights of the merged
+// blocks file, 100 by default
+func WriteBundleWithBundleSize(bundleSize uint64) WriteBundleOption {
+	return func(w *bundleWriter) {
+		w.bundleSize = bundleSize
+	}
+}
+
+// WriteBundleWithBlockWriterFactory encodes the merged blocks file with
+// `factory` instead of DBinBlockWriterFactory
+func WriteBundleWithBlockWriterFactory(factory BlockWriterFactory) WriteBundleOption {
+	return func(w *bundleWriter) {
+		w.writerFactory = factory
+	}
+}
+
+// WriteBundleWithBlockCodec encodes the merged blocks file with the writer
+// factory of `codec`, DBinBlockWriterFactory for the zero codec
+func WriteBundleWithBlockCodec(codec BlockCodec) WriteBundleOption {
+	return func(w *bundleWriter) {
+		w.writerFactory = codec.writerFactory()
+	}
+}
+
+// WriteBundleWithFilenameFormat names the merged blocks file with `format`
+// instead of the 10 digits of its base block number, see FileSourceWithFilenameScheme
+func WriteBundleWithFilenameFormat(format func(baseBlockNum uint64) string) WriteBundleOption {
+	return func(w *bundleWriter) {
+		w.filename = format
+	}
+}
+
+// WriteBundle writes `blocks` as the merged blocks file based at `baseBlockNum`,
+// returning the size and the hex encoded SHA-256 of the content given to the
+// store. The blocks must follow each other (each one being the parent of the
+// next one), with at least one block at each height of the bundle from
+// GetProtocolFirstStreamableBlock: nothing is written otherwise and the error
+// wraps ErrInvalidBundle.
+//
+// The file is encoded in memory and written at once, relying on the store to
+// never expose a partially written object: a crash cannot leave a truncated
+// file behind. When the write fails, the object is deleted in case the store
+// left a part of it, unless it existed before.
+func WriteBundle(ctx context.Context, store dstore.Store, baseBlockNum uint64, blocks []*pbbstream.Block, opts ...WriteBundleOption) (written int64, contentHash string, err error) {
+	w := &bundleWriter{
+		bundleSize:    100,
+		writerFactory: DBinBlockWriterFactory,
+		filename:      defaultFilenameScheme.format,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if err := checkBundle(baseBlockNum, w.bundleSize, blocks); err != nil {
+		return 0, "", err
+	}
+
+	buf := &bytes.Buffer{}
+	writer, err := w.writerFactory.New(buf)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to create block writer: %w", err)
+	}
+	for _, blk := range blocks {
+		if err := writer.Write(blk); err != nil {
+			return 0, "", fmt.Errorf("writing block %s: %w", blk.AsRef(), err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return 0, "", fmt.Errorf("closing block writer: %w", err)
+	}
+	content := buf.Bytes()
+
+	filename := w.filename(baseBlockNum)
+	existed, err := store.FileExists(ctx, filename)
+	if err != nil {
+		return 0, "", fmt.Errorf("checking merged blocks file %q: %w", filename, err)
+	}
+	if err := store.WriteObject(ctx, filename, bytes.NewReader(content)); err != nil {
+		if !existed {
+			if deleteErr := store.DeleteObject(ctx, filename); deleteErr != nil && !errors.Is(deleteErr, dstore.ErrNotFound) {
+				zlog.Warn("unable to delete merged blocks file after a failed write", zap.String("filename", filename), zap.Error(deleteErr))
+			}
+		}
+		return 0, "", fmt.Errorf("writing merged blocks file %q: %w", filename, err)
+	}
+
+	hash := sha256.Sum256(content)
+	return int64(len(content)), hex.EncodeToString(hash[:]), nil
+}
+
+// checkBundle returns an error when `blocks` cannot be read back from a merged
+// blocks file based at `baseBlockNum`, see WriteBundle
+func checkBundle(baseBlockNum, bundleSize uint64, blocks []*pbbstream.Block) error {
+	if bundleSize == 0 || baseBlockNum%bundleSize != 0 {
+		return fmt.Errorf("%w: base block %d is not a multiple of the bundle size %d", ErrInvalidBundle, baseBlockNum, bundleSize)
+	}
+
+	coverage := newBundleCoverage(baseBlockNum, bundleSize)
+	for i, blk := range blocks {
+		if blk.Number < baseBlockNum || blk.Number >= baseBlockNum+bundleSize {
+			return fmt.Errorf("%w: block %s is out of the range [%d, %d]", ErrInvalidBundle, blk.AsRef(), baseBlockNum, baseBlockNum+bundleSize-1)
+		}
+		if err := CheckBlockID(blk); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidBundle, err)
+		}
+		if i > 0 {
+			previous := blocks[i-1]
+			if blk.Number <= previous.Number || blk.ParentId != previous.Id {
+				return fmt.Errorf("%w: block %s with parent %q does not follow block %s", ErrInvalidBundle, blk.AsRef(), blk.ParentId, previous.AsRef())
+			}
+		}
+		coverage.add(blk.Number)
+	}
+
+	if from, to, found := coverage.missing(GetProtocolFirstStreamableBlock); found {
+		return fmt.Errorf("%w: missing blocks in range [%d, %d]", ErrInvalidBundle, from, to)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package bstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBundle(t *testing.T) {
+	defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+	GetProtocolFirstStreamableBlock = 1
+
+	store := dstore.NewMockStore(nil)
+	written, hash, err := WriteBundle(context.Background(), store, 0, linearTestBlocks(1, 9), WriteBundleWithBundleSize(10))
+	require.NoError(t, err)
+
+	reader, err := store.OpenObject(context.Background(), base(0))
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, int64(len(content)), written)
+	assert.Equal(t, hex.EncodeToString(sum[:]), hash)
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+	fs := NewFileSource(store, 1, handler, zlog, FileSourceWithBundleSize(10), FileSourceWithStopBlock(9), FileSourceWithBundleValidation())
+	fs.Run()
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9}, received)
+}
+
+func TestWriteBundle_InvalidBlocks(t *testing.T) {
+	defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+	GetProtocolFirstStreamableBlock = 1
+
+	swapped := linearTestBlocks(10, 19)
+	swapped[3], swapped[4] = swapped[4], swapped[3]
+	forked := linearTestBlocks(10, 19)
+	forked[5] = TestBlockWithNumbers("15b", "14b", 15, 14)
+
+	tests := []struct {
+		name          string
+		baseBlockNum  uint64
+		blocks        []*pbbstream.Block
+		expectedError string
+	}{
+		{name: "out of order", baseBlockNum: 10, blocks: swapped, expectedError: `block #14 (14a) with parent "13a" does not follow block #12 (12a)`},
+		{name: "duplicated", baseBlockNum: 10, blocks: append(linearTestBlocks(10, 19), linearTestBlocks(19, 19)...), expectedError: `block #19 (19a) with parent "18a" does not follow block #19 (19a)`},
+		{name: "not following its parent", baseBlockNum: 10, blocks: forked, expectedError: `block #15 (15b) with parent "14b" does not follow block #14 (14a)`},
+		{name: "missing blocks", baseBlockNum: 10, blocks: linearTestBlocks(10, 17), expectedError: "missing blocks in range [18, 19]"},
+		{name: "empty", baseBlockNum: 10, expectedError: "missing blocks in range [10, 19]"},
+		{name: "out of the range", baseBlockNum: 10, blocks: linearTestBlocks(10, 20), expectedError: "block #20 (20a) is out of the range [10, 19]"},
+		{name: "unaligned base", baseBlockNum: 5, blocks: linearTestBlocks(5, 14), expectedError: "base block 5 is not a multiple of the bundle size 10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := dstore.NewMockStore(func(base string, f io.Reader) error {
+				t.Fatalf("unexpected write of %q", base)
+				return nil
+			})
+
+			_, _, err := WriteBundle(context.Background(), store, test.baseBlockNum, test.blocks, WriteBundleWithBundleSize(10))
+			require.ErrorIs(t, err, ErrInvalidBundle)
+			assert.Contains(t, err.Error(), test.expectedError)
+		})
+	}
+}
+
+func TestWriteBundle_WriteFailure(t *testing.T) {
+	defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+	GetProtocolFirstStreamableBlock = 1
+
+	errWrite := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name           string
+		existing       []byte
+		expectedExists bool
+	}{
+		{name: "partial object deleted"},
+		{name: "existing object kept", existing: []byte("previous"), expectedExists: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := dstore.NewMockStore(nil)
+			if test.existing != nil {
+				store.SetFile(base(0), test.existing)
+			}
+			store.WriteObjectFunc = func(ctx context.Context, base string, f io.Reader) error {
+				// the store leaves the beginning of the object behind
+				partial := make([]byte, 10)
+				_, err := io.ReadFull(f, partial)
+				require.NoError(t, err)
+				store.SetFile(base, partial)
+				return errWrite
+			}
+
+			_, _, err := WriteBundle(context.Background(), store, 0, linearTestBlocks(1, 9), WriteBundleWithBundleSize(10))
+			require.ErrorIs(t, err, errWrite)
+
+			exists, err := store.FileExists(context.Background(), base(0))
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedExists, exists)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/streamingfast/dstore"
+)
+
+// fileSourceCheckpoint is the content of the checkpoint files
+type fileSourceCheckpoint struct {
+	LastFullyProcessed uint64 `json:"last_fully_processed"`
+}
+
+// DStoreCheckpointer returns a checkpointer for FileSourceWithCheckpointer that
+// writes the last fully processed block to `filename` in `store` as JSON. The
+// store must allow overwriting files.
+func DStoreCheckpointer(store dstore.Store, filename string) func(ctx context.Context, lastFullyProcessed uint64) error {
+	return func(ctx context.Context, lastFullyProcessed uint64) error {
+		content, err := json.Marshal(&fileSourceCheckpoint{LastFullyProcessed: lastFullyProcessed})
+		if err != nil {
+			return err
+		}
+		if err := store.WriteObject(ctx, filename, bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("writing checkpoint %q: %w", filename, err)
+		}
+		return nil
+	}
+}
+
+// StartBlockFromCheckpoint returns the block following the last fully processed
+// one written by DStoreCheckpointer to `filename` in `store`, or `defaultStart`
+// when there is no such checkpoint.
+func StartBlockFromCheckpoint(ctx context.Context, store dstore.Store, filename string, defaultStart uint64) (uint64, error) {
+	exists, err := store.FileExists(ctx, filename)
+	if err != nil {
+		return 0, fmt.Errorf("checking checkpoint %q: %w", filename, err)
+	}
+	if !exists {
+		return defaultStart, nil
+	}
+
+	reader, err := store.OpenObject(ctx, filename)
+	if err != nil {
+		return 0, fmt.Errorf("opening checkpoint %q: %w", filename, err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, fmt.Errorf("reading checkpoint %q: %w", filename, err)
+	}
+
+	checkpoint := &fileSourceCheckpoint{}
+	if err := json.Unmarshal(content, checkpoint); err != nil {
+		return 0, fmt.Errorf("decoding checkpoint %q: %w", filename, err)
+	}
+	return checkpoint.LastFullyProcessed + 1, nil
+}
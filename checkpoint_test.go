@@ -0,0 +1,108 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSource_CheckpointResume(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(3, 100)
+	checkpoints := dstore.NewMockStore(nil)
+	checkpoints.SetOverwrite(true)
+	checkpointer := DStoreCheckpointer(checkpoints, "checkpoint.json")
+
+	errCrash := errors.New("crashed")
+	runFrom := func(start uint64, crashAt uint64) (received []uint64, err error) {
+		handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+			if blk.Number == crashAt {
+				return errCrash
+			}
+			received = append(received, blk.Number)
+			return nil
+		})
+		fs := NewFileSource(bs, start, handler, zlog, FileSourceWithStopBlock(lastBlockNum), FileSourceWithCheckpointer(checkpointer))
+
+		testDone := make(chan struct{})
+		go func() {
+			fs.Run()
+			close(testDone)
+		}()
+		select {
+		case <-testDone:
+		case <-time.After(time.Second):
+			t.Fatal("Test timeout")
+		}
+		return received, fs.Err()
+	}
+
+	start, err := StartBlockFromCheckpoint(context.Background(), checkpoints, "checkpoint.json", 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), start)
+
+	received, err := runFrom(start, 250)
+	assert.ErrorIs(t, err, errCrash)
+	assert.Equal(t, uint64(249), received[len(received)-1])
+
+	// the bundle being handled when crashing is processed again
+	start, err = StartBlockFromCheckpoint(context.Background(), checkpoints, "checkpoint.json", 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), start)
+
+	received, err = runFrom(start, 0)
+	assert.ErrorIs(t, err, ErrStopBlockReached)
+	require.Len(t, received, 100)
+	assert.Equal(t, uint64(200), received[0])
+
+	start, err = StartBlockFromCheckpoint(context.Background(), checkpoints, "checkpoint.json", 1)
+	require.NoError(t, err)
+	assert.Equal(t, lastBlockNum+1, start)
+}
+
+func TestFileSource_CheckpointerError(t *testing.T) {
+	bs, _ := newLinearBundlesStore(3, 100)
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithCheckpointer(func(ctx context.Context, lastFullyProcessed uint64) error {
+		return errors.New("checkpoint store unavailable")
+	}))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.Error(t, fs.Err())
+	assert.Contains(t, fs.Err().Error(), "checkpointing block 99: checkpoint store unavailable")
+	assert.Len(t, received, 99, "blocks of the next bundle must not be sent")
+}
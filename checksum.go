@@ -0,0 +1,129 @@
+package bstream
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// ChecksumFieldNumber is the field of the encoded Block holding the CRC32C of the
+// bytes of its dbin message before it, when written with DBinBlockWriterWithChecksum.
+// It is the last field of the message and is not declared in the Block message:
+// the messages of the previous versions, without it, are read without
+// verification and the readers of the previous versions ignore it.
+const ChecksumFieldNumber protowire.Number = 13
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is wrapped by the ChecksumError of the blocks which do not
+// match the checksum written with them
+var ErrChecksumMismatch = errors.New("block checksum mismatch")
+
+// ChecksumError is returned by the readers for a block not matching the checksum
+// written with it, see DBinBlockWriterWithChecksum
+type ChecksumError struct {
+	// BlockNum is the number of the block, as far as it can be decoded
+	BlockNum uint64
+	// Offset is where the message of the block starts in the file
+	Offset   int64
+	Expected uint32
+	Actual   uint32
+
+	// Block is the block decoded despite the mismatch, nil when it cannot be
+	Block *pbbstream.Block
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("block #%d at offset %d: checksum mismatch, expected %08x, got %08x", e.BlockNum, e.Offset, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// ChecksumPolicy is what the FileSource does with a block not matching its
+// checksum, see FileSourceWithChecksumPolicy
+type ChecksumPolicy int
+
+const (
+	// ChecksumPolicyFail fails the source, the default
+	ChecksumPolicyFail ChecksumPolicy = iota
+	// ChecksumPolicyWarn logs the mismatch and sends the block, skipped when it
+	// cannot be decoded
+	ChecksumPolicyWarn
+	// ChecksumPolicySkip logs the mismatch and skips the block
+	ChecksumPolicySkip
+)
+
+func (p ChecksumPolicy) String() string {
+	switch p {
+	case ChecksumPolicyFail:
+		return "fail"
+	case ChecksumPolicyWarn:
+		return "warn"
+	case ChecksumPolicySkip:
+		return "skip"
+	}
+	return fmt.Sprintf("ChecksumPolicy(%d)", int(p))
+}
+
+// appendChecksum appends the checksum of `message`, see ChecksumFieldNumber
+func appendChecksum(message []byte) []byte {
+	sum := crc32.Checksum(message, castagnoliTable)
+	message = protowire.AppendTag(message, ChecksumFieldNumber, protowire.Fixed32Type)
+	return protowire.AppendFixed32(message, sum)
+}
+
+// splitChecksum returns the bytes of `message` before its checksum with the
+// checksum, found being false for a message written without checksum
+func splitChecksum(message []byte) (body []byte, checksum uint32, found bool) {
+	rest := message
+	for len(rest) > 0 {
+		num, typ, n := protowire.ConsumeField(rest)
+		if n < 0 {
+			// a corrupted message, whose checksum is its last bytes if it has one
+			return trailingChecksum(message)
+		}
+		if num == ChecksumFieldNumber && typ == protowire.Fixed32Type && n == len(rest) {
+			_, _, tagLength := protowire.ConsumeTag(rest)
+			checksum, _ = protowire.ConsumeFixed32(rest[tagLength:])
+			return message[:len(message)-len(rest)], checksum, true
+		}
+		rest = rest[n:]
+	}
+	return message, 0, false
+}
+
+func trailingChecksum(message []byte) (body []byte, checksum uint32, found bool) {
+	tag := protowire.AppendTag(nil, ChecksumFieldNumber, protowire.Fixed32Type)
+	start := len(message) - len(tag) - 4
+	if start < 0 || string(message[start:start+len(tag)]) != string(tag) {
+		return message, 0, false
+	}
+	checksum, _ = protowire.ConsumeFixed32(message[start+len(tag):])
+	return message[:start], checksum, true
+}
+
+// verifyChecksum returns a ChecksumError when `body` does not match `checksum`,
+// `blk` being the block decoded from it, nil if it could not be
+func verifyChecksum(body []byte, checksum uint32, offset int64, blk *pbbstream.Block) error {
+	actual := crc32.Checksum(body, castagnoliTable)
+	if actual == checksum {
+		return nil
+	}
+
+	err := &ChecksumError{Offset: offset, Expected: checksum, Actual: actual, Block: blk}
+	if blk != nil {
+		err.BlockNum = blk.Number
+	} else {
+		// as far as the corrupted message can be decoded
+		meta := &pbbstream.BlockMeta{}
+		_ = proto.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, meta)
+		err.BlockNum = meta.Number
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+package bstream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checksummedBlocks(t *testing.T, blocks ...*pbbstream.Block) []byte {
+	buffer := &bytes.Buffer{}
+	writer, err := NewDBinBlockWriter(buffer, DBinBlockWriterWithChecksum(), DBinBlockWriterWithMetadata())
+	require.NoError(t, err)
+	for _, blk := range blocks {
+		require.NoError(t, writer.Write(blk))
+	}
+	return buffer.Bytes()
+}
+
+// corruptPayload flips a byte of the payload of the `index`th block of `content`
+func corruptPayload(t *testing.T, content []byte, index int) []byte {
+	offsets := append(messageOffsets(t, content), int64(len(content)))
+	out := append([]byte(nil), content...)
+	// the payload ends before the checksum, tag and value
+	out[offsets[index+1]-6] ^= 0x01
+	return out
+}
+
+func TestDBinBlockReader_Checksum(t *testing.T) {
+	blocks := linearTestBlocks(1, 3)
+	blocks[1].SetMeta("peer", "10.0.0.1")
+	content := checksummedBlocks(t, blocks...)
+	assert.Greater(t, len(content), len(testBlocks(linearTestBlocks(1, 3)...)))
+
+	for name, factory := range map[string]BlockReaderFactory{
+		"blocks":        DBinBlockReaderFactory,
+		"pooled blocks": PooledDBinBlockReaderFactory(NewBlockPool()),
+		"header only":   HeaderOnlyDBinBlockReaderFactory,
+	} {
+		reader, err := factory.New(bytes.NewReader(content))
+		require.NoError(t, err)
+		for _, expected := range blocks {
+			blk, err := reader.Read()
+			require.NoError(t, err, name)
+			assert.Equal(t, expected.Number, blk.Number, name)
+			assert.Equal(t, expected.Metadata(), blk.Metadata(), name)
+			assert.Nil(t, blk.UnknownFields(), name)
+			if factory != HeaderOnlyDBinBlockReaderFactory {
+				AssertProtoEqual(t, expected, blk)
+			}
+		}
+		_, err = reader.Read()
+		assert.Equal(t, io.EOF, err, name)
+	}
+
+	reader, err := NewDBinBlockReader(bytes.NewReader(testBlocks(blocks...)))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, readBlockNums(t, reader), "written without checksum")
+}
+
+func TestDBinBlockReader_ChecksumMismatch(t *testing.T) {
+	content := checksummedBlocks(t, linearTestBlocks(1, 3)...)
+	offsets := messageOffsets(t, content)
+	corrupted := corruptPayload(t, content, 1)
+
+	reader, err := NewDBinBlockReader(bytes.NewReader(corrupted))
+	require.NoError(t, err)
+	blk, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), blk.Number)
+
+	_, err = reader.Read()
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	checksumErr := err.(*ChecksumError)
+	assert.Equal(t, uint64(2), checksumErr.BlockNum)
+	assert.Equal(t, offsets[1], checksumErr.Offset)
+	assert.NotEqual(t, checksumErr.Expected, checksumErr.Actual)
+	assert.Contains(t, err.Error(), "block #2 at offset")
+	require.NotNil(t, checksumErr.Block, "the payload bytes still decode")
+	assert.Equal(t, uint64(2), checksumErr.Block.Number)
+
+	blk, err = reader.Read()
+	require.NoError(t, err, "the next blocks are read")
+	assert.Equal(t, uint64(3), blk.Number)
+
+	// the length of the ID, the message cannot be decoded anymore
+	undecodable := append([]byte(nil), content...)
+	undecodable[offsets[1]+4+3] ^= 0x40
+	reader, err = NewDBinBlockReader(bytes.NewReader(undecodable))
+	require.NoError(t, err)
+	_, err = reader.Read()
+	require.NoError(t, err)
+	_, err = reader.Read()
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	checksumErr = err.(*ChecksumError)
+	assert.Nil(t, checksumErr.Block)
+	assert.Equal(t, offsets[1], checksumErr.Offset)
+}
+
+func TestFileSource_ChecksumPolicy(t *testing.T) {
+	tests := []struct {
+		name             string
+		options          []FileSourceOption
+		expectedReceived []uint64
+		expectedErr      string
+	}{
+		{
+			name:        "fail by default",
+			expectedErr: `reading merged blocks file "0000000000": block #5 at offset`,
+		},
+		{
+			name:             "warn",
+			options:          []FileSourceOption{FileSourceWithChecksumPolicy(ChecksumPolicyWarn)},
+			expectedReceived: []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name:             "skip, the hole breaks the chain",
+			options:          []FileSourceOption{FileSourceWithChecksumPolicy(ChecksumPolicySkip)},
+			expectedReceived: []uint64{1, 2, 3, 4},
+			expectedErr:      `"#6 (06a)" has previousID "05a" and does not follow "04a"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+			GetProtocolFirstStreamableBlock = 1
+
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(0), corruptPayload(t, checksummedBlocks(t, linearTestBlocks(1, 9)...), 4))
+			bs.SetFile(base(10), checksummedBlocks(t, linearTestBlocks(10, 10)...))
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number == 10 {
+					return errDone
+				}
+				return nil
+			})
+			options := append([]FileSourceOption{FileSourceWithBundleSize(10)}, test.options...)
+			fs := NewFileSource(bs, 1, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectedReceived != nil {
+				assert.Equal(t, test.expectedReceived, received)
+			} else {
+				assert.NotContains(t, received, uint64(5))
+			}
+			if test.expectedErr == "" {
+				assert.Equal(t, errDone, fs.Err())
+				return
+			}
+			require.Error(t, fs.Err())
+			assert.Contains(t, fs.Err().Error(), test.expectedErr)
+		})
+	}
+}
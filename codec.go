@@ -0,0 +1,38 @@
+package bstream
+
+import (
+	"io"
+)
+
+// BlockCodec bundles the factories decoding and encoding the blocks files, for
+// the components given one explicitly instead of the package defaults: two
+// tests or tools of the same process can then use different codecs. The zero
+// value, or a nil factory, uses DBinBlockReaderFactory and DBinBlockWriterFactory.
+type BlockCodec struct {
+	ReaderFactory BlockReaderFactory
+	WriterFactory BlockWriterFactory
+}
+
+// NewReader creates the BlockReader of the blocks file read by `reader`
+func (c BlockCodec) NewReader(reader io.Reader) (BlockReader, error) {
+	return c.readerFactory().New(reader)
+}
+
+// NewWriter creates the BlockWriter of the blocks file written to `writer`
+func (c BlockCodec) NewWriter(writer io.Writer) (BlockWriter, error) {
+	return c.writerFactory().New(writer)
+}
+
+func (c BlockCodec) readerFactory() BlockReaderFactory {
+	if c.ReaderFactory == nil {
+		return DBinBlockReaderFactory
+	}
+	return c.ReaderFactory
+}
+
+func (c BlockCodec) writerFactory() BlockWriterFactory {
+	if c.WriterFactory == nil {
+		return DBinBlockWriterFactory
+	}
+	return c.WriterFactory
+}
@@ -0,0 +1,84 @@
+package bstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writerNameBlockWriter writes the name of the factory which created it in the metadata of the blocks
+type writerNameBlockWriter struct {
+	BlockWriter
+	name string
+}
+
+func (w *writerNameBlockWriter) Write(blk *pbbstream.Block) error {
+	blk = blk.Clone()
+	blk.SetMeta("writer", w.name)
+	return w.BlockWriter.Write(blk)
+}
+
+func testBlockCodec(name string) BlockCodec {
+	return BlockCodec{
+		ReaderFactory: BlockReaderFactoryFunc(func(reader io.Reader) (BlockReader, error) {
+			blockReader, err := NewDBinBlockReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			return &decoderBlockReader{BlockReader: blockReader, name: name}, nil
+		}),
+		WriterFactory: BlockWriterFactoryFunc(func(writer io.Writer) (BlockWriter, error) {
+			blockWriter, err := NewDBinBlockWriter(writer, DBinBlockWriterWithMetadata())
+			if err != nil {
+				return nil, err
+			}
+			return &writerNameBlockWriter{BlockWriter: blockWriter, name: name}, nil
+		}),
+	}
+}
+
+func TestBlockCodec_Default(t *testing.T) {
+	var codec BlockCodec
+	assert.Equal(t, DBinBlockReaderFactory, codec.readerFactory())
+
+	writer, err := codec.NewWriter(io.Discard)
+	require.NoError(t, err)
+	assert.IsType(t, &DBinBlockWriter{}, writer)
+}
+
+func TestBlockCodec_Parallel(t *testing.T) {
+	for _, name := range []string{"a", "b"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			codec := testBlockCodec(name)
+
+			for i := 0; i < 10; i++ {
+				store := dstore.NewMockStore(nil)
+				_, _, err := WriteBundle(context.Background(), store, 10, linearTestBlocks(10, 19), WriteBundleWithBundleSize(10), WriteBundleWithBlockCodec(codec))
+				require.NoError(t, err)
+
+				var received []string
+				handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+					writer, _ := blk.GetMeta("writer")
+					decoder, _ := blk.GetMeta("decoder")
+					received = append(received, fmt.Sprintf("%d:%s%s", blk.Number, writer, decoder))
+					return nil
+				})
+				fs := NewFileSource(store, 10, handler, zlog, FileSourceWithBundleSize(10), FileSourceWithStopBlock(19), FileSourceWithBlockCodec(codec))
+				fs.Run()
+				require.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+				require.Len(t, received, 10)
+				assert.Equal(t, "10:"+name+name, received[0])
+				assert.Equal(t, "19:"+name+name, received[9])
+			}
+		})
+	}
+}
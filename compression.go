@@ -0,0 +1,149 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BundleCompression is the codec used to read and write merged blocks files
+type BundleCompression string
+
+const (
+	// BundleCompressionAuto detects the codec from the first bytes of the file
+	BundleCompressionAuto BundleCompression = ""
+	// BundleCompressionNone reads the file as is, without detection
+	BundleCompressionNone BundleCompression = "none"
+	BundleCompressionGzip BundleCompression = "gzip"
+	BundleCompressionZstd BundleCompression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// unsupportedMagics are the first bytes of the compressed files that cannot be
+// read, reported as such instead of failing to decode the compressed bytes
+var unsupportedMagics = map[string][]byte{
+	"xz":    {0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00},
+	"bzip2": {0x42, 0x5a, 0x68},
+	"lz4":   {0x04, 0x22, 0x4d, 0x18},
+}
+
+// sniffBundleCompression looks at the first bytes of `reader` without consuming them
+func sniffBundleCompression(reader *bufio.Reader) (BundleCompression, error) {
+	header, err := reader.Peek(len(unsupportedMagics["xz"]))
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, zstdMagic):
+		return BundleCompressionZstd, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return BundleCompressionGzip, nil
+	}
+	for name, magic := range unsupportedMagics {
+		if bytes.HasPrefix(header, magic) {
+			return "", fmt.Errorf("unsupported compression %q", name)
+		}
+	}
+	return BundleCompressionNone, nil
+}
+
+// decompressedReader wraps `reader` in the decompressor of `compression`, closing
+// the returned reader does not close `reader`.
+func decompressedReader(reader io.Reader, compression BundleCompression) (io.ReadCloser, error) {
+	if compression == BundleCompressionAuto {
+		buffered := bufio.NewReader(reader)
+		detected, err := sniffBundleCompression(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("detecting compression: %w", err)
+		}
+		reader = buffered
+		compression = detected
+	}
+
+	switch compression {
+	case BundleCompressionNone:
+		return io.NopCloser(reader), nil
+	case BundleCompressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("creating gzip reader: %w", err)
+		}
+		return gzipReader, nil
+	case BundleCompressionZstd:
+		zstdReader, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd reader: %w", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", compression)
+}
+
+// isUncompressed tells if the blocks file of `seeker` is read as is with
+// `compression`, detecting it from the first bytes of the file which is
+// rewound to its start
+func isUncompressed(seeker io.ReadSeeker, compression BundleCompression) (bool, error) {
+	if compression != BundleCompressionAuto {
+		return compression == BundleCompressionNone, nil
+	}
+
+	detected, err := sniffBundleCompression(bufio.NewReader(io.LimitReader(seeker, int64(len(unsupportedMagics["xz"])))))
+	if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+		return false, fmt.Errorf("rewinding after detecting compression: %w", seekErr)
+	}
+	if err != nil {
+		return false, nil
+	}
+	return detected == BundleCompressionNone, nil
+}
+
+// compressedWriter wraps `writer` in the compressor of `compression` at `level`,
+// 0 being the default level of the codec. Closing the returned writer flushes
+// the compressed data without closing `writer`.
+func compressedWriter(writer io.Writer, compression BundleCompression, level int) (io.WriteCloser, error) {
+	switch compression {
+	case BundleCompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gzipWriter, err := gzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return nil, fmt.Errorf("creating gzip writer: %w", err)
+		}
+		return gzipWriter, nil
+	case BundleCompressionZstd:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zstdWriter, err := zstd.NewWriter(writer, options...)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd writer: %w", err)
+		}
+		return zstdWriter, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q for writing, use gzip or zstd", compression)
+}
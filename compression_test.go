@@ -0,0 +1,189 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func gzipped(t *testing.T, in []byte) []byte {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	_, err := writer.Write(in)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func zstded(t *testing.T, in []byte) []byte {
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer encoder.Close()
+	return encoder.EncodeAll(in, nil)
+}
+
+func TestFileSource_Compression(t *testing.T) {
+	bundle := testBlocks(
+		TestBlockWithNumbers("1a", "00", 1, 0),
+		TestBlockWithNumbers("2a", "1a", 2, 0),
+		TestBlockWithNumbers("3a", "2a", 3, 0),
+	)
+
+	tests := []struct {
+		name        string
+		content     []byte
+		compression BundleCompression
+		expectError bool
+	}{
+		{name: "raw", content: bundle},
+		{name: "gzip", content: gzipped(t, bundle)},
+		{name: "zstd", content: zstded(t, bundle)},
+		{name: "forced zstd", content: zstded(t, bundle), compression: BundleCompressionZstd},
+		{name: "forced gzip", content: gzipped(t, bundle), compression: BundleCompressionGzip},
+		{name: "sniffing disabled on raw", content: bundle, compression: BundleCompressionNone},
+		{name: "sniffing disabled on zstd", content: zstded(t, bundle), compression: BundleCompressionNone, expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(0), test.content)
+
+			var received []string
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Id)
+				return nil
+			})
+
+			options := []FileSourceOption{FileSourceWithStopBlock(3)}
+			if test.compression != BundleCompressionAuto {
+				options = append(options, FileSourceWithCompression(test.compression))
+			}
+			fs := NewFileSource(bs, 1, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectError {
+				require.Error(t, fs.Err())
+				assert.NotErrorIs(t, fs.Err(), ErrStopBlockReached)
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Equal(t, []string{"1a", "2a", "3a"}, received)
+		})
+	}
+}
+
+func TestCompressedBlockWriter_RoundTrip(t *testing.T) {
+	var blocks []*pbbstream.Block
+	prevID := "00"
+	for num := uint64(1); num <= 99; num++ {
+		blk := TestBlockWithTimestamp(fmt.Sprintf("%da", num), prevID, time.Unix(1700000000+int64(num), 0))
+		blk.Number = num
+		blk.ParentNum = num - 1
+		blk.Payload.Value = bytes.Repeat([]byte{byte(num)}, 512)
+		blocks = append(blocks, blk)
+		prevID = blk.Id
+	}
+
+	tests := []struct {
+		compression BundleCompression
+		level       int
+	}{
+		{BundleCompressionZstd, 0},
+		{BundleCompressionZstd, 1},
+		{BundleCompressionZstd, 19},
+		{BundleCompressionGzip, 0},
+		{BundleCompressionGzip, 9},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s level %d", test.compression, test.level), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := CompressedBlockWriterFactory(test.compression, test.level).New(buf)
+			require.NoError(t, err)
+			for _, blk := range blocks {
+				require.NoError(t, writer.Write(blk))
+			}
+			require.NoError(t, writer.Close())
+			assert.Less(t, buf.Len(), len(testBlocks(blocks...)))
+
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(0), buf.Bytes())
+
+			var received []*pbbstream.Block
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk)
+				return nil
+			})
+			fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithStopBlock(99))
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			require.Len(t, received, len(blocks))
+			for i := range blocks {
+				assert.True(t, proto.Equal(blocks[i], received[i]), "block #%d differs", blocks[i].Number)
+			}
+		})
+	}
+}
+
+func TestCompression_Unsupported(t *testing.T) {
+	_, err := NewCompressedBlockWriter(&bytes.Buffer{}, BundleCompressionNone, 0)
+	assert.EqualError(t, err, `unsupported compression "none" for writing, use gzip or zstd`)
+
+	xz := append([]byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}, testBlocks(TestBlockWithNumbers("1a", "00", 1, 0))...)
+	_, err = decompressedReader(bytes.NewReader(xz), BundleCompressionAuto)
+	assert.EqualError(t, err, `detecting compression: unsupported compression "xz"`)
+
+	// files shorter than the magics are read as is
+	reader, err := decompressedReader(bytes.NewReader([]byte{0x42}), BundleCompressionAuto)
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x42}, content)
+}
@@ -1,11 +1,14 @@
 package bstream
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/streamingfast/opaque"
+	"go.uber.org/zap/zapcore"
 )
 
 type Cursor struct {
@@ -20,17 +23,54 @@ type Cursor struct {
 	HeadBlock BlockRef
 }
 
+// ErrEmptyCursor is the error of the sources asked to resume from, or stream
+// through, a nil or empty cursor: use the sources from a block number instead.
+var ErrEmptyCursor = errors.New("empty cursor")
+
+// EmptyCursor is the cursor of the objects that have none, like the blocks sent
+// before the first LIB is known. Check for it with IsEmpty, which also matches
+// the nil cursor, rather than comparing pointers.
 var EmptyCursor = &Cursor{
 	Block:     BlockRefEmpty,
 	HeadBlock: BlockRefEmpty,
 	LIB:       BlockRefEmpty,
 }
 
+// NewLIBOnlyCursor returns a cursor on the irreversible block `lib`, for the
+// consumers keeping track of the final blocks only. Resuming from it sends the
+// blocks following `lib`, without trying to resolve forks.
+func NewLIBOnlyCursor(lib BlockRef) *Cursor {
+	return &Cursor{
+		Step:      StepNewIrreversible,
+		Block:     lib,
+		HeadBlock: lib,
+		LIB:       lib,
+	}
+}
+
+// IsLIBOnly returns true when the block and head block of the cursor are its
+// LIB, like the cursors of NewLIBOnlyCursor: there is no fork to resolve to
+// resume from it.
+func (c *Cursor) IsLIBOnly() bool {
+	return !c.isEmptyValue() &&
+		!c.Step.Matches(StepUndo) &&
+		sameBlockRef(c.Block, c.LIB) &&
+		sameBlockRef(c.HeadBlock, c.LIB)
+}
+
 func (c *Cursor) IsOnFinalBlock() bool {
+	if c.IsEmpty() {
+		return false
+	}
 	return c.Block.Num() == c.LIB.Num() && c.Step.Matches(StepIrreversible)
 }
 
+// ToOpaque encodes the cursor as an opaque URL-safe string, decoded by
+// CursorFromString, the empty cursor is the empty string.
 func (c *Cursor) ToOpaque() string {
+	if c.isEmptyValue() {
+		return ""
+	}
 	return opaque.EncodeString(c.String())
 }
 
@@ -42,15 +82,89 @@ func CursorFromOpaque(in string) (*Cursor, error) {
 	return FromString(payload)
 }
 
+// Equals returns true when both cursors have the same step, block, head block
+// and LIB. A nil cursor equals EmptyCursor.
 func (c *Cursor) Equals(cc *Cursor) bool {
-	if c.IsEmpty() {
-		return cc.IsEmpty()
+	if c.isEmptyValue() || cc.isEmptyValue() {
+		return c.isEmptyValue() && cc.isEmptyValue()
+	}
+	return c.Step == cc.Step &&
+		sameBlockRef(c.Block, cc.Block) &&
+		sameBlockRef(c.HeadBlock, cc.HeadBlock) &&
+		sameBlockRef(c.LIB, cc.LIB)
+}
+
+// IsOnSameChainAs returns true when the block of one of the cursors is the block
+// of the other one or one of its ancestors, with both cursors having the same LIB.
+// When it cannot be told from the cursors alone, as for two different blocks above
+// the LIB, it returns false.
+func (c *Cursor) IsOnSameChainAs(other *Cursor) bool {
+	if c.isEmptyValue() || other.isEmptyValue() {
+		return false
+	}
+	if !sameBlockRef(c.LIB, other.LIB) {
+		return false
+	}
+	if sameBlockRef(c.Block, other.Block) {
+		return true
+	}
+	// an undone block is not on the chain anymore
+	if c.Step.Matches(StepUndo) || other.Step.Matches(StepUndo) {
+		return false
 	}
-	return c.Block.ID() == cc.Block.ID() &&
-		c.HeadBlock.ID() == cc.HeadBlock.ID() &&
-		c.LIB.ID() == cc.LIB.ID()
+	// the LIB is an ancestor of all the blocks following it
+	return sameBlockRef(c.Block, c.LIB) || sameBlockRef(other.Block, other.LIB)
 }
 
+// BlocksBehind returns how many blocks the cursor is behind the head block of
+// `head`, the current head cursor. It only compares the block numbers, assuming
+// both cursors are on the same chain, and is not meaningful, returning false,
+// when either cursor is empty or the cursor is past the head block. The block
+// of an undo cursor was undone, its consumer is at the block before it.
+func (c *Cursor) BlocksBehind(head *Cursor) (int64, bool) {
+	if c.IsEmpty() || head.IsEmpty() {
+		return 0, false
+	}
+	position := c.position()
+	if position > head.HeadBlock.Num() {
+		return 0, false
+	}
+	return int64(head.HeadBlock.Num() - position), true
+}
+
+// IsAheadOf returns true when the consumer of the cursor is at a higher block
+// number than the one of `other`, like BlocksBehind it does not tell whether
+// the blocks are on the same chain. It is false when either cursor is empty.
+func (c *Cursor) IsAheadOf(other *Cursor) bool {
+	if c.IsEmpty() || other.IsEmpty() {
+		return false
+	}
+	return c.position() > other.position()
+}
+
+// position is the number of the last block the consumer of the cursor has
+// applied: the block before the cursor block on an undo step
+func (c *Cursor) position() uint64 {
+	if c.Step.Matches(StepUndo) && c.Block.Num() > 0 {
+		return c.Block.Num() - 1
+	}
+	return c.Block.Num()
+}
+
+func sameBlockRef(a, b BlockRef) bool {
+	return a.Num() == b.Num() && a.ID() == b.ID()
+}
+
+// isEmptyValue is true for the nil cursor and EmptyCursor
+func (c *Cursor) isEmptyValue() bool {
+	if c == nil || c.Block == nil || c.HeadBlock == nil || c.LIB == nil {
+		return true
+	}
+	return c.String() == EmptyCursor.String()
+}
+
+// IsEmpty returns true for the nil cursor, EmptyCursor and the cursors missing
+// one of their block references or its ID. It is safe to call on a nil cursor.
 func (c *Cursor) IsEmpty() bool {
 	return c == nil ||
 		c.Block == nil ||
@@ -61,7 +175,49 @@ func (c *Cursor) IsEmpty() bool {
 		c.LIB.ID() == ""
 }
 
+// Summary returns the cursor for the logs, like `NEW blk=#123 (d46eeb12…5a35)
+// head=#125 lib=#100`, with the IDs shortened by shortBlockID. It is `EMPTY` for
+// the nil and empty cursors, see String for the decodable form.
+func (c *Cursor) Summary() string {
+	if c.IsEmpty() {
+		return "EMPTY"
+	}
+	out := fmt.Sprintf("%s blk=#%d", strings.ToUpper(c.Step.String()), c.Block.Num())
+	if id := shortBlockID(c.Block.ID()); id != "" {
+		out += " (" + id + ")"
+	}
+	return fmt.Sprintf("%s head=#%d lib=#%d", out, c.HeadBlock.Num(), c.LIB.Num())
+}
+
+// shortBlockID keeps the first 8 and last 4 characters of the IDs longer than that
+func shortBlockID(id string) string {
+	if len(id) <= 12 {
+		return id
+	}
+	return id[:8] + "…" + id[len(id)-4:]
+}
+
+// MarshalLogObject logs the cursor as discrete fields, the empty cursor has no
+// IDs and its numbers are 0
+func (c *Cursor) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if c == nil || c.Block == nil || c.HeadBlock == nil || c.LIB == nil {
+		c = EmptyCursor
+	}
+	enc.AddString("step", c.Step.String())
+	enc.AddUint64("block_num", c.Block.Num())
+	enc.AddString("block_id", c.Block.ID())
+	enc.AddUint64("head_num", c.HeadBlock.Num())
+	enc.AddUint64("lib_num", c.LIB.Num())
+	return nil
+}
+
+// String returns the versioned c1/c2/c3 form of the cursor decoded by
+// CursorFromString and FromString, the one of EmptyCursor for the nil cursor and
+// the cursors missing a block reference. It is stable and can be persisted.
 func (c *Cursor) String() string {
+	if c == nil || c.Block == nil || c.HeadBlock == nil || c.LIB == nil {
+		c = EmptyCursor
+	}
 	blkID := c.Block.ID()
 	headID := c.HeadBlock.ID()
 	libID := c.LIB.ID()
@@ -74,31 +230,63 @@ func (c *Cursor) String() string {
 	return fmt.Sprintf("c3:%d:%d:%s:%d:%s:%d:%s", c.Step, c.Block.Num(), blkID, c.HeadBlock.Num(), headID, c.LIB.Num(), libID)
 }
 
+// cursorSegments is the amount of segments of each version of the cursor string
+// format, c1 omits the head block (the block itself), c2 omits the LIB (the
+// block itself) and c3 has all of them
+var cursorSegments = map[string]int{
+	"c1": 6,
+	"c2": 6,
+	"c3": 8,
+}
+
+// CursorFromString decodes a cursor encoded with String or ToOpaque, the empty
+// string being the empty cursor. Its errors describe what is malformed.
+func CursorFromString(s string) (*Cursor, error) {
+	if s == "" {
+		return newEmptyCursor(), nil
+	}
+	// the opaque form is URL-safe base64, which never contains a colon
+	if strings.Contains(s, ":") {
+		return FromString(s)
+	}
+
+	payload, err := opaque.DecodeToString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor encoding: %w", err)
+	}
+	return FromString(payload)
+}
+
 func FromString(cur string) (*Cursor, error) {
-	parts := strings.Split(cur, ":")
-	if len(parts) < 6 {
-		return nil, fmt.Errorf("invalid cursor: too short")
+	if cur == EmptyCursor.String() {
+		return newEmptyCursor(), nil
 	}
 
-	switch parts[0] {
-	case "c1":
-		if len(parts) != 6 {
-			return nil, fmt.Errorf("invalid cursor: invalid number of segments")
-		}
+	parts := strings.Split(cur, ":")
+	version := parts[0]
+	segments, found := cursorSegments[version]
+	if !found {
+		return nil, fmt.Errorf("invalid cursor: unknown version %q", version)
+	}
+	if len(parts) != segments {
+		return nil, fmt.Errorf("invalid cursor: version %s has %d segments, got %d", version, segments, len(parts))
+	}
 
-		step, err := readCursorStep(parts[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid step segment: %w", err)
-		}
+	step, err := readCursorStep(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid step segment: %w", err)
+	}
 
-		blkRef, err := readCursorBlockRef(parts[2], parts[3])
-		if err != nil {
-			return nil, fmt.Errorf("invalid block ref segments: %w", err)
-		}
+	blkRef, err := readCursorBlockRef(parts[2], parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid block ref segments: %w", err)
+	}
 
+	switch version {
+	case "c1":
 		libRef, err := readCursorBlockRef(parts[4], parts[5])
 		if err != nil {
-			return nil, fmt.Errorf("invalid block ref segments: %w", err)
+			return nil, fmt.Errorf("invalid LIB ref segments: %w", err)
 		}
 
 		return &Cursor{
@@ -109,20 +297,6 @@ func FromString(cur string) (*Cursor, error) {
 		}, nil
 
 	case "c2":
-		if len(parts) != 6 {
-			return nil, fmt.Errorf("invalid cursor: invalid number of segments")
-		}
-
-		step, err := readCursorStep(parts[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid step segment: %w", err)
-		}
-
-		blkRef, err := readCursorBlockRef(parts[2], parts[3])
-		if err != nil {
-			return nil, fmt.Errorf("invalid block ref segments: %w", err)
-		}
-
 		headBlkRef, err := readCursorBlockRef(parts[4], parts[5])
 		if err != nil {
 			return nil, fmt.Errorf("invalid head block ref segments: %w", err)
@@ -135,21 +309,7 @@ func FromString(cur string) (*Cursor, error) {
 			LIB:       blkRef,
 		}, nil
 
-	case "c3":
-		if len(parts) != 8 {
-			return nil, fmt.Errorf("invalid cursor: invalid number of segments")
-		}
-
-		step, err := readCursorStep(parts[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid step segment: %w", err)
-		}
-
-		blkRef, err := readCursorBlockRef(parts[2], parts[3])
-		if err != nil {
-			return nil, fmt.Errorf("invalid block ref segments: %w", err)
-		}
-
+	default:
 		headBlkRef, err := readCursorBlockRef(parts[4], parts[5])
 		if err != nil {
 			return nil, fmt.Errorf("invalid head block ref segments: %w", err)
@@ -157,7 +317,7 @@ func FromString(cur string) (*Cursor, error) {
 
 		libRef, err := readCursorBlockRef(parts[6], parts[7])
 		if err != nil {
-			return nil, fmt.Errorf("invalid block ref segments: %w", err)
+			return nil, fmt.Errorf("invalid LIB ref segments: %w", err)
 		}
 
 		return &Cursor{
@@ -166,17 +326,21 @@ func FromString(cur string) (*Cursor, error) {
 			HeadBlock: headBlkRef,
 			LIB:       libRef,
 		}, nil
-
-	default:
-		return nil, fmt.Errorf("invalid cursor: invalid prefix")
 	}
+}
 
+func newEmptyCursor() *Cursor {
+	return &Cursor{
+		Block:     BlockRefEmpty,
+		HeadBlock: BlockRefEmpty,
+		LIB:       BlockRefEmpty,
+	}
 }
 
 func readCursorBlockRef(numStr string, id string) (BlockRef, error) {
 	num, err := strconv.ParseUint(numStr, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid block num: %w", err)
+		return nil, fmt.Errorf("invalid block num %q: %w", numStr, err)
 	}
 
 	return NewBlockRef(id, num), nil
@@ -185,17 +349,129 @@ func readCursorBlockRef(numStr string, id string) (BlockRef, error) {
 func readCursorStep(part string) (StepType, error) {
 	step, err := strconv.ParseInt(part, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid cursor step: %w", err)
+		return 0, fmt.Errorf("invalid cursor step %q: %w", part, err)
 	}
 	out := StepType(step)
-
-	if out != StepNew &&
-		out != StepUndo &&
-		out != StepIrreversible &&
-		out != StepNewIrreversible {
+	if !isCursorStep(out) {
 		return 0, fmt.Errorf("invalid step: %d", step)
 	}
 
 	return out, nil
 
 }
+
+// isCursorStep is true for the steps a cursor can be at
+func isCursorStep(step StepType) bool {
+	return step == StepNew ||
+		step == StepUndo ||
+		step == StepIrreversible ||
+		step == StepNewIrreversible ||
+		step == StepStalled
+}
+
+// Validate checks that the cursor is coherent: its block references have IDs
+// unless their number is 0, the LIB is at or below the block, the block is at
+// or below the head block and its step is one a cursor can be at. The block of
+// an undo step is above the head block when the undone blocks were truncated.
+// The empty cursor is valid.
+func (c *Cursor) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Block == nil && c.HeadBlock == nil && c.LIB == nil && c.Step == 0 {
+		return nil
+	}
+
+	refs := []struct {
+		name string
+		ref  BlockRef
+	}{
+		{"block", c.Block},
+		{"head block", c.HeadBlock},
+		{"LIB", c.LIB},
+	}
+	for _, r := range refs {
+		if r.ref == nil {
+			return fmt.Errorf("missing %s", r.name)
+		}
+		if r.ref.ID() == "" && r.ref.Num() != 0 {
+			return fmt.Errorf("%s #%d has no ID", r.name, r.ref.Num())
+		}
+	}
+	if c.isEmptyValue() {
+		return nil
+	}
+
+	if !isCursorStep(c.Step) {
+		return fmt.Errorf("invalid step %d (%s)", c.Step, c.Step)
+	}
+	if c.LIB.Num() > c.Block.Num() {
+		return fmt.Errorf("LIB #%d is above block #%d", c.LIB.Num(), c.Block.Num())
+	}
+	if c.Block.Num() > c.HeadBlock.Num() && !c.Step.Matches(StepUndo) {
+		return fmt.Errorf("block #%d is above head block #%d", c.Block.Num(), c.HeadBlock.Num())
+	}
+	return nil
+}
+
+type jsonBlockRef struct {
+	ID  string `json:"id"`
+	Num uint64 `json:"num"`
+}
+
+type jsonCursor struct {
+	Step      string       `json:"step"`
+	Block     jsonBlockRef `json:"block"`
+	HeadBlock jsonBlockRef `json:"head_block"`
+	LIB       jsonBlockRef `json:"lib"`
+}
+
+// MarshalJSON encodes the cursor with its step in the form of StepType's String,
+// the nil cursor is encoded as null
+func (c *Cursor) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("null"), nil
+	}
+	toJSONRef := func(ref BlockRef) jsonBlockRef {
+		if ref == nil {
+			return jsonBlockRef{}
+		}
+		return jsonBlockRef{ID: ref.ID(), Num: ref.Num()}
+	}
+
+	return json.Marshal(&jsonCursor{
+		Step:      c.Step.String(),
+		Block:     toJSONRef(c.Block),
+		HeadBlock: toJSONRef(c.HeadBlock),
+		LIB:       toJSONRef(c.LIB),
+	})
+}
+
+// UnmarshalJSON decodes a cursor encoded by MarshalJSON, its block references are
+// BasicBlockRef, or BlockRefEmpty when they have no ID and number
+func (c *Cursor) UnmarshalJSON(data []byte) error {
+	var in jsonCursor
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	step, err := parseStepType(in.Step)
+	if err != nil {
+		return fmt.Errorf("invalid cursor step: %w", err)
+	}
+
+	fromJSONRef := func(ref jsonBlockRef) BlockRef {
+		if ref.ID == "" && ref.Num == 0 {
+			return BlockRefEmpty
+		}
+		return NewBlockRef(ref.ID, ref.Num)
+	}
+
+	*c = Cursor{
+		Step:      step,
+		Block:     fromJSONRef(in.Block),
+		HeadBlock: fromJSONRef(in.HeadBlock),
+		LIB:       fromJSONRef(in.LIB),
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package bstream
+
+import (
+	"fmt"
+
+	pbcursor "github.com/streamingfast/bstream/pb/sf/bstream/cursor/v1"
+)
+
+// ToProto returns the structured form of the cursor, nil for the nil cursor. Its
+// chain ID is left to the caller.
+func (c *Cursor) ToProto() *pbcursor.Cursor {
+	if c == nil {
+		return nil
+	}
+
+	toProtoRef := func(ref BlockRef) *pbcursor.BlockRef {
+		if ref == nil {
+			return nil
+		}
+		return &pbcursor.BlockRef{Num: ref.Num(), Id: ref.ID()}
+	}
+
+	return &pbcursor.Cursor{
+		Step:      pbcursor.Step(c.Step),
+		Block:     toProtoRef(c.Block),
+		HeadBlock: toProtoRef(c.HeadBlock),
+		Lib:       toProtoRef(c.LIB),
+	}
+}
+
+// CursorFromProto is the reverse of ToProto, the nil and zero messages are the
+// empty cursor. The cursor is checked like Cursor.Validate does.
+func CursorFromProto(p *pbcursor.Cursor) (*Cursor, error) {
+	if p == nil {
+		return newEmptyCursor(), nil
+	}
+
+	fromProtoRef := func(ref *pbcursor.BlockRef) BlockRef {
+		if ref == nil {
+			return nil
+		}
+		if ref.Id == "" && ref.Num == 0 {
+			return BlockRefEmpty
+		}
+		return NewBlockRef(ref.Id, ref.Num)
+	}
+
+	c := &Cursor{
+		Step:      StepType(p.Step),
+		Block:     fromProtoRef(p.Block),
+		HeadBlock: fromProtoRef(p.HeadBlock),
+		LIB:       fromProtoRef(p.Lib),
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if c.isEmptyValue() {
+		return newEmptyCursor(), nil
+	}
+	return c, nil
+}
@@ -0,0 +1,71 @@
+package bstream
+
+import (
+	"fmt"
+	"testing"
+
+	pbcursor "github.com/streamingfast/bstream/pb/sf/bstream/cursor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCursor_ProtoRoundTrip(t *testing.T) {
+	for _, step := range []StepType{StepNew, StepUndo, StepIrreversible, StepNewIrreversible, StepStalled} {
+		for _, in := range []string{
+			"c1:%d:10:10a:8:8a",
+			"c2:%d:8:8a:10:10a",
+			"c3:%d:9:9a:10:10a:8:8a",
+		} {
+			cursor, err := FromString(fmt.Sprintf(in, step))
+			require.NoError(t, err)
+
+			data, err := proto.Marshal(cursor.ToProto())
+			require.NoError(t, err)
+			p := &pbcursor.Cursor{}
+			require.NoError(t, proto.Unmarshal(data, p))
+			assert.Equal(t, pbcursor.Step(step), p.Step)
+
+			out, err := CursorFromProto(p)
+			require.NoError(t, err)
+			assert.Equal(t, cursor.String(), out.String())
+			assert.True(t, cursor.Equals(out))
+		}
+	}
+}
+
+func TestCursor_ProtoEmpty(t *testing.T) {
+	assert.Nil(t, (*Cursor)(nil).ToProto())
+
+	for _, p := range []*pbcursor.Cursor{nil, {}, EmptyCursor.ToProto()} {
+		out, err := CursorFromProto(p)
+		require.NoError(t, err)
+		assert.True(t, out.IsEmpty())
+		assert.True(t, out.Equals(EmptyCursor))
+		assert.Equal(t, "", out.ToOpaque())
+	}
+}
+
+func TestCursorFromProto_Errors(t *testing.T) {
+	ref := func(num uint64, id string) *pbcursor.BlockRef {
+		return &pbcursor.BlockRef{Num: num, Id: id}
+	}
+
+	tests := []struct {
+		name        string
+		in          *pbcursor.Cursor
+		expectedErr string
+	}{
+		{"missing lib", &pbcursor.Cursor{Step: pbcursor.Step_STEP_NEW, Block: ref(10, "10a"), HeadBlock: ref(10, "10a")}, "invalid cursor: missing LIB"},
+		{"no id", &pbcursor.Cursor{Step: pbcursor.Step_STEP_NEW, Block: ref(10, ""), HeadBlock: ref(10, "10a"), Lib: ref(8, "8a")}, "invalid cursor: block #10 has no ID"},
+		{"invalid step", &pbcursor.Cursor{Step: pbcursor.Step(3), Block: ref(10, "10a"), HeadBlock: ref(10, "10a"), Lib: ref(8, "8a")}, "invalid cursor: invalid step 3 (new,undo)"},
+		{"lib above block", &pbcursor.Cursor{Step: pbcursor.Step_STEP_NEW, Block: ref(10, "10a"), HeadBlock: ref(10, "10a"), Lib: ref(12, "12a")}, "invalid cursor: LIB #12 is above block #10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CursorFromProto(test.in)
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
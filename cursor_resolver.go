@@ -16,11 +16,56 @@ var _ Stepable = (*wrappedObject)(nil)
 
 var ErrResolveCursor = errors.New("cannot resolve cursor")
 
+// CursorResolutionPolicy is what the cursor resolver does when the path from a
+// forked cursor block to the canonical chain cannot be found in the one-block
+// files, for example when they were pruned.
+type CursorResolutionPolicy int
+
+const (
+	// FailOnMissing fails the stream with ErrResolveCursor
+	FailOnMissing CursorResolutionPolicy = iota
+	// RestartAtCursorLIB sends the blocks following the cursor's LIB as new
+	// irreversible blocks, without undoing the forked blocks
+	RestartAtCursorLIB
+	// RestartAtCursorBlockIfCanonical sends the blocks following the cursor's
+	// block, without undos, when the merged blocks contain the cursor's block at
+	// its height. Such a cursor is always resolved without the one-block files,
+	// so the ones that cannot be resolved fail like FailOnMissing, the fallback
+	// callback telling them apart from the resumed ones.
+	RestartAtCursorBlockIfCanonical
+)
+
+func (p CursorResolutionPolicy) String() string {
+	switch p {
+	case FailOnMissing:
+		return "fail_on_missing"
+	case RestartAtCursorLIB:
+		return "restart_at_cursor_lib"
+	case RestartAtCursorBlockIfCanonical:
+		return "restart_at_cursor_block_if_canonical"
+	}
+	return fmt.Sprintf("unknown(%d)", int(p))
+}
+
+// CursorFallback reports a cursor that could not be resolved and what the
+// resolution policy did about it
+type CursorFallback struct {
+	Cursor *Cursor
+	Policy CursorResolutionPolicy
+	// Err is the failure to resolve the cursor
+	Err error
+	// ResumedAfter is the block after which the blocks are sent, nil when the
+	// stream failed
+	ResumedAfter BlockRef
+}
+
 // cursorResolver is a handler that feeds from a source of new+irreversible blocks (filesource)
 // and keeps blocks in a slice until cursor is passed.
 // when it sees the cursor, it sends whatever is needed to bring the consumer back to a "new and irreversible" head
 type cursorResolver struct {
 	forkedBlocksStore dstore.Store
+	// blockReaderFactory decodes the one-block files of forkedBlocksStore
+	blockReaderFactory BlockReaderFactory
 
 	handler Handler
 	cursor  *Cursor
@@ -28,6 +73,9 @@ type cursorResolver struct {
 
 	passThroughCursor bool
 
+	policy     CursorResolutionPolicy
+	onFallback func(*CursorFallback)
+
 	mergedBlocksSeen []*BlockWithObj
 	resolved         bool
 }
@@ -39,11 +87,12 @@ func newCursorResolverHandler(
 	h Handler,
 	logger *zap.Logger) *cursorResolver {
 	return &cursorResolver{
-		forkedBlocksStore: forkedBlocksStore,
-		passThroughCursor: passThroughCursor,
-		cursor:            cursor,
-		logger:            logger,
-		handler:           h,
+		forkedBlocksStore:  forkedBlocksStore,
+		blockReaderFactory: DBinBlockReaderFactory,
+		passThroughCursor:  passThroughCursor,
+		cursor:             cursor,
+		logger:             logger,
+		handler:            h,
 	}
 }
 
@@ -58,6 +107,15 @@ func (f *cursorResolver) ProcessBlock(blk *pbbstream.Block, obj interface{}) err
 		return f.handler.ProcessBlock(blk, obj)
 	}
 
+	if f.cursor.IsLIBOnly() {
+		// nothing to resolve, the blocks up to the LIB were already received
+		if blk.Number <= f.cursor.LIB.Num() {
+			return nil
+		}
+		f.resolved = true
+		return f.handler.ProcessBlock(blk, obj)
+	}
+
 	if blk.Number < f.cursor.Block.Num() {
 		f.mergedBlocksSeen = append(f.mergedBlocksSeen, &BlockWithObj{blk, obj})
 		return nil
@@ -89,6 +147,9 @@ func (f *cursorResolver) ProcessBlock(blk *pbbstream.Block, obj interface{}) err
 	ctx := context.Background()
 	undoBlocks, reorgJunctionBlock, err := f.resolve(ctx)
 	if err != nil {
+		if errors.Is(err, ErrResolveCursor) {
+			return f.fallback(blk, err)
+		}
 		return err
 	}
 
@@ -108,6 +169,31 @@ func (f *cursorResolver) ProcessBlock(blk *pbbstream.Block, obj interface{}) err
 
 }
 
+// fallback applies the resolution policy once the cursor could not be resolved
+// on `blk` because of `resolveErr`
+func (f *cursorResolver) fallback(blk *pbbstream.Block, resolveErr error) error {
+	var resumedAfter BlockRef
+	if f.policy == RestartAtCursorLIB {
+		resumedAfter = f.cursor.LIB
+	}
+
+	if f.onFallback != nil {
+		f.onFallback(&CursorFallback{
+			Cursor:       f.cursor,
+			Policy:       f.policy,
+			Err:          resolveErr,
+			ResumedAfter: resumedAfter,
+		})
+	}
+	if resumedAfter == nil {
+		return resolveErr
+	}
+
+	f.logger.Warn("unable to resolve cursor, resuming without undoing its blocks", zap.Object("cursor", f.cursor), zap.Stringer("policy", f.policy), zap.Stringer("resumed_after", resumedAfter), zap.Error(resolveErr))
+	f.resolved = true
+	return f.sendMergedBlocksBetween(StepNewIrreversible, resumedAfter.Num(), blk.Number)
+}
+
 func (f *cursorResolver) sendUndoBlocks(undoBlocks []*pbbstream.Block, reorgJunctionBlock BlockRef) error {
 	for _, blk := range undoBlocks {
 		block := blk
@@ -164,7 +250,7 @@ func (f *cursorResolver) download(ctx context.Context, file *OneBlockFile) (*pbb
 	if err != nil {
 		return nil, err
 	}
-	return decodeOneblockfileData(data)
+	return decodeOneblockfileData(f.blockReaderFactory, data)
 }
 
 func (f *cursorResolver) seenIrreversible(id string) *BlockWithObj {
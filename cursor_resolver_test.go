@@ -595,3 +595,119 @@ func TestCursorResolverWithHoles(t *testing.T) {
 	}
 	assert.ErrorIs(t, fs.Err(), errDone)
 }
+
+func TestCursorResolver_ResolutionPolicy(t *testing.T) {
+	forkedCursor := &Cursor{
+		Step:      StepNew,
+		Block:     NewBlockRef("3bbbbbbbbbbbbbbb", 3),
+		HeadBlock: NewBlockRef("3bbbbbbbbbbbbbbb", 3),
+		LIB:       NewBlockRef("1aaaaaaaaaaaaaaa", 1),
+	}
+	canonicalCursor := &Cursor{
+		Step:      StepNew,
+		Block:     NewBlockRef("3aaaaaaaaaaaaaaa", 3),
+		HeadBlock: NewBlockRef("3aaaaaaaaaaaaaaa", 3),
+		LIB:       NewBlockRef("1aaaaaaaaaaaaaaa", 1),
+	}
+
+	cases := []struct {
+		name             string
+		cursor           *Cursor
+		policy           CursorResolutionPolicy
+		expected         []string
+		expectedErr      error
+		expectedFallback *CursorFallback
+	}{
+		{
+			name:             "fail on missing",
+			cursor:           forkedCursor,
+			policy:           FailOnMissing,
+			expectedErr:      ErrResolveCursor,
+			expectedFallback: &CursorFallback{Cursor: forkedCursor, Policy: FailOnMissing},
+		},
+		{
+			name:   "restart at cursor LIB",
+			cursor: forkedCursor,
+			policy: RestartAtCursorLIB,
+			expected: []string{
+				"#2 (2aaaaaaaaaaaaaaa) new,irreversible",
+				"#3 (3aaaaaaaaaaaaaaa) new,irreversible",
+				"#4 (4aaaaaaaaaaaaaaa) new,irreversible",
+			},
+			expectedFallback: &CursorFallback{Cursor: forkedCursor, Policy: RestartAtCursorLIB, ResumedAfter: forkedCursor.LIB},
+		},
+		{
+			name:             "restart at forked cursor block",
+			cursor:           forkedCursor,
+			policy:           RestartAtCursorBlockIfCanonical,
+			expectedErr:      ErrResolveCursor,
+			expectedFallback: &CursorFallback{Cursor: forkedCursor, Policy: RestartAtCursorBlockIfCanonical},
+		},
+		{
+			name:   "restart at canonical cursor block",
+			cursor: canonicalCursor,
+			policy: RestartAtCursorBlockIfCanonical,
+			expected: []string{
+				"#2 (2aaaaaaaaaaaaaaa) irreversible",
+				"#3 (3aaaaaaaaaaaaaaa) irreversible",
+				"#4 (4aaaaaaaaaaaaaaa) new,irreversible",
+			},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			merged := dstore.NewMockStore(nil)
+			merged.SetFile(base(0), testBlocks(
+				TestBlockWithNumbers("1aaaaaaaaaaaaaaa", "0aaaaaaaaaaaaaaa", 1, 0),
+				TestBlockWithNumbers("2aaaaaaaaaaaaaaa", "1aaaaaaaaaaaaaaa", 2, 1),
+				TestBlockWithNumbers("3aaaaaaaaaaaaaaa", "2aaaaaaaaaaaaaaa", 3, 1),
+				TestBlockWithNumbers("4aaaaaaaaaaaaaaa", "3aaaaaaaaaaaaaaa", 4, 2),
+			))
+			// the one-block files were pruned
+			forked := dstore.NewMockStore(nil)
+
+			var received []string
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.AsRef().String()+" "+obj.(Stepable).Step().String())
+				if blk.Number == 4 {
+					return errDone
+				}
+				return nil
+			})
+
+			var fallback *CursorFallback
+			onFallback := func(f *CursorFallback) {
+				fallback = f
+			}
+
+			fs := NewFileSourceFromCursor(merged, forked, test.cursor, handler, zlog, FileSourceWithCursorResolutionPolicy(test.policy, onFallback))
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, fs.Err(), test.expectedErr)
+			} else {
+				assert.ErrorIs(t, fs.Err(), errDone)
+			}
+			assert.Equal(t, test.expected, received)
+
+			if test.expectedFallback == nil {
+				assert.Nil(t, fallback)
+				return
+			}
+			require.NotNil(t, fallback)
+			assert.ErrorIs(t, fallback.Err, ErrResolveCursor)
+			fallback.Err = nil
+			assert.Equal(t, test.expectedFallback, fallback)
+		})
+	}
+}
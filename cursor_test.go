@@ -1,10 +1,13 @@
 package bstream
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestFromString(t *testing.T) {
@@ -67,3 +70,355 @@ func TestFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestCursorFromString_RoundTrip(t *testing.T) {
+	blk := NewBlockRef("00000010a", 16)
+	head := NewBlockRef("00000012a", 18)
+	lib := NewBlockRef("0000000aa", 10)
+
+	layouts := []struct {
+		name   string
+		cursor func(step StepType) *Cursor
+	}{
+		{"head is block", func(step StepType) *Cursor { return &Cursor{Step: step, Block: blk, HeadBlock: blk, LIB: lib} }},
+		{"LIB is block", func(step StepType) *Cursor { return &Cursor{Step: step, Block: blk, HeadBlock: head, LIB: blk} }},
+		{"all different", func(step StepType) *Cursor { return &Cursor{Step: step, Block: blk, HeadBlock: head, LIB: lib} }},
+	}
+
+	for _, step := range []StepType{StepNew, StepUndo, StepIrreversible, StepNewIrreversible, StepStalled} {
+		for _, layout := range layouts {
+			t.Run(step.String()+" "+layout.name, func(t *testing.T) {
+				cursor := layout.cursor(step)
+
+				actual, err := CursorFromString(cursor.ToOpaque())
+				require.NoError(t, err)
+				assert.Equal(t, cursor, actual)
+
+				actual, err = CursorFromString(cursor.String())
+				require.NoError(t, err)
+				assert.Equal(t, cursor, actual)
+
+				actual, err = FromString(cursor.String())
+				require.NoError(t, err)
+				assert.Equal(t, cursor, actual)
+			})
+		}
+	}
+}
+
+func TestCursorFromString_Empty(t *testing.T) {
+	assert.Equal(t, "", EmptyCursor.ToOpaque())
+	assert.Equal(t, "", (*Cursor)(nil).ToOpaque())
+
+	actual, err := CursorFromString(EmptyCursor.ToOpaque())
+	require.NoError(t, err)
+	assert.True(t, actual.IsEmpty())
+	assert.Equal(t, EmptyCursor, actual)
+	// the empty cursor is shared, decoding it must not return it
+	assert.NotSame(t, EmptyCursor, actual)
+
+	actual, err = CursorFromString(EmptyCursor.String())
+	require.NoError(t, err)
+	assert.Equal(t, EmptyCursor, actual)
+	assert.NotSame(t, EmptyCursor, actual)
+}
+
+func TestCursorFromString_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		opaque      bool
+		expectedErr string
+	}{
+		{"not opaque", "c1.1.16.00000010a.10.0000000aa", true, "invalid cursor encoding: illegal base64 data at input byte 2"},
+		{"bad version through CursorFromString", "c9:1:16:00000010a:10:0000000aa", true, `invalid cursor: unknown version "c9"`},
+		{"bad version", "c9:1:16:00000010a:10:0000000aa", false, `invalid cursor: unknown version "c9"`},
+		{"no version", "", false, `invalid cursor: unknown version ""`},
+		{"wrong field count", "c3:1:16:00000010a:10:0000000aa", false, "invalid cursor: version c3 has 8 segments, got 6"},
+		{"too many fields", "c1:1:16:00000010a:10:0000000aa:12", false, "invalid cursor: version c1 has 6 segments, got 7"},
+		{"non-numeric step", "c1:new:16:00000010a:10:0000000aa", false, `invalid step segment: invalid cursor step "new": strconv.ParseInt: parsing "new": invalid syntax`},
+		{"unknown step", "c1:7:16:00000010a:10:0000000aa", false, "invalid step segment: invalid step: 7"},
+		{"non-numeric block num", "c1:1:1x:00000010a:10:0000000aa", false, `invalid block ref segments: invalid block num "1x": strconv.ParseUint: parsing "1x": invalid syntax`},
+		{"non-numeric head block num", "c2:1:16:00000010a:-18:00000012a", false, `invalid head block ref segments: invalid block num "-18": strconv.ParseUint: parsing "-18": invalid syntax`},
+		{"non-numeric LIB num", "c3:1:16:00000010a:18:00000012a::0000000aa", false, `invalid LIB ref segments: invalid block num "": strconv.ParseUint: parsing "": invalid syntax`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			if test.opaque {
+				_, err = CursorFromString(test.in)
+			} else {
+				_, err = FromString(test.in)
+			}
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestCursor_Equals(t *testing.T) {
+	blk := NewBlockRef("00000010a", 16)
+	lib := NewBlockRef("0000000aa", 10)
+	cursor := &Cursor{Step: StepNew, Block: blk, HeadBlock: blk, LIB: lib}
+
+	tests := []struct {
+		name     string
+		a, b     *Cursor
+		expected bool
+	}{
+		{"both nil", nil, nil, true},
+		{"nil and empty", nil, EmptyCursor, true},
+		{"empty and nil", EmptyCursor, nil, true},
+		{"nil and set", nil, cursor, false},
+		{"set and nil", cursor, nil, false},
+		{"empty and set", EmptyCursor, cursor, false},
+		{"equal", cursor, &Cursor{Step: StepNew, Block: NewBlockRef("00000010a", 16), HeadBlock: blk, LIB: lib}, true},
+		{"different step", cursor, &Cursor{Step: StepUndo, Block: blk, HeadBlock: blk, LIB: lib}, false},
+		{"different block", cursor, &Cursor{Step: StepNew, Block: NewBlockRef("00000010b", 16), HeadBlock: blk, LIB: lib}, false},
+		{"different head block", cursor, &Cursor{Step: StepNew, Block: blk, HeadBlock: NewBlockRef("00000012a", 18), LIB: lib}, false},
+		{"different LIB num", cursor, &Cursor{Step: StepNew, Block: blk, HeadBlock: blk, LIB: NewBlockRef("0000000aa", 11)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.a.Equals(test.b))
+		})
+	}
+}
+
+func TestCursor_IsOnSameChainAs(t *testing.T) {
+	lib := NewBlockRef("0000000aa", 10)
+	atLIB := &Cursor{Step: StepNewIrreversible, Block: lib, HeadBlock: lib, LIB: lib}
+	blk := NewBlockRef("00000010a", 16)
+	ahead := &Cursor{Step: StepNew, Block: blk, HeadBlock: blk, LIB: lib}
+	aheadAgain := &Cursor{Step: StepNew, Block: NewBlockRef("00000012a", 18), HeadBlock: NewBlockRef("00000012a", 18), LIB: lib}
+	forked := &Cursor{Step: StepNew, Block: NewBlockRef("00000010b", 16), HeadBlock: NewBlockRef("00000010b", 16), LIB: lib}
+	undone := &Cursor{Step: StepUndo, Block: blk, HeadBlock: NewBlockRef("00000010b", 16), LIB: lib}
+	otherLIB := &Cursor{Step: StepNew, Block: blk, HeadBlock: blk, LIB: NewBlockRef("0000000ba", 11)}
+
+	tests := []struct {
+		name     string
+		a, b     *Cursor
+		expected bool
+	}{
+		{"nil", nil, ahead, false},
+		{"to nil", ahead, nil, false},
+		{"empty", EmptyCursor, ahead, false},
+		{"both empty", EmptyCursor, EmptyCursor, false},
+		{"equal", ahead, ahead, true},
+		{"same block other step", ahead, undone, true},
+		{"ahead of LIB", atLIB, ahead, true},
+		{"behind", ahead, atLIB, true},
+		{"forked", ahead, forked, false},
+		{"undecidable above LIB", ahead, aheadAgain, false},
+		{"undone block", atLIB, undone, false},
+		{"different LIBs", ahead, otherLIB, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.a.IsOnSameChainAs(test.b))
+		})
+	}
+}
+
+func TestCursor_BlocksBehind(t *testing.T) {
+	cursor := func(step StepType, blockNum, headNum, libNum uint64) *Cursor {
+		return &Cursor{
+			Step:      step,
+			Block:     NewBlockRef(fmt.Sprintf("%08xa", blockNum), blockNum),
+			HeadBlock: NewBlockRef(fmt.Sprintf("%08xa", headNum), headNum),
+			LIB:       NewBlockRef(fmt.Sprintf("%08xa", libNum), libNum),
+		}
+	}
+	head := cursor(StepNew, 20, 20, 15)
+
+	tests := []struct {
+		name           string
+		c, head        *Cursor
+		expected       int64
+		expectedOK     bool
+		expectedAhead  bool
+		expectedBehind bool
+	}{
+		{"nil", nil, head, 0, false, false, false},
+		{"nil head", head, nil, 0, false, false, false},
+		{"empty", EmptyCursor, head, 0, false, false, false},
+		{"at head", head, head, 0, true, false, false},
+		{"behind", cursor(StepNew, 12, 12, 10), head, 8, true, false, true},
+		{"irreversible", cursor(StepIrreversible, 15, 20, 15), head, 5, true, false, true},
+		{"past head", cursor(StepNew, 22, 22, 15), head, 0, false, true, false},
+		{"undo above its head block", cursor(StepUndo, 21, 19, 15), head, 0, true, false, false},
+		{"undo below head", cursor(StepUndo, 18, 17, 15), head, 3, true, false, true},
+		{"head in undo", cursor(StepNew, 17, 17, 15), cursor(StepUndo, 20, 18, 15), 1, true, false, true},
+		{"past head in undo", cursor(StepNew, 19, 19, 15), cursor(StepUndo, 20, 18, 15), 0, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			behind, ok := test.c.BlocksBehind(test.head)
+			assert.Equal(t, test.expected, behind)
+			assert.Equal(t, test.expectedOK, ok)
+			assert.Equal(t, test.expectedAhead, test.c.IsAheadOf(test.head))
+			assert.Equal(t, test.expectedBehind, test.head.IsAheadOf(test.c))
+		})
+	}
+}
+
+func TestCursor_Summary(t *testing.T) {
+	longID := "d46eeb12ad30ef291a673329bb2f64bc689f15253371b64aaee017556ee95a35"
+	tests := []struct {
+		name     string
+		in       *Cursor
+		expected string
+	}{
+		{"nil", nil, "EMPTY"},
+		{"empty", EmptyCursor, "EMPTY"},
+		{"long ID", &Cursor{Step: StepNew, Block: NewBlockRef(longID, 123), HeadBlock: NewBlockRef(longID, 123), LIB: NewBlockRef("00000064a", 100)}, "NEW blk=#123 (d46eeb12…5a35) head=#123 lib=#100"},
+		{"short ID", &Cursor{Step: StepUndo, Block: NewBlockRef("0000007ba", 123), HeadBlock: NewBlockRef("0000007db", 125), LIB: NewBlockRef("00000064a", 100)}, "UNDO blk=#123 (0000007ba) head=#125 lib=#100"},
+		{"combined step", &Cursor{Step: StepNewIrreversible, Block: NewBlockRef("00000064a", 100), HeadBlock: NewBlockRef("00000064a", 100), LIB: NewBlockRef("00000064a", 100)}, "NEW,IRREVERSIBLE blk=#100 (00000064a) head=#100 lib=#100"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.in.Summary())
+		})
+	}
+}
+
+func TestCursor_MarshalLogObject(t *testing.T) {
+	cursor := &Cursor{Step: StepUndo, Block: NewBlockRef("0000007ba", 123), HeadBlock: NewBlockRef("0000007db", 125), LIB: NewBlockRef("00000064a", 100)}
+
+	enc := zapcore.NewMapObjectEncoder()
+	require.NoError(t, cursor.MarshalLogObject(enc))
+	assert.Equal(t, map[string]interface{}{
+		"step":      "undo",
+		"block_num": uint64(123),
+		"block_id":  "0000007ba",
+		"head_num":  uint64(125),
+		"lib_num":   uint64(100),
+	}, enc.Fields)
+
+	enc = zapcore.NewMapObjectEncoder()
+	require.NoError(t, (*Cursor)(nil).MarshalLogObject(enc))
+	assert.Equal(t, "none", enc.Fields["step"])
+	assert.Equal(t, uint64(0), enc.Fields["block_num"])
+}
+
+func TestCursor_JSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   *Cursor
+		expected string
+	}{
+		{
+			"new",
+			&Cursor{Step: StepNew, Block: NewBlockRef("00000010a", 16), HeadBlock: NewBlockRef("00000012a", 18), LIB: NewBlockRef("0000000aa", 10)},
+			`{"step":"new","block":{"id":"00000010a","num":16},"head_block":{"id":"00000012a","num":18},"lib":{"id":"0000000aa","num":10}}`,
+		},
+		{
+			"new irreversible",
+			&Cursor{Step: StepNewIrreversible, Block: NewBlockRef("00000010a", 16), HeadBlock: NewBlockRef("00000010a", 16), LIB: NewBlockRef("00000010a", 16)},
+			`{"step":"new,irreversible","block":{"id":"00000010a","num":16},"head_block":{"id":"00000010a","num":16},"lib":{"id":"00000010a","num":16}}`,
+		},
+		{
+			"undo",
+			&Cursor{Step: StepUndo, Block: NewBlockRef("00000010b", 16), HeadBlock: NewBlockRef("00000011a", 17), LIB: NewBlockRef("0000000aa", 10)},
+			`{"step":"undo","block":{"id":"00000010b","num":16},"head_block":{"id":"00000011a","num":17},"lib":{"id":"0000000aa","num":10}}`,
+		},
+		{
+			"empty",
+			EmptyCursor,
+			`{"step":"none","block":{"id":"","num":0},"head_block":{"id":"","num":0},"lib":{"id":"","num":0}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := json.Marshal(test.cursor)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(out))
+
+			actual := &Cursor{}
+			require.NoError(t, json.Unmarshal(out, actual))
+			assert.Equal(t, test.cursor, actual)
+		})
+	}
+}
+
+func TestCursor_UnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		expectedErr string
+	}{
+		{"unknown step", `{"step":"NEW","block":{"id":"00000010a","num":16}}`, `invalid cursor step: unknown step "NEW"`},
+		{"unknown combined step", `{"step":"new,final","block":{"id":"00000010a","num":16}}`, `invalid cursor step: unknown step "final"`},
+		{"non-numeric block num", `{"step":"new","block":{"id":"00000010a","num":"16"}}`, "cannot unmarshal string"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := json.Unmarshal([]byte(test.in), &Cursor{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+		})
+	}
+}
+
+func TestCursor_Validate(t *testing.T) {
+	blk := NewBlockRef("00000010a", 16)
+	head := NewBlockRef("00000012a", 18)
+	lib := NewBlockRef("0000000aa", 10)
+
+	tests := []struct {
+		name        string
+		cursor      *Cursor
+		expectedErr string
+	}{
+		{"nil", nil, ""},
+		{"empty", EmptyCursor, ""},
+		{"valid", &Cursor{Step: StepNew, Block: blk, HeadBlock: head, LIB: lib}, ""},
+		{"valid at genesis", &Cursor{Step: StepNewIrreversible, Block: NewBlockRef("00000000a", 0), HeadBlock: NewBlockRef("00000000a", 0), LIB: BlockRefEmpty}, ""},
+		{"undo above head after truncation", &Cursor{Step: StepUndo, Block: head, HeadBlock: blk, LIB: lib}, ""},
+		{"missing head block", &Cursor{Step: StepNew, Block: blk, LIB: lib}, "missing head block"},
+		{"block without ID", &Cursor{Step: StepNew, Block: NewBlockRef("", 16), HeadBlock: head, LIB: lib}, "block #16 has no ID"},
+		{"head block without ID", &Cursor{Step: StepNew, Block: blk, HeadBlock: NewBlockRef("", 18), LIB: lib}, "head block #18 has no ID"},
+		{"LIB without ID", &Cursor{Step: StepNew, Block: blk, HeadBlock: head, LIB: NewBlockRef("", 10)}, "LIB #10 has no ID"},
+		{"LIB above block", &Cursor{Step: StepNew, Block: lib, HeadBlock: head, LIB: blk}, "LIB #16 is above block #10"},
+		{"block above head", &Cursor{Step: StepNew, Block: head, HeadBlock: blk, LIB: lib}, "block #18 is above head block #16"},
+		{"no step", &Cursor{Block: blk, HeadBlock: head, LIB: lib}, "invalid step 0 (none)"},
+		{"filter step", &Cursor{Step: StepsAll, Block: blk, HeadBlock: head, LIB: lib}, "invalid step 51 (new,undo,irreversible,stalled)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cursor.Validate()
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestCursor_NilAndEmpty(t *testing.T) {
+	for _, c := range []*Cursor{nil, EmptyCursor, {}} {
+		assert.True(t, c.IsEmpty())
+		assert.False(t, c.IsOnFinalBlock())
+		assert.False(t, c.IsLIBOnly())
+		assert.Equal(t, EmptyCursor.String(), c.String())
+		assert.Equal(t, "EMPTY", c.Summary())
+		assert.Equal(t, "", c.ToOpaque())
+		assert.True(t, c.Equals(EmptyCursor))
+		assert.NoError(t, c.Validate())
+	}
+
+	var nilCursor *Cursor
+	data, err := nilCursor.MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, "null", string(data))
+
+	assert.False(t, NewLIBOnlyCursor(NewBlockRef("10a", 10)).IsEmpty())
+}
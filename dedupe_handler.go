@@ -0,0 +1,100 @@
+package bstream
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// DedupeHandler drops the blocks given again with the same step, like at the
+// seam of a replay from the blocks files and a live feed, for the handlers which
+// are not idempotent. See NewDedupeHandler.
+type DedupeHandler struct {
+	next       Handler
+	windowSize int
+
+	lock sync.Mutex
+	// seen holds the last sent (block ID, step), the most recent at the front
+	seen    *list.List
+	entries map[dedupeKey]*list.Element
+
+	dropped uint64
+}
+
+type dedupeKey struct {
+	id   string
+	step StepType
+}
+
+// NewDedupeHandler gives the blocks to `next` unless the same block ID was
+// given with the same step among the last `windowSize` blocks, in which case
+// the block is dropped silently (see Dropped). A block with another step is not
+// a repeat: the undo or the irreversible step of a block sent as new goes
+// through. An undo step forgets the new step of the block, which can then be
+// sent again on the chain switching back to it, and the other way around.
+//
+// The step is the one of the objects implementing Stepable, the objects
+// without step are all considered to have the same one. A block whose
+// processing fails is not remembered.
+func NewDedupeHandler(next Handler, windowSize int) *DedupeHandler {
+	if windowSize < 1 {
+		windowSize = 1
+	}
+	return &DedupeHandler{
+		next:       next,
+		windowSize: windowSize,
+		seen:       list.New(),
+		entries:    make(map[dedupeKey]*list.Element),
+	}
+}
+
+func (h *DedupeHandler) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
+	var step StepType
+	if stepable, ok := obj.(Stepable); ok {
+		step = stepable.Step()
+	}
+	key := dedupeKey{id: blk.Id, step: step}
+
+	h.lock.Lock()
+	elem, found := h.entries[key]
+	if found {
+		h.seen.MoveToFront(elem)
+	}
+	h.lock.Unlock()
+	if found {
+		atomic.AddUint64(&h.dropped, 1)
+		return nil
+	}
+
+	if err := h.next.ProcessBlock(blk, obj); err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	switch step {
+	case StepUndo:
+		h.forget(dedupeKey{id: blk.Id, step: StepNew})
+	case StepNew:
+		h.forget(dedupeKey{id: blk.Id, step: StepUndo})
+	}
+	h.entries[key] = h.seen.PushFront(key)
+	for h.seen.Len() > h.windowSize {
+		h.forget(h.seen.Back().Value.(dedupeKey))
+	}
+	return nil
+}
+
+func (h *DedupeHandler) forget(key dedupeKey) {
+	if elem, found := h.entries[key]; found {
+		h.seen.Remove(elem)
+		delete(h.entries, key)
+	}
+}
+
+// Dropped returns the amount of blocks dropped as repeats
+func (h *DedupeHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
@@ -0,0 +1,87 @@
+package bstream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStep is a Stepable object of the given step
+type testStep StepType
+
+func (s testStep) Step() StepType               { return StepType(s) }
+func (s testStep) FinalBlockHeight() uint64     { return 0 }
+func (s testStep) ReorgJunctionBlock() BlockRef { return nil }
+
+func newDedupeRecorder(received *[]string) Handler {
+	return HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		*received = append(*received, fmt.Sprintf("%s:%s", obj.(Stepable).Step(), blk.Id))
+		return nil
+	})
+}
+
+func TestDedupeHandler_Seam(t *testing.T) {
+	var received []string
+	h := NewDedupeHandler(newDedupeRecorder(&received), 10)
+
+	// the replay of the blocks files, then the live feed starting a bit before its end
+	for _, blk := range linearTestBlocks(1, 5) {
+		require.NoError(t, h.ProcessBlock(blk, testStep(StepNew)))
+	}
+	for _, blk := range linearTestBlocks(4, 7) {
+		require.NoError(t, h.ProcessBlock(blk, testStep(StepNew)))
+	}
+
+	assert.Equal(t, []string{"new:01a", "new:02a", "new:03a", "new:04a", "new:05a", "new:06a", "new:07a"}, received)
+	assert.Equal(t, uint64(2), h.Dropped())
+}
+
+func TestDedupeHandler_StepDiffers(t *testing.T) {
+	var received []string
+	h := NewDedupeHandler(newDedupeRecorder(&received), 10)
+
+	blk := TestBlockWithNumbers("05a", "04a", 5, 4)
+	for _, step := range []StepType{StepNew, StepNew, StepUndo, StepUndo, StepNew, StepIrreversible, StepIrreversible} {
+		require.NoError(t, h.ProcessBlock(blk, testStep(step)))
+	}
+
+	// the block comes back after its undo
+	assert.Equal(t, []string{"new:05a", "undo:05a", "new:05a", "irreversible:05a"}, received)
+	assert.Equal(t, uint64(3), h.Dropped())
+}
+
+func TestDedupeHandler_Window(t *testing.T) {
+	var received []string
+	h := NewDedupeHandler(newDedupeRecorder(&received), 2)
+
+	for _, id := range []string{"01a", "02a", "01a", "03a", "02a", "01a"} {
+		require.NoError(t, h.ProcessBlock(TestBlock(id, ""), testStep(StepNew)))
+	}
+
+	// 01a is dropped while among the last 2 blocks seen, then out of the window like 02a
+	assert.Equal(t, []string{"new:01a", "new:02a", "new:03a", "new:02a", "new:01a"}, received)
+	assert.Equal(t, uint64(1), h.Dropped())
+}
+
+func TestDedupeHandler_Failure(t *testing.T) {
+	errFailed := errors.New("failed")
+	calls := 0
+	h := NewDedupeHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		calls++
+		if calls == 1 {
+			return errFailed
+		}
+		return nil
+	}), 10)
+
+	blk := TestBlockWithNumbers("05a", "04a", 5, 4)
+	assert.Equal(t, errFailed, h.ProcessBlock(blk, nil))
+	require.NoError(t, h.ProcessBlock(blk, nil), "given again after its failure")
+	require.NoError(t, h.ProcessBlock(blk, nil))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, uint64(1), h.Dropped())
+}
@@ -16,9 +16,12 @@ package bstream
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,88 +32,763 @@ import (
 	"go.uber.org/zap"
 )
 
-var currentOpenFiles int64
-
 type FileSource struct {
 	*shutter.Shutter
+	fileSourceConfig
 
 	// blocksStore is where we access the blocks archives.
 	blocksStore dstore.Store
 
 	startBlockNum uint64
-	stopBlockNum  uint64
-	bundleSize    uint64
+	// bundleLayout is set when the bundle size is detected from the store layout
+	bundleLayout *bundleLayout
+	// bundleListing answers the existence checks of the blocks archives
+	// from store listings, when far enough from the highest one
+	bundleListing *bundleListing
+	// handlerWatchdog reports handler calls lasting more than the handler
+	// timeout, shutting down the source on shutdownOnHandlerStall
+	handlerWatchdog *handlerWatchdog
+
+	handler Handler
+
+	// after is overridden in tests to control time
+	after func(d time.Duration) <-chan time.Time
+
+	skippedRangesLock sync.Mutex
+	skippedRanges     []*Range
+
+	failedBundlesLock sync.Mutex
+	failedBundles     []uint64
+	failedBundleErrs  []error
+
+	// openFilesSem bounds the amount of blocks archives open at the same time,
+	// a slot is held until all the blocks of the archive were consumed by run()
+	// and the archive is closed
+	openFilesSem chan struct{}
+	openFiles    int64
+
+	// streams are the goroutines reading the blocks archives, the source is
+	// only terminated once they closed their archive, deleting its local file
+	streamsLock sync.Mutex
+	streams     sync.WaitGroup
+
+	// bufferedBytes is the size of the payloads of the blocks decoded from the
+	// archives and not yet given to the handler, it is dropped once run() returns
+	bufferedBytesLock    sync.Mutex
+	bufferedBytes        int
+	bufferedBytesDropped bool
+
+	// cursorErr is the validation error of the cursor of the source, which
+	// fails when started
+	cursorErr error
+
+	nearLive int32
+
+	// ctx is canceled when the source terminates, aborting in-flight downloads
+	ctx context.Context
+
+	// fileStream is a chan of blocks coming from blocks archives, ordered
+	// and parallel processed
+	fileStream chan *incomingBlocksFile
+
+	highestFileProcessedBlockLock sync.RWMutex
+	highestFileProcessedBlock     BlockRef
+
+	// if no blocks match filter in a big range, we will still send "some" blocks to help mark progress
+	// every time we have not matched any blocks for that duration
+	timeBetweenProgressBlocks time.Duration
+
+	logger *zap.Logger
+}
+
+// fileSourceConfig holds the settings of a FileSource set by its options, they
+// can be inspected without building a source, see newFileSourceConfig
+type fileSourceConfig struct {
+	stopBlockNum uint64
+	bundleSize   uint64
+	// detectBundleSize detects the bundle size from the store layout
+	detectBundleSize bool
+	// filenameScheme names the blocks archives after their base block number
+	filenameScheme bundleFilenameScheme
+	// partitions are the stores of each block range, sorted by FromBlock,
+	// blocksStore being the lowest one
+	partitions []StorePartition
 
 	preprocFunc PreprocessFunc
 	// gates incoming blocks based on Gator type BEFORE pre-processing
 	gator Gator
 
-	handler Handler
-
-	// retryDelay determines the time between attempts to retry the
+	// retryBackoff determines the time between attempts to retry the
 	// download of blocks archives (most of the time, waiting for the
 	// blocks archive to be written by some other process in semi
 	// real-time)
-	retryDelay              time.Duration
-	preprocessorThreadCount int
+	retryBackoff *retryBackoff
+	// openRetries is the amount of times opening a blocks archive is retried,
+	// using the retryBackoff policy
+	openRetries int
+	// listBundles answers the existence checks of the blocks archives from
+	// store listings while more than listedBundlesBehind bundles behind the
+	// highest one
+	listBundles         bool
+	listedBundlesBehind uint64
+	// existsErrorBudget is the amount of consecutive failed checks of a blocks
+	// archive existence that are retried, using the retryBackoff policy
+	existsErrorBudget int
+	// localBuffer downloads the blocks archives to local files before reading them
+	localBuffer *localBuffer
+
+	// gracefulDrain is how long the blocks already decoded from the current
+	// archive are still sent to the handler once the source is terminating
+	gracefulDrain time.Duration
+
+	// headTracker returns the chain head block number, the missing archives
+	// are retried faster when far behind it
+	headTracker func() uint64
 
-	// fileStream is a chan of blocks coming from blocks archives, ordered
-	// and parallel processed
-	fileStream                chan *incomingBlocksFile
-	highestFileProcessedBlock BlockRef
-	blockIndexProvider        BlockIndexProvider
+	preprocessorThreadCount int
+	// preprocAttempts is the amount of times preprocFunc is called on a block
+	// before shutting down the source, waiting preprocRetryDelay in between
+	preprocAttempts   int
+	preprocRetryDelay time.Duration
+	// skipPreprocessBelow blocks are sent without calling preprocFunc
+	skipPreprocessBelow uint64
+	// holeSkippingAfter is the amount of failed attempts to find a blocks archive
+	// after which onHole decides if it is skipped
+	holeSkippingAfter int
+	onHole            func(missingBase uint64) (skip bool)
+
+	// errorBudget is the amount of blocks archives that can fail and be skipped,
+	// when onBundleError is set
+	errorBudget   int
+	onBundleError func(base uint64, err error)
+
+	// maxOpenFiles bounds the amount of blocks archives open at the same time
+	maxOpenFiles int
+
+	// prefetch is the amount of upcoming blocks archives that are
+	// downloaded and decoded while the current one is being consumed
+	prefetch int
+	// decodedBlocksBuffer bounds the amount of decoded blocks kept per prefetched archive
+	decodedBlocksBuffer int
+
+	// blockReaderFactory decodes the blocks archives and one-block files
+	blockReaderFactory BlockReaderFactory
+	// blockPool receives the blocks once the handler returns, see FileSourceWithBlockPool
+	blockPool *BlockPool
+	// validateTimestamps rejects the blocks read without time or too far in the
+	// future, see FileSourceWithTimestampValidation
+	validateTimestamps bool
+	maxTimestampSkew   time.Duration
+	// tolerateTruncatedTail ends the merged blocks files at their truncated last
+	// block, see FileSourceWithTolerateTruncatedTail
+	tolerateTruncatedTail bool
+	// checksumPolicy handles the blocks not matching their checksum, see FileSourceWithChecksumPolicy
+	checksumPolicy ChecksumPolicy
+
+	// startBlockID is the expected ID of the start block, when set
+	startBlockID string
+
+	// cursorStep is the step of the cursors of the blocks read from the blocks
+	// archives, their head block is given by headBlockHint when it is higher
+	cursorStep    StepType
+	headBlockHint func() BlockRef
+
+	// cursorResolutionPolicy applies when the cursor of the source cannot be
+	// resolved from the one-block files
+	cursorResolutionPolicy CursorResolutionPolicy
+	onCursorFallback       func(*CursorFallback)
+
+	// reverseOrder streams the blocks from stopBlockNum down to startBlockNum
+	reverseOrder bool
+
+	// terminateOnEnd stops the source with ErrEndOfStore when there are no
+	// more blocks archives in the store
+	terminateOnEnd bool
+
+	// onNearLive is called when the source catches up with the highest blocks
+	// archive, again once it fell more than nearLiveWithin archives behind
+	onNearLive     func(lastBase uint64)
+	nearLiveWithin int
+
+	// validateBundles makes sure that each blocks archive contains all the
+	// blocks it is expected to cover
+	validateBundles bool
+
+	// gapTolerance is the amount of consecutive heights that can be missing
+	// between two blocks of a blocks archive, when detectGaps is set
+	detectGaps   bool
+	gapTolerance uint64
+
+	// compression of the blocks archives, detected from their content by default
+	compression BundleCompression
+
+	// secondaryStore is a mirror of the blocks store, read when it fails
+	secondaryStore dstore.Store
+
+	// oneBlocksStore is read while the expected blocks archive is missing
+	oneBlocksStore dstore.Store
+
+	metrics FileSourceMetrics
+
+	// onProgress is called by run() once all the blocks of an archive were sent
+	onProgress func(bundleBase uint64, lastBlock BlockRef, elapsed time.Duration)
+	// onHandlerStall is called when the handler takes more than handlerTimeout
+	// to process a block, the source shuts down on shutdownOnHandlerStall
+	handlerTimeout         time.Duration
+	onHandlerStall         func(block BlockRef, stuckFor time.Duration)
+	shutdownOnHandlerStall bool
+
+	// checkpointer is called by run() with the last height of each archive once
+	// all its blocks were handled
+	checkpointer func(ctx context.Context, lastFullyProcessed uint64) error
+
+	// blocksLimiter paces the blocks sent to the handler, bytesLimiter the
+	// bytes read from the blocks store
+	blocksLimiter *tokenBucket
+	bytesLimiter  *tokenBucket
+
+	blockIndexProvider BlockIndexProvider
 
 	// these blocks will be included even if the filter does not want them.
 	// If we are on a chain that skips block numbers, the NEXT block will be sent.
 	whitelistedBlocks map[uint64]bool
 
-	// if no blocks match filter in a big range, we will still send "some" blocks to help mark progress
-	// every time we have not matched any blocks for that duration
-	timeBetweenProgressBlocks time.Duration
+	// maxIndexLookahead is the amount of bundles without any block of interest
+	// that are scanned in the index before going back to reading blocks archives
+	maxIndexLookahead uint64
 
-	logger *zap.Logger
+	// sourceOptions are applied to the source once built, see FileSourceOptionFunc
+	sourceOptions []func(s *FileSource)
+}
+
+// newFileSourceConfig returns the settings given by `options`, on top of the
+// defaults of a FileSource
+func newFileSourceConfig(options ...FileSourceOption) *fileSourceConfig {
+	c := &fileSourceConfig{
+		bundleSize:         100,
+		filenameScheme:     defaultFilenameScheme,
+		retryBackoff:       newConstantRetryBackoff(4 * time.Second),
+		existsErrorBudget:  5,
+		cursorStep:         StepNewIrreversible,
+		nearLiveWithin:     1,
+		blockReaderFactory: DBinBlockReaderFactory,
+		metrics:            noopFileSourceMetrics{},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
-type FileSourceOption = func(s *FileSource)
+// FileSourceOption sets a setting of a FileSource, see the FileSourceWith functions
+type FileSourceOption = func(c *fileSourceConfig)
+
+// FileSourceOptionFunc adapts `f`, which configures the source itself, to a
+// FileSourceOption. It is called once the source is built, after the other
+// options have set its settings.
+func FileSourceOptionFunc(f func(s *FileSource)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.sourceOptions = append(c.sourceOptions, f)
+	}
+}
 
 func FileSourceWithConcurrentPreprocess(preprocFunc PreprocessFunc, threadCount int) FileSourceOption {
-	return func(s *FileSource) {
-		s.preprocessorThreadCount = threadCount
-		s.preprocFunc = preprocFunc
+	return func(c *fileSourceConfig) {
+		c.preprocessorThreadCount = threadCount
+		c.preprocFunc = preprocFunc
+	}
+}
+
+// FileSourceWithPreprocessRetry calls the preprocess function up to `attempts`
+// times on a block, waiting `backoff` between attempts, before shutting down the
+// source. The blocks keep their order, later blocks wait for the retried one.
+func FileSourceWithPreprocessRetry(attempts int, backoff time.Duration) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.preprocAttempts = attempts
+		c.preprocRetryDelay = backoff
+	}
+}
+
+// FileSourceWithSkipPreprocessBelow sends the blocks below `num` to the handler
+// without calling the preprocess function, their wrapped object is nil.
+func FileSourceWithSkipPreprocessBelow(num uint64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.skipPreprocessBelow = num
 	}
 }
 
 func FileSourceWithWhitelistedBlocks(nums ...uint64) FileSourceOption {
-	return func(s *FileSource) {
-		if s.whitelistedBlocks == nil {
-			s.whitelistedBlocks = make(map[uint64]bool)
+	return func(c *fileSourceConfig) {
+		if c.whitelistedBlocks == nil {
+			c.whitelistedBlocks = make(map[uint64]bool)
 		}
 		for _, num := range nums {
-			s.whitelistedBlocks[num] = true
+			c.whitelistedBlocks[num] = true
 		}
 	}
 }
 
+// FileSourceWithRetryDelay waits a constant `delay` between attempts to
+// find the next blocks archive.
 func FileSourceWithRetryDelay(delay time.Duration) FileSourceOption {
-	return func(s *FileSource) {
-		s.retryDelay = delay
+	return func(c *fileSourceConfig) {
+		c.retryBackoff = newConstantRetryBackoff(delay)
+	}
+}
+
+// FileSourceWithLocalBuffering fully downloads each blocks archive to a temporary
+// file in `dir` before reading it, for stores resetting long streaming reads. A
+// failed download is resumed where it stopped. The temporary file is deleted once
+// the archive is decoded. No download starts while the temporary files use
+// `maxBytes` or more, the last one started can go over it.
+func FileSourceWithLocalBuffering(dir string, maxBytes int64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.localBuffer = newLocalBuffer(dir, maxBytes)
+	}
+}
+
+// FileSourceWithGracefulDrain keeps sending to the handler, for up to `timeout`,
+// the blocks already decoded from the current blocks archive when the source is
+// terminating, no new blocks are decoded. Only the blocks of archives prefetched
+// with FileSourceWithPrefetch are decoded ahead of the handler. It has no effect
+// in reverse order.
+func FileSourceWithGracefulDrain(timeout time.Duration) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.gracefulDrain = timeout
+	}
+}
+
+// catchingUpRetryDelay is the delay between attempts to find a missing blocks
+// archive when more than two bundles behind the chain head
+const catchingUpRetryDelay = 100 * time.Millisecond
+
+// FileSourceWithAdaptiveRetry looks again for a missing blocks archive after
+// 100ms instead of the retry delay policy when the source is more than two
+// bundles behind the head block number returned by `headTracker`. The archive
+// is then expected to exist and the store to be having a hiccup.
+func FileSourceWithAdaptiveRetry(headTracker func() uint64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.headTracker = headTracker
+	}
+}
+
+// FileSourceWithOpenRetries retries opening a blocks archive up to `n` times,
+// waiting between attempts according to the retry delay policy, before shutting
+// down the source. A not found error is also retried since some stores are
+// eventually consistent, the source terminating is not.
+func FileSourceWithOpenRetries(n int) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.openRetries = n
+	}
+}
+
+// FileSourceWithListedBundles checks the existence of the blocks archives by
+// listing the next ones of the store in a single call, instead of querying each
+// of them, while the source is more than `minBundlesBehind` bundles behind the
+// highest listed archive. Closer to it, where new archives keep appearing, each
+// archive is queried again. Not used in reverse order.
+func FileSourceWithListedBundles(minBundlesBehind uint64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.listBundles = true
+		c.listedBundlesBehind = minBundlesBehind
+	}
+}
+
+// FileSourceWithExistsErrorBudget retries up to `n` consecutive failed checks of
+// the existence of a blocks archive, waiting between them according to the retry
+// delay policy, before shutting down the source with the last error. Defaults
+// to 5.
+func FileSourceWithExistsErrorBudget(n int) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.existsErrorBudget = n
+	}
+}
+
+// FileSourceWithRetryBackoff waits `initial` after the first failed attempt to
+// find the next blocks archive, then doubles the delay on each attempt up to
+// `max`. Each delay is randomly spread by +/- `jitterFraction` of its value so
+// that many sources do not poll the store at the same time. The delay goes back
+// to `initial` once the archive is found.
+func FileSourceWithRetryBackoff(initial, max time.Duration, jitterFraction float64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.retryBackoff = newRetryBackoff(initial, max, jitterFraction)
+	}
+}
+
+// FileSourceWithPrefetch downloads and decodes up to `n` upcoming blocks
+// archives while the current one is being sent to the handler, each archive
+// is decoded in its own goroutine. Blocks are still delivered in order, at
+// most `n` decoded archives are kept in memory on top of the one being
+// consumed, see FileSourceWithDecodedBlocksBuffer to bound them further.
+func FileSourceWithPrefetch(n int) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.prefetch = n
+	}
+}
+
+// FileSourceWithDecodedBlocksBuffer bounds the amount of decoded blocks kept in
+// memory for each archive prefetched with FileSourceWithPrefetch, it defaults to
+// the bundle size. The decoding of an archive waits once its buffer is full.
+func FileSourceWithDecodedBlocksBuffer(n int) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.decodedBlocksBuffer = n
+	}
+}
+
+// FileSourceWithGator drops the blocks that do not pass the gator before they
+// are preprocessed. The gator only sees blocks at or above the start block,
+// lower ones are skipped beforehand. It is called from the goroutines decoding
+// the blocks archives, so it must be safe for concurrent use when more than
+// one archive is streamed at a time.
+func FileSourceWithGator(gator Gator) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.gator = gator
+	}
+}
+
+// FileSourceWithCompression forces the codec used to read the blocks archives
+// instead of detecting it from their first bytes. Use BundleCompressionNone to
+// read them as is.
+func FileSourceWithCompression(compression BundleCompression) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.compression = compression
+	}
+}
+
+// FileSourceWithStartBlockID fails the source if the first block sent at the
+// start block height does not have the ID `id`. Forked blocks at that height
+// with another ID are not sent.
+func FileSourceWithStartBlockID(id string) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.startBlockID = id
+	}
+}
+
+// FileSourceWithCursorStep sets the step of the cursors of the blocks read from the
+// blocks archives, StepNewIrreversible by default. Their block is still their LIB.
+func FileSourceWithCursorStep(step StepType) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.cursorStep = step
+	}
+}
+
+// FileSourceWithHeadBlockHint sets the head block of the cursors of the blocks read
+// from the blocks archives to the block returned by `headBlockHint`, typically the
+// chain head, when it is above the block itself.
+func FileSourceWithHeadBlockHint(headBlockHint func() BlockRef) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.headBlockHint = headBlockHint
+	}
+}
+
+// FileSourceWithReverseOrder streams the blocks from the stop block down to the
+// start block, highest first, terminating with ErrStopBlockReached once the start
+// block was sent. A stop block is required. The blocks archives are read from
+// the highest down and each one is fully decoded before its blocks are sent.
+// The objects sent to the handler have no cursor since such a stream cannot be
+// resumed. The block index provider, hole skipping and bundle size detection
+// are not used in this mode.
+func FileSourceWithReverseOrder() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.reverseOrder = true
+	}
+}
+
+// FileSourceWithTerminateOnEnd terminates the source with ErrEndOfStore, once all
+// the blocks were sent to the handler, when the next blocks archive is missing and
+// the store contains no archive based above it, for stores that are not written
+// to anymore. A missing archive followed by others is still waited for.
+func FileSourceWithTerminateOnEnd() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.terminateOnEnd = true
+	}
+}
+
+// FileSourceWithCursorResolutionPolicy sets what a source created with
+// NewFileSourceFromCursor does when its forked cursor cannot be resolved from the
+// one-block files, FailOnMissing by default. `onFallback`, if not nil, is called
+// with what the policy did.
+func FileSourceWithCursorResolutionPolicy(policy CursorResolutionPolicy, onFallback func(*CursorFallback)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.cursorResolutionPolicy = policy
+		c.onCursorFallback = onFallback
+	}
+}
+
+// FileSourceWithNearLiveCallback calls `cb` with the base of the last blocks
+// archive read when the next one is missing after at least one was read, meaning
+// the source caught up with the store. It is called again when the source catches
+// up after falling behind, which is when more than `withinBundles` archives are
+// read without any of them missing.
+func FileSourceWithNearLiveCallback(withinBundles int, cb func(lastBase uint64)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.nearLiveWithin = withinBundles
+		c.onNearLive = cb
+	}
+}
+
+// FileSourceWithBundleValidation fails the source when a blocks archive does not
+// contain at least one block at each height of the range it covers, which happens
+// for truncated archives. Heights below GetProtocolFirstStreamableBlock are not
+// expected to exist.
+func FileSourceWithBundleValidation() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.validateBundles = true
+	}
+}
+
+// FileSourceWithBlockReaderFactory decodes the blocks archives, the one-block
+// files and, for sources created from a cursor, the forked blocks with `factory`
+// instead of DBinBlockReaderFactory.
+func FileSourceWithBlockReaderFactory(factory BlockReaderFactory) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.blockReaderFactory = factory
+	}
+}
+
+// FileSourceWithBlockCodec is FileSourceWithBlockReaderFactory with the reader
+// factory of `codec`, DBinBlockReaderFactory for the zero codec
+func FileSourceWithBlockCodec(codec BlockCodec) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.blockReaderFactory = codec.readerFactory()
+	}
+}
+
+// FileSourceWithHeaderOnly reads the blocks without decoding their payload, for
+// the tools only needing their number, ID, parent, LIB, head and timestamp: the payload
+// accessors of the blocks return pbbstream.ErrHeaderOnly (ToProtocol panics).
+// It replaces the reader factory with HeaderOnlyDBinBlockReaderFactory.
+func FileSourceWithHeaderOnly() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.blockReaderFactory = HeaderOnlyDBinBlockReaderFactory
+	}
+}
+
+// FileSourceWithTolerateTruncatedTail accepts the merged blocks files cut in
+// their last block, like the files of a merger which crashed while writing them:
+// the complete blocks of the file are sent and the truncation is logged with its
+// offset. The blocks missing from such a file are then a hole, which fails the
+// validation of FileSourceWithBundleValidation, within the budget of
+// FileSourceWithErrorBudget if any. A block which cannot be decoded is still an
+// error anywhere in the file. It applies to the block readers implementing
+// TruncatedTailTolerant.
+func FileSourceWithTolerateTruncatedTail() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.tolerateTruncatedTail = true
+	}
+}
+
+// FileSourceWithChecksumPolicy sets what the source does with a block of the
+// merged blocks files not matching its checksum (see DBinBlockWriterWithChecksum),
+// ChecksumPolicyFail by default. Reading the file again would give the same
+// block, the failure is not retried. A block skipped is then a hole, which fails
+// the validation of FileSourceWithBundleValidation, within the budget of
+// FileSourceWithErrorBudget if any.
+func FileSourceWithChecksumPolicy(policy ChecksumPolicy) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.checksumPolicy = policy
+	}
+}
+
+// FileSourceWithTimestampValidation fails the source on the first block read
+// without timestamp or timestamped more than `maxFutureSkew` ahead of the clock,
+// see CheckBlockTimestamp. Reading the file again would give the same block, the
+// read is not retried.
+func FileSourceWithTimestampValidation(maxFutureSkew time.Duration) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.validateTimestamps = true
+		c.maxTimestampSkew = maxFutureSkew
+	}
+}
+
+// FileSourceWithBlockPool decodes the blocks in blocks taken from `pool` and
+// releases each block to it once the handler returned without error, to reduce
+// the allocations of long replays. The handler must not retain the blocks nor
+// the objects referring to them: a Forkable does, it must be given the pool with
+// forkable.WithBlockPool instead, releasing the blocks once purged from its ForkDB.
+// The sources created from a cursor retain blocks to resolve it, they do not
+// release them. It replaces the reader factory with PooledDBinBlockReaderFactory.
+func FileSourceWithBlockPool(pool *BlockPool) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.blockPool = pool
+		c.blockReaderFactory = PooledDBinBlockReaderFactory(pool)
+	}
+}
+
+// FileSourceWithGapDetection fails the source when a block of a blocks archive
+// is more than `tolerance` heights above the highest block read before it, with
+// the first block of an archive following the last height of the previous one.
+// Forked blocks at heights already read are not gaps. The first block at or after
+// the start block (or GetProtocolFirstStreamableBlock) is not checked.
+func FileSourceWithGapDetection(tolerance uint64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.detectGaps = true
+		c.gapTolerance = tolerance
+	}
+}
+
+// FileSourceWithHoleSkipping calls `onHole` every `afterRetries` failed attempts
+// to find a blocks archive. When it returns true, the archive is skipped and its
+// range is recorded in SkippedRanges, otherwise the source keeps retrying.
+func FileSourceWithHoleSkipping(afterRetries int, onHole func(missingBase uint64) (skip bool)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.holeSkippingAfter = afterRetries
+		c.onHole = onHole
+	}
+}
+
+// FileSourceWithErrorBudget skips the blocks archives that fail to be read, after
+// their own retries, or that fail the bundle validation, up to `k` of them. Each
+// failure is given to `onBundleError` and its range is recorded in SkippedRanges.
+// The blocks of a failing archive read before the failure are still sent. The
+// source is shut down with all the failures once more than `k` archives failed.
+func FileSourceWithErrorBudget(k int, onBundleError func(base uint64, err error)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.errorBudget = k
+		if onBundleError == nil {
+			onBundleError = func(uint64, error) {}
+		}
+		c.onBundleError = onBundleError
+	}
+}
+
+// FileSourceWithProgressCallback calls `onProgress` each time all the blocks of a
+// blocks archive were sent to the handler, with the last block sent (nil if none
+// was) and the time elapsed since the archive was queued for download. It is
+// never called concurrently, and is called for the archive containing the stop
+// block before the source terminates.
+func FileSourceWithProgressCallback(onProgress func(bundleBase uint64, lastBlock BlockRef, elapsed time.Duration)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.onProgress = onProgress
+	}
+}
+
+// FileSourceWithCheckpointer calls `checkpointer` with the last block height of
+// each blocks archive once all its blocks were handled, before the blocks of the
+// next archive are sent, so that the source can be restarted after it. An error
+// from `checkpointer` shuts down the source. It is not called for blocks sent
+// from one-block files nor in reverse order. See DStoreCheckpointer.
+func FileSourceWithCheckpointer(checkpointer func(ctx context.Context, lastFullyProcessed uint64) error) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.checkpointer = checkpointer
+	}
+}
+
+// FileSourceWithHandlerTimeout calls `onStall` when the handler takes more than
+// `timeout` to process a block, then again every `timeout` until it returns, to
+// report a stuck handler. The source keeps waiting for the handler unless
+// FileSourceWithShutdownOnHandlerStall is also given.
+func FileSourceWithHandlerTimeout(timeout time.Duration, onStall func(block BlockRef, stuckFor time.Duration)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.handlerTimeout = timeout
+		c.onHandlerStall = onStall
+	}
+}
+
+// FileSourceWithShutdownOnHandlerStall shuts down the source once the handler
+// timeout of FileSourceWithHandlerTimeout expired, instead of waiting for the
+// handler to return.
+func FileSourceWithShutdownOnHandlerStall() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.shutdownOnHandlerStall = true
+	}
+}
+
+// FileSourceWithRateLimit sends at most `blocksPerSec` blocks per second to the handler
+func FileSourceWithRateLimit(blocksPerSec float64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.blocksLimiter = newTokenBucket(blocksPerSec, 1)
+	}
+}
+
+// FileSourceWithByteRateLimit reads at most `bytesPerSec` bytes per second from
+// the blocks store, averaged over one second.
+func FileSourceWithByteRateLimit(bytesPerSec float64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.bytesLimiter = newTokenBucket(bytesPerSec, bytesPerSec)
+	}
+}
+
+// FileSourceWithSecondaryStore reads the blocks archives from `store`, a mirror
+// of the blocks store, when the blocks store returns an error or does not have
+// them. Each read from the secondary store is logged and reported to the metrics.
+func FileSourceWithSecondaryStore(store dstore.Store) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.secondaryStore = store
+	}
+}
+
+// FileSourceWithOneBlockTail streams the one-block files found in
+// `oneBlocksStore` when the expected blocks archive is still missing after a
+// retry, until the archive is written by the merger. The blocks of the archive
+// that were already sent from one-block files are then skipped. Forked one-block
+// files are all sent, they are not final and have no cursor, a Forkable is
+// expected to sort them out. The start block ID is only verified in archives.
+func FileSourceWithOneBlockTail(oneBlocksStore dstore.Store) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.oneBlocksStore = oneBlocksStore
 	}
 }
+
+// FileSourceWithMaxOpenFiles bounds the amount of blocks archives that are open
+// at the same time, including the one being sent to the handler. It defaults to
+// 2, or to one more than the prefetch amount when FileSourceWithPrefetch is used.
+func FileSourceWithMaxOpenFiles(n int) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.maxOpenFiles = n
+	}
+}
+
+// FileSourceWithMetrics reports the activity of the source to `metrics`
+func FileSourceWithMetrics(metrics FileSourceMetrics) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.metrics = metrics
+	}
+}
+
 func FileSourceWithStopBlock(stopBlock uint64) FileSourceOption {
-	return func(s *FileSource) {
-		s.stopBlockNum = stopBlock
+	return func(c *fileSourceConfig) {
+		c.stopBlockNum = stopBlock
 	}
 }
 
 func FileSourceWithBundleSize(bundleSize uint64) FileSourceOption {
-	return func(s *FileSource) {
-		s.bundleSize = bundleSize
+	return func(c *fileSourceConfig) {
+		c.bundleSize = bundleSize
+	}
+}
+
+// FileSourceWithFilenameScheme names the blocks archives with `format` and gets
+// their base block number back with `parse`, for stores not using the default 10
+// digits names. The names may contain a directory prefix.
+func FileSourceWithFilenameScheme(format func(baseBlockNum uint64) string, parse func(name string) (uint64, error)) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.filenameScheme = bundleFilenameScheme{format: format, parse: parse}
+	}
+}
+
+// FileSourceWithBundleSizeAutoDetect lists the store to find the size of each
+// blocks archive, so that a store containing bundles of different sizes can be
+// traversed. The bundle size is then only used until the first detection.
+func FileSourceWithBundleSizeAutoDetect() FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.detectBundleSize = true
 	}
 }
 
 func FileSourceWithBlockIndexProvider(prov BlockIndexProvider) FileSourceOption {
-	return func(s *FileSource) {
-		s.blockIndexProvider = prov
+	return func(c *fileSourceConfig) {
+		c.blockIndexProvider = prov
+	}
+}
+
+// FileSourceWithMaxIndexLookahead bounds the amount of consecutive bundles
+// without any block of interest that are looked up in the block index. Once
+// reached, the last scanned bundle is read without sending any of its blocks
+// and the lookup resumes from the next one. It is unbounded by default.
+func FileSourceWithMaxIndexLookahead(bundles uint64) FileSourceOption {
+	return func(c *fileSourceConfig) {
+		c.maxIndexLookahead = bundles
 	}
 }
 
@@ -119,6 +797,21 @@ type FileSourceFactory struct {
 	forkedBlocksStore dstore.Store
 	logger            *zap.Logger
 	options           []FileSourceOption
+
+	// filenameScheme names the merged blocks files, see SetFilenameScheme
+	filenameScheme bundleFilenameScheme
+
+	// clampStartBlock makes SourceFromBlockNum start at the lowest available
+	// block when asked for a lower one
+	clampStartBlock bool
+
+	lowestAvailableBlockTTL  time.Duration
+	lowestAvailableBlockLock sync.Mutex
+	lowestAvailableBlock     uint64
+	lowestAvailableBlockAt   time.Time
+
+	// now is overridden in tests to control time
+	now func() time.Time
 }
 
 func NewFileSourceFactory(
@@ -132,27 +825,110 @@ func NewFileSourceFactory(
 		forkedBlocksStore: forkedBlocksStore,
 		logger:            logger,
 		options:           options,
+
+		filenameScheme:          defaultFilenameScheme,
+		lowestAvailableBlockTTL: time.Minute,
+		now:                     time.Now,
+	}
+}
+
+// SetFilenameScheme names the merged blocks files with `format` and parses them
+// with `parse`, in LowestAvailableBlock and in the sources created, like
+// FileSourceWithFilenameScheme.
+func (g *FileSourceFactory) SetFilenameScheme(format func(baseBlockNum uint64) string, parse func(name string) (uint64, error)) {
+	g.filenameScheme = bundleFilenameScheme{format: format, parse: parse}
+	g.options = append(g.options, FileSourceWithFilenameScheme(format, parse))
+}
+
+// SetLowestAvailableBlockTTL sets how long the result of LowestAvailableBlock
+// is cached, one minute by default.
+func (g *FileSourceFactory) SetLowestAvailableBlockTTL(ttl time.Duration) {
+	g.lowestAvailableBlockLock.Lock()
+	defer g.lowestAvailableBlockLock.Unlock()
+	g.lowestAvailableBlockTTL = ttl
+}
+
+// SetClampStartBlock makes SourceFromBlockNum start at the LowestAvailableBlock
+// when asked for a lower start block, the merged blocks files below it having
+// been pruned. Without it, the source waits for them forever.
+func (g *FileSourceFactory) SetClampStartBlock(clamp bool) {
+	g.clampStartBlock = clamp
+}
+
+// LowestAvailableBlock returns the base block number of the first merged
+// blocks file of the store.
+func (g *FileSourceFactory) LowestAvailableBlock(ctx context.Context) (uint64, error) {
+	g.lowestAvailableBlockLock.Lock()
+	defer g.lowestAvailableBlockLock.Unlock()
+
+	if !g.lowestAvailableBlockAt.IsZero() && g.now().Sub(g.lowestAvailableBlockAt) < g.lowestAvailableBlockTTL {
+		return g.lowestAvailableBlock, nil
+	}
+
+	var lowest uint64
+	var found bool
+	prefix := g.filenameScheme.listingPrefix(0, math.MaxUint64)
+	err := g.mergedBlocksStore.Walk(ctx, prefix, func(filename string) error {
+		base, err := g.filenameScheme.parse(filename)
+		if err != nil {
+			return nil
+		}
+		lowest = base
+		found = true
+		return dstore.StopIteration
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing merged blocks files: %w", err)
 	}
+	if !found {
+		return 0, errors.New("no merged blocks files in store")
+	}
+
+	g.lowestAvailableBlock = lowest
+	g.lowestAvailableBlockAt = g.now()
+	return lowest, nil
 }
 
 func (g *FileSourceFactory) SourceFromBlockNum(start uint64, h Handler) Source {
+	return g.SourceFromBlockNumWithOpts(start, h)
+}
+
+// SourceFromBlockNumWithOpts is SourceFromBlockNum with `opts` applied after the
+// options of the factory, overriding them for this source only.
+func (g *FileSourceFactory) SourceFromBlockNumWithOpts(start uint64, h Handler, opts ...FileSourceOption) Source {
+	if g.clampStartBlock {
+		lowest, err := g.LowestAvailableBlock(context.Background())
+		if err != nil {
+			g.logger.Warn("unable to get the lowest available block, not clamping the start block", zap.Uint64("start_block", start), zap.Error(err))
+		} else if start < lowest {
+			g.logger.Info("clamping start block to the lowest available block", zap.Uint64("start_block", start), zap.Uint64("lowest_available_block", lowest))
+			start = lowest
+		}
+	}
+
 	return NewFileSource(
 		g.mergedBlocksStore,
 		start,
 		h,
 		g.logger,
-		g.options...,
+		g.optionsWith(opts)...,
 	)
 }
 
 func (g *FileSourceFactory) SourceFromCursor(cursor *Cursor, h Handler) Source {
+	return g.SourceFromCursorWithOpts(cursor, h)
+}
+
+// SourceFromCursorWithOpts is SourceFromCursor with `opts` applied after the
+// options of the factory, overriding them for this source only.
+func (g *FileSourceFactory) SourceFromCursorWithOpts(cursor *Cursor, h Handler, opts ...FileSourceOption) Source {
 	return NewFileSourceFromCursor(
 		g.mergedBlocksStore,
 		g.forkedBlocksStore,
 		cursor,
 		h,
 		g.logger,
-		g.options...,
+		g.optionsWith(opts)...,
 	)
 }
 
@@ -164,10 +940,29 @@ func (g *FileSourceFactory) SourceThroughCursor(start uint64, cursor *Cursor, h
 		cursor,
 		h,
 		g.logger,
-		g.options...,
+		g.optionsWith(nil)...,
 	)
 }
 
+// optionsWith returns the options of the factory followed by `opts`, in a new
+// slice since the sources append their own options to it
+func (g *FileSourceFactory) optionsWith(opts []FileSourceOption) []FileSourceOption {
+	options := make([]FileSourceOption, 0, len(g.options)+len(opts))
+	options = append(options, g.options...)
+	return append(options, opts...)
+}
+
+// newEmptyCursorFileSource returns the source of NewFileSourceFromCursor and
+// NewFileSourceThroughCursor for a nil or empty cursor, failing with ErrEmptyCursor
+// when run instead of guessing where to start from
+func newEmptyCursorFileSource(mergedBlocksStore dstore.Store, startBlockNum uint64, h Handler, logger *zap.Logger, options ...FileSourceOption) *FileSource {
+	fs := NewFileSource(mergedBlocksStore, startBlockNum, h, logger, options...)
+	fs.cursorErr = ErrEmptyCursor
+	return fs
+}
+
+// NewFileSourceFromCursor returns a source resuming after `cursor`, it fails
+// with ErrEmptyCursor when run on a nil or empty cursor.
 func NewFileSourceFromCursor(
 	mergedBlocksStore dstore.Store,
 	forkedBlocksStore dstore.Store,
@@ -176,6 +971,10 @@ func NewFileSourceFromCursor(
 	logger *zap.Logger,
 	options ...FileSourceOption,
 ) *FileSource {
+	if cursor.IsEmpty() {
+		return newEmptyCursorFileSource(mergedBlocksStore, 0, h, logger, options...)
+	}
+	logger.Debug("creating file source from cursor", zap.Object("cursor", cursor))
 
 	wrappedHandler := newCursorResolverHandler(forkedBlocksStore, cursor, false, h, logger)
 
@@ -188,15 +987,31 @@ func NewFileSourceFromCursor(
 		cursor.Block.Num()+1,
 	))
 
-	return NewFileSource(
+	// the cursor resolver only sends the blocks above the LIB, unless the cursor is on it
+	if cursor.Block.Num() > cursor.LIB.Num() || cursor.IsLIBOnly() {
+		tweakedOptions = append(tweakedOptions, FileSourceWithSkipPreprocessBelow(cursor.LIB.Num()+1))
+	}
+
+	fs := NewFileSource(
 		mergedBlocksStore,
 		cursor.LIB.Num(),
 		wrappedHandler,
 		logger,
 		tweakedOptions...)
-
+	fs.cursorErr = cursor.Validate()
+
+	// the forked blocks are decoded like the merged ones
+	wrappedHandler.blockReaderFactory = fs.blockReaderFactory
+	// the cursor resolver retains the blocks until the cursor is resolved
+	fs.blockPool = nil
+	wrappedHandler.policy = fs.cursorResolutionPolicy
+	wrappedHandler.onFallback = fs.onCursorFallback
+	return fs
 }
 
+// NewFileSourceThroughCursor returns a source sending the blocks from
+// `startBlockNum` through `cursor`, it fails with ErrEmptyCursor when run on a
+// nil or empty cursor.
 func NewFileSourceThroughCursor(
 	mergedBlocksStore dstore.Store,
 	forkedBlocksStore dstore.Store,
@@ -206,6 +1021,10 @@ func NewFileSourceThroughCursor(
 	logger *zap.Logger,
 	options ...FileSourceOption,
 ) *FileSource {
+	if cursor.IsEmpty() {
+		return newEmptyCursorFileSource(mergedBlocksStore, startBlockNum, h, logger, options...)
+	}
+	logger.Debug("creating file source through cursor", zap.Object("cursor", cursor), zap.Uint64("start_block_num", startBlockNum))
 
 	wrappedHandler := newCursorResolverHandler(forkedBlocksStore, cursor, true, h, logger)
 
@@ -219,13 +1038,19 @@ func NewFileSourceThroughCursor(
 		cursor.Block.Num()+1,
 	))
 
-	return NewFileSource(
+	fs := NewFileSource(
 		mergedBlocksStore,
 		startBlockNum,
 		wrappedHandler,
 		logger,
 		tweakedOptions...)
+	fs.cursorErr = cursor.Validate()
 
+	// the forked blocks are decoded like the merged ones
+	wrappedHandler.blockReaderFactory = fs.blockReaderFactory
+	// the cursor resolver retains the blocks until the cursor is resolved
+	fs.blockPool = nil
+	return fs
 }
 
 func NewFileSource(
@@ -235,23 +1060,96 @@ func NewFileSource(
 	logger *zap.Logger,
 	options ...FileSourceOption,
 
+) *FileSource {
+	return NewFileSourceWithContext(context.Background(), blocksStore, startBlockNum, h, logger, options...)
+}
+
+// NewFileSourceWithContext creates a FileSource that uses `ctx` for all
+// its accesses to the blocks store, canceling `ctx` is equivalent to calling
+// `Shutdown(ctx.Err())` on the source.
+func NewFileSourceWithContext(
+	ctx context.Context,
+	blocksStore dstore.Store,
+	startBlockNum uint64,
+	h Handler,
+	logger *zap.Logger,
+	options ...FileSourceOption,
+) *FileSource {
+	return newFileSourceWithConfig(ctx, blocksStore, startBlockNum, h, logger, newFileSourceConfig(options...))
+}
+
+// newFileSourceWithConfig is NewFileSourceWithContext with the settings of its
+// options already applied to `config`
+func newFileSourceWithConfig(
+	ctx context.Context,
+	blocksStore dstore.Store,
+	startBlockNum uint64,
+	h Handler,
+	logger *zap.Logger,
+	config *fileSourceConfig,
 ) *FileSource {
 	s := &FileSource{
+		fileSourceConfig:          *config,
 		startBlockNum:             startBlockNum,
-		bundleSize:                100,
 		blocksStore:               blocksStore,
-		fileStream:                make(chan *incomingBlocksFile, 1),
 		Shutter:                   shutter.New(),
-		retryDelay:                4 * time.Second,
+		after:                     time.After,
 		timeBetweenProgressBlocks: 30 * time.Second,
 		handler:                   h,
 		logger:                    logger,
 	}
 
-	for _, option := range options {
-		option(s)
+	if s.detectBundleSize {
+		s.bundleLayout = newBundleLayout(s.storePartitions(), &s.filenameScheme)
+	}
+	if s.listBundles {
+		s.bundleListing = newBundleListing(&s.filenameScheme, s.listedBundlesBehind)
+	}
+	if s.handlerTimeout > 0 {
+		s.handlerWatchdog = newHandlerWatchdog(s.handlerTimeout, func(block BlockRef, stuckFor time.Duration) {
+			if s.onHandlerStall != nil {
+				s.onHandlerStall(block, stuckFor)
+			}
+			if s.shutdownOnHandlerStall {
+				s.Shutdown(fmt.Errorf("handler stuck processing block %s for %s", block, stuckFor))
+			}
+		})
+	}
+
+	fileStreamSize := 1
+	if s.prefetch > 1 {
+		fileStreamSize = s.prefetch
 	}
+	s.fileStream = make(chan *incomingBlocksFile, fileStreamSize)
 
+	if s.maxOpenFiles <= 0 {
+		s.maxOpenFiles = 2
+		if s.prefetch > 1 {
+			s.maxOpenFiles = s.prefetch + 1
+		}
+	}
+	s.openFilesSem = make(chan struct{}, s.maxOpenFiles)
+
+	parentCtx := ctx
+	stopWatchingParent := context.AfterFunc(parentCtx, func() {
+		s.Shutdown(parentCtx.Err())
+	})
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	s.ctx = ctx
+	s.OnTerminating(func(_ error) {
+		stopWatchingParent()
+		cancel()
+
+		// no stream starts once terminating, see startStream
+		s.streamsLock.Lock()
+		s.streamsLock.Unlock()
+		s.streams.Wait()
+	})
+
+	for _, option := range s.sourceOptions {
+		option(s)
+	}
 	return s
 }
 
@@ -259,30 +1157,118 @@ func (s *FileSource) Run() {
 	s.Shutdown(s.run())
 }
 
-func (s *FileSource) checkExists(baseBlockNum uint64) (exists bool, baseFilename string, err error) {
-	baseFilename = fmt.Sprintf("%010d", baseBlockNum)
+// existsCheckAttempts is the amount of times the existence of a blocks archive
+// is queried, with a growing timeout, before checkExists fails
+const existsCheckAttempts = 5
+
+func (s *FileSource) checkExists(ctx context.Context, baseBlockNum uint64) (exists bool, baseFilename string, err error) {
+	baseFilename = s.filenameScheme.format(baseBlockNum)
+	if s.bundleListing != nil && !s.reverseOrder {
+		store, storeEnd := s.partitionFor(baseBlockNum)
+		exists, known, err := s.bundleListing.exists(ctx, store, storeEnd, baseBlockNum, s.bundleSize)
+		if err != nil {
+			s.logger.Debug("unable to list the blocks files, checking the file", zap.String("base_filename", baseFilename), zap.Error(err))
+		} else if known {
+			return exists, baseFilename, nil
+		}
+	}
+
 	timeout := 4 * time.Second
-	for i := 1; i <= 5; i++ {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		exists, err = s.blocksStore.FileExists(ctx, baseFilename)
+	for i := 1; i <= existsCheckAttempts; i++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		exists, err = s.storeFor(baseBlockNum).FileExists(attemptCtx, baseFilename)
 		cancel()
 		if err != nil {
+			if ctx.Err() != nil {
+				return false, baseFilename, ctx.Err()
+			}
 			timeout += time.Duration(i) * time.Second
 			continue
 		}
 		break
 	}
-	return
+
+	if (err != nil || !exists) && s.secondaryStore != nil && ctx.Err() == nil {
+		if secondaryExists, secondaryErr := s.secondaryStore.FileExists(ctx, baseFilename); secondaryErr == nil && secondaryExists {
+			s.logger.Warn("blocks file found in secondary store", zap.String("base_filename", baseFilename), zap.Error(err))
+			s.metrics.SecondaryStoreFailover()
+			return true, baseFilename, nil
+		}
+	}
+	return
+}
+
+// retainedRef returns the reference of `preBlock` to keep once it was sent, a
+// copy when its block is released to the block pool
+func (s *FileSource) retainedRef(preBlock *PreprocessedBlock) BlockRef {
+	if s.blockPool != nil {
+		return NewBlockRef(preBlock.ID(), preBlock.Num())
+	}
+	return preBlock
 }
 
 func (s *FileSource) run() (err error) {
+	// the blocks left in the chans are never given to the handler
+	defer s.dropBufferedBytes()
 
-	go s.launchReader()
+	if s.cursorErr != nil {
+		return fmt.Errorf("invalid cursor: %w", s.cursorErr)
+	}
+	if s.reverseOrder {
+		if s.stopBlockNum == 0 || s.stopBlockNum < s.startBlockNum {
+			return fmt.Errorf("reverse order requires a stop block at or above start block %d, got %d", s.startBlockNum, s.stopBlockNum)
+		}
+		go s.launchReverseReader()
+	} else {
+		go s.launchReader()
+	}
 
-	// if there is a blockIndexProvider, some blocks may be skipped, so we don't check continuity here.
-	validateBlockOrder := s.blockIndexProvider == nil
+	// if there is a blockIndexProvider or a gator, some blocks may be skipped, so we don't check continuity here.
+	validateBlockOrder := s.blockIndexProvider == nil && s.gator == nil
+
+	var lastBlockID, lastParentID string
+	processBlock := func(preBlock *PreprocessedBlock, incomingFile *incomingBlocksFile) error {
+		s.addBufferedBytes(-preBlock.Block.PayloadSize())
+		filename := incomingFile.filename
+		// forked one-block files are all sent, in no particular order
+		if validateBlockOrder && incomingFile.oneBlockFiles == nil {
+			if s.reverseOrder {
+				if lastParentID != "" && preBlock.Block.Id != lastParentID {
+					return fmt.Errorf("found non-sequential blocks in merged blocks file (%q is not the previous block %q of %q). You will have to fix or reprocess %q", preBlock.Block.AsRef().String(), lastParentID, lastBlockID, filename)
+				}
+			} else if lastBlockID != "" && preBlock.Block.ParentId != lastBlockID {
+				return fmt.Errorf("found non-sequential blocks in merged blocks file (%q has previousID %q and does not follow %q). You will have to fix or reprocess %q", preBlock.Block.AsRef().String(), preBlock.Block.ParentId, lastBlockID, filename)
+			}
+			lastBlockID = preBlock.Block.Id
+			lastParentID = preBlock.Block.ParentId
+		}
+
+		if s.blocksLimiter != nil && !s.blocksLimiter.wait(1, s.Terminating(), s.after) {
+			return nil
+		}
+
+		if s.handlerWatchdog != nil {
+			s.handlerWatchdog.start(preBlock)
+		}
+		err := s.handler.ProcessBlock(preBlock.Block, preBlock.Obj)
+		if s.handlerWatchdog != nil {
+			s.handlerWatchdog.stop()
+		}
+		if err != nil {
+			return err
+		}
+		s.metrics.BlockDelivered()
+		s.highestFileProcessedBlockLock.Lock()
+		if s.highestFileProcessedBlock == nil || preBlock.Num() > s.highestFileProcessedBlock.Num() {
+			s.highestFileProcessedBlock = s.retainedRef(preBlock)
+		}
+		s.highestFileProcessedBlockLock.Unlock()
+		if s.blockPool != nil {
+			s.blockPool.Release(preBlock.Block)
+		}
+		return nil
+	}
 
-	var lastBlockID string
 	for {
 		select {
 		case <-s.Terminating():
@@ -297,24 +1283,76 @@ func (s *FileSource) run() (err error) {
 			}
 
 			s.logger.Debug("feeding from incoming file", zap.String("filename", incomingFile.filename))
+			s.metrics.BundleStarted(incomingFile.baseNum)
+			if incomingFile.afterHole {
+				lastBlockID = ""
+			}
 
-			for preBlock := range incomingFile.blocks {
+			// in reverse order, the whole file is buffered to be sent from its highest block
+			var reversed []*PreprocessedBlock
+			var lastBlock BlockRef
+			for {
+				var preBlock *PreprocessedBlock
+				select {
+				case <-s.Terminating():
+					// the file may never be closed if its download was aborted
+					s.drain(incomingFile, nil, processBlock)
+					return nil
+				case preBlock, ok = <-incomingFile.blocks:
+				}
+				if !ok {
+					incomingFile.release()
+					if incomingFile.validationErr != nil {
+						if s.onBundleError == nil {
+							return incomingFile.validationErr
+						}
+						if err := s.bundleFailed(incomingFile.baseNum, incomingFile.validationErr); err != nil {
+							return err
+						}
+						incomingFile.failed = true
+					}
+					if incomingFile.failed {
+						// the following file does not continue this one
+						lastBlockID = ""
+					}
+					break
+				}
 				if s.IsTerminating() {
+					s.drain(incomingFile, preBlock, processBlock)
 					return nil
 				}
 
-				if validateBlockOrder {
-					if lastBlockID != "" && preBlock.Block.ParentId != lastBlockID {
-						return fmt.Errorf("found non-sequential blocks in merged blocks file (%q has previousID %q and does not follow %q). You will have to fix or reprocess %q", preBlock.Block.AsRef().String(), preBlock.Block.ParentId, lastBlockID, incomingFile.filename)
-					}
-					lastBlockID = preBlock.Block.Id
+				if s.reverseOrder {
+					reversed = append(reversed, preBlock)
+					continue
+				}
+				lastBlock = s.retainedRef(preBlock)
+				if err := processBlock(preBlock, incomingFile); err != nil {
+					return err
 				}
+			}
 
-				if err := s.handler.ProcessBlock(preBlock.Block, preBlock.Obj); err != nil {
+			for i := len(reversed) - 1; i >= 0; i-- {
+				if s.IsTerminating() {
+					return nil
+				}
+				lastBlock = s.retainedRef(reversed[i])
+				if err := processBlock(reversed[i], incomingFile); err != nil {
 					return err
 				}
-				if s.highestFileProcessedBlock != nil && preBlock.Num() > s.highestFileProcessedBlock.Num() {
-					s.highestFileProcessedBlock = preBlock
+			}
+
+			if s.onProgress != nil && incomingFile.oneBlockFiles == nil {
+				s.onProgress(incomingFile.baseNum, lastBlock, time.Since(incomingFile.queuedAt))
+			}
+
+			if s.checkpointer != nil && incomingFile.oneBlockFiles == nil && !s.reverseOrder {
+				lastFullyProcessed := incomingFile.baseNum + incomingFile.bundleSize - 1
+				if s.stopBlockNum != 0 && s.stopBlockNum < lastFullyProcessed {
+					lastFullyProcessed = s.stopBlockNum
+				}
+				if err := s.checkpointer(s.ctx, lastFullyProcessed); err != nil {
+					return fmt.Errorf("checkpointing block %d: %w", lastFullyProcessed, err)
 				}
 			}
 		}
@@ -322,6 +1360,43 @@ func (s *FileSource) run() (err error) {
 
 }
 
+// drain sends `pending` then the blocks already decoded from incomingFile to the
+// handler once the source is terminating, for up to the graceful drain timeout.
+func (s *FileSource) drain(incomingFile *incomingBlocksFile, pending *PreprocessedBlock, processBlock func(*PreprocessedBlock, *incomingBlocksFile) error) {
+	if s.gracefulDrain <= 0 || s.reverseOrder {
+		return
+	}
+
+	timeout := s.after(s.gracefulDrain)
+	for {
+		select {
+		case <-timeout:
+			s.logger.Info("graceful drain timed out", zap.String("filename", incomingFile.filename))
+			return
+		default:
+		}
+
+		if pending == nil {
+			var ok bool
+			select {
+			case <-timeout:
+				s.logger.Info("graceful drain timed out", zap.String("filename", incomingFile.filename))
+				return
+			case pending, ok = <-incomingFile.blocks:
+				if !ok {
+					return
+				}
+			}
+		}
+
+		if err := processBlock(pending, incomingFile); err != nil {
+			s.logger.Warn("handler failed during graceful drain", zap.Error(err))
+			return
+		}
+		pending = nil
+	}
+}
+
 func (s *FileSource) tweakRangeIndexResults(baseBlock uint64, inBlocks []uint64) []uint64 {
 	var addBlocks []uint64
 	for wl := range s.whitelistedBlocks {
@@ -369,14 +1444,23 @@ func (s *FileSource) tweakRangeIndexResults(baseBlock uint64, inBlocks []uint64)
 	return uniqueBoundedBlocks
 }
 
-func (s *FileSource) lookupBlockIndex(in uint64) (baseBlock uint64, outBlocks []uint64, noMoreIndex bool) {
+// indexLookupLogInterval is the amount of bundles scanned in the block index
+// between progress logs
+const indexLookupLogInterval = 1000
+
+func (s *FileSource) lookupBlockIndex(ctx context.Context, in uint64) (baseBlock uint64, outBlocks []uint64, noMoreIndex bool) {
 	if s.stopBlockNum != 0 && in > s.stopBlockNum {
 		return in, nil, true
 	}
 
 	begin := time.Now()
 	baseBlock = in
-	for {
+	for scanned := uint64(1); ; scanned++ {
+		// ctx is canceled when the source terminates
+		if ctx.Err() != nil {
+			return baseBlock, nil, true
+		}
+
 		filteredBlocks, err := s.blockIndexProvider.BlocksInRange(baseBlock, s.bundleSize)
 		if err != nil {
 			s.logger.Debug("blocks_in_range returns error, deactivating",
@@ -391,6 +1475,14 @@ func (s *FileSource) lookupBlockIndex(in uint64) (baseBlock uint64, outBlocks []
 			if time.Since(begin) >= s.timeBetweenProgressBlocks {
 				return baseBlock, []uint64{baseBlock}, false
 			}
+			if s.maxIndexLookahead != 0 && scanned >= s.maxIndexLookahead {
+				// none of the blocks pass an empty filter
+				s.logger.Debug("index lookahead limit reached", zap.Uint64("base_block", baseBlock), zap.Uint64("scanned_bundles", scanned))
+				return baseBlock, []uint64{}, false
+			}
+			if scanned%indexLookupLogInterval == 0 {
+				s.logger.Info("still looking up block index for blocks of interest", zap.Uint64("base_block", baseBlock), zap.Uint64("scanned_bundles", scanned), zap.Duration("elapsed", time.Since(begin)))
+			}
 			baseBlock += s.bundleSize
 			continue
 		}
@@ -399,43 +1491,63 @@ func (s *FileSource) lookupBlockIndex(in uint64) (baseBlock uint64, outBlocks []
 	}
 }
 
-func (s *FileSource) streamReader(blockReader *DBinBlockReader, prevLastBlockRead BlockRef, incomingBlockFile *incomingBlocksFile) (err error) {
+// blockReadError is a failure to read the next block of a file, the following
+// blocks can still be read from a new reader skipping the ones already read
+type blockReadError struct {
+	err error
+}
+
+func (e *blockReadError) Error() string { return e.err.Error() }
+func (e *blockReadError) Unwrap() error { return e.err }
+
+// streamReader sends the blocks of `blockReader` following `prevLastBlockRead`
+// to the blocks of `incomingBlockFile`, which is closed at the end of the file.
+// On a blockReadError, it is left open for another attempt to resume reading.
+func (s *FileSource) streamReader(blockReader BlockReader, prevLastBlockRead BlockRef, incomingBlockFile *incomingBlocksFile) (err error) {
 	var previousLastBlockPassed bool
 	if prevLastBlockRead == nil {
 		previousLastBlockPassed = true
 	}
 
-	done := make(chan interface{})
-	preprocessed := make(chan chan *PreprocessedBlock, s.preprocessorThreadCount)
+	tolerant, _ := blockReader.(TruncatedTailTolerant)
+	if s.validateTimestamps {
+		blockReader = NewTimestampValidatingBlockReader(blockReader, s.maxTimestampSkew)
+	}
 
-	go func() {
-		defer close(done)
-		defer close(incomingBlockFile.blocks)
+	if incomingBlockFile.preprocessed == nil {
+		s.forwardPreprocessed(incomingBlockFile)
+	}
+	done := incomingBlockFile.forwarded
+	preprocessed := incomingBlockFile.preprocessed
 
-		for {
-			select {
-			case <-s.Terminating():
-				return
-			case ppChan, ok := <-preprocessed:
-				if !ok {
-					return
-				}
-				select {
-				case <-s.Terminating():
-					return
-				case preprocessBlock := <-ppChan:
-					select {
-					case <-s.Terminating():
-						return
-					case incomingBlockFile.blocks <- preprocessBlock:
-					}
-				}
-			}
+	fromOneBlockFiles := incomingBlockFile.oneBlockFiles != nil
+
+	// if there is a blockIndexProvider or a gator, we check continuity directly here
+	validateBlockOrder := (s.blockIndexProvider != nil || s.gator != nil) && !fromOneBlockFiles
+
+	var coverage *bundleCoverage
+	if s.validateBundles && !fromOneBlockFiles {
+		coveredSize := incomingBlockFile.bundleSize
+		if s.stopBlockNum != 0 && s.stopBlockNum < incomingBlockFile.baseNum+coveredSize {
+			// the blocks after the stop block are not read
+			coveredSize = s.stopBlockNum - incomingBlockFile.baseNum + 1
 		}
-	}()
+		coverage = newBundleCoverage(incomingBlockFile.baseNum, coveredSize)
+	}
+
+	// highestBlockNum is the highest block read, 0 until the first one when it is
+	// not checked
+	var highestBlockNum uint64
+	detectGaps := s.detectGaps && !fromOneBlockFiles
+	if detectGaps && incomingBlockFile.baseNum > s.startBlockNum && incomingBlockFile.baseNum > GetProtocolFirstStreamableBlock {
+		highestBlockNum = incomingBlockFile.baseNum - 1
+	}
 
-	// if there is a blockIndexProvider, we check continuity directly here
-	validateBlockOrder := s.blockIndexProvider != nil
+	checkStartBlockID := s.startBlockID != "" && !fromOneBlockFiles && incomingBlockFile.baseNum <= s.startBlockNum && s.startBlockNum < incomingBlockFile.baseNum+incomingBlockFile.bundleSize
+	var startBlockIDs []string
+	startBlockMismatch := func() error {
+		return fmt.Errorf("start block #%d has ID(s) %q in merged blocks file %q, expected %q", s.startBlockNum, startBlockIDs, incomingBlockFile.filename, s.startBlockID)
+	}
 
 	var lastBlockID string
 	for {
@@ -445,22 +1557,68 @@ func (s *FileSource) streamReader(blockReader *DBinBlockReader, prevLastBlockRea
 
 		var blk *pbbstream.Block
 		blk, err = blockReader.Read()
+		var checksumErr *ChecksumError
+		if errors.As(err, &checksumErr) && s.checksumPolicy != ChecksumPolicyFail {
+			s.logger.Warn("block of merged blocks file does not match its checksum", zap.String("filename", incomingBlockFile.filename), zap.Stringer("policy", s.checksumPolicy), zap.Error(err))
+			if s.checksumPolicy != ChecksumPolicyWarn || checksumErr.Block == nil {
+				continue
+			}
+			blk, err = checksumErr.Block, nil
+		}
 		if err != nil && err != io.EOF {
-			close(preprocessed)
-			return err
+			if errors.Is(err, ErrInvalidBlockID) || errors.Is(err, ErrInvalidBlockTimestamp) || errors.Is(err, ErrChecksumMismatch) {
+				// reading the file again would read the same block
+				return fmt.Errorf("reading merged blocks file %q: %w", incomingBlockFile.filename, err)
+			}
+			return &blockReadError{err: err}
 		}
 
-		if err == io.EOF && (blk == nil || blk.Number == 0) {
+		endOfFile := err == io.EOF && (blk == nil || blk.Number == 0)
+		// blocks are ordered in the file, none of the following ones would be sent
+		pastStopBlock := !endOfFile && s.stopBlockNum != 0 && blk.Number > s.stopBlockNum
+		if endOfFile || pastStopBlock {
+			truncation := truncatedTail(tolerant)
+			if truncation != nil {
+				s.logger.Warn("merged blocks file is truncated, its last block is dropped", zap.String("filename", incomingBlockFile.filename), zap.Int64("offset", truncation.Offset), zap.Error(truncation.Err))
+			}
+			if checkStartBlockID {
+				return startBlockMismatch()
+			}
+			if coverage != nil {
+				if from, to, found := coverage.missing(GetProtocolFirstStreamableBlock); found {
+					// reported by run() once the blocks of the previous files were sent
+					incomingBlockFile.validationErr = fmt.Errorf("incomplete merged blocks file %q: missing blocks in range [%d, %d]", incomingBlockFile.filename, from, to)
+					if truncation != nil {
+						incomingBlockFile.validationErr = fmt.Errorf("%w (%s)", incomingBlockFile.validationErr, truncation)
+					}
+				}
+			}
 			close(preprocessed)
 			break
 		}
 		blockNum := blk.Number
+		s.metrics.BlockDecoded()
+		if coverage != nil {
+			coverage.add(blockNum)
+		}
 
 		// historically, we were saving the last block of the previous bundle in here. We don't do it anymore but we will skip such blocks.
 		if blockNum < s.startBlockNum {
 			continue
 		}
 
+		if checkStartBlockID {
+			if blockNum > s.startBlockNum {
+				return startBlockMismatch()
+			}
+			if blk.Id != s.startBlockID {
+				// a forked block at the start block height
+				startBlockIDs = append(startBlockIDs, blk.Id)
+				continue
+			}
+			checkStartBlockID = false
+		}
+
 		if validateBlockOrder {
 			if lastBlockID != "" && blk.ParentId != lastBlockID {
 				return fmt.Errorf("found non-sequential blocks in merged blocks file (%q has previousID %q and does not follow %q). You will have to fix or reprocess %q", blk.AsRef().String(), blk.ParentId, lastBlockID, incomingBlockFile.filename)
@@ -473,10 +1631,15 @@ func (s *FileSource) streamReader(blockReader *DBinBlockReader, prevLastBlockRea
 			continue
 		}
 
-		if !incomingBlockFile.PassesFilter(blockNum) {
-			continue
+		if detectGaps && blockNum > highestBlockNum {
+			if highestBlockNum != 0 && blockNum-highestBlockNum-1 > s.gapTolerance {
+				return fmt.Errorf("gap in merged blocks file %q: block #%d follows block #%d", incomingBlockFile.filename, blockNum, highestBlockNum)
+			}
+			highestBlockNum = blockNum
 		}
 
+		// checked before the filters, which were applied to these blocks by the
+		// previous attempt and are not idempotent
 		if !previousLastBlockPassed {
 			s.logger.Debug("skipping because this is not the first attempt and we have not seen prevLastBlockRead yet", zap.Stringer("block", blk.AsRef()), zap.Stringer("prev_last_block_read", prevLastBlockRead))
 			if prevLastBlockRead.ID() == blk.Id {
@@ -485,6 +1648,15 @@ func (s *FileSource) streamReader(blockReader *DBinBlockReader, prevLastBlockRea
 			continue
 		}
 
+		if incomingBlockFile.skipBlocks[oneBlockKey(blockNum, TruncateBlockID(blk.Id))] {
+			// already sent from its one-block file
+			continue
+		}
+
+		if !incomingBlockFile.PassesFilter(blockNum) {
+			continue
+		}
+
 		if s.gator != nil && !s.gator.Pass(blk) {
 			s.logger.Debug("gator not passed dropping block")
 			continue
@@ -498,93 +1670,414 @@ func (s *FileSource) streamReader(blockReader *DBinBlockReader, prevLastBlockRea
 			return
 		case preprocessed <- out:
 		}
-		go s.preprocess(blk, out)
+		incomingBlockFile.lastBlockRead = blk.AsRef()
+		go s.preprocess(s.ctx, blk, fromOneBlockFiles, out)
 	}
 
 	<-done
 	return nil
 }
 
-func (s *FileSource) preprocess(block *pbbstream.Block, out chan *PreprocessedBlock) {
+// forwardPreprocessed sends the blocks of the preprocessed chan of `file` to its
+// blocks chan, in order, closing it once preprocessed is closed.
+func (s *FileSource) forwardPreprocessed(file *incomingBlocksFile) {
+	file.preprocessed = make(chan chan *PreprocessedBlock, s.preprocessorThreadCount)
+	file.forwarded = make(chan struct{})
+
+	go func() {
+		defer close(file.forwarded)
+		defer close(file.blocks)
+
+		for {
+			select {
+			case <-s.Terminating():
+				return
+			case ppChan, ok := <-file.preprocessed:
+				if !ok {
+					return
+				}
+				select {
+				case <-s.Terminating():
+					return
+				case preprocessBlock := <-ppChan:
+					// accounted before the send so that run() never sees it unaccounted
+					size := preprocessBlock.Block.PayloadSize()
+					s.addBufferedBytes(size)
+					select {
+					case <-s.Terminating():
+						s.addBufferedBytes(-size)
+						return
+					case file.blocks <- preprocessBlock:
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (s *FileSource) preprocess(ctx context.Context, block *pbbstream.Block, fromOneBlockFile bool, out chan *PreprocessedBlock) {
 	var obj interface{}
 	var err error
-	if s.preprocFunc != nil {
-		obj, err = s.preprocFunc(block)
-		if err != nil {
-			s.Shutdown(fmt.Errorf("preprocess block: %s: %w", block, err))
-			return
+	if s.preprocFunc != nil && block.Number >= s.skipPreprocessBelow {
+		for attempt := 1; ; attempt++ {
+			obj, err = s.preprocFunc(block)
+			if err == nil {
+				break
+			}
+			if attempt >= s.preprocAttempts {
+				if attempt > 1 {
+					s.Shutdown(fmt.Errorf("preprocess block %s: after %d attempts: %w", block.AsRef(), attempt, err))
+					return
+				}
+				s.Shutdown(fmt.Errorf("preprocess block: %s: %w", block, err))
+				return
+			}
+
+			s.logger.Warn("preprocessing block failed, retrying", zap.Stringer("block", block.AsRef()), zap.Int("attempt", attempt), zap.Duration("retry_delay", s.preprocRetryDelay), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.after(s.preprocRetryDelay):
+			}
 		}
 	}
-	obj = &wrappedObject{
-		obj: obj,
-		cursor: &Cursor{
-			Step:      StepNewIrreversible,
-			Block:     block.AsRef(),
-			LIB:       block.AsRef(),
-			HeadBlock: block.AsRef(),
-		}}
+	if fromOneBlockFile {
+		obj = &wrappedObject{obj: obj, reversible: true}
+	} else if s.reverseOrder {
+		obj = &wrappedObject{obj: obj}
+	} else {
+		blockRef := block.AsRef()
+		var headBlock BlockRef = blockRef
+		if s.headBlockHint != nil {
+			if hint := s.headBlockHint(); hint != nil && hint.Num() > blockRef.Num() {
+				headBlock = hint
+			}
+		}
+		obj = &wrappedObject{
+			obj: obj,
+			cursor: &Cursor{
+				Step:      s.cursorStep,
+				Block:     blockRef,
+				LIB:       blockRef,
+				HeadBlock: headBlock,
+			}}
+	}
 
 	select {
-	case <-s.Terminating():
+	case <-ctx.Done():
 		return
 	case out <- &PreprocessedBlock{Block: block, Obj: obj}:
 	}
 }
 
 func (s *FileSource) streamIncomingFile(newIncomingFile *incomingBlocksFile, blocksStore dstore.Store) error {
-	atomic.AddInt64(&currentOpenFiles, 1)
-	s.logger.Debug("open files", zap.Int64("count", atomic.LoadInt64(&currentOpenFiles)), zap.String("filename", newIncomingFile.filename))
-	defer atomic.AddInt64(&currentOpenFiles, -1)
+	if newIncomingFile.oneBlockFiles != nil {
+		return s.streamOneBlockFiles(newIncomingFile)
+	}
+
+	var backoff *retryBackoff
+	for attempt := 1; ; attempt++ {
+		// resumes after the blocks sent by the previous attempts
+		err := s.streamBlocksFile(newIncomingFile, blocksStore, newIncomingFile.lastBlockRead)
+		var readErr *blockReadError
+		if err == nil || !errors.As(err, &readErr) {
+			return err
+		}
+
+		if attempt > s.openRetries || s.ctx.Err() != nil {
+			endStream(newIncomingFile)
+			if attempt > 1 {
+				return fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		if backoff == nil {
+			backoff = s.retryBackoff.clone()
+		}
+		delay := backoff.next()
+		s.logger.Warn("reading blocks file failed, resuming", zap.String("filename", newIncomingFile.filename), zap.Stringer("last_block_read", newIncomingFile.lastBlockRead), zap.Int("attempt", attempt), zap.Duration("retry_delay", delay), zap.Error(err))
 
-	var skipBlocksBefore BlockRef
+		select {
+		case <-s.ctx.Done():
+			endStream(newIncomingFile)
+			return s.ctx.Err()
+		case <-s.after(delay):
+		}
+	}
+}
+
+// endStream closes the blocks of `file` after the ones already read, once a
+// failed read is not retried
+func endStream(file *incomingBlocksFile) {
+	if file.ended {
+		return
+	}
+	file.ended = true
+	if file.preprocessed == nil {
+		// nothing was read
+		close(file.blocks)
+		return
+	}
+	close(file.preprocessed)
+}
 
-	reader, err := blocksStore.OpenObject(context.Background(), newIncomingFile.filename)
+// streamBlocksFile opens the blocks file and streams its blocks following
+// `prevLastBlockRead`
+func (s *FileSource) streamBlocksFile(newIncomingFile *incomingBlocksFile, blocksStore dstore.Store, prevLastBlockRead BlockRef) error {
+	var reader io.ReadCloser
+	var err error
+	if s.localBuffer != nil {
+		reader, err = s.download(blocksStore, newIncomingFile.filename)
+	} else {
+		reader, err = s.openObject(blocksStore, newIncomingFile.filename)
+	}
 	if err != nil {
 		return fmt.Errorf("fetching %s from block store: %w", newIncomingFile.filename, err)
 	}
+	s.logger.Debug("open files", zap.Int64("count", atomic.AddInt64(&s.openFiles, 1)), zap.String("filename", newIncomingFile.filename))
 	defer func() {
+		atomic.AddInt64(&s.openFiles, -1)
 		if err := reader.Close(); err != nil {
 			s.logger.Error("unable to close reader", zap.Error(err))
 		}
 	}()
 
-	//blockReader, err := s.blockReaderFactory.New(reader)
-	blockReader, err := NewDBinBlockReader(reader)
+	var counted io.Reader = &countingReader{Reader: reader, metrics: s.metrics}
+	if s.bytesLimiter != nil {
+		counted = &limitedReader{Reader: counted, limiter: s.bytesLimiter, source: s}
+	}
+	blockReader, err := s.seekingBlockReader(newIncomingFile, prevLastBlockRead, reader, counted)
 	if err != nil {
-		return fmt.Errorf("unable to create block reader: %w", err)
+		return fmt.Errorf("reading %s: %w", newIncomingFile.filename, err)
+	}
+	if blockReader == nil {
+		decompressed, err := decompressedReader(counted, s.compression)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", newIncomingFile.filename, err)
+		}
+		defer decompressed.Close()
+
+		blockReader, err = s.blockReaderFactory.New(decompressed)
+		if err != nil {
+			return fmt.Errorf("unable to create block reader: %w", err)
+		}
+	}
+	if tolerant, ok := blockReader.(TruncatedTailTolerant); ok && s.tolerateTruncatedTail {
+		tolerant.TolerateTruncatedTail()
 	}
 
-	if err := s.streamReader(blockReader, skipBlocksBefore, newIncomingFile); err != nil {
+	if err := s.streamReader(blockReader, prevLastBlockRead, newIncomingFile); err != nil {
 		return fmt.Errorf("error processing incoming file: %w", err)
 	}
 	return nil
 }
 
+func truncatedTail(reader TruncatedTailTolerant) *TruncatedTailError {
+	if reader == nil {
+		return nil
+	}
+	return reader.TruncatedTail()
+}
+
+// seekingBlockReader returns a block reader starting at the first block of `file`
+// that can be sent, the start block for the sources created from a cursor (the
+// LIB of the cursor) and the first matching block for the filtered ones. It needs
+// `reader` to be seekable and uncompressed, like the files of the local buffer,
+// and a SeekingBlockReaderFactory. It returns nil to read the whole file.
+func (s *FileSource) seekingBlockReader(file *incomingBlocksFile, prevLastBlockRead BlockRef, reader io.Reader, counted io.Reader) (BlockReader, error) {
+	factory, ok := s.blockReaderFactory.(SeekingBlockReaderFactory)
+	if !ok {
+		return nil, nil
+	}
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		return nil, nil
+	}
+	// a new attempt resumes after the last block read, the validations need all the blocks
+	if prevLastBlockRead != nil || s.validateBundles || s.detectGaps {
+		return nil, nil
+	}
+
+	fromBlockNum := s.startBlockNum
+	if len(file.filteredBlocks) != 0 && file.filteredBlocks[0] > fromBlockNum {
+		fromBlockNum = file.filteredBlocks[0]
+	}
+	if fromBlockNum <= file.baseNum {
+		return nil, nil
+	}
+
+	uncompressed, err := isUncompressed(seeker, s.compression)
+	if err != nil || !uncompressed {
+		return nil, err
+	}
+
+	blockReader, err := factory.NewFrom(struct {
+		io.Reader
+		io.Seeker
+	}{counted, seeker}, fromBlockNum)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create block reader: %w", err)
+	}
+	if seekable, ok := blockReader.(*SeekableBlockReader); ok {
+		if seekable.IndexErr != nil {
+			s.logger.Warn("ignoring the index of merged blocks file", zap.String("filename", file.filename), zap.Error(seekable.IndexErr))
+		} else if seekable.Seeked {
+			s.logger.Debug("seeked merged blocks file", zap.String("filename", file.filename), zap.Uint64("from_block_num", fromBlockNum))
+		}
+	}
+	return blockReader, nil
+}
+
+func (s *FileSource) streamOneBlockFiles(newIncomingFile *incomingBlocksFile) error {
+	reader := &oneBlockFilesReader{
+		ctx:           s.ctx,
+		files:         newIncomingFile.oneBlockFiles,
+		downloader:    OneBlockDownloaderFromStore(s.oneBlocksStore),
+		readerFactory: s.blockReaderFactory,
+	}
+	if err := s.streamReader(reader, nil, newIncomingFile); err != nil {
+		var readErr *blockReadError
+		if errors.As(err, &readErr) {
+			endStream(newIncomingFile)
+		}
+		return fmt.Errorf("error processing one-block files: %w", err)
+	}
+	return nil
+}
+
+// oneBlockFilesReader reads the block of each one-block file in turn
+type oneBlockFilesReader struct {
+	ctx           context.Context
+	files         []*OneBlockFile
+	downloader    OneBlockDownloaderFunc
+	readerFactory BlockReaderFactory
+}
+
+func (r *oneBlockFilesReader) Read() (*pbbstream.Block, error) {
+	if len(r.files) == 0 {
+		return nil, io.EOF
+	}
+	file := r.files[0]
+	r.files = r.files[1:]
+
+	data, err := file.Data(r.ctx, r.downloader)
+	if err != nil {
+		return nil, err
+	}
+	blk, err := decodeOneblockfileData(r.readerFactory, data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding one-block file %q: %w", file.CanonicalName, err)
+	}
+	if blk == nil {
+		return nil, fmt.Errorf("one-block file %q contains no block", file.CanonicalName)
+	}
+	return blk, nil
+}
+
+// oneBlockKey identifies a block by its number and truncated ID, like one-block file names do
+func oneBlockKey(num uint64, truncatedID string) string {
+	return fmt.Sprintf("%d-%s", num, truncatedID)
+}
+
+func (s *FileSource) openObject(blocksStore dstore.Store, filename string) (io.ReadCloser, error) {
+	var backoff *retryBackoff
+	for attempt := 1; ; attempt++ {
+		reader, err := blocksStore.OpenObject(s.ctx, filename)
+		if err == nil {
+			return reader, nil
+		}
+
+		if s.secondaryStore != nil && s.ctx.Err() == nil {
+			if reader, secondaryErr := s.secondaryStore.OpenObject(s.ctx, filename); secondaryErr == nil {
+				s.logger.Warn("opening blocks file from secondary store", zap.String("filename", filename), zap.Error(err))
+				s.metrics.SecondaryStoreFailover()
+				return reader, nil
+			}
+		}
+
+		if attempt > s.openRetries || !isRetriableOpenError(err) || s.ctx.Err() != nil {
+			if attempt > 1 {
+				return nil, fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+
+		if backoff == nil {
+			backoff = s.retryBackoff.clone()
+		}
+		delay := backoff.next()
+		s.logger.Warn("opening blocks file failed, retrying", zap.String("filename", filename), zap.Int("attempt", attempt), zap.Duration("retry_delay", delay), zap.Error(err))
+
+		select {
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		case <-s.after(delay):
+		}
+	}
+}
+
+// isRetriableOpenError returns false for errors that will not go away by
+// opening the object again
+func isRetriableOpenError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) {
+		return temporary.Temporary()
+	}
+	return true
+}
+
 func (s *FileSource) launchReader() {
 	baseBlockNum := lowBoundary(s.startBlockNum, s.bundleSize)
 	var delay time.Duration
+	var missingAttempts int
+	var existsErrors int
+	var afterHole bool
+	// archives read since the last missing one
+	var readSinceMissing int
+	// blocks of the missing archive that were sent from one-block files
+	tailedBlocks := make(map[string]bool)
+
+	// nextBundle returns false when there are no more blocks archives to read
+	nextBundle := func() bool {
+		baseBlockNum += s.bundleSize
+		if s.stopBlockNum != 0 && baseBlockNum > s.stopBlockNum {
+			// queued after the last file so that run() terminates the source only
+			// once all of its blocks were sent to the handler
+			select {
+			case <-s.Terminating():
+			case s.fileStream <- &incomingBlocksFile{err: ErrStopBlockReached}:
+			}
+			return false
+		}
+		return true
+	}
 
 	defer close(s.fileStream)
 	for {
 		select {
 		case <-s.Terminating():
 			return
-		case <-time.After(delay):
+		case <-s.after(delay):
 		}
 
 		var filteredBlocks []uint64
 		if s.blockIndexProvider != nil {
-			nextBase, matching, noMoreIndex := s.lookupBlockIndex(baseBlockNum)
+			nextBase, matching, noMoreIndex := s.lookupBlockIndex(s.ctx, baseBlockNum)
 			if noMoreIndex {
 				s.blockIndexProvider = nil
 
-				exists, _, _ := s.checkExists(nextBase)
+				exists, _, _ := s.checkExists(s.ctx, nextBase)
 				if !exists && nextBase > baseBlockNum {
 					matching = nil
 					nextBase -= s.bundleSize
 					s.logger.Debug("index pushing us farther than the last bundle, reading previous one entirely", zap.Uint64("next_base", nextBase))
 				} else {
-					if nextExists, _, _ := s.checkExists(nextBase + s.bundleSize); !nextExists {
+					if nextExists, _, _ := s.checkExists(s.ctx, nextBase+s.bundleSize); !nextExists {
 						matching = nil
 						s.logger.Debug("index pushing us to the last bundle, reading it entirely", zap.Uint64("next_base", nextBase))
 					}
@@ -596,44 +2089,466 @@ func (s *FileSource) launchReader() {
 		}
 
 		now := time.Now()
-		exists, baseFilename, err := s.checkExists(baseBlockNum)
+		exists, baseFilename, err := s.checkExists(s.ctx, baseBlockNum)
 		if err != nil {
-			s.logger.Warn("storage returned an error reading blocks file", zap.Error(err))
-			s.Shutdown(fmt.Errorf("filesource reading file existence: %w, since %s", err, time.Since(now)))
-			return
+			existsErrors++
+			retryDelay, retry := s.existsErrorRetryDelay(existsErrors)
+			if !retry {
+				s.logger.Warn("storage returned an error reading blocks file", zap.Int("consecutive_errors", existsErrors), zap.Error(err))
+				s.Shutdown(fmt.Errorf("filesource reading file existence (%d consecutive errors): %w, since %s", existsErrors, err, time.Since(now)))
+				return
+			}
+			delay = retryDelay
+			s.logger.Warn("storage returned an error reading blocks file, retrying", zap.String("base_filename", baseFilename), zap.Int("consecutive_errors", existsErrors), zap.Duration("retry_delay", delay), zap.Error(err))
+			continue
+		}
+		existsErrors = 0
+
+		if s.bundleLayout != nil {
+			filename, bundleSize, found, err := s.detectBundle(s.ctx, baseBlockNum, exists)
+			if err != nil {
+				s.Shutdown(fmt.Errorf("filesource detecting bundle size: %w", err))
+				return
+			}
+			if found {
+				if bundleSize != s.bundleSize {
+					s.logger.Info("bundle size changed", zap.String("base_filename", filename), zap.Uint64("bundle_size", bundleSize), zap.Uint64("previous_bundle_size", s.bundleSize))
+				}
+				exists = true
+				baseFilename = filename
+				s.bundleSize = bundleSize
+			}
 		}
 
 		if !exists {
-			s.logger.Debug("reading from blocks store: file does not (yet?) exist, retrying in", zap.String("filename", s.blocksStore.ObjectPath(baseFilename)), zap.String("base_filename", baseFilename), zap.Any("retry_delay", s.retryDelay))
-			delay = s.retryDelay
+			if readSinceMissing > 0 && !s.IsNearLive() {
+				s.setNearLive(true)
+				if s.onNearLive != nil {
+					s.onNearLive(baseBlockNum - s.bundleSize)
+				}
+			}
+			readSinceMissing = 0
+
+			if s.terminateOnEnd {
+				ended, err := s.storeEndsBefore(s.ctx, baseBlockNum)
+				if err != nil {
+					s.logger.Warn("unable to list the blocks store to find its end", zap.Uint64("base_block_num", baseBlockNum), zap.Error(err))
+				}
+				if ended {
+					s.logger.Info("no more blocks files in store", zap.String("base_filename", baseFilename))
+					// queued after the last file like for the stop block
+					select {
+					case <-s.Terminating():
+					case s.fileStream <- &incomingBlocksFile{err: ErrEndOfStore}:
+					}
+					return
+				}
+			}
+
+			missingAttempts++
+			if s.oneBlocksStore != nil && missingAttempts > 1 {
+				if !s.queueOneBlockFiles(baseBlockNum, tailedBlocks) {
+					return
+				}
+			}
+			if s.onHole != nil && missingAttempts >= s.holeSkippingAfter {
+				missingAttempts = 0
+				if s.onHole(baseBlockNum) {
+					s.logger.Warn("skipping missing blocks file", zap.String("base_filename", baseFilename))
+					s.addSkippedRange(baseBlockNum)
+					afterHole = true
+					tailedBlocks = make(map[string]bool)
+					delay = 0
+					s.retryBackoff.reset()
+					if !nextBundle() {
+						return
+					}
+					continue
+				}
+			}
+
+			delay = s.missingBundleRetryDelay(baseBlockNum)
+			s.metrics.MissingBundleWait(delay)
+			s.logger.Debug("reading from blocks store: file does not (yet?) exist, retrying in", zap.String("filename", s.storeFor(baseBlockNum).ObjectPath(baseFilename)), zap.String("base_filename", baseFilename), zap.Duration("retry_delay", delay))
 			continue
 		}
 		delay = 0 * time.Second
+		missingAttempts = 0
+		s.retryBackoff.reset()
+
+		readSinceMissing++
+		if readSinceMissing > s.nearLiveWithin && s.IsNearLive() {
+			s.logger.Debug("fell behind the highest blocks file", zap.String("base_filename", baseFilename), zap.Int("read_since_missing", readSinceMissing))
+			s.setNearLive(false)
+		}
 
 		// container that is sent to s.fileStream
-		newIncomingFile := newIncomingBlocksFile(baseBlockNum, baseFilename, filteredBlocks)
+		newIncomingFile := newIncomingBlocksFile(baseBlockNum, s.bundleSize, baseFilename, filteredBlocks)
+		newIncomingFile.afterHole = afterHole || len(tailedBlocks) > 0
+		afterHole = false
+		if len(tailedBlocks) > 0 {
+			newIncomingFile.skipBlocks = tailedBlocks
+			tailedBlocks = make(map[string]bool)
+		}
+		if s.prefetch > 0 {
+			// the archive can be decoded ahead of the handler, up to its buffer size
+			bufferSize := s.bundleSize
+			if s.decodedBlocksBuffer > 0 && uint64(s.decodedBlocksBuffer) < bufferSize {
+				bufferSize = uint64(s.decodedBlocksBuffer)
+			}
+			newIncomingFile.blocks = make(chan *PreprocessedBlock, bufferSize)
+		}
+
+		if !s.queueIncomingFile(newIncomingFile) {
+			return
+		}
+
+		if !nextBundle() {
+			return
+		}
+	}
+
+}
 
+// missingBundleRetryDelay returns the delay before looking again for the
+// missing blocks archive starting at baseBlockNum
+func (s *FileSource) missingBundleRetryDelay(baseBlockNum uint64) time.Duration {
+	if s.headTracker != nil && s.headTracker() > baseBlockNum+2*s.bundleSize {
+		return catchingUpRetryDelay
+	}
+	return s.retryBackoff.next()
+}
+
+// storeEndsBefore returns true when the store has no blocks archive based at or
+// above `baseBlockNum`.
+func (s *FileSource) storeEndsBefore(ctx context.Context, baseBlockNum uint64) (bool, error) {
+	prefix := s.filenameScheme.listingPrefix(baseBlockNum, math.MaxUint64)
+	partitions := s.storePartitions()
+	for i, partition := range partitions {
+		low, high := partitionRange(partitions, i)
+		if high <= baseBlockNum {
+			continue
+		}
+		low = max(low, baseBlockNum)
+
+		var found bool
+		err := partition.Store.WalkFrom(ctx, prefix, s.filenameScheme.format(low), func(filename string) error {
+			if base, err := s.filenameScheme.parse(filename); err == nil && base >= low && base < high {
+				found = true
+				return dstore.StopIteration
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// existsErrorRetryDelay returns the delay before checking the existence of a
+// blocks archive again after `existsErrors` consecutive errors, false once the
+// error budget is spent or the source is terminating.
+func (s *FileSource) existsErrorRetryDelay(existsErrors int) (time.Duration, bool) {
+	if existsErrors > s.existsErrorBudget || s.ctx.Err() != nil {
+		return 0, false
+	}
+	return s.retryBackoff.next(), true
+}
+
+// queueIncomingFile sends the file to run() and starts streaming its blocks,
+// it returns false if the source is terminating.
+func (s *FileSource) queueIncomingFile(newIncomingFile *incomingBlocksFile) bool {
+	// acquired in files order so that the file consumed by run() always has a slot
+	select {
+	case <-s.Terminating():
+		return false
+	case s.openFilesSem <- struct{}{}:
+	}
+	if !s.startStream() {
+		<-s.openFilesSem
+		return false
+	}
+	newIncomingFile.queuedAt = time.Now()
+	// the slot is released once run() consumed the blocks and the archive is
+	// closed, the blocks can be consumed before the reader is closed
+	pending := int32(2)
+	release := func() {
+		if atomic.AddInt32(&pending, -1) == 0 {
+			<-s.openFilesSem
+		}
+	}
+	var releaseOnce sync.Once
+	newIncomingFile.release = func() {
+		releaseOnce.Do(release)
+	}
+
+	select {
+	case <-s.Terminating():
+		newIncomingFile.release()
+		release()
+		s.streams.Done()
+		return false
+	case s.fileStream <- newIncomingFile:
+		zlog.Debug("new incoming file", zap.String("filename", newIncomingFile.filename))
+	}
+
+	go func() {
+		defer release()
+		s.logger.Debug("launching processing of file", zap.String("base_filename", newIncomingFile.filename))
+		err := s.streamIncomingFile(newIncomingFile, s.storeFor(newIncomingFile.baseNum))
+		// the archive is closed, shutting down the source below waits for it
+		s.streams.Done()
+		if err != nil {
+			if s.onBundleError != nil && newIncomingFile.oneBlockFiles == nil && !s.IsTerminating() {
+				s.logger.Warn("processing of file failed, skipping it", zap.String("base_filename", newIncomingFile.filename), zap.Error(err))
+				if budgetErr := s.bundleFailed(newIncomingFile.baseNum, err); budgetErr != nil {
+					s.Shutdown(budgetErr)
+					return
+				}
+				// read by run() once the blocks are closed
+				newIncomingFile.failed = true
+				endStream(newIncomingFile)
+				return
+			}
+			s.Shutdown(fmt.Errorf("processing of file %q failed: %w", newIncomingFile.filename, err))
+		}
+	}()
+	return true
+}
+
+// startStream registers a goroutine reading a blocks archive, waited for when
+// the source terminates. It returns false if the source is terminating.
+func (s *FileSource) startStream() bool {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	if s.IsTerminating() {
+		return false
+	}
+	s.streams.Add(1)
+	return true
+}
+
+// queueOneBlockFiles sends the one-block files of the missing archive starting at
+// baseBlockNum that are not in `sent` yet, it returns false if the source is
+// terminating.
+func (s *FileSource) queueOneBlockFiles(baseBlockNum uint64, sent map[string]bool) bool {
+	to := baseBlockNum + s.bundleSize - 1
+	if s.stopBlockNum != 0 && s.stopBlockNum < to {
+		to = s.stopBlockNum
+	}
+
+	files, err := listOneBlocks(s.ctx, baseBlockNum, to, s.oneBlocksStore)
+	if err != nil {
+		s.Shutdown(fmt.Errorf("filesource listing one-block files: %w", err))
+		return false
+	}
+
+	var newFiles []*OneBlockFile
+	for _, file := range files {
+		key := oneBlockKey(file.Num, file.ID)
+		if sent[key] {
+			continue
+		}
+		sent[key] = true
+		newFiles = append(newFiles, file)
+	}
+	if len(newFiles) == 0 {
+		return true
+	}
+
+	filename := fmt.Sprintf("one-block files [%d, %d]", newFiles[0].Num, newFiles[len(newFiles)-1].Num)
+	s.logger.Debug("blocks archive missing, sending one-block files", zap.Uint64("base_block_num", baseBlockNum), zap.Int("count", len(newFiles)))
+	newIncomingFile := newIncomingBlocksFile(baseBlockNum, s.bundleSize, filename, nil)
+	newIncomingFile.oneBlockFiles = newFiles
+	return s.queueIncomingFile(newIncomingFile)
+}
+
+// launchReverseReader sends the files from the one containing stopBlockNum down
+// to the one containing startBlockNum
+func (s *FileSource) launchReverseReader() {
+	lowestBaseBlockNum := lowBoundary(s.startBlockNum, s.bundleSize)
+	baseBlockNum := lowBoundary(s.stopBlockNum, s.bundleSize)
+	var delay time.Duration
+	var existsErrors int
+
+	defer close(s.fileStream)
+	for {
 		select {
 		case <-s.Terminating():
 			return
-		case s.fileStream <- newIncomingFile:
-			zlog.Debug("new incoming file", zap.String("filename", newIncomingFile.filename))
+		case <-s.after(delay):
 		}
 
-		go func() {
-			s.logger.Debug("launching processing of file", zap.String("base_filename", baseFilename))
-			if err := s.streamIncomingFile(newIncomingFile, s.blocksStore); err != nil {
-				s.Shutdown(fmt.Errorf("processing of file %q failed: %w", baseFilename, err))
+		now := time.Now()
+		exists, baseFilename, err := s.checkExists(s.ctx, baseBlockNum)
+		if err != nil {
+			existsErrors++
+			retryDelay, retry := s.existsErrorRetryDelay(existsErrors)
+			if !retry {
+				s.logger.Warn("storage returned an error reading blocks file", zap.Int("consecutive_errors", existsErrors), zap.Error(err))
+				s.Shutdown(fmt.Errorf("filesource reading file existence (%d consecutive errors): %w, since %s", existsErrors, err, time.Since(now)))
+				return
 			}
-		}()
+			delay = retryDelay
+			s.logger.Warn("storage returned an error reading blocks file, retrying", zap.String("base_filename", baseFilename), zap.Int("consecutive_errors", existsErrors), zap.Duration("retry_delay", delay), zap.Error(err))
+			continue
+		}
+		existsErrors = 0
 
-		baseBlockNum += s.bundleSize
-		if s.stopBlockNum != 0 && baseBlockNum > s.stopBlockNum {
-			s.fileStream <- &incomingBlocksFile{err: ErrStopBlockReached}
+		if !exists {
+			delay = s.retryBackoff.next()
+			s.metrics.MissingBundleWait(delay)
+			s.logger.Debug("reading from blocks store: file does not (yet?) exist, retrying in", zap.String("filename", s.storeFor(baseBlockNum).ObjectPath(baseFilename)), zap.String("base_filename", baseFilename), zap.Duration("retry_delay", delay))
+			continue
+		}
+		delay = 0
+		s.retryBackoff.reset()
+
+		if !s.queueIncomingFile(newIncomingBlocksFile(baseBlockNum, s.bundleSize, baseFilename, nil)) {
 			return
 		}
+
+		if baseBlockNum <= lowestBaseBlockNum {
+			select {
+			case <-s.Terminating():
+			case s.fileStream <- &incomingBlocksFile{err: ErrStopBlockReached}:
+			}
+			return
+		}
+		baseBlockNum -= s.bundleSize
+	}
+}
+
+// detectBundle returns the file containing `baseBlockNum` and the amount of
+// blocks it holds from `baseBlockNum`, which is where the next file starts.
+// When the file does not exist at `baseBlockNum`, the store is listed again to
+// find one that covers it.
+func (s *FileSource) detectBundle(ctx context.Context, baseBlockNum uint64, exists bool) (filename string, bundleSize uint64, found bool, err error) {
+	fileBase := baseBlockNum
+	if !exists {
+		fileBase, found, err = s.bundleLayout.covering(ctx, baseBlockNum, true)
+		if err != nil || !found {
+			return "", 0, false, err
+		}
+	}
+
+	next, found, err := s.bundleLayout.nextBase(ctx, fileBase, false)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if !found {
+		if !exists {
+			// we cannot tell if the last file covers baseBlockNum
+			return "", 0, false, nil
+		}
+		// this is the last file, we keep the current bundle size
+		return s.filenameScheme.format(fileBase), s.bundleSize, true, nil
+	}
+	if next <= baseBlockNum {
+		return "", 0, false, nil
+	}
+
+	return s.filenameScheme.format(fileBase), next - baseBlockNum, true, nil
+}
+
+// bundleFailed records the failure of the blocks archive based at `baseNum`, it
+// returns the error shutting down the source once the error budget is exceeded.
+func (s *FileSource) bundleFailed(baseNum uint64, err error) error {
+	s.failedBundlesLock.Lock()
+	s.failedBundles = append(s.failedBundles, baseNum)
+	s.failedBundleErrs = append(s.failedBundleErrs, err)
+	failed := append([]uint64(nil), s.failedBundles...)
+	errs := append([]error(nil), s.failedBundleErrs...)
+	s.failedBundlesLock.Unlock()
+
+	s.addSkippedRange(baseNum)
+	s.onBundleError(baseNum, err)
+	if len(failed) > s.errorBudget {
+		return fmt.Errorf("error budget of %d failed merged blocks files exceeded, failed bases %v: %w", s.errorBudget, failed, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (s *FileSource) addSkippedRange(baseBlockNum uint64) {
+	s.skippedRangesLock.Lock()
+	defer s.skippedRangesLock.Unlock()
+
+	endBlockNum := baseBlockNum + s.bundleSize
+	if count := len(s.skippedRanges); count > 0 && *s.skippedRanges[count-1].EndBlock() == baseBlockNum {
+		s.skippedRanges[count-1] = NewRangeExcludingEnd(s.skippedRanges[count-1].StartBlock(), endBlockNum)
+		return
+	}
+	s.skippedRanges = append(s.skippedRanges, NewRangeExcludingEnd(baseBlockNum, endBlockNum))
+}
+
+// SkippedRanges returns the ranges of the blocks archives that were skipped
+// because they were missing, see FileSourceWithHoleSkipping. Contiguous ranges
+// are merged, end blocks are exclusive.
+func (s *FileSource) SkippedRanges() []*Range {
+	s.skippedRangesLock.Lock()
+	defer s.skippedRangesLock.Unlock()
+
+	out := make([]*Range, len(s.skippedRanges))
+	copy(out, s.skippedRanges)
+	return out
+}
+
+// IsNearLive returns true when the source caught up with the highest blocks
+// archive of the store, see FileSourceWithNearLiveCallback. It is safe to call
+// while the source is running.
+func (s *FileSource) IsNearLive() bool {
+	return atomic.LoadInt32(&s.nearLive) == 1
+}
+
+func (s *FileSource) setNearLive(nearLive bool) {
+	var value int32
+	if nearLive {
+		value = 1
 	}
+	atomic.StoreInt32(&s.nearLive, value)
+}
+
+// OpenFiles returns the amount of blocks archives currently open on the store
+func (s *FileSource) OpenFiles() int {
+	return int(atomic.LoadInt64(&s.openFiles))
+}
+
+// BufferedPayloadBytes returns the size of the payloads of the blocks decoded
+// ahead of the handler, waiting in the buffers of the prefetched archives. It is
+// safe to call while the source is running and is 0 once it stopped.
+func (s *FileSource) BufferedPayloadBytes() int {
+	s.bufferedBytesLock.Lock()
+	defer s.bufferedBytesLock.Unlock()
+	return s.bufferedBytes
+}
+
+func (s *FileSource) addBufferedBytes(delta int) {
+	s.bufferedBytesLock.Lock()
+	defer s.bufferedBytesLock.Unlock()
+	if !s.bufferedBytesDropped {
+		s.bufferedBytes += delta
+	}
+}
+
+func (s *FileSource) dropBufferedBytes() {
+	s.bufferedBytesLock.Lock()
+	defer s.bufferedBytesLock.Unlock()
+	s.bufferedBytesDropped = true
+	s.bufferedBytes = 0
+}
+
+// HighestProcessedBlock returns the highest block that was successfully
+// processed by the handler, or nil if none was processed yet. It is safe to
+// call while the source is running.
+func (s *FileSource) HighestProcessedBlock() BlockRef {
+	s.highestFileProcessedBlockLock.RLock()
+	defer s.highestFileProcessedBlockLock.RUnlock()
 
+	return s.highestFileProcessedBlock
 }
 
 func (s *FileSource) SetLogger(logger *zap.Logger) {
@@ -0,0 +1,238 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// slowStore simulates the latency of a remote object store on OpenObject
+type slowStore struct {
+	*dstore.MockStore
+	latency time.Duration
+
+	canceledOpens int64
+}
+
+func (s *slowStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	select {
+	case <-ctx.Done():
+		atomic.AddInt64(&s.canceledOpens, 1)
+		return nil, ctx.Err()
+	case <-time.After(s.latency):
+	}
+	return s.MockStore.OpenObject(ctx, name)
+}
+
+// countingStore counts the existence checks and listings of the store
+type countingStore struct {
+	*dstore.MockStore
+
+	fileExistsCalls int64
+	walkCalls       int64
+}
+
+func (s *countingStore) FileExists(ctx context.Context, base string) (bool, error) {
+	atomic.AddInt64(&s.fileExistsCalls, 1)
+	return s.MockStore.FileExists(ctx, base)
+}
+
+func (s *countingStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	atomic.AddInt64(&s.walkCalls, 1)
+	return s.MockStore.Walk(ctx, prefix, f)
+}
+
+func (s *countingStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) error) error {
+	atomic.AddInt64(&s.walkCalls, 1)
+	return s.MockStore.WalkFrom(ctx, prefix, startingPoint, f)
+}
+
+func newLinearBundlesStore(bundleCount int, bundleSize uint64) (store *dstore.MockStore, lastBlockNum uint64) {
+	store = dstore.NewMockStore(nil)
+
+	prevID := "00"
+	for i := 0; i < bundleCount; i++ {
+		baseNum := uint64(i) * bundleSize
+		var blocks []*pbbstream.Block
+		for num := baseNum; num < baseNum+bundleSize; num++ {
+			if num == 0 {
+				continue
+			}
+			id := fmt.Sprintf("%da", num)
+			blocks = append(blocks, TestBlockWithNumbers(id, prevID, num, 0))
+			prevID = id
+			lastBlockNum = num
+		}
+		store.SetFile(base(int(baseNum)), testBlocks(blocks...))
+	}
+	return
+}
+
+func BenchmarkFileSource_SlowStore(b *testing.B) {
+	mockStore, lastBlockNum := newLinearBundlesStore(10, 100)
+	store := &slowStore{MockStore: mockStore, latency: 10 * time.Millisecond}
+
+	for _, prefetch := range []int{0, 2, 4} {
+		b.Run(fmt.Sprintf("prefetch=%d", prefetch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+					if blk.Number == lastBlockNum {
+						return errDone
+					}
+					return nil
+				})
+
+				fs := NewFileSource(store, 1, handler, zap.NewNop(), FileSourceWithPrefetch(prefetch))
+				fs.Run()
+				if fs.Err() != errDone {
+					b.Fatalf("unexpected error: %s", fs.Err())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFileSource_ListedBundles(b *testing.B) {
+	mockStore, lastBlockNum := newLinearBundlesStore(200, 10)
+
+	for _, listed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("listed=%t", listed), func(b *testing.B) {
+			store := &countingStore{MockStore: mockStore}
+			for i := 0; i < b.N; i++ {
+				handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+					if blk.Number == lastBlockNum {
+						return errDone
+					}
+					return nil
+				})
+
+				var opts []FileSourceOption
+				if listed {
+					opts = append(opts, FileSourceWithListedBundles(5))
+				}
+				fs := NewFileSource(store, 1, handler, zap.NewNop(), append(opts, FileSourceWithBundleSize(10))...)
+				fs.Run()
+				if fs.Err() != errDone {
+					b.Fatalf("unexpected error: %s", fs.Err())
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&store.fileExistsCalls))/float64(b.N), "exists/op")
+			b.ReportMetric(float64(atomic.LoadInt64(&store.walkCalls))/float64(b.N), "walks/op")
+		})
+	}
+}
+
+// cpuHeavyReader simulates an expensive block decoding
+type cpuHeavyReader struct {
+	BlockReader
+	rounds int
+}
+
+func (r *cpuHeavyReader) Read() (*pbbstream.Block, error) {
+	blk, err := r.BlockReader.Read()
+	if blk != nil {
+		sum := sha256.Sum256([]byte(blk.Id))
+		for i := 0; i < r.rounds; i++ {
+			sum = sha256.Sum256(sum[:])
+		}
+	}
+	return blk, err
+}
+
+func BenchmarkFileSource_CPUBoundDecode(b *testing.B) {
+	store, lastBlockNum := newLinearBundlesStore(16, 100)
+
+	for _, prefetch := range []int{0, 1, 3, 7} {
+		b.Run(fmt.Sprintf("parallel_bundles=%d", prefetch+1), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+					if blk.Number == lastBlockNum {
+						return errDone
+					}
+					return nil
+				})
+
+				cpuHeavyReaderFactory := BlockReaderFactoryFunc(func(reader io.Reader) (BlockReader, error) {
+					blockReader, err := NewDBinBlockReader(reader)
+					if err != nil {
+						return nil, err
+					}
+					return &cpuHeavyReader{BlockReader: blockReader, rounds: 2000}, nil
+				})
+				fs := NewFileSource(store, 1, handler, zap.NewNop(), FileSourceWithPrefetch(prefetch), FileSourceWithBlockReaderFactory(cpuHeavyReaderFactory))
+				fs.Run()
+				if fs.Err() != errDone {
+					b.Fatalf("unexpected error: %s", fs.Err())
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFileSource_HeaderOnly replays bundles of blocks with large payloads
+func BenchmarkFileSource_HeaderOnly(b *testing.B) {
+	store := dstore.NewMockStore(nil)
+	prevID := "00"
+	var lastBlockNum uint64
+	for baseNum := uint64(0); baseNum < 1000; baseNum += 100 {
+		var blocks []*pbbstream.Block
+		for num := baseNum; num < baseNum+100; num++ {
+			blk := TestBlockWithTimestamp(fmt.Sprintf("%08xa", num), prevID, time.Unix(1700000000+int64(num), 0))
+			blk.Number = num
+			blk.LibNum = num
+			blk.Payload.Value = bytes.Repeat([]byte{0x01}, 64*1024)
+			blocks = append(blocks, blk)
+			prevID = blk.Id
+			lastBlockNum = num
+		}
+		store.SetFile(base(int(baseNum)), testBlocks(blocks...))
+	}
+
+	for _, headerOnly := range []bool{false, true} {
+		b.Run(fmt.Sprintf("header_only=%t", headerOnly), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+					if blk.Number == lastBlockNum {
+						return errDone
+					}
+					return nil
+				})
+
+				var opts []FileSourceOption
+				if headerOnly {
+					opts = append(opts, FileSourceWithHeaderOnly())
+				}
+				fs := NewFileSource(store, 1, handler, zap.NewNop(), opts...)
+				fs.Run()
+				if fs.Err() != errDone {
+					b.Fatalf("unexpected error: %s", fs.Err())
+				}
+			}
+		})
+	}
+}
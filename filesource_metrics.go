@@ -0,0 +1,119 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// FileSourceMetrics receives the events of a FileSource, it is called from
+// many goroutines so implementations must be safe for concurrent use.
+type FileSourceMetrics interface {
+	// BytesRead is called with the amount of bytes read from the store
+	BytesRead(n int)
+	// BlockDecoded is called for each block read from a blocks archive
+	BlockDecoded()
+	// BlockDelivered is called for each block successfully processed by the handler
+	BlockDelivered()
+	// BundleStarted is called when the blocks of an archive start being sent to the handler
+	BundleStarted(baseBlockNum uint64)
+	// MissingBundleWait is called with the delay before trying again to find a missing archive
+	MissingBundleWait(delay time.Duration)
+	// SecondaryStoreFailover is called each time a file is read from the secondary store
+	SecondaryStoreFailover()
+}
+
+type noopFileSourceMetrics struct{}
+
+func (noopFileSourceMetrics) BytesRead(int)                   {}
+func (noopFileSourceMetrics) BlockDecoded()                   {}
+func (noopFileSourceMetrics) BlockDelivered()                 {}
+func (noopFileSourceMetrics) BundleStarted(uint64)            {}
+func (noopFileSourceMetrics) MissingBundleWait(time.Duration) {}
+func (noopFileSourceMetrics) SecondaryStoreFailover()         {}
+
+// AtomicFileSourceMetrics is an in-memory FileSourceMetrics, its zero value is ready to use
+type AtomicFileSourceMetrics struct {
+	bytesRead         int64
+	blocksDecoded     int64
+	blocksDelivered   int64
+	currentBundleBase uint64
+	missingBundleWait int64
+	failovers         int64
+}
+
+func (m *AtomicFileSourceMetrics) BytesRead(n int) {
+	atomic.AddInt64(&m.bytesRead, int64(n))
+}
+
+func (m *AtomicFileSourceMetrics) BlockDecoded() {
+	atomic.AddInt64(&m.blocksDecoded, 1)
+}
+
+func (m *AtomicFileSourceMetrics) BlockDelivered() {
+	atomic.AddInt64(&m.blocksDelivered, 1)
+}
+
+func (m *AtomicFileSourceMetrics) BundleStarted(baseBlockNum uint64) {
+	atomic.StoreUint64(&m.currentBundleBase, baseBlockNum)
+}
+
+func (m *AtomicFileSourceMetrics) MissingBundleWait(delay time.Duration) {
+	atomic.AddInt64(&m.missingBundleWait, int64(delay))
+}
+
+func (m *AtomicFileSourceMetrics) SecondaryStoreFailover() {
+	atomic.AddInt64(&m.failovers, 1)
+}
+
+func (m *AtomicFileSourceMetrics) TotalBytesRead() int64 {
+	return atomic.LoadInt64(&m.bytesRead)
+}
+
+func (m *AtomicFileSourceMetrics) TotalBlocksDecoded() int64 {
+	return atomic.LoadInt64(&m.blocksDecoded)
+}
+
+func (m *AtomicFileSourceMetrics) TotalBlocksDelivered() int64 {
+	return atomic.LoadInt64(&m.blocksDelivered)
+}
+
+func (m *AtomicFileSourceMetrics) CurrentBundleBase() uint64 {
+	return atomic.LoadUint64(&m.currentBundleBase)
+}
+
+func (m *AtomicFileSourceMetrics) TotalMissingBundleWait() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.missingBundleWait))
+}
+
+func (m *AtomicFileSourceMetrics) TotalSecondaryStoreFailovers() int64 {
+	return atomic.LoadInt64(&m.failovers)
+}
+
+// countingReader reports the bytes read from the store to the metrics
+type countingReader struct {
+	io.Reader
+	metrics FileSourceMetrics
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.metrics.BytesRead(n)
+	}
+	return n, err
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"math"
+	"sort"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// StorePartition is a blocks store holding the blocks archives based at or
+// above FromBlock, up to the FromBlock of the next partition.
+type StorePartition struct {
+	Store     dstore.Store
+	FromBlock uint64
+}
+
+// NewPartitionedFileSource creates a FileSource reading each blocks archive from
+// the partition owning its base block number, the blocks below the lowest
+// FromBlock being read from the lowest partition. A partition boundary within a
+// bundle is read from the partition owning the base block of the bundle. It
+// panics when `partitions` is empty.
+func NewPartitionedFileSource(
+	partitions []StorePartition,
+	startBlockNum uint64,
+	h Handler,
+	logger *zap.Logger,
+	options ...FileSourceOption,
+) *FileSource {
+	if len(partitions) == 0 {
+		panic("partitioned file source requires at least one partition")
+	}
+
+	sorted := make([]StorePartition, len(partitions))
+	copy(sorted, partitions)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FromBlock < sorted[j].FromBlock })
+
+	// applied first so that the other options see the partitions
+	options = append([]FileSourceOption{func(c *fileSourceConfig) { c.partitions = sorted }}, options...)
+	return NewFileSource(sorted[0].Store, startBlockNum, h, logger, options...)
+}
+
+// partitionRange returns the base block numbers owned by the partition at
+// `index`, from `low` included to `high` excluded.
+func partitionRange(partitions []StorePartition, index int) (low, high uint64) {
+	if index > 0 {
+		low = partitions[index].FromBlock
+	}
+	high = math.MaxUint64
+	if index < len(partitions)-1 {
+		high = partitions[index+1].FromBlock
+	}
+	return
+}
+
+// storePartitions returns the partitions of the source, blocksStore being the
+// only one when it is not partitioned.
+func (s *FileSource) storePartitions() []StorePartition {
+	if s.partitions != nil {
+		return s.partitions
+	}
+	return []StorePartition{{Store: s.blocksStore}}
+}
+
+// storeFor returns the store of the partition owning `baseBlockNum`.
+func (s *FileSource) storeFor(baseBlockNum uint64) dstore.Store {
+	store, _ := s.partitionFor(baseBlockNum)
+	return store
+}
+
+// partitionFor returns the store of the partition owning `baseBlockNum` and
+// the base block number at which the next partition starts.
+func (s *FileSource) partitionFor(baseBlockNum uint64) (store dstore.Store, end uint64) {
+	store, end = s.blocksStore, math.MaxUint64
+	for _, partition := range s.partitions {
+		if partition.FromBlock > baseBlockNum {
+			end = partition.FromBlock
+			break
+		}
+		store = partition.Store
+	}
+	return store, end
+}
@@ -16,7 +16,16 @@ package bstream
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -113,17 +122,16 @@ func TestFileSource_Race(t *testing.T) {
 }
 
 func TestFileSource_Run(t *testing.T) {
-	bs := dstore.NewMockStore(nil)
-	bs.SetFile(base(0), testBlocks(
+	expectedBlocks := []*pbbstream.Block{
 		TestBlockWithNumbers("1a", "00", 1, 0),
 		TestBlockWithNumbers("2a", "1a", 2, 0),
-	))
-	bs.SetFile(base(100), testBlocks(
 		TestBlockWithNumbers("103a", "2a", 103, 0),
 		TestBlockWithNumbers("104a", "103a", 104, 0),
-	))
+	}
+	bs := dstore.NewMockStore(nil)
+	bs.SetFile(base(0), testBlocks(expectedBlocks[:2]...))
+	bs.SetFile(base(100), testBlocks(expectedBlocks[2:]...))
 
-	expectedBlocks := []uint64{1, 2, 103, 104}
 	preProcessCount := 0
 	preprocessor := PreprocessFunc(func(blk *pbbstream.Block) (interface{}, error) {
 		preProcessCount++
@@ -134,7 +142,7 @@ func TestFileSource_Run(t *testing.T) {
 	handlerCount := 0
 	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
 		zlog.Debug("test : received block", zap.Stringer("block_ref", blk.AsRef()))
-		require.Equal(t, expectedBlocks[handlerCount], blk.Number)
+		AssertBlocksEqual(t, expectedBlocks[handlerCount], blk)
 		require.Equal(t, blk.Id, obj.(ObjectWrapper).WrappedObject())
 		if handlerCount >= len(expectedBlocks)-1 {
 			close(testDone)
@@ -221,6 +229,7 @@ func TestFileSource_lookupBlockIndex(t *testing.T) {
 		stopBlockNum                uint64
 		indexProvider               BlockIndexProvider
 		simulatePassedProgressDelay bool
+		maxIndexLookahead           uint64
 		expectBaseBlock             uint64
 		expectOutBLocks             []uint64
 		expectNoMoreIndex           bool
@@ -285,6 +294,29 @@ func TestFileSource_lookupBlockIndex(t *testing.T) {
 			expectNoMoreIndex:           false,
 			simulatePassedProgressDelay: true,
 		},
+		{
+			name: "next block of interest 10,000 bundles away",
+			in:   100,
+			indexProvider: &TestBlockIndexProvider{
+				Blocks:           []uint64{1_000_150},
+				LastIndexedBlock: 2_000_000,
+			},
+			expectBaseBlock:   1_000_100,
+			expectOutBLocks:   []uint64{1_000_150},
+			expectNoMoreIndex: false,
+		},
+		{
+			name: "next block of interest past the max lookahead",
+			in:   100,
+			indexProvider: &TestBlockIndexProvider{
+				Blocks:           []uint64{1_000_150},
+				LastIndexedBlock: 2_000_000,
+			},
+			maxIndexLookahead: 50,
+			expectBaseBlock:   5000,
+			expectOutBLocks:   []uint64{},
+			expectNoMoreIndex: false,
+		},
 	}
 
 	for _, test := range tests {
@@ -294,14 +326,17 @@ func TestFileSource_lookupBlockIndex(t *testing.T) {
 				progDelay = 0
 			}
 			fs := &FileSource{
+				fileSourceConfig: fileSourceConfig{
+					stopBlockNum:       test.stopBlockNum,
+					blockIndexProvider: test.indexProvider,
+					bundleSize:         100,
+					maxIndexLookahead:  test.maxIndexLookahead,
+				},
 				startBlockNum:             test.startBlockNum,
-				stopBlockNum:              test.stopBlockNum,
-				blockIndexProvider:        test.indexProvider,
-				bundleSize:                100,
 				logger:                    zlog,
 				timeBetweenProgressBlocks: progDelay,
 			}
-			baseBlock, blocks, noMoreIndex := fs.lookupBlockIndex(test.in)
+			baseBlock, blocks, noMoreIndex := fs.lookupBlockIndex(context.Background(), test.in)
 			assert.Equal(t, test.expectNoMoreIndex, noMoreIndex)
 			assert.Equal(t, test.expectBaseBlock, baseBlock)
 			assert.Equal(t, test.expectOutBLocks, blocks)
@@ -309,3 +344,3156 @@ func TestFileSource_lookupBlockIndex(t *testing.T) {
 	}
 
 }
+
+func TestFileSourceConfig(t *testing.T) {
+	var built []*FileSource
+	config := newFileSourceConfig(
+		FileSourceWithBundleSize(10),
+		FileSourceWithBundleSizeAutoDetect(),
+		FileSourceOptionFunc(func(s *FileSource) { built = append(built, s) }),
+	)
+	assert.Equal(t, uint64(10), config.bundleSize)
+	assert.True(t, config.detectBundleSize)
+	assert.Empty(t, built, "inspecting the options does not build a source")
+
+	fs := newFileSourceWithConfig(context.Background(), dstore.NewMockStore(nil), 1, nil, zlog, config)
+	assert.Equal(t, []*FileSource{fs}, built)
+	assert.Equal(t, uint64(10), fs.bundleSize)
+	assert.NotNil(t, fs.bundleLayout)
+}
+
+type shutdownIndexProvider struct {
+	calls      int
+	shutdownAt int
+	fs         *FileSource
+}
+
+func (p *shutdownIndexProvider) BlocksInRange(lowBlockNum uint64, bundleSize uint64) ([]uint64, error) {
+	p.calls++
+	if p.calls == p.shutdownAt {
+		p.fs.Shutdown(errDone)
+	}
+	return nil, nil
+}
+
+func TestFileSource_lookupBlockIndexShutdown(t *testing.T) {
+	indexProvider := &shutdownIndexProvider{shutdownAt: 500}
+	fs := NewFileSource(dstore.NewMockStore(nil), 0, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error { return nil }), zlog,
+		FileSourceWithBlockIndexProvider(indexProvider),
+	)
+	indexProvider.fs = fs
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, blocks, noMoreIndex := fs.lookupBlockIndex(fs.ctx, 100)
+		assert.True(t, noMoreIndex)
+		assert.Nil(t, blocks)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lookup did not return on shutdown")
+	}
+	assert.Equal(t, 500, indexProvider.calls)
+}
+
+func TestFileSource_Prefetch(t *testing.T) {
+	mockStore, lastBlockNum := newLinearBundlesStore(5, 100)
+	store := &slowStore{MockStore: mockStore, latency: time.Millisecond}
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		if blk.Number == lastBlockNum {
+			return errDone
+		}
+		return nil
+	})
+
+	fs := NewFileSource(store, 1, handler, zlog, FileSourceWithPrefetch(3))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.Equal(t, errDone, fs.Err())
+	require.Len(t, received, int(lastBlockNum))
+	for i, num := range received {
+		require.Equal(t, uint64(i+1), num)
+	}
+}
+
+func TestFileSource_PrefetchCancelsDownloads(t *testing.T) {
+	mockStore, _ := newLinearBundlesStore(5, 100)
+	store := &slowStore{MockStore: mockStore, latency: time.Hour}
+
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	})
+	fs := NewFileSource(store, 1, handler, zlog, FileSourceWithPrefetch(3))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	fs.Shutdown(errDone)
+
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.Equal(t, errDone, fs.Err())
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&store.canceledOpens) > 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestFileSource_BufferedPayloadBytes(t *testing.T) {
+	const payloadSize = 100
+
+	tests := []struct {
+		name          string
+		stopAtFirst   bool
+		expectedCount int
+	}{
+		{name: "shutdown with full buffers", stopAtFirst: true, expectedCount: 1},
+		{name: "all blocks consumed", expectedCount: 19},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := dstore.NewMockStore(nil)
+			prevID := "00a"
+			for baseNum := uint64(0); baseNum < 20; baseNum += 10 {
+				var blocks []*pbbstream.Block
+				for num := baseNum; num < baseNum+10; num++ {
+					if num == 0 {
+						continue
+					}
+					blk := TestBlockWithNumbers(fmt.Sprintf("%02da", num), prevID, num, 0)
+					blk.Payload.Value = bytes.Repeat([]byte{0x01}, payloadSize)
+					blocks = append(blocks, blk)
+					prevID = blk.Id
+				}
+				bs.SetFile(base(int(baseNum)), testBlocks(blocks...))
+			}
+
+			var fs *FileSource
+			var received int
+			var negative bool
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received++
+				if fs.BufferedPayloadBytes() < 0 {
+					negative = true
+				}
+				if blk.Number == 1 {
+					// blocks 2 to 9 and the whole following archive are decoded ahead
+					for i := 0; i < 500 && fs.BufferedPayloadBytes() != 18*payloadSize; i++ {
+						time.Sleep(time.Millisecond)
+					}
+					assert.Equal(t, 18*payloadSize, fs.BufferedPayloadBytes())
+					if test.stopAtFirst {
+						return errDone
+					}
+				}
+				if blk.Number == 19 {
+					assert.Equal(t, 0, fs.BufferedPayloadBytes())
+					return errDone
+				}
+				return nil
+			})
+			fs = NewFileSource(bs, 1, handler, zlog, FileSourceWithBundleSize(10), FileSourceWithPrefetch(1))
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.Equal(t, errDone, fs.Err())
+			assert.Equal(t, test.expectedCount, received)
+			assert.False(t, negative)
+			assert.Equal(t, 0, fs.BufferedPayloadBytes())
+		})
+	}
+}
+
+func TestFileSource_RetryBackoff(t *testing.T) {
+	bs, _ := newLinearBundlesStore(2, 100)
+
+	misses := 0
+	bs.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+		switch filename {
+		case base(0):
+			return true, nil
+		case base(100):
+			// written by another process after a few attempts
+			if misses < 4 {
+				misses++
+				return false, nil
+			}
+			return true, nil
+		}
+		return false, nil
+	}
+
+	fs := NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	}), zlog, FileSourceWithRetryBackoff(time.Second, 5*time.Second, 0))
+
+	expectedDelays := []time.Duration{
+		0,           // base 0
+		0,           // base 100
+		time.Second, // base 100 missing
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second, // capped
+		0,               // base 200, reset since base 100 was found
+		time.Second,     // base 200 missing
+		2 * time.Second,
+	}
+
+	var delays []time.Duration
+	fired := make(chan time.Time)
+	close(fired)
+	fs.after = func(d time.Duration) <-chan time.Time {
+		if len(delays) < len(expectedDelays) {
+			delays = append(delays, d)
+			if len(delays) == len(expectedDelays) {
+				fs.Shutdown(nil)
+			}
+		}
+		return fired
+	}
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.Equal(t, expectedDelays, delays)
+}
+
+func TestFileSource_ContextCanceled(t *testing.T) {
+	mockStore, _ := newLinearBundlesStore(3, 100)
+	store := &slowStore{MockStore: mockStore, latency: time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		if blk.Number == 50 {
+			cancel()
+		}
+		return nil
+	})
+
+	fs := NewFileSourceWithContext(ctx, store, 1, handler, zlog, FileSourceWithPrefetch(2))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.ErrorIs(t, fs.Err(), context.Canceled)
+}
+
+func TestFileSource_StopBlock(t *testing.T) {
+	tests := []struct {
+		name           string
+		startBlockNum  uint64
+		stopBlockNum   uint64
+		expectedFirst  uint64
+		expectedLast   uint64
+		withPreprocess bool
+	}{
+		{
+			name:          "last block of a bundle",
+			startBlockNum: 1,
+			stopBlockNum:  99,
+			expectedFirst: 1,
+			expectedLast:  99,
+		},
+		{
+			name:          "first block of a bundle",
+			startBlockNum: 1,
+			stopBlockNum:  100,
+			expectedFirst: 1,
+			expectedLast:  100,
+		},
+		{
+			name:           "in the middle of a bundle",
+			startBlockNum:  1,
+			stopBlockNum:   150,
+			expectedFirst:  1,
+			expectedLast:   150,
+			withPreprocess: true,
+		},
+		{
+			name:          "equal to start block",
+			startBlockNum: 150,
+			stopBlockNum:  150,
+			expectedFirst: 150,
+			expectedLast:  150,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, _ := newLinearBundlesStore(4, 100)
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			options := []FileSourceOption{FileSourceWithStopBlock(test.stopBlockNum)}
+			if test.withPreprocess {
+				preprocessor := PreprocessFunc(func(blk *pbbstream.Block) (interface{}, error) {
+					// let the other preprocessing goroutines run, to complete out of order
+					runtime.Gosched()
+					return blk.Id, nil
+				})
+				options = append(options, FileSourceWithConcurrentPreprocess(preprocessor, 4))
+			}
+			fs := NewFileSource(bs, test.startBlockNum, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			// generous, the package tests leave goroutines running behind them
+			select {
+			case <-testDone:
+			case <-time.After(10 * time.Second):
+				t.Fatal("file source did not terminate after stop block")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			require.Len(t, received, int(test.expectedLast-test.expectedFirst+1))
+			assert.Equal(t, test.expectedFirst, received[0])
+			assert.Equal(t, test.expectedLast, received[len(received)-1])
+		})
+	}
+}
+
+func TestFileSource_HighestProcessedBlock(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	})
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithStopBlock(lastBlockNum))
+	assert.Nil(t, fs.HighestProcessedBlock())
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+
+	var polled uint64
+	timeout := time.After(time.Second)
+polling:
+	for {
+		select {
+		case <-testDone:
+			break polling
+		case <-timeout:
+			t.Fatal("Test timeout")
+		default:
+			if ref := fs.HighestProcessedBlock(); ref != nil {
+				require.GreaterOrEqual(t, ref.Num(), polled)
+				polled = ref.Num()
+			}
+		}
+	}
+
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	require.NotNil(t, fs.HighestProcessedBlock())
+	assert.Equal(t, lastBlockNum, fs.HighestProcessedBlock().Num())
+	assert.Equal(t, "199a", fs.HighestProcessedBlock().ID())
+}
+
+type evenBlocksGator struct{}
+
+func (g evenBlocksGator) Pass(block *pbbstream.Block) bool {
+	return block.Number%2 == 0
+}
+
+func TestFileSource_Gator(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+	var preprocessed int64
+	preprocessor := PreprocessFunc(func(blk *pbbstream.Block) (interface{}, error) {
+		if blk.Number%2 != 0 {
+			return nil, fmt.Errorf("odd block %d preprocessed", blk.Number)
+		}
+		atomic.AddInt64(&preprocessed, 1)
+		return blk.Id, nil
+	})
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+
+	fs := NewFileSource(bs, 5, handler, zlog,
+		FileSourceWithGator(evenBlocksGator{}),
+		FileSourceWithConcurrentPreprocess(preprocessor, 2),
+		FileSourceWithStopBlock(lastBlockNum),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	var expected []uint64
+	for num := uint64(6); num <= lastBlockNum; num += 2 {
+		expected = append(expected, num)
+	}
+	assert.Equal(t, expected, received)
+	assert.Equal(t, int64(len(expected)), atomic.LoadInt64(&preprocessed))
+}
+
+func TestFileSource_BundleValidation(t *testing.T) {
+	defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+	GetProtocolFirstStreamableBlock = 1
+
+	bs, _ := newLinearBundlesStore(1, 100)
+
+	// truncated by a crashed merger, blocks 160 to 199 are missing
+	var truncated []*pbbstream.Block
+	prevID := "99a"
+	for num := uint64(100); num < 160; num++ {
+		id := fmt.Sprintf("%da", num)
+		truncated = append(truncated, TestBlockWithNumbers(id, prevID, num, 0))
+		prevID = id
+	}
+	bs.SetFile(base(100), testBlocks(truncated...))
+	bs.SetFile(base(200), testBlocks(TestBlockWithNumbers("200a", "199a", 200, 0)))
+
+	var lastReceived uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		lastReceived = blk.Number
+		return nil
+	})
+
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithBundleValidation())
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.Error(t, fs.Err())
+	assert.Contains(t, fs.Err().Error(), `incomplete merged blocks file "0000000100": missing blocks in range [160, 199]`)
+	assert.Equal(t, uint64(159), lastReceived, "blocks of the next file must not be sent")
+}
+
+func TestFileSource_HoleSkipping(t *testing.T) {
+	tests := []struct {
+		name           string
+		skip           bool
+		expectedLast   uint64
+		expectedRanges []string
+	}{
+		{
+			name:           "skip",
+			skip:           true,
+			expectedLast:   399,
+			expectedRanges: []string{"[100, 300)"},
+		},
+		{
+			name:         "keep waiting",
+			skip:         false,
+			expectedLast: 99,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(4, 100)
+			require.NoError(t, bs.DeleteObject(context.Background(), base(100)))
+			require.NoError(t, bs.DeleteObject(context.Background(), base(200)))
+
+			var received []uint64
+			firstBundleDone := make(chan struct{})
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number == 99 {
+					close(firstBundleDone)
+				}
+				return nil
+			})
+
+			// the reader may go on a little after the shutdown
+			var consultedLock sync.Mutex
+			var consulted []uint64
+			var fs *FileSource
+			fs = NewFileSource(bs, 1, handler, zlog,
+				FileSourceWithStopBlock(lastBlockNum),
+				FileSourceWithHoleSkipping(3, func(missingBase uint64) bool {
+					consultedLock.Lock()
+					defer consultedLock.Unlock()
+					if fs.IsTerminating() {
+						return false
+					}
+					consulted = append(consulted, missingBase)
+					if !test.skip && len(consulted) == 3 {
+						<-firstBundleDone
+						fs.Shutdown(errDone)
+					}
+					return test.skip
+				}),
+			)
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			require.NotEmpty(t, received)
+			assert.Equal(t, uint64(1), received[0])
+			assert.Equal(t, test.expectedLast, received[len(received)-1])
+
+			var ranges []string
+			for _, skipped := range fs.SkippedRanges() {
+				ranges = append(ranges, skipped.String())
+			}
+			assert.Equal(t, test.expectedRanges, ranges)
+
+			consultedLock.Lock()
+			defer consultedLock.Unlock()
+			if test.skip {
+				assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+				assert.Equal(t, []uint64{100, 200}, consulted)
+				assert.Len(t, received, 99+100)
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), errDone)
+			assert.Equal(t, []uint64{100, 100, 100}, consulted)
+		})
+	}
+}
+
+type walkCountingStore struct {
+	*dstore.MockStore
+	walks int64
+}
+
+func (s *walkCountingStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	atomic.AddInt64(&s.walks, 1)
+	return s.MockStore.Walk(ctx, prefix, f)
+}
+
+// newBundlesStore writes linear blocks in bundles starting at each of `bases`,
+// the last bundle ends at `lastBlockNum`
+func newBundlesStore(bases []uint64, lastBlockNum uint64) *dstore.MockStore {
+	store := dstore.NewMockStore(nil)
+
+	prevID := "00"
+	for i, baseNum := range bases {
+		end := lastBlockNum + 1
+		if i < len(bases)-1 {
+			end = bases[i+1]
+		}
+
+		var blocks []*pbbstream.Block
+		for num := baseNum; num < end; num++ {
+			if num == 0 {
+				continue
+			}
+			id := fmt.Sprintf("%da", num)
+			blocks = append(blocks, TestBlockWithNumbers(id, prevID, num, 0))
+			prevID = id
+		}
+		store.SetFile(base(int(baseNum)), testBlocks(blocks...))
+	}
+	return store
+}
+
+func TestFileSource_BundleSizeAutoDetect(t *testing.T) {
+	tests := []struct {
+		name          string
+		bases         []uint64
+		startBlockNum uint64
+		stopBlockNum  uint64
+	}{
+		{
+			name:          "from 1000 to 100 blocks bundles",
+			bases:         []uint64{0, 1000, 2000, 2100, 2200, 2300},
+			startBlockNum: 1500,
+			stopBlockNum:  2399,
+		},
+		{
+			name:          "from 100 to 1000 blocks bundles",
+			bases:         []uint64{0, 100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 2000},
+			startBlockNum: 850,
+			stopBlockNum:  2999,
+		},
+		{
+			name:          "across listing regions",
+			bases:         []uint64{8000, 9000, 9900, 10000, 11000},
+			startBlockNum: 8500,
+			stopBlockNum:  11999,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &walkCountingStore{MockStore: newBundlesStore(test.bases, test.stopBlockNum)}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			fs := NewFileSource(store, test.startBlockNum, handler, zlog,
+				FileSourceWithBundleSizeAutoDetect(),
+				FileSourceWithBundleValidation(),
+				FileSourceWithStopBlock(test.stopBlockNum),
+			)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			require.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			require.Len(t, received, int(test.stopBlockNum-test.startBlockNum+1))
+			for i, num := range received {
+				require.Equal(t, test.startBlockNum+uint64(i), num)
+			}
+			assert.LessOrEqual(t, atomic.LoadInt64(&store.walks), int64(4), "listings are cached by region")
+		})
+	}
+}
+
+func TestFileSource_ReverseOrder(t *testing.T) {
+	bs, _ := newLinearBundlesStore(3, 100)
+
+	preprocessor := PreprocessFunc(func(blk *pbbstream.Block) (interface{}, error) {
+		return blk.Id, nil
+	})
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		require.Equal(t, blk.Id, obj.(ObjectWrapper).WrappedObject())
+		require.True(t, obj.(Cursorable).Cursor().IsEmpty(), "reverse streams cannot be resumed")
+		received = append(received, blk.Number)
+		return nil
+	})
+
+	fs := NewFileSource(bs, 50, handler, zlog,
+		FileSourceWithReverseOrder(),
+		FileSourceWithStopBlock(150),
+		FileSourceWithConcurrentPreprocess(preprocessor, 4),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	require.Len(t, received, 101)
+	for i, num := range received {
+		require.Equal(t, uint64(150-i), num)
+	}
+}
+
+func TestFileSource_ReverseOrderRequiresStopBlock(t *testing.T) {
+	bs, _ := newLinearBundlesStore(1, 100)
+
+	fs := NewFileSource(bs, 50, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	}), zlog, FileSourceWithReverseOrder())
+	fs.Run()
+
+	require.Error(t, fs.Err())
+	assert.Contains(t, fs.Err().Error(), "reverse order requires a stop block")
+}
+
+// flakyStore fails the first `failures` attempts to open `filename` with `err`
+type flakyStore struct {
+	*dstore.MockStore
+	filename string
+	failures int
+	err      error
+
+	lock     sync.Mutex
+	attempts map[string]int
+}
+
+func (s *flakyStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.lock.Lock()
+	s.attempts[name]++
+	attempt := s.attempts[name]
+	s.lock.Unlock()
+
+	if name == s.filename && attempt <= s.failures {
+		return nil, s.err
+	}
+	return s.MockStore.OpenObject(ctx, name)
+}
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "access denied" }
+func (permanentError) Temporary() bool { return false }
+
+func TestFileSource_OpenRetries(t *testing.T) {
+	transientErr := errors.New("503 service unavailable")
+
+	tests := []struct {
+		name             string
+		openRetries      int
+		err              error
+		expectErr        error
+		expectedAttempts int
+	}{
+		{
+			name:             "transient errors retried",
+			openRetries:      2,
+			err:              transientErr,
+			expectErr:        ErrStopBlockReached,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "not found retried",
+			openRetries:      3,
+			err:              dstore.ErrNotFound,
+			expectErr:        ErrStopBlockReached,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "retries exhausted",
+			openRetries:      1,
+			err:              transientErr,
+			expectErr:        transientErr,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "no retries by default",
+			err:              transientErr,
+			expectErr:        transientErr,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "permanent error",
+			openRetries:      5,
+			err:              permanentError{},
+			expectErr:        permanentError{},
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(2, 100)
+			store := &flakyStore{MockStore: bs, filename: base(0), failures: 2, err: test.err, attempts: make(map[string]int)}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			fs := NewFileSource(store, 1, handler, zlog,
+				FileSourceWithStopBlock(lastBlockNum),
+				FileSourceWithOpenRetries(test.openRetries),
+			)
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), test.expectErr)
+			store.lock.Lock()
+			assert.Equal(t, test.expectedAttempts, store.attempts[base(0)])
+			store.lock.Unlock()
+			if test.expectErr == ErrStopBlockReached {
+				assert.Len(t, received, int(lastBlockNum))
+			}
+		})
+	}
+}
+
+// openTrackingStore records the highest amount of objects open at the same time
+type openTrackingStore struct {
+	*dstore.MockStore
+
+	lock    sync.Mutex
+	open    int
+	maxOpen int
+}
+
+type trackedReader struct {
+	io.ReadCloser
+	store *openTrackingStore
+}
+
+func (r *trackedReader) Close() error {
+	r.store.lock.Lock()
+	r.store.open--
+	r.store.lock.Unlock()
+	return r.ReadCloser.Close()
+}
+
+func (s *openTrackingStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, err := s.MockStore.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.open++
+	if s.open > s.maxOpen {
+		s.maxOpen = s.open
+	}
+	s.lock.Unlock()
+	return &trackedReader{ReadCloser: reader, store: s}, nil
+}
+
+func TestFileSource_MaxOpenFiles(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(8, 10)
+	store := &openTrackingStore{MockStore: bs}
+
+	// the handler stops on the first block of each bundle, the archives being
+	// prefetched meanwhile: a single decoded block buffered keeps them open
+	reached := make(chan uint64)
+	proceed := make(chan struct{})
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		if blk.Number == 1 || blk.Number%10 == 0 {
+			reached <- blk.Number
+			<-proceed
+		}
+		return nil
+	})
+
+	fs := NewFileSource(store, 1, handler, zlog,
+		FileSourceWithBundleSize(10),
+		FileSourceWithPrefetch(4),
+		FileSourceWithMaxOpenFiles(2),
+		FileSourceWithDecodedBlocksBuffer(1),
+		FileSourceWithStopBlock(lastBlockNum),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+
+	openFiles := func() int {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		return store.open
+	}
+	for bundle := 0; bundle < 8; bundle++ {
+		select {
+		case num := <-reached:
+			require.Equal(t, uint64(bundle*10), num-num%10)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("first block of bundle %d not reached", bundle)
+		}
+
+		// the current archive and the next one, the others wait for a slot
+		expected := 2
+		if bundle == 7 {
+			expected = 1
+		}
+		require.Eventually(t, func() bool {
+			return openFiles() == expected && fs.OpenFiles() == expected
+		}, 10*time.Second, time.Millisecond, "bundle %d", bundle)
+		store.lock.Lock()
+		assert.Equal(t, 2, store.maxOpen)
+		store.lock.Unlock()
+
+		proceed <- struct{}{}
+	}
+
+	select {
+	case <-testDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	assert.Eventually(t, func() bool {
+		return openFiles() == 0 && fs.OpenFiles() == 0
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestFileSource_Metrics(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+	var totalBytes int64
+	for _, filename := range []string{base(0), base(100)} {
+		reader, err := bs.OpenObject(context.Background(), filename)
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		totalBytes += int64(len(content))
+	}
+
+	misses := 0
+	bs.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+		if filename == base(100) && misses < 2 {
+			misses++
+			return false, nil
+		}
+		return filename == base(0) || filename == base(100), nil
+	}
+
+	metrics := &AtomicFileSourceMetrics{}
+	fs := NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	}), zlog,
+		FileSourceWithMetrics(metrics),
+		FileSourceWithStopBlock(lastBlockNum),
+		FileSourceWithRetryDelay(time.Second),
+	)
+	fired := make(chan time.Time)
+	close(fired)
+	fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	require.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	assert.Equal(t, totalBytes, metrics.TotalBytesRead())
+	assert.Equal(t, int64(lastBlockNum), metrics.TotalBlocksDecoded())
+	assert.Equal(t, int64(lastBlockNum), metrics.TotalBlocksDelivered())
+	assert.Equal(t, uint64(100), metrics.CurrentBundleBase())
+	assert.Equal(t, 2*time.Second, metrics.TotalMissingBundleWait())
+}
+
+func TestFileSource_StopBlockWithinBundle(t *testing.T) {
+	bs, _ := newLinearBundlesStore(3, 100)
+
+	var preprocessed []uint64
+	var preprocessedLock sync.Mutex
+	preprocessor := PreprocessFunc(func(blk *pbbstream.Block) (interface{}, error) {
+		preprocessedLock.Lock()
+		preprocessed = append(preprocessed, blk.Number)
+		preprocessedLock.Unlock()
+		return blk.Id, nil
+	})
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+
+	fs := NewFileSource(bs, 100, handler, zlog,
+		FileSourceWithStopBlock(105),
+		FileSourceWithConcurrentPreprocess(preprocessor, 2),
+		FileSourceWithBundleValidation(),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	expected := []uint64{100, 101, 102, 103, 104, 105}
+	require.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	assert.Equal(t, expected, received)
+
+	preprocessedLock.Lock()
+	defer preprocessedLock.Unlock()
+	assert.ElementsMatch(t, expected, preprocessed)
+}
+
+func TestFileSource_StartBlockID(t *testing.T) {
+	tests := []struct {
+		name          string
+		blocks        []*pbbstream.Block
+		startBlockID  string
+		expectedIDs   []string
+		expectedError string
+	}{
+		{
+			name: "match",
+			blocks: []*pbbstream.Block{
+				TestBlockWithNumbers("2a", "1a", 2, 0),
+				TestBlockWithNumbers("3a", "2a", 3, 0),
+				TestBlockWithNumbers("4a", "3a", 4, 0),
+			},
+			startBlockID: "3a",
+			expectedIDs:  []string{"3a", "4a"},
+		},
+		{
+			name: "mismatch",
+			blocks: []*pbbstream.Block{
+				TestBlockWithNumbers("2a", "1a", 2, 0),
+				TestBlockWithNumbers("3a", "2a", 3, 0),
+				TestBlockWithNumbers("4a", "3a", 4, 0),
+			},
+			startBlockID:  "3z",
+			expectedError: `start block #3 has ID(s) ["3a"] in merged blocks file "0000000000", expected "3z"`,
+		},
+		{
+			name: "forked sibling before the expected one",
+			blocks: []*pbbstream.Block{
+				TestBlockWithNumbers("2a", "1a", 2, 0),
+				TestBlockWithNumbers("3b", "2a", 3, 0),
+				TestBlockWithNumbers("3a", "2a", 3, 0),
+				TestBlockWithNumbers("4a", "3a", 4, 0),
+			},
+			startBlockID: "3a",
+			expectedIDs:  []string{"3a", "4a"},
+		},
+		{
+			name: "forked siblings without the expected one",
+			blocks: []*pbbstream.Block{
+				TestBlockWithNumbers("3b", "2a", 3, 0),
+				TestBlockWithNumbers("3c", "2a", 3, 0),
+			},
+			startBlockID:  "3a",
+			expectedError: `start block #3 has ID(s) ["3b" "3c"] in merged blocks file "0000000000", expected "3a"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(0), testBlocks(test.blocks...))
+
+			var received []string
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Id)
+				return nil
+			})
+
+			fs := NewFileSource(bs, 3, handler, zlog,
+				FileSourceWithStartBlockID(test.startBlockID),
+				FileSourceWithStopBlock(4),
+			)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectedError != "" {
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), test.expectedError)
+				assert.Empty(t, received)
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Equal(t, test.expectedIDs, received)
+		})
+	}
+}
+
+func TestFileSource_OneBlockTail(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(2, 100)
+	var merged atomic.Bool
+	bs.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+		switch filename {
+		case base(0):
+			return true, nil
+		case base(100):
+			return merged.Load(), nil
+		}
+		return false, nil
+	}
+
+	oneBlocks := dstore.NewMockStore(nil)
+	var oneBlockFiles []string
+	addOneBlock := func(blk *pbbstream.Block) {
+		filename := BlockFileName(blk)
+		oneBlocks.SetFile(filename, testBlocks(blk))
+		oneBlockFiles = append(oneBlockFiles, filename)
+	}
+	prevID := "99a"
+	for num := uint64(100); num < 150; num++ {
+		id := fmt.Sprintf("%da", num)
+		addOneBlock(TestBlockWithNumbers(id, prevID, num, 0))
+		prevID = id
+		if num == 120 {
+			addOneBlock(TestBlockWithNumbers("120b", "119a", 120, 0))
+		}
+	}
+	sort.Strings(oneBlockFiles)
+
+	// the one-block files are produced while the source tails them
+	var visibleUpTo atomic.Uint64
+	visibleUpTo.Store(129)
+	oneBlocks.WalkFunc = func(ctx context.Context, prefix string, f func(filename string) error) error {
+		for _, filename := range oneBlockFiles {
+			obf, err := NewOneBlockFile(filename)
+			require.NoError(t, err)
+			if obf.Num > visibleUpTo.Load() {
+				return nil
+			}
+			if err := f(filename); err != nil {
+				if err == dstore.StopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	var received []string
+	steps := make(map[string]StepType)
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Id)
+		steps[blk.Id] = obj.(Stepable).Step()
+		switch blk.Id {
+		case "110a":
+			visibleUpTo.Store(149)
+		case "140a":
+			// the merger catches up in the middle of the one-block files
+			merged.Store(true)
+		}
+		return nil
+	})
+
+	fs := NewFileSource(bs, 1, handler, zlog,
+		FileSourceWithStopBlock(lastBlockNum),
+		FileSourceWithOneBlockTail(oneBlocks),
+	)
+	fired := make(chan time.Time)
+	close(fired)
+	fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	var expected []string
+	for num := 1; num <= int(lastBlockNum); num++ {
+		expected = append(expected, fmt.Sprintf("%da", num))
+		if num == 120 {
+			expected = append(expected, "120b")
+		}
+	}
+	assert.Equal(t, expected, received)
+
+	assert.Equal(t, StepNewIrreversible, steps["99a"])
+	assert.Equal(t, StepNew, steps["120b"])
+	assert.Equal(t, StepNew, steps["149a"])
+	assert.Equal(t, StepNewIrreversible, steps["150a"])
+}
+
+func TestFileSource_PreprocessRetry(t *testing.T) {
+	transientErr := errors.New("lookup timed out")
+
+	tests := []struct {
+		name          string
+		attempts      int
+		expectErr     error
+		expectedError string
+	}{
+		{
+			name:      "succeeds after retries",
+			attempts:  3,
+			expectErr: ErrStopBlockReached,
+		},
+		{
+			name:          "retries exhausted",
+			attempts:      2,
+			expectErr:     transientErr,
+			expectedError: `preprocess block #50 (50a): after 2 attempts: lookup timed out`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+			var lock sync.Mutex
+			attempts := make(map[uint64]int)
+			preprocFunc := func(blk *pbbstream.Block) (interface{}, error) {
+				lock.Lock()
+				defer lock.Unlock()
+				attempts[blk.Number]++
+				if blk.Number == 50 && attempts[blk.Number] <= 2 {
+					return nil, transientErr
+				}
+				return blk.Id, nil
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				assert.Equal(t, blk.Id, obj.(ObjectWrapper).WrappedObject())
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			fs := NewFileSource(bs, 1, handler, zlog,
+				FileSourceWithStopBlock(lastBlockNum),
+				FileSourceWithConcurrentPreprocess(preprocFunc, 4),
+				FileSourceWithPreprocessRetry(test.attempts, time.Second),
+			)
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			assert.ErrorIs(t, fs.Err(), test.expectErr)
+			if test.expectedError != "" {
+				assert.EqualError(t, fs.Err(), test.expectedError)
+				assert.Equal(t, 2, attempts[50])
+				return
+			}
+
+			var expected []uint64
+			for num := uint64(1); num <= lastBlockNum; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+			assert.Equal(t, 3, attempts[50])
+		})
+	}
+}
+
+func TestFileSource_BlockIndexFiltering(t *testing.T) {
+	bs, _ := newLinearBundlesStore(3, 100)
+
+	var lock sync.Mutex
+	var preprocessed []uint64
+	preprocFunc := func(blk *pbbstream.Block) (interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		preprocessed = append(preprocessed, blk.Number)
+		return nil, nil
+	}
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+
+	fs := NewFileSource(bs, 100, handler, zlog,
+		FileSourceWithStopBlock(199),
+		FileSourceWithConcurrentPreprocess(preprocFunc, 2),
+		FileSourceWithBlockIndexProvider(&TestBlockIndexProvider{
+			Blocks:           []uint64{110, 150, 180},
+			LastIndexedBlock: 299,
+		}),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	// the start and stop blocks are always sent
+	expected := []uint64{100, 110, 150, 180, 199}
+	assert.Equal(t, expected, received)
+
+	lock.Lock()
+	defer lock.Unlock()
+	sort.Slice(preprocessed, func(i, j int) bool { return preprocessed[i] < preprocessed[j] })
+	assert.Equal(t, expected, preprocessed)
+}
+
+func TestFileSource_SkipPreprocessBelow(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+	tests := []struct {
+		name                 string
+		newSource            func(h Handler, options ...FileSourceOption) *FileSource
+		expectedFirstBlock   uint64
+		expectedPreprocessed int64
+	}{
+		{
+			name: "skip below",
+			newSource: func(h Handler, options ...FileSourceOption) *FileSource {
+				return NewFileSource(bs, 0, h, zlog, append(options, FileSourceWithSkipPreprocessBelow(60))...)
+			},
+			expectedFirstBlock:   1,
+			expectedPreprocessed: 140,
+		},
+		{
+			name: "resuming from cursor mid-bundle",
+			newSource: func(h Handler, options ...FileSourceOption) *FileSource {
+				return NewFileSourceFromCursor(bs, nil, &Cursor{
+					Step:      StepNew,
+					Block:     NewBlockRef("52a", 52),
+					HeadBlock: NewBlockRef("52a", 52),
+					LIB:       NewBlockRef("50a", 50),
+				}, h, zlog, options...)
+			},
+			expectedFirstBlock: 51,
+			// the LIB block is only used to resolve the cursor
+			expectedPreprocessed: 149,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var preprocessed int64
+			preprocFunc := func(blk *pbbstream.Block) (interface{}, error) {
+				atomic.AddInt64(&preprocessed, 1)
+				return blk.Id, nil
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number < 60 && test.expectedFirstBlock == 1 {
+					assert.Nil(t, obj.(ObjectWrapper).WrappedObject())
+				} else {
+					assert.Equal(t, blk.Id, obj.(ObjectWrapper).WrappedObject())
+				}
+				return nil
+			})
+
+			fs := test.newSource(handler,
+				FileSourceWithStopBlock(lastBlockNum),
+				FileSourceWithConcurrentPreprocess(preprocFunc, 2),
+			)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+			require.NotEmpty(t, received)
+			assert.Equal(t, test.expectedFirstBlock, received[0])
+			assert.Equal(t, lastBlockNum, received[len(received)-1])
+			assert.Equal(t, test.expectedPreprocessed, atomic.LoadInt64(&preprocessed))
+		})
+	}
+}
+
+func TestFileSource_SecondaryStore(t *testing.T) {
+	primary, lastBlockNum := newLinearBundlesStore(3, 100)
+	require.NoError(t, primary.DeleteObject(context.Background(), base(100)))
+	// outage of the primary store while opening the last bundle
+	primaryStore := &flakyStore{MockStore: primary, filename: base(200), failures: 100, err: errors.New("503 service unavailable"), attempts: make(map[string]int)}
+
+	secondary, _ := newLinearBundlesStore(3, 100)
+
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		return nil
+	})
+
+	metrics := &AtomicFileSourceMetrics{}
+	fs := NewFileSource(primaryStore, 1, handler, zlog,
+		FileSourceWithStopBlock(lastBlockNum),
+		FileSourceWithSecondaryStore(secondary),
+		FileSourceWithMetrics(metrics),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	var expected []uint64
+	for num := uint64(1); num <= lastBlockNum; num++ {
+		expected = append(expected, num)
+	}
+	assert.Equal(t, expected, received)
+
+	// the missing bundle is found then opened from the secondary store, the erroring one is only opened from it
+	assert.Equal(t, int64(3), metrics.TotalSecondaryStoreFailovers())
+}
+
+func TestFileSource_RateLimit(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var received []uint64
+	var receivedAt []time.Time
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		receivedAt = append(receivedAt, clock.Now())
+		return nil
+	})
+
+	fs := NewFileSource(bs, 1, handler, zlog,
+		FileSourceWithStopBlock(lastBlockNum),
+		FileSourceWithRateLimit(20),
+	)
+	fs.blocksLimiter.now = clock.Now
+	fs.after = clock.After
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	require.Len(t, received, int(lastBlockNum))
+	for i := 1; i < len(receivedAt); i++ {
+		assert.Equal(t, 50*time.Millisecond, receivedAt[i].Sub(receivedAt[i-1]))
+	}
+}
+
+func TestFileSource_ByteRateLimit(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(1, 100)
+	reader, err := bs.OpenObject(context.Background(), base(0))
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	fileSize := float64(len(content))
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	fs := NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error { return nil }), zlog,
+		FileSourceWithStopBlock(lastBlockNum),
+		FileSourceWithByteRateLimit(1000),
+	)
+	fs.bytesLimiter.now = clock.Now
+	fs.after = clock.After
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	// the first second worth of bytes is read right away
+	var waited time.Duration
+	for _, wait := range clock.Waits() {
+		waited += wait
+	}
+	assert.InDelta(t, (fileSize-1000)/1000, waited.Seconds(), 0.001)
+}
+
+func TestFileSource_RateLimitShutdown(t *testing.T) {
+	bs, _ := newLinearBundlesStore(1, 100)
+
+	received := make(chan uint64, 10)
+	fs := NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received <- blk.Number
+		return nil
+	}), zlog, FileSourceWithRateLimit(0.001))
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+
+	assert.Equal(t, uint64(1), <-received)
+	fs.Shutdown(errDone)
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("rate limited source did not stop")
+	}
+	assert.Len(t, received, 0)
+}
+
+func TestFileSource_DecodedBlocksBuffer(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(5, 100)
+
+	unblock := make(chan struct{})
+	var received []uint64
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		if blk.Number == 1 {
+			<-unblock
+		}
+		received = append(received, blk.Number)
+		return nil
+	})
+
+	metrics := &AtomicFileSourceMetrics{}
+	fs := NewFileSource(bs, 1, handler, zlog,
+		FileSourceWithStopBlock(lastBlockNum),
+		FileSourceWithPrefetch(2),
+		FileSourceWithDecodedBlocksBuffer(5),
+		FileSourceWithMetrics(metrics),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+
+	// 3 archives are decoded while the handler is blocked, each one up to its
+	// buffer and the few blocks in flight in the decoding pipeline
+	time.Sleep(50 * time.Millisecond)
+	assert.LessOrEqual(t, metrics.TotalBlocksDecoded(), int64(3*(5+3)))
+	close(unblock)
+
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	var expected []uint64
+	for num := uint64(1); num <= lastBlockNum; num++ {
+		expected = append(expected, num)
+	}
+	assert.Equal(t, expected, received)
+}
+
+func TestFileSource_ProgressCallback(t *testing.T) {
+	bs, _ := newLinearBundlesStore(3, 100)
+
+	type progress struct {
+		bundleBase uint64
+		lastBlock  string
+	}
+	var progresses []progress
+	var inCallback int32
+	onProgress := func(bundleBase uint64, lastBlock BlockRef, elapsed time.Duration) {
+		require.True(t, atomic.CompareAndSwapInt32(&inCallback, 0, 1), "called concurrently")
+		defer atomic.StoreInt32(&inCallback, 0)
+
+		assert.Greater(t, elapsed, time.Duration(0))
+		progresses = append(progresses, progress{bundleBase, lastBlock.ID()})
+	}
+
+	fs := NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error { return nil }), zlog,
+		FileSourceWithStopBlock(250),
+		FileSourceWithPrefetch(2),
+		FileSourceWithProgressCallback(onProgress),
+	)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+
+	assert.Equal(t, []progress{
+		{0, "99a"},
+		{100, "199a"},
+		// the stop block bundle is partially consumed
+		{200, "250a"},
+	}, progresses)
+}
+
+func TestFileSource_AdaptiveRetry(t *testing.T) {
+	bs, _ := newLinearBundlesStore(1, 100)
+
+	var head atomic.Uint64
+	head.Store(10_000)
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var fs *FileSource
+	fs = NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error { return nil }), zlog,
+		FileSourceWithAdaptiveRetry(head.Load),
+	)
+	fs.after = func(d time.Duration) <-chan time.Time {
+		fired := clock.After(d)
+		switch len(clock.Waits()) {
+		case 3:
+			// the source is now near the head
+			head.Store(250)
+		case 6:
+			fs.Shutdown(errDone)
+		}
+		return fired
+	}
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.ErrorIs(t, fs.Err(), errDone)
+
+	waits := clock.Waits()
+	require.GreaterOrEqual(t, len(waits), 6)
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		4 * time.Second,
+		4 * time.Second,
+		4 * time.Second,
+	}, waits[:6])
+}
+
+func TestFileSource_GracefulDrain(t *testing.T) {
+	tests := []struct {
+		name          string
+		drain         time.Duration
+		expireAtBlock uint64
+		expectedLast  uint64
+	}{
+		{
+			name:         "no drain",
+			expectedLast: 50,
+		},
+		{
+			name:         "decoded blocks of the current bundle delivered",
+			drain:        time.Minute,
+			expectedLast: 99,
+		},
+		{
+			name:          "drain timeout expiring",
+			drain:         time.Minute,
+			expireAtBlock: 60,
+			expectedLast:  60,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, _ := newLinearBundlesStore(3, 100)
+
+			metrics := &AtomicFileSourceMetrics{}
+			expired := make(chan time.Time)
+			var received []uint64
+			var fs *FileSource
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				switch blk.Number {
+				case 50:
+					// both prefetched bundles are fully decoded
+					require.Eventually(t, func() bool { return metrics.TotalBlocksDecoded() == 199 }, time.Second, time.Millisecond)
+					time.Sleep(20 * time.Millisecond)
+					fs.Shutdown(errDone)
+				case test.expireAtBlock:
+					close(expired)
+				}
+				return nil
+			})
+
+			fs = NewFileSource(bs, 1, handler, zlog,
+				FileSourceWithPrefetch(1),
+				FileSourceWithGracefulDrain(test.drain),
+				FileSourceWithMetrics(metrics),
+			)
+			fs.after = func(d time.Duration) <-chan time.Time {
+				if d == time.Minute {
+					return expired
+				}
+				return time.After(d)
+			}
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Test timeout")
+			}
+			assert.ErrorIs(t, fs.Err(), errDone)
+
+			var expected []uint64
+			for num := uint64(1); num <= test.expectedLast; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+			assert.Equal(t, test.expectedLast, fs.HighestProcessedBlock().Num())
+		})
+	}
+}
+
+// resettingStore fails the first read of each file after `failAfter` bytes
+type resettingStore struct {
+	*dstore.MockStore
+	failAfter int
+
+	lock    sync.Mutex
+	opened  map[string]bool
+	onOpen  func()
+	resets  int
+	attempt int
+}
+
+func (s *resettingStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.onOpen != nil {
+		s.onOpen()
+	}
+
+	reader, err := s.MockStore.OpenObject(ctx, name)
+	if err != nil || s.opened[name] {
+		return reader, err
+	}
+	s.opened[name] = true
+	s.resets++
+	return &resettingReader{ReadCloser: reader, remaining: s.failAfter}, nil
+}
+
+type resettingReader struct {
+	io.ReadCloser
+	remaining int
+}
+
+func (r *resettingReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+func TestFileSource_LocalBuffering(t *testing.T) {
+	tests := []struct {
+		name           string
+		localBuffering bool
+		expectErr      error
+	}{
+		{
+			name:           "resumed downloads",
+			localBuffering: true,
+			expectErr:      ErrStopBlockReached,
+		},
+		{
+			name: "streaming reads reset",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(4, 100)
+			store := &resettingStore{MockStore: bs, failAfter: 1000, opened: make(map[string]bool)}
+			dir := t.TempDir()
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			options := []FileSourceOption{FileSourceWithStopBlock(lastBlockNum), FileSourceWithPrefetch(3)}
+			if test.localBuffering {
+				options = append(options, FileSourceWithLocalBuffering(dir, 1))
+			}
+			fs := NewFileSource(store, 1, handler, zlog, options...)
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			var usedAtOpen []int64
+			if test.localBuffering {
+				store.onOpen = func() { usedAtOpen = append(usedAtOpen, fs.localBuffer.usedBytes()) }
+			}
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if !test.localBuffering {
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), "connection reset by peer")
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), test.expectErr)
+
+			var expected []uint64
+			for num := uint64(1); num <= lastBlockNum; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+			assert.Equal(t, 4, store.resets)
+
+			// each download waits for the previous temporary files to be deleted,
+			// which happens once they are decoded
+			require.Len(t, usedAtOpen, 8)
+			for i, used := range usedAtOpen {
+				if i%2 == 0 {
+					assert.Equal(t, int64(0), used, "first attempt %d", i/2)
+				} else {
+					assert.Equal(t, int64(1000), used, "resumed attempt %d", i/2)
+				}
+			}
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+			assert.Equal(t, int64(0), fs.localBuffer.usedBytes())
+		})
+	}
+}
+
+func TestFileSource_GapDetection(t *testing.T) {
+	defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+	GetProtocolFirstStreamableBlock = 1
+
+	// blocks of the bundle at base 100, except the ones in `missing`, and a
+	// forked block at each height in `forked`
+	gappedBundle := func(missing *Range, forked ...uint64) []*pbbstream.Block {
+		var blocks []*pbbstream.Block
+		prevID := "99a"
+		for num := uint64(100); num < 200; num++ {
+			if missing != nil && missing.Contains(num) {
+				continue
+			}
+			id := fmt.Sprintf("%da", num)
+			blocks = append(blocks, TestBlockWithNumbers(id, prevID, num, 0))
+			for _, forkedNum := range forked {
+				if forkedNum == num {
+					blocks = append(blocks, TestBlockWithNumbers(fmt.Sprintf("%db", num), prevID, num, 0))
+				}
+			}
+			prevID = id
+		}
+		return blocks
+	}
+
+	tests := []struct {
+		name          string
+		blocks        []*pbbstream.Block
+		startBlockNum uint64
+		tolerance     uint64
+		expectErr     string
+	}{
+		{
+			name:      "gap in the middle",
+			blocks:    gappedBundle(NewRangeExcludingEnd(150, 161)),
+			expectErr: `gap in merged blocks file "0000000100": block #161 follows block #149`,
+		},
+		{
+			name:      "gap at the start",
+			blocks:    gappedBundle(NewRangeExcludingEnd(100, 105)),
+			expectErr: `gap in merged blocks file "0000000100": block #105 follows block #99`,
+		},
+		{
+			name:      "gap within tolerance",
+			blocks:    gappedBundle(NewRangeExcludingEnd(150, 153)),
+			tolerance: 3,
+		},
+		{
+			name:   "forked blocks",
+			blocks: gappedBundle(nil, 120, 150),
+		},
+		{
+			name:          "first block after the start block",
+			blocks:        gappedBundle(NewRangeExcludingEnd(100, 130)),
+			startBlockNum: 120,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(100), testBlocks(test.blocks...))
+
+			fs := NewFileSource(bs, test.startBlockNum, nil, zlog, FileSourceWithGapDetection(test.tolerance))
+			defer fs.Shutdown(nil)
+
+			// forked blocks are rejected by the continuity checks of run(),
+			// the blocks file is streamed on its own
+			incomingFile := newIncomingBlocksFile(100, 100, base(100), nil)
+			var received int
+			drained := make(chan struct{})
+			go func() {
+				for range incomingFile.blocks {
+					received++
+				}
+				close(drained)
+			}()
+
+			err := fs.streamIncomingFile(incomingFile, bs)
+			if test.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			<-drained
+
+			var expected int
+			for _, blk := range test.blocks {
+				if blk.Number >= test.startBlockNum {
+					expected++
+				}
+			}
+			assert.Equal(t, expected, received)
+		})
+	}
+}
+
+func TestFileSourceFactory_LowestAvailableBlock(t *testing.T) {
+	// the bundles below 1,000,000 were pruned
+	bs := newBundlesStore([]uint64{1000000, 1000100}, 1000199)
+
+	factory := NewFileSourceFactory(bs, nil, zlog)
+	now := time.Now()
+	factory.now = func() time.Time { return now }
+	factory.SetLowestAvailableBlockTTL(time.Minute)
+
+	lowest, err := factory.LowestAvailableBlock(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000000), lowest)
+
+	bs.SetFile(base(999900), nil)
+	lowest, err = factory.LowestAvailableBlock(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000000), lowest, "cached until the TTL expires")
+
+	now = now.Add(time.Minute)
+	lowest, err = factory.LowestAvailableBlock(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(999900), lowest)
+
+	_, err = NewFileSourceFactory(dstore.NewMockStore(nil), nil, zlog).LowestAvailableBlock(context.Background())
+	assert.Error(t, err)
+}
+
+func TestFileSourceFactory_ClampStartBlock(t *testing.T) {
+	tests := []struct {
+		name          string
+		clamp         bool
+		startBlockNum uint64
+		expectStart   uint64
+	}{
+		{"clamped", true, 5, 1000000},
+		{"above lowest block", true, 1000150, 1000150},
+		{"not clamped", false, 5, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := newBundlesStore([]uint64{1000000, 1000100}, 1000199)
+			factory := NewFileSourceFactory(bs, nil, zlog)
+			factory.SetClampStartBlock(test.clamp)
+
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				return nil
+			})
+			fs := factory.SourceFromBlockNum(test.startBlockNum, handler).(*FileSource)
+			assert.Equal(t, test.expectStart, fs.startBlockNum)
+		})
+	}
+}
+
+// countingReaderFactory creates DBinBlockReader instances, counting them
+type countingReaderFactory struct {
+	created int64
+}
+
+func (f *countingReaderFactory) New(reader io.Reader) (BlockReader, error) {
+	atomic.AddInt64(&f.created, 1)
+	return NewDBinBlockReader(reader)
+}
+
+func TestFileSource_BlockReaderFactory(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(3, 100)
+	factory := &countingReaderFactory{}
+
+	run := func(options ...FileSourceOption) {
+		options = append(options, FileSourceWithStopBlock(lastBlockNum))
+		fs := NewFileSource(bs, 1, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error { return nil }), zlog, options...)
+
+		testDone := make(chan struct{})
+		go func() {
+			fs.Run()
+			close(testDone)
+		}()
+		select {
+		case <-testDone:
+		case <-time.After(time.Second):
+			t.Fatal("Test timeout")
+		}
+		assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	}
+
+	run(FileSourceWithBlockReaderFactory(factory))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&factory.created))
+
+	run()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&factory.created), "other sources use the default factory")
+
+	cursor := &Cursor{Step: StepNew, Block: NewBlockRef("120a", 120), LIB: NewBlockRef("110a", 110), HeadBlock: NewBlockRef("120a", 120)}
+	fs := NewFileSourceFromCursor(bs, dstore.NewMockStore(nil), cursor, nil, zlog, FileSourceWithBlockReaderFactory(factory))
+	assert.Equal(t, factory, fs.handler.(*cursorResolver).blockReaderFactory)
+	fs = NewFileSourceFromCursor(bs, dstore.NewMockStore(nil), cursor, nil, zlog)
+	assert.IsType(t, DBinBlockReaderFactory, fs.handler.(*cursorResolver).blockReaderFactory)
+}
+
+func TestFileSource_ExistsErrorBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    int
+		expectErr string
+	}{
+		{
+			name:   "within budget",
+			budget: 2,
+		},
+		{
+			name:      "budget spent",
+			budget:    1,
+			expectErr: "filesource reading file existence (2 consecutive errors): service unavailable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(3, 100)
+
+			// two consecutive checks of base 100 fail
+			var failures int
+			bs.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+				if filename == base(100) && failures < 2*existsCheckAttempts {
+					failures++
+					return false, errors.New("service unavailable")
+				}
+				return filename == base(0) || filename == base(100) || filename == base(200), nil
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			fs := NewFileSource(bs, 1, handler, zlog,
+				FileSourceWithStopBlock(lastBlockNum),
+				FileSourceWithExistsErrorBudget(test.budget),
+				FileSourceWithRetryBackoff(time.Second, 5*time.Second, 0),
+			)
+			var delays []time.Duration
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time {
+				delays = append(delays, d)
+				return fired
+			}
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectErr != "" {
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), test.expectErr)
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Len(t, received, int(lastBlockNum))
+			assert.Equal(t, []time.Duration{0, 0, time.Second, 2 * time.Second, 0}, delays)
+		})
+	}
+}
+
+func TestFileSource_FilenameScheme(t *testing.T) {
+	// legacy naming: 9 digits with a suffix, in a per-chain directory
+	format := func(baseBlockNum uint64) string {
+		return fmt.Sprintf("eth/%09d.dbin.zst", baseBlockNum)
+	}
+	parse := func(name string) (uint64, error) {
+		var base uint64
+		if _, err := fmt.Sscanf(name, "eth/%09d.dbin.zst", &base); err != nil {
+			return 0, err
+		}
+		return base, nil
+	}
+
+	defaultNamed, lastBlockNum := newLinearBundlesStore(3, 100)
+	bs := dstore.NewMockStore(nil)
+	for _, baseNum := range []uint64{0, 100, 200} {
+		reader, err := defaultNamed.OpenObject(context.Background(), base(int(baseNum)))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		bs.SetFile(format(baseNum), content)
+	}
+	bs.SetFile("eth/README", []byte("not a bundle"))
+
+	tests := []struct {
+		name    string
+		options []FileSourceOption
+	}{
+		{"fixed bundle size", nil},
+		{"bundle size auto detection", []FileSourceOption{FileSourceWithBundleSizeAutoDetect()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			options := append([]FileSourceOption{FileSourceWithStopBlock(lastBlockNum)}, test.options...)
+			options = append(options, FileSourceWithFilenameScheme(format, parse))
+			fs := NewFileSource(bs, 1, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			var expected []uint64
+			for num := uint64(1); num <= lastBlockNum; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+		})
+	}
+
+	bs.DeleteObject(context.Background(), format(0))
+	factory := NewFileSourceFactory(bs, nil, zlog)
+	factory.SetFilenameScheme(format, parse)
+	lowest, err := factory.LowestAvailableBlock(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), lowest)
+}
+
+// recordingStore records the files opened
+type recordingStore struct {
+	*dstore.MockStore
+
+	lock   sync.Mutex
+	opened []string
+}
+
+func (s *recordingStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.lock.Lock()
+	s.opened = append(s.opened, name)
+	s.lock.Unlock()
+	return s.MockStore.OpenObject(ctx, name)
+}
+
+func TestFileSource_Partitions(t *testing.T) {
+	all, lastBlockNum := newLinearBundlesStore(5, 100)
+
+	tests := []struct {
+		name           string
+		startBlockNum  uint64
+		options        []FileSourceOption
+		expectArchival []string
+		expectHot      []string
+	}{
+		{
+			name:           "from the archival partition",
+			startBlockNum:  1,
+			expectArchival: []string{base(0), base(100), base(200)},
+			expectHot:      []string{base(300), base(400)},
+		},
+		{
+			name:          "from the hot partition",
+			startBlockNum: 320,
+			expectHot:     []string{base(300), base(400)},
+		},
+		{
+			name:           "bundle size auto detection",
+			startBlockNum:  1,
+			options:        []FileSourceOption{FileSourceWithBundleSizeAutoDetect()},
+			expectArchival: []string{base(0), base(100), base(200)},
+			expectHot:      []string{base(300), base(400)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the boundary at block 250 falls within the bundle based at 200,
+			// which both stores hold and the archival partition owns
+			archival := &recordingStore{MockStore: dstore.NewMockStore(nil)}
+			hot := &recordingStore{MockStore: dstore.NewMockStore(nil)}
+			for _, baseNum := range []int{0, 100, 200, 300, 400} {
+				reader, err := all.OpenObject(context.Background(), base(baseNum))
+				require.NoError(t, err)
+				content, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				if baseNum <= 200 {
+					archival.SetFile(base(baseNum), content)
+				}
+				if baseNum >= 200 {
+					hot.SetFile(base(baseNum), content)
+				}
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			partitions := []StorePartition{{Store: hot, FromBlock: 250}, {Store: archival}}
+			options := append([]FileSourceOption{FileSourceWithStopBlock(lastBlockNum)}, test.options...)
+			fs := NewPartitionedFileSource(partitions, test.startBlockNum, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			var expected []uint64
+			for num := test.startBlockNum; num <= lastBlockNum; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+			// the files are opened concurrently
+			sort.Strings(archival.opened)
+			sort.Strings(hot.opened)
+			assert.Equal(t, test.expectArchival, archival.opened)
+			assert.Equal(t, test.expectHot, hot.opened)
+		})
+	}
+}
+
+func TestFileSource_TerminateOnEnd(t *testing.T) {
+	tests := []struct {
+		name          string
+		lastBlockNum  uint64
+		missingChecks int64
+	}{
+		{
+			name:         "ending at a bundle boundary",
+			lastBlockNum: 299,
+		},
+		{
+			name:         "ending with a partial bundle",
+			lastBlockNum: 249,
+		},
+		{
+			name:          "missing bundle followed by others",
+			lastBlockNum:  299,
+			missingChecks: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := newBundlesStore([]uint64{0, 100, 200}, test.lastBlockNum)
+
+			// base 100 is written after the following ones
+			var checks int64
+			bs.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+				if filename == base(100) && atomic.AddInt64(&checks, 1) <= test.missingChecks {
+					return false, nil
+				}
+				return filename == base(0) || filename == base(100) || filename == base(200), nil
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithTerminateOnEnd())
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrEndOfStore)
+			var expected []uint64
+			for num := uint64(1); num <= test.lastBlockNum; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+		})
+	}
+}
+
+func TestFileSource_HandlerTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		shutdown bool
+	}{
+		{"keeps waiting", false},
+		{"shuts down", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+			unblock := make(chan struct{})
+			defer func() {
+				select {
+				case <-unblock:
+				default:
+					close(unblock)
+				}
+			}()
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				if blk.Number == 50 {
+					<-unblock
+				}
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			stalls := make(chan BlockRef, 10)
+			options := []FileSourceOption{
+				FileSourceWithStopBlock(lastBlockNum),
+				FileSourceWithHandlerTimeout(20*time.Millisecond, func(block BlockRef, stuckFor time.Duration) {
+					assert.GreaterOrEqual(t, stuckFor, 20*time.Millisecond)
+					stalls <- block
+				}),
+			}
+			if test.shutdown {
+				options = append(options, FileSourceWithShutdownOnHandlerStall())
+			}
+			fs := NewFileSource(bs, 1, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+
+			select {
+			case block := <-stalls:
+				assert.Equal(t, uint64(50), block.Num())
+			case <-time.After(time.Second):
+				t.Fatal("stall not reported")
+			}
+
+			if test.shutdown {
+				select {
+				case <-fs.Terminated():
+				case <-time.After(time.Second):
+					t.Fatal("Test timeout")
+				}
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), `handler stuck processing block #50 (50a) for`)
+				return
+			}
+
+			assert.False(t, fs.IsTerminating())
+			close(unblock)
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Len(t, received, int(lastBlockNum))
+		})
+	}
+}
+
+func TestFileSource_ResumeBundleRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		openRetries int
+		expectErr   string
+	}{
+		{
+			name:        "resumed after the last block read",
+			openRetries: 1,
+		},
+		{
+			name:      "not retried",
+			expectErr: `processing of file "0000000000" failed: error processing incoming file`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(3, 100)
+			// the first read of each file fails halfway
+			store := &resettingStore{MockStore: bs, failAfter: 5000, opened: make(map[string]bool)}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				return nil
+			})
+
+			fs := NewFileSource(store, 1, handler, zlog, FileSourceWithStopBlock(lastBlockNum), FileSourceWithOpenRetries(test.openRetries))
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectErr != "" {
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), test.expectErr)
+				return
+			}
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Equal(t, 3, store.resets)
+
+			var expected []uint64
+			for num := uint64(1); num <= lastBlockNum; num++ {
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+		})
+	}
+}
+
+func TestFileSourceFactory_PerCallOptions(t *testing.T) {
+	bs, _ := newLinearBundlesStore(3, 100)
+	factory := NewFileSourceFactory(bs, dstore.NewMockStore(nil), zlog, FileSourceWithStopBlock(299), FileSourceWithPrefetch(1))
+
+	// both sources run at the same time
+	received := make([][]uint64, 2)
+	var wg sync.WaitGroup
+	for i, stopBlockNum := range []uint64{50, 150} {
+		i := i
+		handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+			received[i] = append(received[i], blk.Number)
+			return nil
+		})
+		src := factory.SourceFromBlockNumWithOpts(1, handler, FileSourceWithStopBlock(stopBlockNum))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src.Run()
+			assert.ErrorIs(t, src.Err(), ErrStopBlockReached)
+		}()
+	}
+
+	testDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	assert.Len(t, received[0], 50)
+	assert.Len(t, received[1], 150)
+
+	cursor := &Cursor{Step: StepNew, Block: NewBlockRef("120a", 120), LIB: NewBlockRef("110a", 110), HeadBlock: NewBlockRef("120a", 120)}
+	fs := factory.SourceFromCursorWithOpts(cursor, nil, FileSourceWithStopBlock(150)).(*FileSource)
+	assert.Equal(t, uint64(150), fs.stopBlockNum)
+	assert.IsType(t, &cursorResolver{}, fs.handler)
+
+	fs = factory.SourceFromCursor(cursor, nil).(*FileSource)
+	assert.Equal(t, uint64(299), fs.stopBlockNum, "factory options are left untouched")
+}
+
+func TestFileSource_CursorStep(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         []FileSourceOption
+		expectStep      StepType
+		expectHeadBlock func(blk *pbbstream.Block) BlockRef
+	}{
+		{
+			name:            "default",
+			expectStep:      StepNewIrreversible,
+			expectHeadBlock: func(blk *pbbstream.Block) BlockRef { return blk.AsRef() },
+		},
+		{
+			name: "new step with head block hint",
+			options: []FileSourceOption{
+				FileSourceWithCursorStep(StepNew),
+				FileSourceWithHeadBlockHint(func() BlockRef { return NewBlockRef("150a", 150) }),
+			},
+			expectStep: StepNew,
+			expectHeadBlock: func(blk *pbbstream.Block) BlockRef {
+				if blk.Number < 150 {
+					return NewBlockRef("150a", 150)
+				}
+				return blk.AsRef()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, lastBlockNum := newLinearBundlesStore(2, 100)
+
+			var count int
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				count++
+				cursor := obj.(Cursorable).Cursor()
+				assert.Equal(t, test.expectStep, cursor.Step)
+				assert.Equal(t, test.expectStep, obj.(Stepable).Step())
+				assert.Equal(t, blk.AsRef().String(), cursor.Block.String())
+				assert.Equal(t, blk.AsRef().String(), cursor.LIB.String())
+				assert.Equal(t, test.expectHeadBlock(blk).String(), cursor.HeadBlock.String())
+				return nil
+			})
+
+			options := append([]FileSourceOption{FileSourceWithStopBlock(lastBlockNum)}, test.options...)
+			fs := NewFileSource(bs, 1, handler, zlog, options...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+			assert.Equal(t, int(lastBlockNum), count)
+		})
+	}
+}
+
+func TestFileSource_ListedBundles(t *testing.T) {
+	tests := []struct {
+		name            string
+		bundleCount     int
+		expectedChecked []string
+	}{
+		{
+			name:        "far from the highest bundle",
+			bundleCount: 30,
+		},
+		{
+			name:            "close to the highest bundle",
+			bundleCount:     3,
+			expectedChecked: []string{base(0), base(10), base(20)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStore, lastBlockNum := newLinearBundlesStore(test.bundleCount, 10)
+			store := &countingStore{MockStore: mockStore}
+
+			// only the existing files are recorded, the source keeps polling past the last one
+			var lock sync.Mutex
+			var checked []string
+			mockStore.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+				base, err := strconv.ParseUint(filename, 10, 64)
+				require.NoError(t, err)
+				if base >= uint64(test.bundleCount)*10 {
+					return false, nil
+				}
+				lock.Lock()
+				checked = append(checked, filename)
+				lock.Unlock()
+				return true, nil
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number == lastBlockNum {
+					return errDone
+				}
+				return nil
+			})
+
+			fs := NewFileSource(store, 1, handler, zlog, FileSourceWithBundleSize(10), FileSourceWithListedBundles(5))
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.Equal(t, errDone, fs.Err())
+			assert.Len(t, received, int(lastBlockNum))
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, test.expectedChecked, checked)
+			assert.LessOrEqual(t, atomic.LoadInt64(&store.walkCalls), int64(2))
+		})
+	}
+}
+
+func TestFileSource_NearLiveCallback(t *testing.T) {
+	bs := newBundlesStore([]uint64{0, 100, 200, 300, 400, 500}, 599)
+
+	// the highest available base is raised each time the source waits for a missing file
+	var lock sync.Mutex
+	highest := uint64(100)
+	published := []uint64{200, 500}
+	bs.FileExistsFunc = func(ctx context.Context, filename string) (bool, error) {
+		baseNum, err := strconv.ParseUint(filename, 10, 64)
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if baseNum <= highest {
+			return true, nil
+		}
+		if len(published) > 0 {
+			highest, published = published[0], published[1:]
+		}
+		return false, nil
+	}
+
+	var nearLiveCalls []uint64
+	nearLive := make(chan struct{})
+	var fs *FileSource
+	onNearLive := func(lastBase uint64) {
+		assert.True(t, fs.IsNearLive())
+		nearLiveCalls = append(nearLiveCalls, lastBase)
+		if lastBase == 500 {
+			close(nearLive)
+		}
+	}
+
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error { return nil })
+	fs = NewFileSource(bs, 1, handler, zlog, FileSourceWithNearLiveCallback(1, onNearLive))
+	fired := make(chan time.Time)
+	close(fired)
+	fs.after = func(d time.Duration) <-chan time.Time { return fired }
+	assert.False(t, fs.IsNearLive())
+
+	go fs.Run()
+	defer fs.Shutdown(nil)
+
+	select {
+	case <-nearLive:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	// caught up at 100, still near live when 200 appears alone, behind once 300 to 500 appear together
+	assert.Equal(t, []uint64{100, 500}, nearLiveCalls)
+	assert.True(t, fs.IsNearLive())
+}
+
+func TestFileSource_ErrorBudget(t *testing.T) {
+	tests := []struct {
+		name            string
+		budget          int
+		gapIn300        bool
+		expectedErr     string
+		expectedSkipped []*Range
+		// expectedReceived300 is the amount of blocks received from bundle 300
+		expectedReceived300 uint64
+	}{
+		{
+			name:        "budget exceeded",
+			budget:      1,
+			expectedErr: "error budget of 1 failed merged blocks files exceeded, failed bases [100 300]",
+		},
+		{
+			name:            "within budget",
+			budget:          3,
+			expectedSkipped: []*Range{NewRangeExcludingEnd(100, 200), NewRangeExcludingEnd(300, 400)},
+		},
+		{
+			name:                "with a gap detected",
+			budget:              3,
+			gapIn300:            true,
+			expectedSkipped:     []*Range{NewRangeExcludingEnd(100, 200), NewRangeExcludingEnd(300, 400)},
+			expectedReceived300: 50,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := newBundlesStore([]uint64{0, 100, 200, 300, 400}, 499)
+			bs.SetFile(base(100), []byte("poison"))
+			var opts []FileSourceOption
+			if test.gapIn300 {
+				// blocks 350 to 359 are missing, the ones before the gap are sent
+				var blocks []*pbbstream.Block
+				prevID := "299a"
+				for num := uint64(300); num < 400; num++ {
+					if num >= 350 && num < 360 {
+						continue
+					}
+					id := fmt.Sprintf("%da", num)
+					blocks = append(blocks, TestBlockWithNumbers(id, prevID, num, 0))
+					prevID = id
+				}
+				bs.SetFile(base(300), testBlocks(blocks...))
+				opts = append(opts, FileSourceWithGapDetection(0))
+			} else {
+				bs.SetFile(base(300), []byte("poison"))
+			}
+
+			var lock sync.Mutex
+			var failedBases []uint64
+			onBundleError := func(base uint64, err error) {
+				lock.Lock()
+				defer lock.Unlock()
+				failedBases = append(failedBases, base)
+			}
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number == 499 {
+					return errDone
+				}
+				return nil
+			})
+
+			fs := NewFileSource(bs, 1, handler, zlog, append(opts, FileSourceWithErrorBudget(test.budget, onBundleError))...)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, []uint64{100, 300}, failedBases)
+
+			if test.expectedErr != "" {
+				require.Error(t, fs.Err())
+				assert.Contains(t, fs.Err().Error(), test.expectedErr)
+				return
+			}
+			assert.Equal(t, errDone, fs.Err())
+			assert.Equal(t, test.expectedSkipped, fs.SkippedRanges())
+
+			var expected []uint64
+			for num := uint64(1); num <= 499; num++ {
+				if (num >= 100 && num < 200) || (num >= 300+test.expectedReceived300 && num < 400) {
+					continue
+				}
+				expected = append(expected, num)
+			}
+			assert.Equal(t, expected, received)
+		})
+	}
+}
+
+func TestFileSource_InvalidCursor(t *testing.T) {
+	bs := newBundlesStore([]uint64{0, 100}, 199)
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		t.Fatal("no block expected")
+		return nil
+	})
+
+	fs := NewFileSourceFromCursor(bs, nil, &Cursor{
+		Step:      StepNew,
+		Block:     NewBlockRef("50a", 50),
+		HeadBlock: NewBlockRef("50a", 50),
+		LIB:       NewBlockRef("60a", 60),
+	}, handler, zlog)
+
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.EqualError(t, fs.Err(), "invalid cursor: LIB #60 is above block #50")
+}
+
+func TestFileSource_BlockPool(t *testing.T) {
+	bs, lastBlockNum := newLinearBundlesStore(3, 100)
+
+	var received []uint64
+	pointers := make(map[*pbbstream.Block]bool)
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Number)
+		pointers[blk] = true
+		return nil
+	})
+
+	var progress []string
+	testDone := make(chan struct{})
+	fs := NewFileSource(bs, 1, handler, zlog,
+		FileSourceWithBlockPool(NewBlockPool()),
+		FileSourceWithProgressCallback(func(bundleBase uint64, lastBlock BlockRef, elapsed time.Duration) {
+			progress = append(progress, lastBlock.ID())
+			if bundleBase == 200 {
+				close(testDone)
+			}
+		}),
+	)
+	go fs.Run()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+	fs.Shutdown(nil)
+
+	require.Len(t, received, int(lastBlockNum))
+	for i, num := range received {
+		require.Equal(t, uint64(i+1), num)
+	}
+	assert.Less(t, len(pointers), len(received), "blocks are reused")
+	// the sent blocks are referenced by copy
+	assert.Equal(t, []string{"99a", "199a", "299a"}, progress)
+	assert.Equal(t, lastBlockNum, fs.HighestProcessedBlock().Num())
+}
+
+func TestFileSource_EmptyCursor(t *testing.T) {
+	bs := newBundlesStore([]uint64{0, 100}, 199)
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		t.Fatal("no block expected")
+		return nil
+	})
+
+	for _, cursor := range []*Cursor{nil, EmptyCursor} {
+		for _, fs := range []*FileSource{
+			NewFileSourceFromCursor(bs, nil, cursor, handler, zlog),
+			NewFileSourceThroughCursor(bs, nil, 50, cursor, handler, zlog),
+		} {
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.ErrorIs(t, fs.Err(), ErrEmptyCursor)
+		}
+	}
+}
+
+func TestFileSource_ResumeFromLIBOnlyCursor(t *testing.T) {
+	bs := newBundlesStore([]uint64{0, 100, 200}, 299)
+
+	stream := func(newSource func(h Handler) *FileSource, untilBlockNum uint64) (received []uint64, last Cursorable) {
+		handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+			received = append(received, blk.Number)
+			last = obj.(Cursorable)
+			if blk.Number == untilBlockNum {
+				return errDone
+			}
+			return nil
+		})
+		fs := newSource(handler)
+
+		testDone := make(chan struct{})
+		go func() {
+			fs.Run()
+			close(testDone)
+		}()
+		select {
+		case <-testDone:
+		case <-time.After(time.Second):
+			t.Fatal("Test timeout")
+		}
+		assert.Equal(t, errDone, fs.Err())
+		return
+	}
+
+	first, last := stream(func(h Handler) *FileSource { return NewFileSource(bs, 1, h, zlog) }, 150)
+	// only the final block number and ID are kept
+	lib := last.Cursor().LIB
+	cursor := NewLIBOnlyCursor(NewBlockRef(lib.ID(), lib.Num()))
+	assert.True(t, cursor.IsLIBOnly())
+
+	resumed, _ := stream(func(h Handler) *FileSource { return NewFileSourceFromCursor(bs, nil, cursor, h, zlog) }, 299)
+	require.NotEmpty(t, resumed)
+	assert.Equal(t, uint64(151), resumed[0])
+
+	all := append(first, resumed...)
+	for i, num := range all {
+		require.Equal(t, uint64(i+1), num, "duplicate or missing block")
+	}
+	assert.Len(t, all, 299)
+}
+
+func TestFileSource_HeaderOnly(t *testing.T) {
+	bs := dstore.NewMockStore(nil)
+	bs.SetFile(base(0), testBlocks(
+		TestBlockWithLIBNum("00000001a", "00000000a", 0),
+		TestBlockWithLIBNum("00000002a", "00000001a", 1),
+		TestBlockWithLIBNum("00000003a", "00000002a", 2),
+	))
+
+	var received []string
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		if _, err := pbbstream.NewDecodedBlock(blk).Decoded(func([]byte) (interface{}, error) { return nil, nil }); !errors.Is(err, pbbstream.ErrHeaderOnly) {
+			return fmt.Errorf("expected a header only block, decoding returned %v", err)
+		}
+		received = append(received, fmt.Sprintf("%s lib=%d", blk.Id, blk.LibNum))
+		return nil
+	})
+
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithHeaderOnly(), FileSourceWithStopBlock(3))
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	assert.Equal(t, []string{"00000001a lib=0", "00000002a lib=1", "00000003a lib=2"}, received)
+}
+
+func TestFileSource_BlockMetadata(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewDBinBlockWriter(buf, DBinBlockWriterWithMetadata())
+	require.NoError(t, err)
+	for num := uint64(1); num <= 3; num++ {
+		blk := TestBlockWithNumbers(fmt.Sprintf("%08xa", num), fmt.Sprintf("%08xa", num-1), num, num-1)
+		blk.SetMeta("peer", fmt.Sprintf("peer-%d", num))
+		require.NoError(t, writer.Write(blk))
+	}
+	bs := dstore.NewMockStore(nil)
+	bs.SetFile(base(0), buf.Bytes())
+
+	var received []string
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		peer, _ := blk.GetMeta("peer")
+		verified, _ := blk.GetMeta("verified")
+		received = append(received, fmt.Sprintf("#%d peer=%s verified=%s obj=%v", blk.Number, peer, verified, obj.(ObjectWrapper).WrappedObject()))
+		return nil
+	})
+	preproc := func(blk *pbbstream.Block) (interface{}, error) {
+		blk.SetMeta("verified", "true")
+		return blk.Number * 10, nil
+	}
+
+	fs := NewFileSource(bs, 1, handler, zlog, FileSourceWithConcurrentPreprocess(preproc, 2), FileSourceWithStopBlock(3))
+	testDone := make(chan struct{})
+	go func() {
+		fs.Run()
+		close(testDone)
+	}()
+	select {
+	case <-testDone:
+	case <-time.After(time.Second):
+		t.Fatal("Test timeout")
+	}
+
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	assert.Equal(t, []string{
+		"#1 peer=peer-1 verified=true obj=10",
+		"#2 peer=peer-2 verified=true obj=20",
+		"#3 peer=peer-3 verified=true obj=30",
+	}, received)
+}
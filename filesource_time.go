@@ -0,0 +1,190 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// NewFileSourceFromTime creates a FileSource starting at the first block
+// produced at or after `t`, found by a binary search over the blocks archives
+// of `blocksStore` on the timestamp of their first block. Archives missing or
+// removed while searching are ignored. The archives are read like the source
+// reads them, with the filename scheme, compression, block reader factory and
+// secondary store given in `options`.
+func NewFileSourceFromTime(
+	ctx context.Context,
+	blocksStore dstore.Store,
+	t time.Time,
+	h Handler,
+	logger *zap.Logger,
+	options ...FileSourceOption,
+) (*FileSource, error) {
+	fs := NewFileSourceWithContext(ctx, blocksStore, 0, h, logger, options...)
+	searcher := &blockTimeSearcher{source: fs}
+
+	startBlockNum, err := searcher.blockNumAt(ctx, t)
+	if err != nil {
+		err = fmt.Errorf("finding block at %s: %w", t, err)
+		fs.Shutdown(err)
+		return nil, err
+	}
+	logger.Info("starting file source from time", zap.Time("time", t), zap.Uint64("start_block", startBlockNum))
+
+	fs.startBlockNum = startBlockNum
+	return fs, nil
+}
+
+// blockTimeSearcher reads the blocks archives of `source`, before it is run
+type blockTimeSearcher struct {
+	source *FileSource
+}
+
+// blockNumAt returns the number of the first block with a timestamp at or after `t`
+func (s *blockTimeSearcher) blockNumAt(ctx context.Context, t time.Time) (uint64, error) {
+	bases, err := s.listBases(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// lowest index of the archives whose first block is at or after t
+	lo, hi := 0, len(bases)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		blk, found, err := s.firstBlockAt(ctx, bases[mid], time.Time{})
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			bases = append(bases[:mid], bases[mid+1:]...)
+			hi--
+			continue
+		}
+
+		blockTime, err := blockTimestamp(blk, bases[mid])
+		if err != nil {
+			return 0, err
+		}
+		if blockTime.Before(t) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	// the block is in the archive before, else it is the first one of the archive found
+	if lo > 0 {
+		blk, found, err := s.firstBlockAt(ctx, bases[lo-1], t)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return blk.Number, nil
+		}
+	}
+	for i := lo; i < len(bases); i++ {
+		blk, found, err := s.firstBlockAt(ctx, bases[i], time.Time{})
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return blk.Number, nil
+		}
+	}
+	return 0, errors.New("no block at or after this time in blocks store")
+}
+
+func (s *blockTimeSearcher) listBases(ctx context.Context) ([]uint64, error) {
+	var bases []uint64
+	scheme := s.source.filenameScheme
+	err := s.source.blocksStore.Walk(ctx, scheme.listingPrefix(0, math.MaxUint64), func(filename string) error {
+		base, err := scheme.parse(filename)
+		if err != nil || scheme.format(base) != filename {
+			return nil
+		}
+		bases = append(bases, base)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing merged blocks files: %w", err)
+	}
+	if len(bases) == 0 {
+		return nil, errors.New("no merged blocks files in store")
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+// firstBlockAt returns the first block of the archive based at `baseNum` with a
+// timestamp at or after `t`, `found` is false when there is none or when the
+// archive does not exist
+func (s *blockTimeSearcher) firstBlockAt(ctx context.Context, baseNum uint64, t time.Time) (blk *pbbstream.Block, found bool, err error) {
+	filename := s.source.filenameScheme.format(baseNum)
+	reader, err := s.source.openObject(s.source.storeFor(baseNum), filename)
+	if err != nil {
+		if errors.Is(err, dstore.ErrNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("opening merged blocks file %q: %w", filename, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := decompressedReader(reader, s.source.compression)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading merged blocks file %q: %w", filename, err)
+	}
+	defer decompressed.Close()
+
+	blockReader, err := s.source.blockReaderFactory.New(decompressed)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to create block reader for %q: %w", filename, err)
+	}
+
+	for {
+		blk, err := blockReader.Read()
+		if blk != nil && blk.Number >= baseNum {
+			blockTime, err := blockTimestamp(blk, baseNum)
+			if err != nil {
+				return nil, false, err
+			}
+			if !blockTime.Before(t) {
+				return blk, true, nil
+			}
+		}
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("reading merged blocks file %q: %w", filename, err)
+		}
+	}
+}
+
+func blockTimestamp(blk *pbbstream.Block, baseNum uint64) (time.Time, error) {
+	if !blk.HasTime() {
+		return time.Time{}, fmt.Errorf("block #%d of merged blocks file based at %d has no timestamp, unable to search by time", blk.Number, baseNum)
+	}
+	return blk.Time(), nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var timedBlocksGenesis = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// newTimedBundlesStore has bundles of 100 blocks up to block 999, block N is
+// produced N seconds after timedBlocksGenesis. The bundles in `missing` are
+// not in the store and the ones in `removed` are listed but cannot be opened.
+func newTimedBundlesStore(withTimestamps bool, missing, removed []uint64) *dstore.MockStore {
+	store := dstore.NewMockStore(nil)
+	files := make(map[string][]byte)
+	prevID := "00"
+	for baseNum := uint64(0); baseNum < 1000; baseNum += 100 {
+		var blocks []*pbbstream.Block
+		for num := baseNum; num < baseNum+100; num++ {
+			if num == 0 {
+				continue
+			}
+			id := fmt.Sprintf("%da", num)
+			blockJSON := fmt.Sprintf(`{"id":%q,"prev":%q,"num":%d}`, id, prevID, num)
+			if withTimestamps {
+				blockTime := timedBlocksGenesis.Add(time.Duration(num) * time.Second)
+				blockJSON = fmt.Sprintf(`{"id":%q,"prev":%q,"num":%d,"time":%q}`, id, prevID, num, blockTime.Format(testBlockDateLayout))
+			}
+			blocks = append(blocks, TestBlockFromJSON(blockJSON))
+			prevID = id
+		}
+		files[base(int(baseNum))] = testBlocks(blocks...)
+	}
+
+	for _, baseNum := range missing {
+		delete(files, base(int(baseNum)))
+	}
+	for name, content := range files {
+		store.SetFile(name, content)
+	}
+	store.OpenObjectFunc = func(ctx context.Context, name string) (io.ReadCloser, error) {
+		for _, baseNum := range removed {
+			if name == base(int(baseNum)) {
+				return nil, dstore.ErrNotFound
+			}
+		}
+		content, found := files[name]
+		if !found {
+			return nil, dstore.ErrNotFound
+		}
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	return store
+}
+
+func TestBlockTimeSearcher_BlockNumAt(t *testing.T) {
+	tests := []struct {
+		name             string
+		withoutTimestamp bool
+		missing          []uint64
+		removed          []uint64
+		at               time.Duration
+		expectedBlockNum uint64
+		expectedErr      string
+	}{
+		{
+			name:             "exact block time",
+			at:               250 * time.Second,
+			expectedBlockNum: 250,
+		},
+		{
+			name:             "between blocks",
+			at:               250*time.Second + 500*time.Millisecond,
+			expectedBlockNum: 251,
+		},
+		{
+			name:             "first block of a bundle",
+			at:               500 * time.Second,
+			expectedBlockNum: 500,
+		},
+		{
+			name:             "before the first block",
+			at:               -time.Hour,
+			expectedBlockNum: 1,
+		},
+		{
+			name:             "in a missing bundle",
+			missing:          []uint64{400},
+			at:               450 * time.Second,
+			expectedBlockNum: 500,
+		},
+		{
+			name:             "bundles removed while searching",
+			removed:          []uint64{400, 500, 600},
+			at:               550 * time.Second,
+			expectedBlockNum: 700,
+		},
+		{
+			name:        "after the last block",
+			at:          time.Hour,
+			expectedErr: "no block at or after this time in blocks store",
+		},
+		{
+			name:             "without timestamps",
+			withoutTimestamp: true,
+			at:               250 * time.Second,
+			expectedErr:      "block #500 of merged blocks file based at 500 has no timestamp, unable to search by time",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			searcher := &blockTimeSearcher{
+				source: NewFileSource(newTimedBundlesStore(!test.withoutTimestamp, test.missing, test.removed), 0, nil, zlog),
+			}
+
+			blockNum, err := searcher.blockNumAt(context.Background(), timedBlocksGenesis.Add(test.at))
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedBlockNum, blockNum)
+		})
+	}
+}
+
+func TestNewFileSourceFromTime(t *testing.T) {
+	gzippedStore := func(t *testing.T) dstore.Store {
+		store := newTimedBundlesStore(true, nil, nil)
+		openObject := store.OpenObjectFunc
+		store.OpenObjectFunc = func(ctx context.Context, name string) (io.ReadCloser, error) {
+			reader, err := openObject(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(bytes.NewReader(gzipped(t, content))), nil
+		}
+		return store
+	}
+
+	tests := []struct {
+		name             string
+		store            func(t *testing.T) dstore.Store
+		options          []FileSourceOption
+		expectedReceived []uint64
+	}{
+		{
+			name:             "plain store",
+			store:            func(t *testing.T) dstore.Store { return newTimedBundlesStore(true, nil, nil) },
+			expectedReceived: []uint64{317, 318, 319, 320},
+		},
+		{
+			name:             "compressed store",
+			store:            gzippedStore,
+			expectedReceived: []uint64{317, 318, 319, 320},
+		},
+		{
+			name:             "without secondary store",
+			store:            func(t *testing.T) dstore.Store { return newTimedBundlesStore(true, nil, []uint64{300}) },
+			expectedReceived: []uint64{400},
+		},
+		{
+			name:             "secondary store",
+			store:            func(t *testing.T) dstore.Store { return newTimedBundlesStore(true, nil, []uint64{300}) },
+			options:          []FileSourceOption{FileSourceWithSecondaryStore(newTimedBundlesStore(true, nil, nil))},
+			expectedReceived: []uint64{317, 318, 319, 320},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number >= 320 {
+					return errDone
+				}
+				return nil
+			})
+
+			fs, err := NewFileSourceFromTime(context.Background(), test.store(t), timedBlocksGenesis.Add(317*time.Second), handler, zlog, test.options...)
+			require.NoError(t, err)
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			assert.Equal(t, errDone, fs.Err())
+			assert.Equal(t, test.expectedReceived, received)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"github.com/streamingfast/bstream"
+)
+
+// ChainView is a read-only view of the ForkDB as it was when a ForkableObject was
+// emitted. It's safe to use from any goroutine, even after the Forkable moved on.
+type ChainView interface {
+	// BlockExists returns true if the block was known by the ForkDB
+	BlockExists(id string) bool
+
+	// InCurrentChain returns true if the block is an ancestor of (or is) the Head block
+	InCurrentChain(ref bstream.BlockRef) bool
+
+	LIB() bstream.BlockRef
+	Head() bstream.BlockRef
+}
+
+// chainSnapshot is an immutable copy of the ForkDB links, it holds block refs only
+// and is shared between all the views emitted while the ForkDB did not change.
+type chainSnapshot struct {
+	links       map[string]string
+	nums        map[string]uint64
+	normalizeID func(string) string
+}
+
+type chainView struct {
+	snapshot *chainSnapshot
+	lib      bstream.BlockRef
+	head     bstream.BlockRef
+}
+
+func (v *chainView) normalizeID(id string) string {
+	if v.snapshot.normalizeID == nil || id == "" {
+		return id
+	}
+	return v.snapshot.normalizeID(id)
+}
+
+func (v *chainView) BlockExists(id string) bool {
+	id = v.normalizeID(id)
+	if id == "" {
+		return false
+	}
+
+	if _, found := v.snapshot.links[id]; found {
+		return true
+	}
+	return id == v.lib.ID()
+}
+
+func (v *chainView) InCurrentChain(ref bstream.BlockRef) bool {
+	if ref == nil || bstream.IsEmpty(v.head) {
+		return false
+	}
+
+	id := v.normalizeID(ref.ID())
+	cur := v.head.ID()
+	curNum := v.head.Num()
+
+	// Bounded by the amount of links so a corrupted snapshot cannot loop forever
+	for i := 0; i <= len(v.snapshot.links); i++ {
+		if cur == id {
+			return true
+		}
+		if curNum <= ref.Num() {
+			return false
+		}
+
+		prev, found := v.snapshot.links[cur]
+		if !found {
+			return false
+		}
+
+		prevNum, found := v.snapshot.nums[prev]
+		if !found {
+			// prev is the root of the ForkDB, we can only match it by ID
+			return prev == id
+		}
+
+		cur = prev
+		curNum = prevNum
+	}
+
+	return false
+}
+
+func (v *chainView) LIB() bstream.BlockRef {
+	return v.lib
+}
+
+func (v *chainView) Head() bstream.BlockRef {
+	return v.head
+}
+
+// chainViewFor returns a view of the ForkDB with `head` as the head block, the
+// underlying snapshot is reused until the ForkDB changes. Must be called while
+// holding the Forkable lock.
+func (p *Forkable) chainViewFor(head bstream.BlockRef) ChainView {
+	if !p.withChainView {
+		return nil
+	}
+
+	if p.chainSnapshot == nil {
+		links, nums := p.forkDB.ClonedLinks()
+		p.chainSnapshot = &chainSnapshot{
+			links:       links,
+			nums:        nums,
+			normalizeID: p.blockIDNormalizer,
+		}
+	}
+
+	return &chainView{
+		snapshot: p.chainSnapshot,
+		lib:      p.forkDB.LIBRef(),
+		head:     head,
+	}
+}
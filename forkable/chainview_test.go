@@ -0,0 +1,99 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForkable_ChainView(t *testing.T) {
+	var objs []*ForkableObject
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		objs = append(objs, obj.(*ForkableObject))
+		return nil
+	})
+
+	p := New(handler, WithExclusiveLIB(bRef("00000001a")), WithChainView())
+	for _, blk := range []*pbbstream.Block{
+		bTestBlock("00000002a", "00000001a"),
+		bTestBlock("00000003a", "00000002a"),
+		bTestBlock("00000003b", "00000002a"),
+		bTestBlock("00000004b", "00000003b"),
+	} {
+		require.NoError(t, p.ProcessBlock(blk, nil))
+	}
+
+	require.Len(t, objs, 5) // new 2a, new 3a, undo 3a, new 3b, new 4b
+
+	viewAt3a := objs[1].ChainView()
+	require.NotNil(t, viewAt3a)
+	assert.Equal(t, "00000003a", viewAt3a.Head().ID())
+	assert.Equal(t, "00000001a", viewAt3a.LIB().ID())
+	assert.True(t, viewAt3a.BlockExists("00000003a"))
+	assert.True(t, viewAt3a.BlockExists("00000001a"))
+	assert.False(t, viewAt3a.BlockExists("00000003b"), "snapshot must not see blocks added later")
+	assert.True(t, viewAt3a.InCurrentChain(bRef("00000002a")))
+	assert.True(t, viewAt3a.InCurrentChain(bRef("00000001a")))
+
+	viewAt4b := objs[4].ChainView()
+	assert.Equal(t, "00000004b", viewAt4b.Head().ID())
+	assert.True(t, viewAt4b.BlockExists("00000003a"))
+	assert.False(t, viewAt4b.InCurrentChain(bRef("00000003a")))
+	assert.True(t, viewAt4b.InCurrentChain(bRef("00000003b")))
+	assert.True(t, viewAt4b.InCurrentChain(bRef("00000002a")))
+	assert.False(t, viewAt4b.InCurrentChain(bRef("00000005b")))
+
+	withoutView := New(nullHandler)
+	require.NoError(t, withoutView.ProcessBlock(bTestBlock("00000002a", "00000001a"), nil))
+	assert.Nil(t, (&ForkableObject{}).ChainView())
+}
+
+func TestForkable_ChainView_ConcurrentReaders(t *testing.T) {
+	views := make(chan ChainView, 100)
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		views <- obj.(*ForkableObject).ChainView()
+		return nil
+	})
+
+	p := New(handler, WithExclusiveLIB(bRef("00000001a")), WithChainView(), WithKeptFinalBlocks(2))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for view := range views {
+			head := view.Head()
+			assert.True(t, view.BlockExists(head.ID()))
+			assert.True(t, view.InCurrentChain(head))
+			assert.NotNil(t, view.LIB())
+		}
+	}()
+
+	prev := "00000001a"
+	for i := uint64(2); i < 200; i++ {
+		id := fmt.Sprintf("%08xa", i)
+		require.NoError(t, p.ProcessBlock(tb(id, prev, i-1), nil))
+		prev = id
+	}
+	close(views)
+	wg.Wait()
+}
@@ -17,6 +17,7 @@ package forkable
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -41,11 +42,15 @@ type Forkable struct {
 	ensureBlockFlows                   bstream.BlockRef
 	ensureBlockFlowed                  bool
 	ensureAllBlocksTriggerLongestChain bool
+	tieBreak                           func(a, b bstream.BlockRef) bool
 
 	holdBlocksUntilLIB bool // if true, never passthrough anything before a LIB is set
 	keptFinalBlocks    int  // how many blocks we keep behind LIB
 
-	includeInitialLIB bool
+	includeInitialLIB    bool
+	firstStreamableBlock *uint64 // if set, first block at or above it is the initial LIB when none is known
+
+	failOnConflictingBlocks bool
 
 	failOnUnlinkableBlocksCount       int
 	failOnUnlinkableBlocksGracePeriod time.Duration
@@ -54,16 +59,28 @@ type Forkable struct {
 	unlinkableBlocksSince             time.Time
 
 	lastLongestChain []*Block
+
+	blockIDNormalizer func(string) string
+
+	headWatchdog *headWatchdog
+
+	onSkippedBlock func(bstream.BlockRef, bstream.StepType)
+
+	blockPool *bstream.BlockPool // receives the blocks purged from the forkDB
+
+	withChainView bool
+	chainSnapshot *chainSnapshot // reset whenever the forkDB changes
 }
 
 func (p *Forkable) AllBlocksAt(num uint64) (out []*pbbstream.Block) {
 	p.RLock()
 	defer p.RUnlock()
-	for id, n := range p.forkDB.nums {
-		if n == num {
-			out = append(out, p.forkDB.objects[id].(*ForkableBlock).Block)
+	p.forkDB.IterateLinks(func(_, _ string, obj interface{}) bool {
+		if fb, ok := obj.(*ForkableBlock); ok && fb.Block.Number == num {
+			out = append(out, fb.Block)
 		}
-	}
+		return true
+	})
 	return
 }
 
@@ -75,8 +92,10 @@ func (p *Forkable) CanonicalBlockAt(num uint64) *pbbstream.Block {
 	}
 	blkref := p.forkDB.BlockInCurrentChain(bstream.NewBlockRef(p.lastBlockSent.Id, p.lastBlockSent.Number), num)
 	if id := blkref.ID(); id != "" {
-		if out, ok := p.forkDB.objects[id]; ok {
-			return out.(*ForkableBlock).Block
+		if blk := p.forkDB.BlockForID(id); blk != nil {
+			if fb, ok := blk.Object.(*ForkableBlock); ok {
+				return fb.Block
+			}
 		}
 	}
 	return nil
@@ -86,12 +105,14 @@ func (p *Forkable) GetBlockByHash(id string) (out *pbbstream.Block) {
 	p.RLock()
 	defer p.RUnlock()
 
-	block := p.forkDB.objects[id]
+	block := p.forkDB.BlockForID(id)
 	if block == nil {
 		return nil
 	}
-
-	return block.(*ForkableBlock).Block
+	if fb, ok := block.Object.(*ForkableBlock); ok {
+		return fb.Block
+	}
+	return nil
 }
 
 func (p *Forkable) CallWithBlocksFromNum(num uint64, callback func([]*bstream.PreprocessedBlock), withForks bool) (err error) {
@@ -114,10 +135,14 @@ func (p *Forkable) CallWithBlocksFromNum(num uint64, callback func([]*bstream.Pr
 	return nil
 }
 
+// CallWithBlocksFromCursor fails with bstream.ErrEmptyCursor on a nil or empty cursor
 func (p *Forkable) CallWithBlocksFromCursor(cursor *bstream.Cursor, callback func([]*bstream.PreprocessedBlock)) error {
+	if cursor.IsEmpty() {
+		return bstream.ErrEmptyCursor
+	}
 	p.RLock()
 	defer p.RUnlock()
-	blks, err := p.blocksFromCursor(cursor)
+	blks, err := p.blocksFromCursor(p.normalizeCursor(cursor))
 	if err != nil {
 		return err
 	}
@@ -125,10 +150,14 @@ func (p *Forkable) CallWithBlocksFromCursor(cursor *bstream.Cursor, callback fun
 	return nil
 }
 
+// CallWithBlocksThroughCursor fails with bstream.ErrEmptyCursor on a nil or empty cursor
 func (p *Forkable) CallWithBlocksThroughCursor(startBlock uint64, cursor *bstream.Cursor, callback func([]*bstream.PreprocessedBlock)) error {
+	if cursor.IsEmpty() {
+		return bstream.ErrEmptyCursor
+	}
 	p.RLock()
 	defer p.RUnlock()
-	blks, err := p.blocksThroughCursor(startBlock, cursor)
+	blks, err := p.blocksThroughCursor(startBlock, p.normalizeCursor(cursor))
 	if err != nil {
 		return err
 	}
@@ -143,11 +172,12 @@ func (p *Forkable) blocksFromNumWithForks(startNum uint64) ([]*bstream.Preproces
 	}
 
 	var wantedBlocks []*ForkableBlock
-	for id, num := range p.forkDB.nums {
-		if num >= startNum {
-			wantedBlocks = append(wantedBlocks, p.forkDB.objects[id].(*ForkableBlock))
+	p.forkDB.IterateLinks(func(_, _ string, obj interface{}) bool {
+		if fb, ok := obj.(*ForkableBlock); ok && fb.Block.Number >= startNum {
+			wantedBlocks = append(wantedBlocks, fb)
 		}
-	}
+		return true
+	})
 
 	sort.Slice(wantedBlocks, func(i, j int) bool {
 		return wantedBlocks[i].Block.Number < wantedBlocks[j].Block.Number
@@ -175,12 +205,14 @@ func (p *Forkable) blocksFromNum(num uint64) ([]*bstream.PreprocessedBlock, erro
 	}
 	headRef := head.AsRef()
 
-	seg, reachLIB := p.forkDB.CompleteSegment(headRef)
+	seg, reachLIB, release := p.completeSegment(headRef)
+	defer release()
 	if !reachLIB {
 		return nil, fmt.Errorf("head segment does not reach LIB")
 	}
 
-	libNum := p.forkDB.libRef.Num()
+	libRef := p.forkDB.LIBRef()
+	libNum := libRef.Num()
 
 	var out []*bstream.PreprocessedBlock
 	var seenBlock bool
@@ -196,7 +228,7 @@ func (p *Forkable) blocksFromNum(num uint64) ([]*bstream.PreprocessedBlock, erro
 			}
 			continue
 		}
-		lib := p.forkDB.libRef
+		lib := libRef
 		if lib.Num() > ref.Num() {
 			lib = ref // never send cursor with LIB > Block
 		}
@@ -212,25 +244,97 @@ func (p *Forkable) blocksFromNum(num uint64) ([]*bstream.PreprocessedBlock, erro
 	return out, nil
 }
 
+func (p *Forkable) normalizeID(id string) string {
+	if p.blockIDNormalizer == nil || id == "" {
+		return id
+	}
+	return p.blockIDNormalizer(id)
+}
+
+func (p *Forkable) normalizeRef(ref bstream.BlockRef) bstream.BlockRef {
+	if p.blockIDNormalizer == nil || ref == nil || ref.ID() == "" {
+		return ref
+	}
+	return bstream.NewBlockRef(p.blockIDNormalizer(ref.ID()), ref.Num())
+}
+
+// normalizeCursor returns a copy of the cursor with all its block IDs normalized, the
+// received cursor is returned as-is when no normalizer is configured.
+func (p *Forkable) normalizeCursor(cursor *bstream.Cursor) *bstream.Cursor {
+	if p.blockIDNormalizer == nil || cursor == nil {
+		return cursor
+	}
+
+	return &bstream.Cursor{
+		Step:      cursor.Step,
+		Block:     p.normalizeRef(cursor.Block),
+		HeadBlock: p.normalizeRef(cursor.HeadBlock),
+		LIB:       p.normalizeRef(cursor.LIB),
+	}
+}
+
+// normalizedBlock returns `blk` with its IDs normalized, as a copy sharing its
+// payload when they change: the block received is shared with the other
+// handlers of the caller, it must not be modified.
+func (p *Forkable) normalizedBlock(blk *pbbstream.Block) *pbbstream.Block {
+	if p.blockIDNormalizer == nil {
+		return blk
+	}
+	id, parentID := p.normalizeID(blk.Id), p.normalizeID(blk.ParentId)
+	if id == blk.Id && parentID == blk.ParentId {
+		return blk
+	}
+
+	out := blk.CloneRef()
+	out.Id = id
+	out.ParentId = parentID
+	out.PayloadKind = blk.PayloadKind
+	out.PayloadVersion = blk.PayloadVersion
+	out.PayloadBuffer = blk.PayloadBuffer
+	out.Payload = blk.Payload
+	// the metadata and the unknown fields
+	out.ProtoReflect().SetUnknown(blk.ProtoReflect().GetUnknown())
+	return out
+}
+
 func (p *Forkable) Linkable(blk *pbbstream.Block) bool {
 	// blk is already in the forkdb
-	if _, ok := p.forkDB.links[blk.Id]; ok {
+	if p.forkDB.BlockForID(blk.Id) != nil {
 		return !bstream.IsEmpty(p.forkDB.BlockInCurrentChain(blk.AsRef(), blk.LibNum))
 	}
 
 	// blk is not in the forkdb yet, look for it's parent and start there
-	if prevID, ok := p.forkDB.links[blk.ParentId]; ok {
-		prevNum, found := p.forkDB.nums[prevID]
-		if !found {
-			return false
-		}
-		return !bstream.IsEmpty(p.forkDB.BlockInCurrentChain(bstream.NewBlockRef(prevID, prevNum), blk.LibNum))
+	if prevRef, found := p.forkDB.previousRef(blk.ParentId); found {
+		return !bstream.IsEmpty(p.forkDB.BlockInCurrentChain(prevRef, blk.LibNum))
 	}
 
 	return false
 
 }
 
+var segmentBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]*Block, 0, 512)
+		return &buf
+	},
+}
+
+// completeSegment is like `ForkDB.CompleteSegment` but uses a pooled buffer, `release`
+// must be called once the segment is not used anymore.
+func (p *Forkable) completeSegment(startBlock bstream.BlockRef) (seg []*Block, reachLIB bool, release func()) {
+	buf := segmentBuffers.Get().(*[]*Block)
+	seg, reachLIB = p.forkDB.CompleteSegmentInto(startBlock, *buf)
+
+	return seg, reachLIB, func() {
+		if cap(seg) > cap(*buf) {
+			*buf = seg
+		}
+		clear((*buf)[:cap(*buf)])
+		*buf = (*buf)[:0]
+		segmentBuffers.Put(buf)
+	}
+}
+
 func blockIn(id string, array []*Block) bool {
 	for _, b := range array {
 		if id == b.BlockID {
@@ -247,7 +351,8 @@ func (p *Forkable) blocksFromCursor(cursor *bstream.Cursor) ([]*bstream.Preproce
 
 	head := p.lastBlockSent.AsRef()
 
-	seg, reachLIB := p.forkDB.CompleteSegment(head)
+	seg, reachLIB, release := p.completeSegment(head)
+	defer release()
 	if !reachLIB {
 		return nil, fmt.Errorf("head segment does not reach LIB")
 	}
@@ -256,12 +361,15 @@ func (p *Forkable) blocksFromCursor(cursor *bstream.Cursor) ([]*bstream.Preproce
 		return nil, fmt.Errorf("no complete segment")
 	}
 
-	if cursor.LIB.Num() < seg[0].BlockNum {
+	// a LIB-only cursor on the forkDB LIB, which may not be in the segment, is
+	// not forked either
+	libOnly := cursor.IsLIBOnly() && cursor.LIB.Num() == p.forkDB.LIBNum() && cursor.LIB.ID() == p.forkDB.LIBID()
+	if cursor.LIB.Num() < seg[0].BlockNum && !libOnly {
 		return nil, fmt.Errorf("complete segment does not include cursor LIB (lowest block: %d, cursor lib: %d)", seg[0].BlockNum, cursor.LIB.Num())
 	}
 
 	// cursor is not forked, we can bring it quickly to forkDB HEAD
-	if blockIn(cursor.Block.ID(), seg) && blockIn(cursor.LIB.ID(), seg) {
+	if libOnly || (blockIn(cursor.Block.ID(), seg) && blockIn(cursor.LIB.ID(), seg)) {
 		out := []*bstream.PreprocessedBlock{}
 		for i := range seg {
 			if seg[i].BlockNum <= cursor.LIB.Num() {
@@ -281,7 +389,7 @@ func (p *Forkable) blocksFromCursor(cursor *bstream.Cursor) ([]*bstream.Preproce
 			// send NEW from cursor's block up to forkdb Head
 			if seg[i].BlockNum > cursor.Block.Num() ||
 				cursor.Step.Matches(bstream.StepUndo) && seg[i].BlockNum == cursor.Block.Num() {
-				out = append(out, wrapBlockForkableObject(seg[i].Object.(*ForkableBlock), bstream.StepNew, head, p.forkDB.libRef, nil))
+				out = append(out, wrapBlockForkableObject(seg[i].Object.(*ForkableBlock), bstream.StepNew, head, p.forkDB.LIBRef(), nil))
 				continue
 			}
 
@@ -291,25 +399,25 @@ func (p *Forkable) blocksFromCursor(cursor *bstream.Cursor) ([]*bstream.Preproce
 
 	// cursor is forked, trying to bring user back to the canonical chain
 	var undos []*ForkableBlock
-	blockID := cursor.Block.ID()
-	for {
-		found := p.forkDB.BlockForID(blockID)
-		if found == nil {
-			return nil, fmt.Errorf("cannot find block with ID %s", blockID)
+	var reorgJunctionBlock *Block
+	err := p.forkDB.IterateAncestors(cursor.Block.ID(), func(blk *Block) bool {
+		if blk.BlockID != cursor.Block.ID() && blockIn(blk.BlockID, seg) {
+			reorgJunctionBlock = blk
+			return true
 		}
-		fb := found.Object.(*ForkableBlock)
 
-		alreadyUndone := blockID == cursor.Block.ID() && cursor.Step == bstream.StepUndo
+		alreadyUndone := blk.BlockID == cursor.Block.ID() && cursor.Step == bstream.StepUndo
 		if !alreadyUndone {
-			undos = append(undos, fb)
-		}
-
-		blockID = found.PreviousBlockID
-		if blockIn(blockID, seg) {
-			break
+			undos = append(undos, blk.Object.(*ForkableBlock))
 		}
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot find undo blocks of forked cursor: %w", err)
+	}
+	if reorgJunctionBlock == nil {
+		return nil, fmt.Errorf("cannot find junction block between cursor %s and the canonical chain", cursor.Block)
 	}
-	reorgJunctionBlock := p.forkDB.BlockForID(blockID)
 	preprocessedUndos := make([]*bstream.PreprocessedBlock, len(undos))
 	for i := range undos {
 		preprocessedUndos[i] = wrapBlockForkableObject(undos[i], bstream.StepUndo, head, cursor.LIB, reorgJunctionBlock.AsRef())
@@ -317,7 +425,7 @@ func (p *Forkable) blocksFromCursor(cursor *bstream.Cursor) ([]*bstream.Preproce
 
 	newCursor := &bstream.Cursor{
 		Step:      bstream.StepNew,
-		Block:     bstream.NewBlockRef(blockID, p.forkDB.BlockForID(blockID).BlockNum),
+		Block:     reorgJunctionBlock.AsRef(),
 		HeadBlock: head,
 		LIB:       cursor.LIB,
 	}
@@ -337,7 +445,8 @@ func (p *Forkable) blocksThroughCursor(startBlock uint64, cursor *bstream.Cursor
 	}
 
 	head := p.lastBlockSent.AsRef()
-	seg, reachLIB := p.forkDB.CompleteSegment(head)
+	seg, reachLIB, release := p.completeSegment(head)
+	defer release()
 	if !reachLIB {
 		return nil, fmt.Errorf("head segment does not reach LIB")
 	}
@@ -348,7 +457,7 @@ func (p *Forkable) blocksThroughCursor(startBlock uint64, cursor *bstream.Cursor
 	if seg[0].BlockNum > startBlock {
 		return nil, fmt.Errorf("startBlock not contained in segment")
 	}
-	libRef := p.forkDB.libRef
+	libRef := p.forkDB.LIBRef()
 	if blockIn(cursor.Block.ID(), seg) {
 		out := []*bstream.PreprocessedBlock{}
 		for i := range seg {
@@ -367,7 +476,8 @@ func (p *Forkable) blocksThroughCursor(startBlock uint64, cursor *bstream.Cursor
 		return out, nil
 	}
 
-	seg, reachLIB = p.forkDB.CompleteSegment(cursor.Block)
+	seg, reachLIB, releaseCursorSegment := p.completeSegment(cursor.Block)
+	defer releaseCursorSegment()
 	if !reachLIB {
 		return nil, fmt.Errorf("head segment does not reach LIB")
 	}
@@ -442,6 +552,8 @@ type ForkableObject struct {
 	block       bstream.BlockRef
 	lastLIBSent bstream.BlockRef
 
+	chainView ChainView
+
 	// Object that was returned by PreprocessBlock(). Could be nil
 	Obj interface{}
 }
@@ -461,10 +573,25 @@ func (fobj *ForkableObject) ReorgJunctionBlock() bstream.BlockRef {
 	return fobj.reorgJunctionBlock
 }
 
+// ForkPoint returns the block at which the undone branch forked off the new
+// longest chain, the redo segment restarts right after this block. It is only
+// set on StepUndo objects and returns nil for any other step.
+func (fobj *ForkableObject) ForkPoint() bstream.BlockRef {
+	return fobj.ReorgJunctionBlock()
+}
+
+// ChainView returns a read-only view of the ForkDB as it was when this object was emitted,
+// it is only available when the Forkable was created with `WithChainView`, nil otherwise.
+func (fobj *ForkableObject) ChainView() ChainView {
+	return fobj.chainView
+}
+
 func (fobj *ForkableObject) WrappedObject() interface{} {
 	return fobj.Obj
 }
 
+// Cursor returns bstream.EmptyCursor, never nil, while the object has no cursor
+// yet, like before the first LIB was sent
 func (fobj *ForkableObject) Cursor() *bstream.Cursor {
 	if fobj == nil ||
 		fobj.block == nil ||
@@ -487,6 +614,12 @@ type ForkableBlock struct {
 	sentAsNew bool
 }
 
+// PayloadSize returns the size of the encoded payload of the block, it is
+// accounted in the PayloadBytes of the ForkDB stats
+func (b *ForkableBlock) PayloadSize() int {
+	return b.Block.PayloadSize()
+}
+
 func New(h bstream.Handler, opts ...Option) *Forkable {
 	f := &Forkable{
 		filterSteps:      bstream.StepsAll,
@@ -502,11 +635,36 @@ func New(h bstream.Handler, opts ...Option) *Forkable {
 	}
 
 	// Done afterwards so forkdb can get configured forkable logger from options
-	f.forkDB.logger = f.logger
+	f.forkDB.SetLogger(f.logger)
+	if f.headWatchdog != nil {
+		f.headWatchdog.logger = f.logger
+	}
+	if f.blockIDNormalizer != nil {
+		f.forkDB.setBlockIDNormalizer(f.blockIDNormalizer)
+		f.lastLIBSeen = f.normalizeRef(f.lastLIBSeen)
+		f.ensureBlockFlows = f.normalizeRef(f.ensureBlockFlows)
+	}
 
 	return f
 }
 
+// BindOwner ties the Forkable's background routines (like the head watchdog) to the lifecycle
+// of `owner`: they stop when it terminates and errors they produce shut it down.
+func (p *Forkable) BindOwner(owner bstream.Shutterer) {
+	if p.headWatchdog != nil {
+		p.headWatchdog.bindOwner(owner)
+	}
+}
+
+// Close stops the Forkable's background routines (like the head watchdog), for
+// the Forkables used without an owner, see BindOwner. The head is not watched
+// anymore once closed. Calling it more than once is a no-op.
+func (p *Forkable) Close() {
+	if p.headWatchdog != nil {
+		p.headWatchdog.stop()
+	}
+}
+
 func (p *Forkable) targetChainBlock(blk bstream.BlockRef) bstream.BlockRef {
 	if p.ensureBlockFlows.ID() != "" && !p.ensureBlockFlowed {
 		return p.ensureBlockFlows
@@ -538,7 +696,8 @@ func (p *Forkable) computeNewLongestChain(ppBlk *ForkableBlock) []*Block {
 			PreviousBlockID: ppBlk.Block.ParentId,
 		})
 	} else {
-		longestChain, _ = p.forkDB.ReversibleSegment(p.targetChainBlock(blk.AsRef()))
+		// The previous longest chain is replaced, its backing array can be reused
+		longestChain, _ = p.forkDB.ReversibleSegmentInto(p.targetChainBlock(blk.AsRef()), p.lastLongestChain)
 	}
 	p.lastLongestChain = longestChain
 	return longestChain
@@ -549,6 +708,19 @@ func (p *Forkable) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
 	p.Lock()
 	defer p.Unlock()
 
+	if p.headWatchdog != nil {
+		if err := p.headWatchdog.Err(); err != nil {
+			return err
+		}
+		p.headWatchdog.start()
+	}
+
+	blk = p.normalizedBlock(blk)
+
+	if err := bstream.CheckBlockID(blk); err != nil {
+		return err
+	}
+
 	if blk.Id == blk.ParentId {
 		return fmt.Errorf("invalid block ID detected on block %s (previousID: %s), bad data", blk.AsRef().String(), blk.ParentId)
 	}
@@ -574,26 +746,50 @@ func (p *Forkable) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
 
 	ppBlk := &ForkableBlock{Block: blk, Obj: obj}
 
+	if p.firstStreamableBlock != nil && !p.forkDB.HasLIB() {
+		if blk.Number < *p.firstStreamableBlock {
+			return nil
+		}
+
+		zlogBlk.Debug("first streamable block received, assuming it's the initial LIB", zap.Uint64("first_streamable_block", *p.firstStreamableBlock))
+		p.forkDB.AddLink(blk.AsRef(), blk.ParentId, ppBlk)
+		p.forkDB.InitLIB(blk.AsRef())
+		p.chainSnapshot = nil
+		return p.processInitialInclusiveIrreversibleBlock(blk, obj, true)
+	}
+
 	var reorgJunctionBlock bstream.BlockRef
 	var undos, redos []*ForkableBlock
 	if p.matchFilter(bstream.StepUndo) {
 		if triggersNewLongestChain && p.lastBlockSent != nil {
-			undos, redos, reorgJunctionBlock = p.sentChainSwitchSegments(p.lastBlockSent.Id, blk.ParentId)
+			var err error
+			undos, redos, reorgJunctionBlock, err = p.sentChainSwitchSegments(p.lastBlockSent.Id, blk.ParentId)
+			if err != nil {
+				return fmt.Errorf("computing chain switch segments for block %s: %w", blk.AsRef(), err)
+			}
 		}
 	}
 
-	if exists, _ := p.forkDB.AddLink(blk.AsRef(), blk.ParentId, ppBlk); exists {
+	exists, _, err := p.forkDB.AddLinkStrict(blk.AsRef(), blk.ParentId, ppBlk)
+	if err != nil {
+		if p.failOnConflictingBlocks {
+			return fmt.Errorf("adding block %s to forkdb: %w", blk.AsRef(), err)
+		}
+		zlogBlk.Warn("ignoring block conflicting with an already known one", zap.Error(err))
+	}
+	if exists {
 		return nil
 	}
+	p.chainSnapshot = nil
 
 	var firstIrreverbleBlock *Block
 	if !p.forkDB.HasLIB() { // always skip processing until LIB is set
 		p.forkDB.SetLIB(blk.AsRef(), blk.LibNum)
 		if p.forkDB.HasLIB() { //this is an edge case. forkdb will not is returning the 1st lib in the forkDB.HasNewIrreversibleSegment call
-			if p.forkDB.libRef.Num() == blk.Number { // this block just came in and was determined as LIB, it is probably first streamable block and must be processed.
+			if p.forkDB.LIBNum() == blk.Number { // this block just came in and was determined as LIB, it is probably first streamable block and must be processed.
 				return p.processInitialInclusiveIrreversibleBlock(blk, obj, true)
 			}
-			firstIrreverbleBlock = p.forkDB.BlockForID(p.forkDB.libRef.ID())
+			firstIrreverbleBlock = p.forkDB.BlockForID(p.forkDB.LIBID())
 		} else {
 			if p.holdBlocksUntilLIB {
 				return nil
@@ -681,7 +877,7 @@ func (p *Forkable) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
 	// TODO: check preconditions here, and decide on whether we
 	// continue or not early return would be perfect if there's no
 	// `irreversibleSegment` or `stalledBlocks` to process.
-	hasNew, irreversibleSegment, stalledBlocks := p.forkDB.HasNewIrreversibleSegment(libRef)
+	hasNew, irreversibleSegment, _ := p.forkDB.HasNewIrreversibleSegment(libRef)
 	if firstIrreverbleBlock != nil {
 		irreversibleSegment = append(irreversibleSegment, firstIrreverbleBlock)
 	}
@@ -695,8 +891,19 @@ func (p *Forkable) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
 		zlogBlk.Debug("moving lib (1/600)", zap.Stringer("lib", libRef))
 	}
 
-	p.forkDB.MoveLIB(libRef)
-	_ = p.forkDB.PurgeBeforeLIB(p.keptFinalBlocks)
+	truncatedBranches := p.forkDB.MoveLIB(libRef)
+	purgedBlocks := p.forkDB.PurgeBeforeLIB(p.keptFinalBlocks)
+	if tracer.Enabled() {
+		zlogBlk.Debug("lib moved", zap.Stringer("lib", libRef), zap.Int("truncated_branch_count", len(truncatedBranches)), zap.Int("purged_block_count", len(purgedBlocks)))
+	}
+	p.chainSnapshot = nil
+
+	// Stalled blocks are the ones of the branches orphaned by the LIB move, the same
+	// ones reported to the branch orphaned callback
+	var stalledBlocks []*Block
+	for _, branch := range truncatedBranches {
+		stalledBlocks = append(stalledBlocks, branch...)
+	}
 
 	if err := p.processIrreversibleSegment(irreversibleSegment, ppBlk.Block.AsRef()); err != nil {
 		return err
@@ -706,6 +913,15 @@ func (p *Forkable) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
 		return err
 	}
 
+	// the purged blocks, stalled ones included, were all sent
+	if p.blockPool != nil {
+		for _, purged := range purgedBlocks {
+			if fb, ok := purged.Object.(*ForkableBlock); ok {
+				p.blockPool.Release(fb.Block)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -718,21 +934,36 @@ func ids(blocks []*ForkableBlock) (ids []string) {
 	return
 }
 
-func (p *Forkable) sentChainSwitchSegments(currentHeadBlockID string, newHeadsPreviousID string) (undos []*ForkableBlock, redos []*ForkableBlock, junctionBlock bstream.BlockRef) {
+// sentChainSwitchSegments returns the blocks to undo, ordered strictly from the current head
+// downwards to (but excluding) the fork point, and the blocks to redo, ordered from the fork
+// point upwards. This is the same ordering as what `blocksFromCursor` produces for a forked cursor.
+func (p *Forkable) sentChainSwitchSegments(currentHeadBlockID string, newHeadsPreviousID string) (undos []*ForkableBlock, redos []*ForkableBlock, junctionBlock bstream.BlockRef, err error) {
 	if currentHeadBlockID == newHeadsPreviousID {
 		return
 	}
 
-	undoIDs, redoIDs, junctionBlockID := p.forkDB.ChainSwitchSegments(currentHeadBlockID, newHeadsPreviousID)
+	undoIDs, redoIDs, junctionBlockID, err := p.forkDB.ChainSwitchSegmentsWithMaxDepth(currentHeadBlockID, newHeadsPreviousID, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	if undoIDs != nil {
 		if junction := p.forkDB.BlockForID(junctionBlockID); junction != nil {
 			junctionBlock = junction.AsRef()
+		} else if junctionBlockID == p.forkDB.LIBID() {
+			// The LIB is not necessarily linked in the ForkDB, it's still a valid fork point
+			junctionBlock = p.forkDB.LIBRef()
 		}
 	}
 
 	undos = p.sentChainSegment(undoIDs, false)
 	redos = p.sentChainSegment(redoIDs, true)
+
+	// Parent links walk always gives us descending undos, but we make it explicit since
+	// consumers rely on undos flowing from the old head down to the fork point.
+	sort.SliceStable(undos, func(i, j int) bool {
+		return undos[i].Block.Number > undos[j].Block.Number
+	})
 	return
 }
 
@@ -753,6 +984,9 @@ func (p *Forkable) sentChainSegment(ids []string, doingRedos bool) (ppBlocks []*
 	return
 }
 
+// processBlocks sends `blocks` as a single multi-block step, in the order received. Every
+// ForkableObject shares the same `StepBlocks` slice, with `StepIndex` giving the position of
+// the current block in it.
 func (p *Forkable) processBlocks(currentBlock *pbbstream.Block, blocks []*ForkableBlock, step bstream.StepType, reorgJunctionBlock bstream.BlockRef) error {
 	var objs []*bstream.PreprocessedBlock
 
@@ -767,7 +1001,7 @@ func (p *Forkable) processBlocks(currentBlock *pbbstream.Block, blocks []*Forkab
 
 		lib := p.lastLIBSeen
 		if bstream.IsEmpty(lib) {
-			lib = p.forkDB.libRef
+			lib = p.forkDB.LIBRef()
 		}
 		fo := &ForkableObject{
 			step:               step,
@@ -776,15 +1010,16 @@ func (p *Forkable) processBlocks(currentBlock *pbbstream.Block, blocks []*Forkab
 			headBlock:          currentBlock.AsRef(),
 			block:              block.Block.AsRef(),
 			reorgJunctionBlock: reorgJunctionBlock,
+			chainView:          p.chainViewFor(currentBlock.AsRef()),
 
 			StepIndex:  idx,
 			StepCount:  len(blocks),
 			StepBlocks: objs,
 		}
 
-		err := p.handler.ProcessBlock(block.Block, fo)
+		err := p.sendToHandler(block.Block, fo)
 
-		p.logger.Debug("sent block", zap.Stringer("block", block.Block.AsRef()), zap.Stringer("step_type", step))
+		p.logger.Debug("sent block", zap.Object("cursor", fo.Cursor()))
 		if err != nil {
 			return fmt.Errorf("process block [%s] step=%q: %w", block.Block, step, err)
 		}
@@ -792,6 +1027,22 @@ func (p *Forkable) processBlocks(currentBlock *pbbstream.Block, blocks []*Forkab
 	return nil
 }
 
+// sendToHandler sends the block to the handler, treating `bstream.ErrSkipBlock` as a success.
+// The failures are bstream.NonRetriable: the block is already recorded, it would
+// be ignored if processed again.
+func (p *Forkable) sendToHandler(blk *pbbstream.Block, fo *ForkableObject) error {
+	err := p.handler.ProcessBlock(blk, fo)
+	if err != nil && errors.Is(err, bstream.ErrSkipBlock) {
+		p.logger.Debug("handler skipped block", zap.Object("cursor", fo.Cursor()))
+		if p.onSkippedBlock != nil {
+			p.onSkippedBlock(blk.AsRef(), fo.step)
+		}
+		return nil
+	}
+
+	return bstream.NonRetriable(err)
+}
+
 func (p *Forkable) processNewBlocks(longestChain []*Block) (err error) {
 	headBlock := longestChain[len(longestChain)-1]
 	for _, b := range longestChain {
@@ -807,7 +1058,7 @@ func (p *Forkable) processNewBlocks(longestChain []*Block) (err error) {
 
 			lib := p.lastLIBSeen
 			if bstream.IsEmpty(lib) {
-				lib = p.forkDB.libRef
+				lib = p.forkDB.LIBRef()
 			}
 			fo := &ForkableObject{
 				headBlock:   headBlock.AsRef(),
@@ -815,9 +1066,10 @@ func (p *Forkable) processNewBlocks(longestChain []*Block) (err error) {
 				step:        bstream.StepNew,
 				lastLIBSent: lib,
 				Obj:         ppBlk.Obj,
+				chainView:   p.chainViewFor(headBlock.AsRef()),
 			}
 
-			err = p.handler.ProcessBlock(ppBlk.Block, fo)
+			err = p.sendToHandler(ppBlk.Block, fo)
 			if err != nil {
 				return
 			}
@@ -833,6 +1085,9 @@ func (p *Forkable) processNewBlocks(longestChain []*Block) (err error) {
 		p.blockFlowed(ppBlk.Block.AsRef())
 		ppBlk.sentAsNew = true
 		p.lastBlockSent = ppBlk.Block
+		if p.headWatchdog != nil {
+			p.headWatchdog.headSent(ppBlk.Block.AsRef())
+		}
 	}
 
 	return
@@ -887,13 +1142,14 @@ func (p *Forkable) processIrreversibleSegment(irreversibleSegment []*Block, head
 				Obj:         preprocBlock.Obj,
 				block:       blkRef,
 				headBlock:   headBlock,
+				chainView:   p.chainViewFor(headBlock),
 
 				StepIndex:  idx,
 				StepCount:  len(irreversibleSegment),
 				StepBlocks: irrGroup,
 			}
 
-			if err := p.handler.ProcessBlock(preprocBlock.Block, objWrap); err != nil {
+			if err := p.sendToHandler(preprocBlock.Block, objWrap); err != nil {
 				return err
 			}
 		}
@@ -928,13 +1184,14 @@ func (p *Forkable) processStalledSegment(stalledBlocks []*Block, headBlock bstre
 				Obj:         preprocBlock.Obj,
 				block:       staleBlock.AsRef(),
 				headBlock:   headBlock,
+				chainView:   p.chainViewFor(headBlock),
 
 				StepIndex:  idx,
 				StepCount:  len(stalledBlocks),
 				StepBlocks: stalledGroup,
 			}
 
-			if err := p.handler.ProcessBlock(preprocBlock.Block, objWrap); err != nil {
+			if err := p.sendToHandler(preprocBlock.Block, objWrap); err != nil {
 				return err
 			}
 		}
@@ -982,6 +1239,13 @@ func (p *Forkable) triggersNewLongestChain(blk *pbbstream.Block) bool {
 		return true
 	}
 
+	if p.tieBreak != nil &&
+		blk.Number == p.lastBlockSent.Number &&
+		blk.Id != p.lastBlockSent.Id &&
+		p.tieBreak(blk.AsRef(), p.lastBlockSent.AsRef()) {
+		return true
+	}
+
 	return false
 }
 
@@ -1041,6 +1305,28 @@ func ShouldReplaceStarknePendingtBlock(oldBlock *pbbstream.Block, newBlock *pbbs
 	return newBlockTxCount > oldBlockTxCount
 }
 
+// NormalizeStarknetFeltBlockID normalizes a Starknet felt block hash to its canonical
+// form: lower-cased, `0x` prefixed and left padded with zeros to 64 hexadecimal characters.
+// Inputs that are not valid felts are returned unchanged.
+func NormalizeStarknetFeltBlockID(in string) string {
+	hexPart := in
+	if len(hexPart) >= 2 && (hexPart[:2] == "0x" || hexPart[:2] == "0X") {
+		hexPart = hexPart[2:]
+	}
+	hexPart = strings.ToLower(strings.TrimLeft(hexPart, "0"))
+
+	if len(hexPart) > 64 {
+		return in
+	}
+	for _, c := range hexPart {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return in
+		}
+	}
+
+	return "0x" + strings.Repeat("0", 64-len(hexPart)) + hexPart
+}
+
 func (p *Forkable) HeadInfo() (headNum uint64, headID string, headTime time.Time, libNum uint64, err error) {
 	p.RLock()
 	defer p.RUnlock()
@@ -1054,12 +1340,29 @@ func (p *Forkable) HeadInfo() (headNum uint64, headID string, headTime time.Time
 func (p *Forkable) AllIDs() (out []string) {
 	p.RLock()
 	defer p.RUnlock()
-	for id := range p.forkDB.links {
+	p.forkDB.IterateLinks(func(id, _ string, _ interface{}) bool {
 		out = append(out, id)
-	}
+		return true
+	})
 	return
 }
 
+func (p *Forkable) ForkDBStats() ForkDBStats {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.forkDB.Stats()
+}
+
+// PayloadBytes returns the size of the encoded payloads of the blocks retained
+// in the ForkDB
+func (p *Forkable) PayloadBytes() int {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.forkDB.PayloadBytes()
+}
+
 func (p *Forkable) HeadNum() uint64 {
 	p.RLock()
 	defer p.RUnlock()
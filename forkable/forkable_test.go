@@ -14,15 +14,19 @@
 package forkable
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/streamingfast/bstream"
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -1816,6 +1820,87 @@ func TestForkable_BlocksFromCursor(t *testing.T) {
 	}
 }
 
+func TestForkable_BlocksFromLIBOnlyCursor(t *testing.T) {
+	sink := newTestForkableSink(nil, nil)
+	p := New(sink, WithExclusiveLIB(bstream.NewBlockRefFromID("00000002a")))
+	for _, blk := range []*pbbstream.Block{
+		bstream.TestBlockWithLIBNum("00000003a", "00000002a", 2),
+		bstream.TestBlockWithLIBNum("00000004a", "00000003a", 2),
+		bstream.TestBlockWithLIBNum("00000005a", "00000004a", 2),
+	} {
+		require.NoError(t, p.ProcessBlock(blk, nil))
+	}
+
+	// the LIB is not in the forkDB, it is not a forked cursor
+	var blocks []*bstream.PreprocessedBlock
+	require.NoError(t, p.CallWithBlocksFromCursor(bstream.NewLIBOnlyCursor(bstream.NewBlockRefFromID("00000002a")), func(in []*bstream.PreprocessedBlock) { blocks = in }))
+
+	var received []string
+	for _, blk := range blocks {
+		received = append(received, blk.Block.Id+" "+blk.Obj.(*ForkableObject).Step().String())
+	}
+	assert.Equal(t, []string{"00000003a new", "00000004a new", "00000005a new"}, received)
+
+	// a LIB-only cursor on another block at the LIB height is forked
+	err := p.CallWithBlocksFromCursor(bstream.NewLIBOnlyCursor(bstream.NewBlockRefFromID("00000002b")), func(in []*bstream.PreprocessedBlock) {})
+	assert.Error(t, err)
+}
+
+func TestForkable_BlockPool(t *testing.T) {
+	var sent []string
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		sent = append(sent, blk.Id+" "+obj.(*ForkableObject).Step().String())
+		return nil
+	})
+	p := New(handler, WithExclusiveLIB(bstream.NewBlockRefFromID("00000001a")), WithBlockPool(bstream.NewBlockPool()))
+
+	blocks := []*pbbstream.Block{
+		bstream.TestBlockWithLIBNum("00000002a", "00000001a", 1),
+		bstream.TestBlockWithLIBNum("00000003a", "00000002a", 1),
+		bstream.TestBlockWithLIBNum("00000004a", "00000003a", 3),
+		bstream.TestBlockWithLIBNum("00000005a", "00000004a", 4),
+	}
+	for _, blk := range blocks {
+		require.NoError(t, p.ProcessBlock(blk, nil))
+	}
+
+	assert.Equal(t, []string{
+		"00000002a new",
+		"00000003a new",
+		"00000004a new",
+		"00000002a irreversible",
+		"00000003a irreversible",
+		"00000005a new",
+		"00000004a irreversible",
+	}, sent)
+
+	// the blocks purged below the LIB were released once sent, and reset
+	var ids []string
+	for _, blk := range blocks {
+		ids = append(ids, blk.Id)
+	}
+	assert.Equal(t, []string{"", "", "00000004a", "00000005a"}, ids)
+}
+
+func TestForkable_BlocksFromEmptyCursor(t *testing.T) {
+	sink := newTestForkableSink(nil, nil)
+	p := New(sink, WithExclusiveLIB(bstream.NewBlockRefFromID("00000002a")))
+	require.NoError(t, p.ProcessBlock(bstream.TestBlockWithLIBNum("00000003a", "00000002a", 2), nil))
+
+	for _, cursor := range []*bstream.Cursor{nil, bstream.EmptyCursor} {
+		called := false
+		err := p.CallWithBlocksFromCursor(cursor, func(in []*bstream.PreprocessedBlock) { called = true })
+		assert.ErrorIs(t, err, bstream.ErrEmptyCursor)
+
+		err = p.CallWithBlocksThroughCursor(3, cursor, func(in []*bstream.PreprocessedBlock) { called = true })
+		assert.ErrorIs(t, err, bstream.ErrEmptyCursor)
+		assert.False(t, called)
+	}
+
+	var nilObj *ForkableObject
+	assert.True(t, nilObj.Cursor().IsEmpty())
+}
+
 func TestComputeNewLongestChain(t *testing.T) {
 	p := &Forkable{
 		forkDB:           NewForkDB(),
@@ -1883,7 +1968,560 @@ func TestForkableSentChainSwitchSegments(t *testing.T) {
 	p.forkDB.AddLink(bRef("00000003a"), "00000002a", nil)
 	p.forkDB.AddLink(bRef("00000002a"), "00000001a", nil)
 
-	undos, redos, _ := p.sentChainSwitchSegments("00000003a", "00000003a")
+	undos, redos, _, err := p.sentChainSwitchSegments("00000003a", "00000003a")
+	require.NoError(t, err)
 	assert.Nil(t, undos)
 	assert.Nil(t, redos)
 }
+
+func TestForkable_ProcessBlock_UndoOrdering(t *testing.T) {
+	sink := newTestForkableSink(nil, nil)
+	p := New(sink, WithExclusiveLIB(bRef("00000002a")))
+
+	processed := map[string]*pbbstream.Block{}
+	for _, blk := range []*pbbstream.Block{
+		bTestBlock("00000003a", "00000002a"),
+		bTestBlock("00000004a", "00000003a"),
+		bTestBlock("00000005a", "00000004a"),
+		bTestBlock("00000006a", "00000005a"),
+		bTestBlock("00000003b", "00000002a"),
+		bTestBlock("00000004b", "00000003b"),
+		bTestBlock("00000005b", "00000004b"),
+		bTestBlock("00000006b", "00000005b"),
+		bTestBlock("00000007b", "00000006b"),
+	} {
+		processed[blk.Id] = blk.Clone()
+		require.NoError(t, p.ProcessBlock(blk, blk.Id))
+	}
+
+	var undos []*ForkableObject
+	for _, res := range sink.results {
+		if res.Step() == bstream.StepUndo {
+			undos = append(undos, res)
+		}
+	}
+
+	expectedUndoIDs := []string{"00000006a", "00000005a", "00000004a", "00000003a"}
+	require.Len(t, undos, len(expectedUndoIDs))
+	for i, undo := range undos {
+		assert.Equal(t, expectedUndoIDs[i], undo.block.ID())
+		assert.Equal(t, i, undo.StepIndex)
+		assert.Equal(t, len(expectedUndoIDs), undo.StepCount)
+		require.Len(t, undo.StepBlocks, len(expectedUndoIDs))
+		for j, stepBlock := range undo.StepBlocks {
+			bstream.AssertBlocksEqual(t, processed[expectedUndoIDs[j]], stepBlock.Block)
+		}
+
+		require.NotNil(t, undo.ForkPoint())
+		assert.Equal(t, "00000002a", undo.ForkPoint().ID())
+		assert.Equal(t, uint64(2), undo.ForkPoint().Num())
+	}
+
+	// The redo segment restarts right after the fork point
+	var newAfterUndo []string
+	for _, res := range sink.results[len(sink.results)-5:] {
+		assert.Equal(t, bstream.StepNew, res.Step())
+		assert.Nil(t, res.ForkPoint())
+		newAfterUndo = append(newAfterUndo, res.block.ID())
+	}
+	assert.Equal(t, []string{"00000003b", "00000004b", "00000005b", "00000006b", "00000007b"}, newAfterUndo)
+}
+
+func TestNormalizeStarknetFeltBlockID(t *testing.T) {
+	canonical := "0x00a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f"
+
+	assert.Equal(t, canonical, NormalizeStarknetFeltBlockID(canonical))
+	assert.Equal(t, canonical, NormalizeStarknetFeltBlockID("0xa1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f"))
+	assert.Equal(t, canonical, NormalizeStarknetFeltBlockID("0XA1B2C3D4E5F60718293A4B5C6D7E8F90A1B2C3D4E5F60718293A4B5C6D7E8F"))
+	assert.Equal(t, canonical, NormalizeStarknetFeltBlockID("a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f"))
+	assert.Equal(t, "0x"+strings.Repeat("0", 64), NormalizeStarknetFeltBlockID("0x0"))
+	assert.Equal(t, "not-a-felt", NormalizeStarknetFeltBlockID("not-a-felt"))
+}
+
+func TestForkable_BlockIDNormalizer(t *testing.T) {
+	felt := func(num uint64) string {
+		return fmt.Sprintf("0x%064x", num*0x1000+0xabc)
+	}
+	unpadded := func(num uint64) string {
+		return fmt.Sprintf("0X%X", num*0x1000+0xabc)
+	}
+	block := func(num uint64, id, parentID string) *pbbstream.Block {
+		return bstream.TestBlockWithNumbers(id, parentID, num, num-1)
+	}
+
+	sink := newTestForkableSink(nil, nil)
+	p := New(sink,
+		WithExclusiveLIB(bstream.NewBlockRef(unpadded(2), 2)),
+		WithBlockIDNormalizer(NormalizeStarknetFeltBlockID),
+	)
+
+	// Producers disagree on the textual form of parent IDs
+	require.NoError(t, p.ProcessBlock(block(3, felt(3), unpadded(2)), nil))
+	require.NoError(t, p.ProcessBlock(block(4, unpadded(4), felt(3)), nil))
+	require.NoError(t, p.ProcessBlock(block(5, felt(5), unpadded(4)), nil))
+
+	require.Len(t, sink.results, 3)
+	for i, res := range sink.results {
+		num := uint64(3 + i)
+		assert.Equal(t, bstream.StepNew, res.Step())
+		assert.Equal(t, felt(num), res.Cursor().Block.ID())
+		assert.Equal(t, felt(2), res.Cursor().LIB.ID())
+	}
+
+	assert.NotNil(t, p.forkDB.BlockForID(unpadded(4)))
+	assert.True(t, p.forkDB.Exists(strings.ToUpper(felt(5))))
+
+	seg, reachLIB := p.forkDB.ReversibleSegment(bstream.NewBlockRef(unpadded(5), 5))
+	assert.True(t, reachLIB)
+	assert.Len(t, seg, 3)
+
+	// Cursor emitted round-trips, even when presented using another textual form
+	cursor := &bstream.Cursor{
+		Step:      bstream.StepNew,
+		Block:     bstream.NewBlockRef(unpadded(4), 4),
+		HeadBlock: bstream.NewBlockRef(unpadded(4), 4),
+		LIB:       bstream.NewBlockRef(unpadded(3), 3),
+	}
+
+	var blocks []*bstream.PreprocessedBlock
+	require.NoError(t, p.CallWithBlocksFromCursor(cursor, func(in []*bstream.PreprocessedBlock) { blocks = in }))
+	require.Len(t, blocks, 1)
+	assert.Equal(t, felt(5), blocks[0].Block.Id)
+
+	// the blocks given are shared with the other handlers, they are not modified
+	given := block(6, unpadded(6), unpadded(5))
+	given.LibNum = 3
+	given.SetMeta("peer", "10.0.0.1")
+	assert.True(t, p.Linkable(given), "linkable through another textual form of its parent")
+	require.NoError(t, p.ProcessBlock(given, nil))
+	assert.Equal(t, unpadded(6), given.Id)
+	assert.Equal(t, unpadded(5), given.ParentId)
+	assert.True(t, p.Linkable(given), "linkable once linked under its normalized ID")
+
+	sent := p.lastBlockSent
+	assert.Equal(t, felt(6), sent.Id)
+	assert.Equal(t, felt(5), sent.ParentId)
+	assert.Equal(t, given.PayloadBytes(), sent.PayloadBytes())
+	peer, _ := sent.GetMeta("peer")
+	assert.Equal(t, "10.0.0.1", peer)
+}
+
+func TestForkable_ConcurrentReads(t *testing.T) {
+	id := func(num uint64) string { return fmt.Sprintf("%08xa", num) }
+	block := func(num uint64) *pbbstream.Block {
+		var libNum uint64
+		if num > 10 {
+			libNum = num - 10
+		}
+		return tb(id(num), id(num-1), libNum)
+	}
+
+	p := New(newTestForkableSink(nil, nil), WithExclusiveLIB(bRef(id(1))))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for num := uint64(2); num < 200; num += 17 {
+				p.Linkable(block(num))
+				p.GetBlockByHash(id(num))
+				p.AllBlocksAt(num)
+			}
+			p.AllIDs()
+		}
+	}()
+
+	for num := uint64(2); num < 200; num++ {
+		require.NoError(t, p.ProcessBlock(block(num), nil))
+	}
+	close(done)
+	wg.Wait()
+
+	assert.True(t, p.Linkable(block(200)))
+	assert.NotNil(t, p.GetBlockByHash(id(199)))
+}
+
+func TestForkable_FirstStreamableBlock(t *testing.T) {
+	sink := newTestForkableSink(nil, nil)
+	p := New(sink,
+		WithFirstStreamableBlock(100),
+		WithFailOnUnlinkableBlocks(1, 0),
+	)
+
+	// LibNum of first streamable block points below the pruning horizon
+	for _, blk := range []*pbbstream.Block{
+		tb("00000062a", "00000061a", 0x20),
+		tb("00000063a", "00000062a", 0x20),
+		tb("00000064a", "00000063a", 0x32),
+		tb("00000065a", "00000064a", 0x32),
+		tb("00000066a", "00000065a", 0x64),
+		tb("00000067a", "00000066a", 0x65),
+	} {
+		require.NoError(t, p.ProcessBlock(blk, blk.Id))
+	}
+
+	var steps []string
+	for _, res := range sink.results {
+		steps = append(steps, fmt.Sprintf("%s:%s", res.Step(), res.block.ID()))
+	}
+
+	assert.Equal(t, []string{
+		"new:00000064a",
+		"irreversible:00000064a",
+		"new:00000065a",
+		"new:00000066a",
+		"new:00000067a",
+		"irreversible:00000065a",
+	}, steps)
+	assert.Equal(t, "00000065a", p.forkDB.LIBID())
+}
+
+func TestForkable_DeterministicTieBreak(t *testing.T) {
+	process := func(opts ...Option) (steps []string) {
+		sink := newTestForkableSink(nil, nil)
+		p := New(sink, append([]Option{WithExclusiveLIB(bRef("00000001a"))}, opts...)...)
+
+		for _, blk := range []*pbbstream.Block{
+			bTestBlock("00000002a", "00000001a"),
+			bTestBlock("00000003b", "00000002a"),
+			bTestBlock("00000003a", "00000002a"),
+		} {
+			require.NoError(t, p.ProcessBlock(blk, blk.Id))
+		}
+
+		for _, res := range sink.results {
+			steps = append(steps, fmt.Sprintf("%s:%s", res.Step(), res.block.ID()))
+		}
+		return
+	}
+
+	assert.Equal(t, []string{
+		"new:00000002a",
+		"new:00000003b",
+	}, process(), "first seen wins without tie break")
+
+	assert.Equal(t, []string{
+		"new:00000002a",
+		"new:00000003b",
+		"undo:00000003b",
+		"new:00000003a",
+	}, process(WithDeterministicTieBreak(nil)), "smaller ID wins by default")
+
+	assert.Equal(t, []string{
+		"new:00000002a",
+		"new:00000003b",
+	}, process(WithDeterministicTieBreak(func(a, b bstream.BlockRef) bool {
+		return a.ID() > b.ID()
+	})), "custom comparator keeps larger ID")
+}
+
+func TestForkable_SkipBlock(t *testing.T) {
+	var received []string
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		step := obj.(*ForkableObject).Step()
+		if blk.Id == "00000003a" {
+			return fmt.Errorf("dependency missing: %w", bstream.ErrSkipBlock)
+		}
+		received = append(received, fmt.Sprintf("%s:%s", step, blk.Id))
+		return nil
+	})
+
+	var skipped []string
+	p := New(handler,
+		WithExclusiveLIB(bRef("00000001a")),
+		WithSkippedBlockCallback(func(block bstream.BlockRef, step bstream.StepType) {
+			skipped = append(skipped, fmt.Sprintf("%s:%s", step, block.ID()))
+		}),
+	)
+
+	for _, blk := range []*pbbstream.Block{
+		tb("00000002a", "00000001a", 1),
+		tb("00000003a", "00000002a", 1),
+		tb("00000004a", "00000003a", 3),
+	} {
+		require.NoError(t, p.ProcessBlock(blk, nil))
+	}
+
+	assert.Equal(t, []string{
+		"new:00000002a",
+		"new:00000004a",
+		"irreversible:00000002a",
+	}, received)
+	assert.Equal(t, []string{
+		"new:00000003a",
+		"irreversible:00000003a",
+	}, skipped)
+	assert.Equal(t, "00000004a", p.lastBlockSent.Id)
+	assert.Equal(t, "00000003a", p.lastLIBSeen.ID())
+
+	failing := New(bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return fmt.Errorf("boom")
+	}), WithExclusiveLIB(bRef("00000001a")))
+	assert.Error(t, failing.ProcessBlock(tb("00000002a", "00000001a", 1), nil))
+}
+
+func TestForkable_BranchOrphaned(t *testing.T) {
+	var stalled []string
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		if obj.(*ForkableObject).Step() == bstream.StepStalled {
+			stalled = append(stalled, blk.Id)
+		}
+		return nil
+	})
+
+	type orphan struct {
+		forkPoint string
+		blocks    []string
+	}
+	var orphans []orphan
+	p := New(handler,
+		WithExclusiveLIB(bRef("00000001a")),
+		WithBranchOrphanedCallback(func(forkPoint bstream.BlockRef, branch []*Block) {
+			orphans = append(orphans, orphan{forkPoint.String(), blockIDs(branch)})
+		}),
+	)
+
+	//          /- 3b <- 4b <- 5b
+	// 1a <- 2a <- 3a <- 4a <- 5a <- 6a (LIB 3a)
+	for _, blk := range []*pbbstream.Block{
+		tb("00000002a", "00000001a", 1),
+		tb("00000003a", "00000002a", 1),
+		tb("00000004a", "00000003a", 1),
+		tb("00000005a", "00000004a", 1),
+		tb("00000003b", "00000002a", 1),
+		tb("00000004b", "00000003b", 1),
+		tb("00000005b", "00000004b", 1),
+	} {
+		require.NoError(t, p.ProcessBlock(blk, nil))
+	}
+	assert.Len(t, orphans, 0)
+
+	require.NoError(t, p.ProcessBlock(tb("00000006a", "00000005a", 3), nil))
+
+	assert.Equal(t, []orphan{
+		{bRef("00000002a").String(), []string{"00000003b", "00000004b", "00000005b"}},
+	}, orphans)
+	assert.Equal(t, []string{"00000003b", "00000004b", "00000005b"}, stalled)
+}
+
+func TestForkable_ConflictingBlocks(t *testing.T) {
+	lenient := New(nullHandler, WithExclusiveLIB(bRef("00000001a")))
+	require.NoError(t, lenient.ProcessBlock(tb("00000002a", "00000001a", 1), nil))
+	require.NoError(t, lenient.ProcessBlock(tb("00000002a", "00000001b", 1), nil))
+
+	strict := New(nullHandler, WithExclusiveLIB(bRef("00000001a")), WithFailOnConflictingBlocks())
+	require.NoError(t, strict.ProcessBlock(tb("00000002a", "00000001a", 1), nil))
+	require.NoError(t, strict.ProcessBlock(tb("00000002a", "00000001a", 1), nil))
+
+	err := strict.ProcessBlock(tb("00000002a", "00000001b", 1), nil)
+	assert.ErrorIs(t, err, ErrConflictingLink)
+}
+
+func TestForkable_ChainSwitchAborted(t *testing.T) {
+	p := New(nullHandler, WithExclusiveLIB(bRef("00000001a")))
+	require.NoError(t, p.ProcessBlock(tb("00000002a", "00000001a", 1), nil))
+	require.NoError(t, p.ProcessBlock(tb("00000003a", "00000002a", 1), nil))
+
+	// Corrupted links looping on themselves
+	p.forkDB.AddLink(bRef("00000004x"), "00000005x", nil)
+	p.forkDB.AddLink(bRef("00000005x"), "00000004x", nil)
+
+	err := p.ProcessBlock(tb("00000006x", "00000005x", 1), nil)
+	var aborted ErrChainSwitchAborted
+	require.ErrorAs(t, err, &aborted)
+	assert.True(t, aborted.Cycle)
+}
+
+func TestForkable_InvalidBlockID(t *testing.T) {
+	previous := bstream.ValidateBlockID
+	bstream.ValidateBlockID = bstream.StarknetBlockIDValidator
+	defer func() { bstream.ValidateBlockID = previous }()
+
+	var sent []string
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		sent = append(sent, blk.Id)
+		return nil
+	})
+	p := New(handler, WithExclusiveLIB(bstream.NewBlockRef("0x1", 1)))
+
+	require.NoError(t, p.ProcessBlock(bstream.TestBlockWithNumbers("0x2", "0x1", 2, 1), nil))
+	err := p.ProcessBlock(bstream.TestBlockWithNumbers("0x3zz", "0x2", 3, 2), nil)
+	require.ErrorIs(t, err, bstream.ErrInvalidBlockID)
+	assert.Contains(t, err.Error(), `invalid block ID "0x3zz" of block #3`)
+	assert.Equal(t, []string{"0x2"}, sent)
+	assert.False(t, p.forkDB.Exists("0x3zz"), "not added to the ForkDB")
+
+	t.Run("skipped", func(t *testing.T) {
+		bstream.SkipBlockIDValidation(t)
+		require.NoError(t, p.ProcessBlock(bstream.TestBlockWithNumbers("00000003a", "0x2", 3, 2), nil))
+	})
+}
+
+func TestForkable_BlockMetadata(t *testing.T) {
+	blk := func(id, prev string, num, libNum uint64) *pbbstream.Block {
+		return bstream.TestBlockFromJSON(fmt.Sprintf(`{"id":%q,"prev":%q,"num":%d,"prevnum":%d,"libnum":%d}`, id, prev, num, num-1, libNum))
+	}
+
+	// forked one-block files, written with the peer they were received from
+	store := dstore.NewMockStore(nil)
+	for _, b := range []*pbbstream.Block{
+		blk("00000002a", "00000001a", 2, 1),
+		blk("00000002b", "00000001a", 2, 1),
+		blk("00000003b", "00000002b", 3, 1),
+		blk("00000004b", "00000003b", 4, 3),
+	} {
+		b.SetMeta("peer", "peer-"+b.Id[8:])
+		buf := &bytes.Buffer{}
+		writer, err := bstream.NewDBinBlockWriter(buf, bstream.DBinBlockWriterWithMetadata())
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(b))
+		store.SetFile(bstream.BlockFileName(b), buf.Bytes())
+	}
+
+	var sent []string
+	handler := bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		peer, _ := blk.GetMeta("peer")
+		verified, _ := blk.GetMeta("verified")
+		sent = append(sent, fmt.Sprintf("%s %s peer=%s verified=%s", blk.Id, obj.(*ForkableObject).Step(), peer, verified))
+		return nil
+	})
+	p := New(handler, WithFilters(bstream.StepsAll), WithExclusiveLIB(bstream.NewBlockRef("00000001a", 1)))
+	preprocessor := bstream.NewPreprocessor(func(blk *pbbstream.Block) (interface{}, error) {
+		blk.SetMeta("verified", "true")
+		return nil, nil
+	}, p)
+
+	source, err := bstream.NewOneBlocksSource(2, store, preprocessor)
+	require.NoError(t, err)
+	source.Run()
+	require.NoError(t, source.Err())
+
+	assert.Equal(t, []string{
+		"00000002a new peer=peer-a verified=true",
+		"00000002a undo peer=peer-a verified=true",
+		"00000002b new peer=peer-b verified=true",
+		"00000003b new peer=peer-b verified=true",
+		"00000004b new peer=peer-b verified=true",
+		"00000002b irreversible peer=peer-b verified=true",
+		"00000003b irreversible peer=peer-b verified=true",
+		"00000002a stalled peer=peer-a verified=true",
+	}, sent)
+}
+
+func TestForkable_PayloadBytes(t *testing.T) {
+	p := New(newTestForkableSink(nil, nil), WithExclusiveLIB(bRef("00000002a")))
+
+	blk := func(id, prev string, libNum uint64) *pbbstream.Block {
+		out := bstream.TestBlockWithLIBNum(id, prev, libNum)
+		out.Payload.Value = make([]byte, 100)
+		return out
+	}
+
+	require.NoError(t, p.ProcessBlock(blk("00000003a", "00000002a", 2), nil))
+	require.NoError(t, p.ProcessBlock(blk("00000004a", "00000003a", 2), nil))
+	assert.Equal(t, 200, p.PayloadBytes())
+
+	// the blocks below the new LIB are purged
+	require.NoError(t, p.ProcessBlock(blk("00000005a", "00000004a", 4), nil))
+	assert.Equal(t, 200, p.PayloadBytes())
+	assert.Equal(t, p.ForkDBStats().LinkCount*100, p.PayloadBytes())
+}
+
+func TestForkable_RetryHandler(t *testing.T) {
+	errTimeout := errors.New("database timeout")
+	retriable := func(err error) bool { return errors.Is(err, errTimeout) }
+	blocks := []*pbbstream.Block{
+		bTestBlock("00000003a", "00000002a"),
+		bTestBlock("00000004a", "00000003a"),
+		bTestBlock("00000005a", "00000004a"),
+	}
+
+	// fails once on block 4
+	newFlakyHandler := func(received *[]string) bstream.Handler {
+		failed := false
+		return bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+			if blk.Number == 4 && !failed {
+				failed = true
+				return errTimeout
+			}
+			*received = append(*received, fmt.Sprintf("%s:%s", obj.(*ForkableObject).Step(), blk.Id))
+			return nil
+		})
+	}
+
+	t.Run("downstream", func(t *testing.T) {
+		var received []string
+		p := New(bstream.NewRetryHandler(newFlakyHandler(&received), 3, 0, retriable), WithExclusiveLIB(bRef("00000002a")))
+		for _, blk := range blocks {
+			require.NoError(t, p.ProcessBlock(blk, nil))
+		}
+		assert.Equal(t, []string{"new:00000003a", "new:00000004a", "new:00000005a"}, received)
+	})
+
+	t.Run("upstream", func(t *testing.T) {
+		var received []string
+		h := bstream.NewRetryHandler(New(newFlakyHandler(&received), WithExclusiveLIB(bRef("00000002a"))), 3, 0, retriable)
+		require.NoError(t, h.ProcessBlock(blocks[0], nil))
+
+		// the forkable recorded the block, giving it again would not send it
+		err := h.ProcessBlock(blocks[1], nil)
+		require.ErrorIs(t, err, errTimeout)
+		assert.NotContains(t, err.Error(), "attempts")
+		assert.Equal(t, []string{"new:00000003a"}, received)
+	})
+}
+
+func TestForkable_StepFilterHandler(t *testing.T) {
+	objects := []*ForkableObject{
+		{step: bstream.StepNew, block: bRef("00000003a"), headBlock: bRef("00000003a"), lastLIBSent: bRef("00000002a"), Obj: "3a"},
+		{step: bstream.StepUndo, block: bRef("00000003a"), headBlock: bRef("00000003b"), lastLIBSent: bRef("00000002a"), reorgJunctionBlock: bRef("00000002a"), Obj: "3a"},
+		{step: bstream.StepNewIrreversible, block: bRef("00000003b"), headBlock: bRef("00000003b"), lastLIBSent: bRef("00000003b"), Obj: "3b"},
+		{step: bstream.StepIrreversible, block: bRef("00000004b"), headBlock: bRef("00000005b"), lastLIBSent: bRef("00000004b"), Obj: "4b"},
+		{step: bstream.StepStalled, block: bRef("00000004c"), headBlock: bRef("00000005b"), lastLIBSent: bRef("00000004b"), Obj: "4c"},
+	}
+
+	tests := []struct {
+		name     string
+		steps    bstream.StepType
+		opts     []bstream.StepFilterOption
+		expected []string
+	}{
+		{name: "all", steps: bstream.StepsAll, expected: []string{"new:3a", "undo:3a", "new,irreversible:3b", "irreversible:4b", "stalled:4c"}},
+		{name: "new", steps: bstream.StepNew, expected: []string{"new:3a", "new,irreversible:3b"}},
+		{name: "irreversible", steps: bstream.StepIrreversible, expected: []string{"new,irreversible:3b", "irreversible:4b"}},
+		{name: "undo and stalled", steps: bstream.StepUndo | bstream.StepStalled, expected: []string{"undo:3a", "stalled:4c"}},
+		{name: "split", steps: bstream.StepsAll, opts: []bstream.StepFilterOption{bstream.StepFilterWithSplitNewIrreversible()}, expected: []string{"new:3a", "undo:3a", "new:3b", "irreversible:3b", "irreversible:4b", "stalled:4c"}},
+		{name: "split new", steps: bstream.StepNew, opts: []bstream.StepFilterOption{bstream.StepFilterWithSplitNewIrreversible()}, expected: []string{"new:3a", "new:3b"}},
+		{name: "split irreversible", steps: bstream.StepIrreversible, opts: []bstream.StepFilterOption{bstream.StepFilterWithSplitNewIrreversible()}, expected: []string{"irreversible:3b", "irreversible:4b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received []string
+			var cursors []*bstream.Cursor
+			h := bstream.NewStepFilterHandler(test.steps, bstream.HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				fobj := obj.(bstream.ForkableObject)
+				received = append(received, fmt.Sprintf("%s:%s", fobj.Step(), fobj.WrappedObject()))
+				cursors = append(cursors, fobj.Cursor())
+				return nil
+			}), test.opts...)
+
+			for _, obj := range objects {
+				require.NoError(t, h.ProcessBlock(bTestBlock(obj.block.ID(), ""), obj))
+			}
+			assert.Equal(t, test.expected, received)
+
+			// the cursor of a split delivery is the one of the block with its own step
+			for i, cursor := range cursors {
+				assert.Equal(t, strings.Split(received[i], ":")[0], cursor.Step.String())
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ package forkable
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
@@ -36,11 +37,41 @@ func ForkDBWithLogger(logger *zap.Logger) ForkDBOption {
 	}
 }
 
+// ForkDBWithBlockIDNormalizer normalizes every block ID received by the ForkDB
+// before it's used as a key, so that different textual forms of the same block
+// ID resolve to the same link.
+func ForkDBWithBlockIDNormalizer(normalizer func(string) string) ForkDBOption {
+	return func(db *ForkDB) {
+		db.normalizeBlockID = normalizer
+	}
+}
+
+// ForkDBWithBranchOrphanedCallback registers `callback` to be called by PurgeBeforeLIB
+// once for each side branch that became unreachable because of a LIB move, see
+// BranchOrphanedFunc.
+func ForkDBWithBranchOrphanedCallback(callback BranchOrphanedFunc) ForkDBOption {
+	return func(db *ForkDB) {
+		db.onBranchOrphaned = callback
+	}
+}
+
+// BranchOrphanedFunc receives a maximal side branch that can never become the longest
+// chain anymore, `forkPoint` is the finalized block the branch forked from and `branch`
+// blocks are ordered from the fork point outward.
+type BranchOrphanedFunc func(forkPoint bstream.BlockRef, branch []*Block)
+
 // ForkDB holds the graph of block headBlockID to previous block.
+//
+// The ForkDB has its own lock so read methods (BlockForID, ReversibleSegment,
+// CompleteSegment, HasLIB, LIBNum, etc.) are safe to call concurrently with the
+// owner of the ForkDB mutating it. Keeping invariants that span multiple calls
+// (like a Forkable does) remains the responsibility of the caller.
 type ForkDB struct {
 	// links contain block_id -> previous_block_id
-	links     map[string]string
-	linksLock sync.Mutex
+	links map[string]string
+
+	// linksLock guards links, nums, objects, libRef and the chain index
+	linksLock sync.RWMutex
 
 	// nums contain block_id -> block_num. For blocks that were not EXPLICITLY added through AddLink
 	// (as the first BlockRef) or added through InitLIB(), the number will not be set.
@@ -52,18 +83,34 @@ type ForkDB struct {
 	// (lists of transaction IDs, Block, etc..)
 	objects map[string]interface{}
 
+	// payloadSizes are the payload sizes of the objects implementing PayloadSizer, taken
+	// when they were added so that removing them gives back exactly what was accounted
+	payloadSizes map[string]int
+	payloadBytes int
+
 	libRef bstream.BlockRef
 
+	// normalizeBlockID, when set, is applied to all block IDs entering the ForkDB
+	normalizeBlockID func(string) string
+
+	// orphanedBranches are the branches truncated by MoveLIB not yet reported to onBranchOrphaned
+	onBranchOrphaned BranchOrphanedFunc
+	orphanedBranches []orphanedBranch
+
+	// chainIdx speeds up BlockInCurrentChain, it must be reset whenever links are deleted or replaced
+	chainIdx *chainIndex
+
 	logger *zap.Logger
 }
 
 func NewForkDB(opts ...ForkDBOption) *ForkDB {
 	db := &ForkDB{
-		links:   make(map[string]string),
-		nums:    make(map[string]uint64),
-		objects: make(map[string]interface{}),
-		libRef:  bstream.BlockRefEmpty,
-		logger:  zlog,
+		links:        make(map[string]string),
+		nums:         make(map[string]uint64),
+		objects:      make(map[string]interface{}),
+		payloadSizes: make(map[string]int),
+		libRef:       bstream.BlockRefEmpty,
+		logger:       zlog,
 	}
 
 	for _, opt := range opts {
@@ -74,11 +121,24 @@ func NewForkDB(opts ...ForkDBOption) *ForkDB {
 }
 
 func (f *ForkDB) InitLIB(ref bstream.BlockRef) {
+	f.linksLock.Lock()
+	defer f.linksLock.Unlock()
+
+	ref = f.normalizeRef(ref)
 	f.libRef = ref
 	f.nums[ref.ID()] = ref.Num()
+	f.chainIdx = nil
 }
 
 func (f *ForkDB) HasLIB() bool {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	return f.hasLIB()
+}
+
+// hasLIB must be called while holding the linksLock
+func (f *ForkDB) hasLIB() bool {
 	if f.libRef == nil {
 		return false
 	}
@@ -87,14 +147,57 @@ func (f *ForkDB) HasLIB() bool {
 }
 
 func (f *ForkDB) SetLogger(logger *zap.Logger) {
+	f.linksLock.Lock()
+	defer f.linksLock.Unlock()
+
 	f.logger = logger
 }
 
+// setBlockIDNormalizer normalizes the block IDs entering the ForkDB with
+// `normalize`, the LIB already known included
+func (f *ForkDB) setBlockIDNormalizer(normalize func(string) string) {
+	f.linksLock.Lock()
+	defer f.linksLock.Unlock()
+
+	f.normalizeBlockID = normalize
+	if f.hasLIB() {
+		// the LIB could have been initialized by an option before the normalizer was known
+		delete(f.nums, f.libRef.ID())
+		f.libRef = f.normalizeRef(f.libRef)
+		f.nums[f.libRef.ID()] = f.libRef.Num()
+		f.chainIdx = nil
+	}
+}
+
+func (f *ForkDB) normalizeID(id string) string {
+	if f.normalizeBlockID == nil || id == "" {
+		return id
+	}
+
+	return f.normalizeBlockID(id)
+}
+
+func (f *ForkDB) normalizeRef(ref bstream.BlockRef) bstream.BlockRef {
+	if f.normalizeBlockID == nil || bstream.IsEmpty(ref) {
+		return ref
+	}
+
+	normalizedID := f.normalizeBlockID(ref.ID())
+	if normalizedID == ref.ID() {
+		return ref
+	}
+
+	return bstream.NewBlockRef(normalizedID, ref.Num())
+}
+
 // Set a new lib without cleaning up blocks older then new lib (NO PURGE)
 func (f *ForkDB) SetLIB(headRef bstream.BlockRef, libNum uint64) {
 	if headRef.Num() == bstream.GetProtocolFirstStreamableBlock {
+		f.linksLock.Lock()
 		f.libRef = headRef
-		f.logger.Debug("SetLIB received first streamable block of chain, assuming it's the new LIB", zap.Stringer("lib", f.libRef))
+		f.linksLock.Unlock()
+
+		f.logger.Debug("SetLIB received first streamable block of chain, assuming it's the new LIB", zap.Stringer("lib", headRef))
 		return
 	}
 	libRef := f.BlockInCurrentChain(headRef, libNum)
@@ -112,13 +215,28 @@ func (f *ForkDB) SetLIB(headRef bstream.BlockRef, libNum uint64) {
 	f.MoveLIB(libRef)
 }
 
+// LIBRef returns the reference of the last irreversible block, BlockRefEmpty
+// while it is not known
+func (f *ForkDB) LIBRef() bstream.BlockRef {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	return f.libRef
+}
+
 // Get the last irreversible block ID
 func (f *ForkDB) LIBID() string {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
 	return f.libRef.ID()
 }
 
 // Get the last irreversible block num
 func (f *ForkDB) LIBNum() uint64 {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
 	return f.libRef.Num()
 }
 
@@ -133,79 +251,238 @@ func (f *ForkDB) IsBehindLIB(blockNum uint64) bool {
 //
 // This assumes you are querying for something that *is* the longest
 // chain (or the to-become longest chain).
+//
+// Nothing is returned if the walk is aborted, see ChainSwitchSegmentsWithMaxDepth
+// to get the reason.
 func (f *ForkDB) ChainSwitchSegments(oldHeadBlockID, newHeadsPreviousID string) (truncatedUndo []string, reversedRedo []string, reorgJunctionBlock string) {
-	cur := oldHeadBlockID
-	var undoChain []string
-	seen := make(map[string]struct{})
+	truncatedUndo, reversedRedo, reorgJunctionBlock, _ = f.ChainSwitchSegmentsWithMaxDepth(oldHeadBlockID, newHeadsPreviousID, 0)
+	return
+}
 
-	f.linksLock.Lock()
-	for {
-		undoChain = append(undoChain, cur)
-		seen[cur] = struct{}{}
+// ErrChainSwitchAborted is returned by ChainSwitchSegmentsWithMaxDepth when walking
+// the links of one of the heads exceeded the maximum depth or looped back on itself.
+type ErrChainSwitchAborted struct {
+	OldHeadBlockID     string
+	NewHeadsPreviousID string
+	Depth              int
+	Cycle              bool
+}
 
-		prev := f.links[cur]
-		if prev == "" {
-			break
+func (e ErrChainSwitchAborted) Error() string {
+	reason := "max depth reached"
+	if e.Cycle {
+		reason = "cycle detected"
+	}
+
+	return fmt.Sprintf("chain switch from old head %q to new head's previous %q aborted after %d blocks: %s", e.OldHeadBlockID, e.NewHeadsPreviousID, e.Depth, reason)
+}
+
+// ChainSwitchSegmentsWithMaxDepth is like ChainSwitchSegments but returns an ErrChainSwitchAborted
+// error when a cycle is found in the links or when more than `maxDepth` blocks are walked from one
+// of the heads. A `maxDepth` of 0 or less defaults to the number of links, which bounds the height
+// span of the ForkDB.
+func (f *ForkDB) ChainSwitchSegmentsWithMaxDepth(oldHeadBlockID, newHeadsPreviousID string, maxDepth int) (truncatedUndo []string, reversedRedo []string, reorgJunctionBlock string, err error) {
+	oldHeadBlockID = f.normalizeID(oldHeadBlockID)
+	newHeadsPreviousID = f.normalizeID(newHeadsPreviousID)
+
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	junction, undoPath, redoPath, err := f.commonAncestor(f.refForID(oldHeadBlockID), f.refForID(newHeadsPreviousID), maxDepth)
+	if err != nil {
+		var aborted *walkAbortedError
+		if errors.As(err, &aborted) {
+			return nil, nil, "", ErrChainSwitchAborted{
+				OldHeadBlockID:     oldHeadBlockID,
+				NewHeadsPreviousID: newHeadsPreviousID,
+				Depth:              aborted.depth,
+				Cycle:              aborted.cycle,
+			}
 		}
-		cur = prev
+
+		// couldn't reach a common point, probably unlinked
+		return nil, nil, "", nil
 	}
-	f.linksLock.Unlock()
 
-	cur = newHeadsPreviousID
-	var redoChain []string
-	for {
-		if _, found := seen[cur]; found {
-			reorgJunctionBlock = cur
-			break
+	truncatedUndo = undoPath
+
+	l := len(redoPath)
+	for i := 0; i < l; i++ {
+		reversedRedo = append(reversedRedo, redoPath[l-i-1])
+	}
+
+	return truncatedUndo, reversedRedo, junction.ID(), nil
+}
+
+// ErrNotFound is returned by CommonAncestor when the blocks have no common ancestor
+// in the ForkDB
+var ErrNotFound = errors.New("not found")
+
+// CommonAncestor returns the most recent block that both `a` and `b` descend from (or are),
+// when one of them is an ancestor of the other, it is the one returned. ErrNotFound is returned
+// when the two blocks are on disjoint parts of the ForkDB.
+func (f *ForkDB) CommonAncestor(a, b bstream.BlockRef) (bstream.BlockRef, error) {
+	a = f.normalizeRef(a)
+	b = f.normalizeRef(b)
+
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	ancestor, _, _, err := f.commonAncestor(a, b, 0)
+	if err != nil {
+		return nil, fmt.Errorf("common ancestor of %s and %s: %w", a, b, err)
+	}
+	return ancestor, nil
+}
+
+type walkAbortedError struct {
+	depth int
+	cycle bool
+}
+
+func (e *walkAbortedError) Error() string {
+	if e.cycle {
+		return fmt.Sprintf("cycle detected after %d blocks", e.depth)
+	}
+	return fmt.Sprintf("max depth reached after %d blocks", e.depth)
+}
+
+// commonAncestor walks back from `a` and `b`, always moving the highest of the two, until
+// they meet. It returns the IDs walked from each side, excluding the ancestor, ordered
+// from `a` (or `b`) down. Blocks without a known number (the root of the ForkDB) are
+// assumed to be right below their child. Must be called while holding the linksLock.
+func (f *ForkDB) commonAncestor(a, b bstream.BlockRef, maxDepth int) (ancestor bstream.BlockRef, pathA, pathB []string, err error) {
+	if maxDepth <= 0 {
+		// The root of the ForkDB is walked too, it is not part of the links
+		maxDepth = len(f.links) + 1
+	}
+
+	type walker struct {
+		id   string
+		num  uint64
+		path []string
+		seen map[string]bool
+	}
+
+	step := func(w *walker) error {
+		if w.seen[w.id] {
+			return &walkAbortedError{depth: len(w.path), cycle: true}
+		}
+		if len(w.path) >= maxDepth {
+			return &walkAbortedError{depth: len(w.path)}
 		}
-		redoChain = append(redoChain, cur)
 
-		prev := f.links[cur]
-		if prev == "" {
-			// couldn't reach a common point, probably unlinked
-			return nil, nil, ""
+		w.seen[w.id] = true
+		w.path = append(w.path, w.id)
+
+		previousID := f.links[w.id]
+		if previousID == "" {
+			return ErrNotFound
 		}
-		cur = prev
-	}
 
-	for _, blk := range undoChain {
-		if blk == reorgJunctionBlock {
-			break
+		previousNum, found := f.nums[previousID]
+		if !found {
+			previousNum = 0
+			if w.num > 0 {
+				previousNum = w.num - 1
+			}
 		}
-		truncatedUndo = append(truncatedUndo, blk)
+
+		w.id = previousID
+		w.num = previousNum
+		return nil
 	}
 
-	// WARN: what happens if `reorgJunctionBlock` isn't found?
-	// This should not happen if we DO have links up until LIB.
+	wa := &walker{id: a.ID(), num: a.Num(), seen: make(map[string]bool)}
+	wb := &walker{id: b.ID(), num: b.Num(), seen: make(map[string]bool)}
+	if wa.id == "" || wb.id == "" {
+		return nil, nil, nil, ErrNotFound
+	}
 
-	l := len(redoChain)
-	for i := 0; i < l; i++ {
-		reversedRedo = append(reversedRedo, redoChain[l-i-1])
+	for wa.id != wb.id {
+		stepA := wa.num >= wb.num
+		stepB := wb.num >= wa.num
+
+		if stepA {
+			if err := step(wa); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if stepB {
+			if err := step(wb); err != nil {
+				return nil, nil, nil, err
+			}
+		}
 	}
 
-	return truncatedUndo, reversedRedo, reorgJunctionBlock
+	return bstream.NewBlockRef(wa.id, wa.num), wa.path, wb.path, nil
+}
+
+// refForID must be called while holding the linksLock
+func (f *ForkDB) refForID(blockID string) bstream.BlockRef {
+	return bstream.NewBlockRef(blockID, f.nums[blockID])
 }
 
 func (f *ForkDB) Exists(blockID string) bool {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
 
-	return f.links[blockID] != ""
+	return f.links[f.normalizeID(blockID)] != ""
+}
+
+// previousRef returns the reference of the block previous to `blockID`, found
+// when `blockID` is linked and the number of its previous block is known
+func (f *ForkDB) previousRef(blockID string) (ref bstream.BlockRef, found bool) {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	previousID, linked := f.links[f.normalizeID(blockID)]
+	if !linked {
+		return nil, false
+	}
+	previousNum, found := f.nums[previousID]
+	if !found {
+		return nil, false
+	}
+	return bstream.NewBlockRef(previousID, previousNum), true
 }
 
+// AddLink links `blockRef` to its previous block, if the block is already linked, nothing
+// is changed and `exists` is true, even if it was linked to a different previous block
+// or number. Use AddLinkStrict to detect those inconsistencies.
 func (f *ForkDB) AddLink(blockRef bstream.BlockRef, previousRefID string, obj interface{}) (exists bool, seenPrevious bool) {
+	exists, seenPrevious, _ = f.addLink(blockRef, previousRefID, obj)
+	return
+}
+
+// AddLinkStrict is like AddLink but returns an error wrapping ErrConflictingLink when the
+// block is already linked with a different previous block ID or block number. The ForkDB
+// is left untouched in that case.
+func (f *ForkDB) AddLinkStrict(blockRef bstream.BlockRef, previousRefID string, obj interface{}) (exists bool, seenPrevious bool, err error) {
+	return f.addLink(blockRef, previousRefID, obj)
+}
+
+func (f *ForkDB) addLink(blockRef bstream.BlockRef, previousRefID string, obj interface{}) (exists bool, seenPrevious bool, err error) {
 	f.linksLock.Lock()
 	defer f.linksLock.Unlock()
 
-	blockID := blockRef.ID()
+	blockID := f.normalizeID(blockRef.ID())
+	previousRefID = f.normalizeID(previousRefID)
 	if blockID == previousRefID || blockID == "" {
-		return false, false
+		return false, false, nil
 	}
 
 	seenPrevious = f.links[previousRefID] != ""
 
-	if f.links[blockID] != "" {
-		return true, seenPrevious
+	if knownPreviousID := f.links[blockID]; knownPreviousID != "" {
+		if knownNum := f.nums[blockID]; knownPreviousID != previousRefID || knownNum != blockRef.Num() {
+			err = fmt.Errorf("%w: block %s with previous %q, already linked as block %s with previous %q",
+				ErrConflictingLink,
+				bstream.NewBlockRef(blockID, blockRef.Num()), previousRefID,
+				bstream.NewBlockRef(blockID, knownNum), knownPreviousID,
+			)
+		}
+		return true, seenPrevious, err
 	}
 
 	f.links[blockID] = previousRefID
@@ -215,10 +492,54 @@ func (f *ForkDB) AddLink(blockRef bstream.BlockRef, previousRefID string, obj in
 	//f.nums[previousID] = previousRef.Num()
 
 	if obj != nil {
-		f.objects[blockID] = obj
+		f.setObject(blockID, obj)
+	}
+
+	return false, seenPrevious, nil
+}
+
+// PayloadSizer is implemented by the objects whose payload size is accounted in the
+// PayloadBytes of the ForkDB stats, like *ForkableBlock
+type PayloadSizer interface {
+	PayloadSize() int
+}
+
+func (f *ForkDB) setObject(id string, obj interface{}) {
+	f.deleteObject(id)
+	f.objects[id] = obj
+	if sizer, ok := obj.(PayloadSizer); ok {
+		size := sizer.PayloadSize()
+		f.payloadSizes[id] = size
+		f.payloadBytes += size
+	}
+}
+
+func (f *ForkDB) deleteObject(id string) {
+	delete(f.objects, id)
+	if size, found := f.payloadSizes[id]; found {
+		delete(f.payloadSizes, id)
+		f.payloadBytes -= size
+	}
+}
+
+// resetPayloadSizes accounts the payload sizes of all the objects again, once they were replaced
+func (f *ForkDB) resetPayloadSizes() {
+	objects := f.objects
+	f.objects = make(map[string]interface{}, len(objects))
+	f.payloadSizes = make(map[string]int)
+	f.payloadBytes = 0
+	for id, obj := range objects {
+		f.setObject(id, obj)
 	}
+}
 
-	return false, seenPrevious
+// PayloadBytes returns the size of the payloads of the objects retained in the ForkDB,
+// see PayloadSizer
+func (f *ForkDB) PayloadBytes() int {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	return f.payloadBytes
 }
 
 // BlockInCurrentChain finds the block_id at height `blockNum` under
@@ -228,10 +549,24 @@ func (f *ForkDB) AddLink(blockRef bstream.BlockRef, previousRefID string, obj in
 func (f *ForkDB) BlockInCurrentChain(startAtBlock bstream.BlockRef, blockNum uint64) bstream.BlockRef {
 	f.linksLock.Lock()
 	defer f.linksLock.Unlock()
+
+	startAtBlock = f.normalizeRef(startAtBlock)
 	if startAtBlock.Num() == blockNum {
 		return startAtBlock
 	}
 
+	if idx := f.chainIndexFor(startAtBlock); idx != nil {
+		if ref, found := idx.lookup(blockNum); found {
+			return ref
+		}
+	}
+
+	return f.walkBlockInCurrentChain(startAtBlock, blockNum)
+}
+
+// walkBlockInCurrentChain is BlockInCurrentChain without the help of the chain index,
+// must be called while holding the linksLock.
+func (f *ForkDB) walkBlockInCurrentChain(startAtBlock bstream.BlockRef, blockNum uint64) bstream.BlockRef {
 	cur := startAtBlock.ID()
 	curNum := startAtBlock.Num()
 	for {
@@ -264,18 +599,28 @@ func (f *ForkDB) BlockInCurrentChain(startAtBlock bstream.BlockRef, blockNum uin
 // No special handling is required for the genesis block as its parent will simply not be found
 // in ForkDB as it cannot exist and it's just the "normal" case.
 func (f *ForkDB) CompleteSegment(startBlock bstream.BlockRef) (blocks []*Block, reachLIB bool) {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	return f.CompleteSegmentInto(startBlock, nil)
+}
+
+// CompleteSegmentInto is like CompleteSegment but reuses `buf` backing array to
+// hold the returned blocks. The content of `buf` is overwritten, callers must not
+// use it anymore after the call, only the returned slice.
+func (f *ForkDB) CompleteSegmentInto(startBlock bstream.BlockRef, buf []*Block) (blocks []*Block, reachLIB bool) {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
 
-	var reversedBlocks []*Block
+	blocks = buf[:0]
+	alloc := newBlockAllocator(0)
 
+	startBlock = f.normalizeRef(startBlock)
 	curID := startBlock.ID()
 	curNum := startBlock.Num()
 
-	seenIDs := make(map[string]bool)
+	// A segment cannot hold more blocks than there are links, walking more means we are looping
+	seenCount := 0
 	for {
-		if seenIDs[curID] {
-			zlog.Error("loop detected in complete segment", zap.String("cur_id", curID), zap.Uint64("cur_num", curNum), zap.Int("block_seen_count", len(seenIDs)))
+		if seenCount > len(f.links) {
+			zlog.Error("loop detected in complete segment", zap.String("cur_id", curID), zap.Uint64("cur_num", curNum), zap.Int("block_seen_count", seenCount))
 			return nil, false
 		}
 
@@ -288,26 +633,15 @@ func (f *ForkDB) CompleteSegment(startBlock bstream.BlockRef) (blocks []*Block,
 			break
 		}
 
-		reversedBlocks = append(reversedBlocks, &Block{
-			BlockID:         curID,
-			BlockNum:        curNum,
-			PreviousBlockID: parentID,
-			Object:          f.objects[curID],
-		})
+		blocks = append(blocks, alloc.newBlock(curID, curNum, parentID, f.objects[curID]))
 
-		seenIDs[curID] = true
+		seenCount++
 
 		curID = parentID
 		curNum = f.nums[parentID]
 	}
 
-	// Reverse sort `blocks`
-	blocks = make([]*Block, len(reversedBlocks))
-	j := 0
-	for i := len(reversedBlocks); i != 0; i-- {
-		blocks[j] = reversedBlocks[i-1]
-		j++
-	}
+	blocks = reverseBlocks(blocks)
 	return
 }
 
@@ -321,34 +655,56 @@ func (f *ForkDB) CompleteSegment(startBlock bstream.BlockRef) (blocks []*Block,
 // WARN: if the segment is broken by some unlinkable blocks, the
 // return value is `nil`.
 func (f *ForkDB) ReversibleSegment(startBlock bstream.BlockRef) (blocks []*Block, reachLIB bool) {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	return f.ReversibleSegmentInto(startBlock, nil)
+}
 
-	var reversedBlocks []*Block
+// ReversibleSegmentInto is like ReversibleSegment but reuses `buf` backing array to
+// hold the returned blocks. The content of `buf` is overwritten, callers must not
+// use it anymore after the call, only the returned slice.
+func (f *ForkDB) ReversibleSegmentInto(startBlock bstream.BlockRef, buf []*Block) (blocks []*Block, reachLIB bool) {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
 
+	startBlock = f.normalizeRef(startBlock)
 	curID := startBlock.ID()
 	curNum := startBlock.Num()
 
+	// On a linear chain, the segment holds exactly one block per height above the LIB
+	expectedCount := 0
+	if libNum := f.libRef.Num(); curNum > libNum {
+		expectedCount = int(curNum - libNum)
+		if expectedCount > len(f.links) {
+			expectedCount = len(f.links)
+		}
+	}
+
+	blocks = buf[:0]
+	if blocks == nil {
+		blocks = make([]*Block, 0, expectedCount)
+	}
+	alloc := newBlockAllocator(expectedCount)
+
 	// Those are for debugging purposes, they are the value of `curID` and `curNum`
 	// just before those are switched to a previous parent link,
 	prevID := ""
 	prevNum := uint64(0)
 
-	seenIDs := make(map[string]bool)
+	// A segment cannot hold more blocks than there are links, walking more means we are looping
+	seenCount := 0
 	for {
-		if seenIDs[curID] {
-			zlog.Error("loop detected in reversible segment", zap.String("cur_id", curID), zap.Uint64("cur_num", curNum), zap.Int("block_seen_count", len(seenIDs)))
+		if seenCount > len(f.links) {
+			zlog.Error("loop detected in reversible segment", zap.String("cur_id", curID), zap.Uint64("cur_num", curNum), zap.Int("block_seen_count", seenCount))
 			return nil, false
 		}
 
-		if curNum > bstream.GetProtocolFirstStreamableBlock && curNum < f.LIBNum() {
+		if curNum > bstream.GetProtocolFirstStreamableBlock && curNum < f.libRef.Num() {
 			f.logger.Debug("forkdb linking past known irreversible block",
 				zap.Stringer("lib", f.libRef),
 				zap.Stringer("start_block", startBlock),
 				zap.Stringer("current_block", bstream.NewBlockRef(curID, curNum)),
 				zap.Stringer("previous_block", bstream.NewBlockRef(prevID, prevNum)),
 			)
-			return
+			return nil, false
 		}
 
 		if curID == f.libRef.ID() {
@@ -358,7 +714,7 @@ func (f *ForkDB) ReversibleSegment(startBlock bstream.BlockRef) (blocks []*Block
 
 		parentID, found := f.links[curID]
 		if !found {
-			if f.HasLIB() {
+			if f.hasLIB() {
 				// This error will eventually bubble up in forkable under 'too many consecutive unlinkable blocks' error
 				f.logger.Debug("forkdb unlinkable block, unable to reach last irrerversible block by following parent links",
 					zap.Stringer("lib", f.libRef),
@@ -372,14 +728,9 @@ func (f *ForkDB) ReversibleSegment(startBlock bstream.BlockRef) (blocks []*Block
 			break //reach the root of the chain. This should be the LIB, but we don't know yet.
 		}
 
-		reversedBlocks = append(reversedBlocks, &Block{
-			BlockID:         curID,
-			BlockNum:        curNum,
-			PreviousBlockID: parentID,
-			Object:          f.objects[curID],
-		})
+		blocks = append(blocks, alloc.newBlock(curID, curNum, parentID, f.objects[curID]))
 
-		seenIDs[curID] = true
+		seenCount++
 
 		prevID = curID
 		prevNum = curNum
@@ -388,18 +739,54 @@ func (f *ForkDB) ReversibleSegment(startBlock bstream.BlockRef) (blocks []*Block
 		curNum = f.nums[parentID]
 	}
 
-	// Reverse sort `blocks`
-	blocks = make([]*Block, len(reversedBlocks))
-	j := 0
-	for i := len(reversedBlocks); i != 0; i-- {
-		blocks[j] = reversedBlocks[i-1]
-		j++
-	}
+	blocks = reverseBlocks(blocks)
 	return
 }
 
+// reverseBlocks reverses `blocks` in place, a `nil` input gives back an empty
+// non-nil slice since `nil` means a broken segment for callers.
+func reverseBlocks(blocks []*Block) []*Block {
+	if blocks == nil {
+		return []*Block{}
+	}
+
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks
+}
+
+// blockAllocator hands out `Block` from chunks allocated in one go instead of
+// doing one heap allocation per block of a segment.
+type blockAllocator struct {
+	chunk []Block
+}
+
+func newBlockAllocator(expectedCount int) *blockAllocator {
+	return &blockAllocator{chunk: make([]Block, 0, expectedCount)}
+}
+
+func (a *blockAllocator) newBlock(id string, num uint64, previousID string, obj interface{}) *Block {
+	if len(a.chunk) == cap(a.chunk) {
+		// Blocks handed out so far keep pointing to the previous chunk, which stays alive
+		size := 2 * cap(a.chunk)
+		if size < 16 {
+			size = 16
+		}
+		a.chunk = make([]Block, 0, size)
+	}
+
+	a.chunk = append(a.chunk, Block{
+		BlockID:         id,
+		BlockNum:        num,
+		PreviousBlockID: previousID,
+		Object:          obj,
+	})
+	return &a.chunk[len(a.chunk)-1]
+}
+
 func (f *ForkDB) stalledInSegment(blocks []*Block) (out []*Block) {
-	if f.libRef.ID() == "" || len(blocks) == 0 {
+	if f.LIBID() == "" || len(blocks) == 0 {
 		return
 	}
 
@@ -411,7 +798,7 @@ func (f *ForkDB) stalledInSegment(blocks []*Block) (out []*Block) {
 	start := blocks[0].BlockNum
 	end := blocks[len(blocks)-1].BlockNum
 
-	f.linksLock.Lock()
+	f.linksLock.RLock()
 
 	for blkID, prevID := range f.links {
 		linkBlkNum := f.nums[blkID]
@@ -424,7 +811,7 @@ func (f *ForkDB) stalledInSegment(blocks []*Block) (out []*Block) {
 			})
 		}
 	}
-	f.linksLock.Unlock()
+	f.linksLock.RUnlock()
 
 	sort.Slice(out, func(i, j int) bool {
 		return out[i].BlockID < out[j].BlockID
@@ -443,7 +830,7 @@ func (f *ForkDB) HasNewIrreversibleSegment(newLIB bstream.BlockRef) (hasNew bool
 	}
 
 	newLIBID := newLIB.ID()
-	if f.libRef.ID() == newLIBID {
+	if f.LIBID() == newLIBID {
 		return false, nil, nil
 	}
 
@@ -460,18 +847,123 @@ func (f *ForkDB) HasNewIrreversibleSegment(newLIB bstream.BlockRef) (hasNew bool
 func (f *ForkDB) DeleteLink(id string) {
 	f.linksLock.Lock()
 	defer f.linksLock.Unlock()
+
+	id = f.normalizeID(id)
 	delete(f.links, id)
-	delete(f.objects, id)
+	f.deleteObject(id)
 	delete(f.nums, id)
+	f.chainIdx = nil
 }
 
-func (f *ForkDB) MoveLIB(blockRef bstream.BlockRef) {
-	f.libRef = blockRef
+// MoveLIB sets the new LIB without purging anything and returns the branches
+// truncated by the move: branches forking off the chain between the previous LIB
+// (inclusive) and the new LIB (exclusive), which can never become the longest chain
+// anymore. Blocks of each branch are sorted by block number, from the fork point outward.
+func (f *ForkDB) MoveLIB(blockRef bstream.BlockRef) (truncatedBranches [][]*Block) {
+	f.linksLock.Lock()
+	defer f.linksLock.Unlock()
+
+	previousLIB := f.libRef
+	f.libRef = f.normalizeRef(blockRef)
+
+	truncatedBranches = f.truncatedBranches(previousLIB, f.libRef)
+	if f.onBranchOrphaned != nil {
+		for _, branch := range truncatedBranches {
+			// Fork points are on the finalized chain, they are all known at this point
+			forkPointID := branch[0].PreviousBlockID
+			f.orphanedBranches = append(f.orphanedBranches, orphanedBranch{
+				forkPoint: bstream.NewBlockRef(forkPointID, f.nums[forkPointID]),
+				blocks:    branch,
+			})
+		}
+	}
+
+	return truncatedBranches
 }
 
+// truncatedBranches must be called while holding the linksLock
+func (f *ForkDB) truncatedBranches(previousLIB, newLIB bstream.BlockRef) (out [][]*Block) {
+	if bstream.IsEmpty(newLIB) || (previousLIB != nil && previousLIB.ID() == newLIB.ID()) {
+		return nil
+	}
+
+	// Finalized chain from the block just below `newLIB` down to `previousLIB`
+	finalized := make(map[string]bool)
+	cur := f.links[newLIB.ID()]
+	for cur != "" && !finalized[cur] {
+		finalized[cur] = true
+		if previousLIB != nil && cur == previousLIB.ID() {
+			break
+		}
+		cur = f.links[cur]
+	}
+	if len(finalized) == 0 {
+		return nil
+	}
+
+	children := make(map[string][]string)
+	var roots []string
+	for id, prev := range f.links {
+		children[prev] = append(children[prev], id)
+		if finalized[prev] && !finalized[id] && id != newLIB.ID() {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		var branch []*Block
+		queue := []string{root}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			branch = append(branch, &Block{
+				BlockID:         id,
+				BlockNum:        f.nums[id],
+				PreviousBlockID: f.links[id],
+				Object:          f.objects[id],
+			})
+			queue = append(queue, children[id]...)
+		}
+
+		sort.SliceStable(branch, func(i, j int) bool {
+			if branch[i].BlockNum == branch[j].BlockNum {
+				return branch[i].BlockID < branch[j].BlockID
+			}
+			return branch[i].BlockNum < branch[j].BlockNum
+		})
+		out = append(out, branch)
+	}
+
+	return out
+}
+
+type orphanedBranch struct {
+	forkPoint bstream.BlockRef
+	blocks    []*Block
+}
+
+// PurgeBeforeLIB removes all blocks (final or on a side branch) more than `keptBlocks`
+// below the LIB and returns them. Branches orphaned by MoveLIB calls since the last
+// purge are reported to the branch orphaned callback, if any, once the purge is done.
 func (f *ForkDB) PurgeBeforeLIB(keptBlocks int) (purgedBlocks []*Block) {
 	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	orphanedBranches := f.orphanedBranches
+	f.orphanedBranches = nil
+	defer func() {
+		f.linksLock.Unlock()
+
+		// Called without the lock held so the callback can inspect the ForkDB
+		for _, branch := range orphanedBranches {
+			f.onBranchOrphaned(branch.forkPoint, branch.blocks)
+		}
+	}()
 
 	cutoff := f.libRef.Num()
 	if cutoff < uint64(keptBlocks) {
@@ -496,12 +988,74 @@ func (f *ForkDB) PurgeBeforeLIB(keptBlocks int) (purgedBlocks []*Block) {
 				PreviousBlockID: prev,
 			})
 
-			delete(f.objects, blk)
+			f.deleteObject(blk)
 		}
 	}
 
 	f.links = newLinks
 	f.nums = newNums
+	f.trimChainIndex()
+
+	return
+}
+
+// ForkDBStats holds size information about the content of a ForkDB
+type ForkDBStats struct {
+	// LinkCount is the number of blocks linked in the ForkDB
+	LinkCount int
+	// BranchCount is the number of distinct branches, i.e. blocks that have no children
+	BranchCount int
+	// LowestBlockNum and HighestBlockNum are the lowest and highest block numbers linked in the ForkDB
+	LowestBlockNum  uint64
+	HighestBlockNum uint64
+	// HeightSpan is the height difference between the lowest and the highest block in the ForkDB
+	HeightSpan uint64
+	// BlocksBelowLIB is the number of blocks still retained strictly below the LIB
+	BlocksBelowLIB int
+	// PayloadBytes is the size of the payloads of the objects retained, see PayloadSizer
+	PayloadBytes int
+}
+
+// Stats computes size information about the ForkDB in a single pass over the links, it is
+// cheap enough to be polled regularly.
+func (f *ForkDB) Stats() (out ForkDBStats) {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	out.LinkCount = len(f.links)
+	out.PayloadBytes = f.payloadBytes
+	if out.LinkCount == 0 {
+		return
+	}
+
+	libNum := f.libRef.Num()
+	hasLIB := f.hasLIB()
+	hasChildren := make(map[string]bool, len(f.links))
+
+	first := true
+	for id, prev := range f.links {
+		hasChildren[prev] = true
+
+		num := f.nums[id]
+		if first || num < out.LowestBlockNum {
+			out.LowestBlockNum = num
+		}
+		if first || num > out.HighestBlockNum {
+			out.HighestBlockNum = num
+		}
+		first = false
+
+		if hasLIB && num < libNum {
+			out.BlocksBelowLIB++
+		}
+	}
+
+	for id := range f.links {
+		if !hasChildren[id] {
+			out.BranchCount++
+		}
+	}
+	out.HeightSpan = out.HighestBlockNum - out.LowestBlockNum
 
 	return
 }
@@ -509,8 +1063,8 @@ func (f *ForkDB) PurgeBeforeLIB(keptBlocks int) (purgedBlocks []*Block) {
 // CloneLinks retrieves a snapshot of the links in the ForkDB.  Used
 // only in ForkViewerin `eosws`.
 func (f *ForkDB) ClonedLinks() (out map[string]string, nums map[string]uint64) {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
 
 	out = make(map[string]string)
 	nums = make(map[string]uint64)
@@ -524,8 +1078,10 @@ func (f *ForkDB) ClonedLinks() (out map[string]string, nums map[string]uint64) {
 }
 
 func (f *ForkDB) BlockForID(blockID string) *Block {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	blockID = f.normalizeID(blockID)
 
 	if previous, ok := f.links[blockID]; ok {
 		return &Block{
@@ -539,6 +1095,63 @@ func (f *ForkDB) BlockForID(blockID string) *Block {
 	return nil
 }
 
+// ErrConflictingLink is returned by AddLinkStrict when a block is re-added with a
+// different previous block ID or block number than the ones already known.
+var ErrConflictingLink = errors.New("conflicting link")
+
+// ErrLinkMissing is returned by IterateAncestors when the chain of parent links
+// is broken, `ID` is the block that could not be found in the ForkDB.
+type ErrLinkMissing struct {
+	ID string
+}
+
+func (e ErrLinkMissing) Error() string {
+	return fmt.Sprintf("link missing for block %q", e.ID)
+}
+
+// IterateAncestors calls `fn` with `fromID` block and then each of its ancestors, following
+// parent links. It stops when `fn` returns true, after the LIB block or when reaching the
+// root of the ForkDB if no LIB is set. An `ErrLinkMissing` is returned if a block cannot
+// be found before reaching any of those.
+//
+// The ForkDB is locked while iterating, `fn` must not call back into the ForkDB.
+func (f *ForkDB) IterateAncestors(fromID string, fn func(blk *Block) (stop bool)) error {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	libID := f.libRef.ID()
+	hasLIB := f.hasLIB()
+
+	id := f.normalizeID(fromID)
+	for walked := 0; ; walked++ {
+		if walked > len(f.links) {
+			return fmt.Errorf("loop detected while iterating ancestors of %q", fromID)
+		}
+
+		previousID, found := f.links[id]
+		if !found {
+			if id == libID || (walked > 0 && !hasLIB) {
+				return nil
+			}
+			return ErrLinkMissing{ID: id}
+		}
+
+		if fn(&Block{
+			BlockID:         id,
+			BlockNum:        f.nums[id],
+			PreviousBlockID: previousID,
+			Object:          f.objects[id],
+		}) {
+			return nil
+		}
+
+		if id == libID {
+			return nil
+		}
+		id = previousID
+	}
+}
+
 // blockRefForID returns a BlockRef for a given block ID. Used only
 // if you already hold the f.linksLock!
 func (f *ForkDB) blockRefForID(blockID string) bstream.BlockRef {
@@ -550,8 +1163,8 @@ func (f *ForkDB) blockRefForID(blockID string) bstream.BlockRef {
 }
 
 func (f *ForkDB) IterateLinks(callback func(blockID, previousBlockID string, object interface{}) (getNext bool)) {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
 
 	for id, prevID := range f.links {
 		if !callback(id, prevID, f.objects[id]) {
@@ -561,8 +1174,8 @@ func (f *ForkDB) IterateLinks(callback func(blockID, previousBlockID string, obj
 }
 
 func (f *ForkDB) Serialize() ([]byte, error) {
-	f.linksLock.Lock()
-	defer f.linksLock.Unlock()
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
 
 	msg := &pbforkable.ForkDB{}
 	msg.Links = f.links
@@ -648,19 +1261,22 @@ func (f *ForkDB) Deserialize(data []byte, objectFactory ObjectFactory) error {
 		return fmt.Errorf("unmarshal: %w", err)
 	}
 
-	// We don't need to lock here, as we are deserializing the whole state
-	// we must therefore be the only one accessing it.
+	f.linksLock.Lock()
+	defer f.linksLock.Unlock()
 
 	f.links = msg.Links
 	f.nums = msg.Nums
+	f.chainIdx = nil
 	f.objects = make(map[string]interface{}, len(msg.Objects))
+	f.payloadSizes = make(map[string]int)
+	f.payloadBytes = 0
 
-	var err error
 	for id, obj := range msg.Objects {
-		f.objects[id], err = f.deserializeObject(obj, objectFactory)
+		object, err := f.deserializeObject(obj, objectFactory)
 		if err != nil {
 			return fmt.Errorf("deserialize object for block %s: %w", f.blockRefForID(id), err)
 		}
+		f.setObject(id, object)
 	}
 
 	if msg.LibRef != nil {
@@ -722,6 +1338,107 @@ func (f *ForkDB) deserializeObject(obj *pbforkable.ForkNodeObject, objectFactory
 	}
 }
 
+// ObjectMarshaler turns a ForkDB block object into opaque bytes for MarshalProto
+type ObjectMarshaler func(blockID string, obj interface{}) ([]byte, error)
+
+// ObjectUnmarshaler turns bytes produced by an ObjectMarshaler back into a block
+// object for UnmarshalProto
+type ObjectUnmarshaler func(blockID string, data []byte) (interface{}, error)
+
+// MarshalProto is like Serialize but the block objects are turned into opaque bytes
+// by `marshal` instead of being required to implement one of the known marshaling
+// interfaces. When `marshal` is nil, objects are not serialized and are restored as
+// nil by UnmarshalProto.
+func (f *ForkDB) MarshalProto(marshal ObjectMarshaler) ([]byte, error) {
+	f.linksLock.RLock()
+	defer f.linksLock.RUnlock()
+
+	msg := &pbforkable.ForkDB{
+		Links:   f.links,
+		Nums:    f.nums,
+		Objects: make(map[string]*pbforkable.ForkNodeObject),
+		LibRef: &pbbstream.BlockRef{
+			Id:  f.libRef.ID(),
+			Num: f.libRef.Num(),
+		},
+	}
+
+	if marshal != nil {
+		for id, obj := range f.objects {
+			if obj == nil {
+				continue
+			}
+
+			data, err := marshal(id, obj)
+			if err != nil {
+				return nil, fmt.Errorf("marshal object for block %s: %w", f.blockRefForID(id), err)
+			}
+
+			msg.Objects[id] = &pbforkable.ForkNodeObject{Object: &pbforkable.ForkNodeObject_Binary{
+				Binary: data,
+			}}
+		}
+	}
+
+	return proto.Marshal(msg)
+}
+
+// UnmarshalProto restores the ForkDB from bytes produced by MarshalProto, replacing
+// its current content. Unknown fields are ignored so snapshots produced by a newer version
+// can still be restored. When `unmarshal` is nil, all block objects are restored as nil.
+func (f *ForkDB) UnmarshalProto(data []byte, unmarshal ObjectUnmarshaler) error {
+	msg := &pbforkable.ForkDB{}
+	if err := (proto.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, msg); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+
+	links := msg.Links
+	if links == nil {
+		links = make(map[string]string)
+	}
+	nums := msg.Nums
+	if nums == nil {
+		nums = make(map[string]uint64)
+	}
+
+	objects := make(map[string]interface{}, len(links))
+	for id := range links {
+		objects[id] = nil
+	}
+
+	if unmarshal != nil {
+		for id, obj := range msg.Objects {
+			binary, ok := obj.GetObject().(*pbforkable.ForkNodeObject_Binary)
+			if !ok {
+				continue
+			}
+
+			object, err := unmarshal(id, binary.Binary)
+			if err != nil {
+				return fmt.Errorf("unmarshal object for block %s: %w", bstream.NewBlockRef(id, nums[id]), err)
+			}
+			objects[id] = object
+		}
+	}
+
+	libRef := bstream.BlockRefEmpty
+	if msg.LibRef != nil && msg.LibRef.Id != "" {
+		libRef = bstream.NewBlockRef(msg.LibRef.Id, msg.LibRef.Num)
+	}
+
+	f.linksLock.Lock()
+	defer f.linksLock.Unlock()
+
+	f.links = links
+	f.nums = nums
+	f.chainIdx = nil
+	f.objects = objects
+	f.resetPayloadSizes()
+	f.libRef = libRef
+
+	return nil
+}
+
 // ObjectFactory is an interface that tells the ForkDB how to create a new object
 // for deserialization. It is used when deserializing the ForkDB's object so that the
 // correct type is instantiated.
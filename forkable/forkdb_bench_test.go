@@ -311,3 +311,95 @@ func newFilledLinear(blockCount int) (forkdb *ForkDB, head bstream.BlockRef) {
 
 	return
 }
+
+func BenchmarkForkDB_ReversibleSegment_360Blocks(b *testing.B) {
+	forkdb, head := newFilledLinear(360)
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			forkdb.ReversibleSegment(head)
+		}
+	})
+
+	b.Run("into_buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []*Block
+		for n := 0; n < b.N; n++ {
+			buf, _ = forkdb.ReversibleSegmentInto(head, buf)
+		}
+	})
+}
+
+func BenchmarkForkDB_CompleteSegment_360Blocks(b *testing.B) {
+	forkdb, head := newFilledLinear(360)
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			forkdb.CompleteSegment(head)
+		}
+	})
+
+	b.Run("into_buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []*Block
+		for n := 0; n < b.N; n++ {
+			buf, _ = forkdb.CompleteSegmentInto(head, buf)
+		}
+	})
+}
+
+func BenchmarkForkDB_BlockInCurrentChain_2000BlocksWindow(b *testing.B) {
+	newWindow := func() (*ForkDB, bstream.BlockRef) {
+		fdb := NewForkDB(ForkDBWithLogger(zlog))
+		fdb.InitLIB(bRefInSegment(1, "aa"))
+
+		head := bRefInSegment(1, "aa")
+		for i := uint64(2); i <= 2001; i++ {
+			ref := bRefInSegment(i, "aa")
+			fdb.AddLink(ref, head.ID(), nil)
+			head = ref
+		}
+		return fdb, head
+	}
+
+	b.Run("walk", func(b *testing.B) {
+		fdb, head := newWindow()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			fdb.linksLock.Lock()
+			fdb.walkBlockInCurrentChain(head, head.Num()-2000)
+			fdb.linksLock.Unlock()
+		}
+	})
+
+	b.Run("indexed", func(b *testing.B) {
+		fdb, head := newWindow()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			fdb.BlockInCurrentChain(head, head.Num()-2000)
+		}
+	})
+
+	b.Run("indexed_growing_chain", func(b *testing.B) {
+		fdb, head := newWindow()
+		refs := make([]bstream.BlockRef, b.N)
+		for n := 0; n < b.N; n++ {
+			refs[n] = bRefInSegment(head.Num()+uint64(n)+1, "aa")
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		previous := head
+		for n := 0; n < b.N; n++ {
+			fdb.AddLink(refs[n], previous.ID(), nil)
+			fdb.BlockInCurrentChain(refs[n], refs[n].Num()-2000)
+			previous = refs[n]
+		}
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/streamingfast/bstream"
+)
+
+const dotShortIDLength = 8
+
+type DOTOptions struct {
+	// Head is the block used to compute the canonical segment, when nil, the highest
+	// block of the ForkDB is used (lowest ID wins on same height).
+	Head bstream.BlockRef
+
+	// LastHeights limits the output to the blocks at most `LastHeights` below the
+	// highest block of the ForkDB, 0 means no limit.
+	LastHeights uint64
+}
+
+// ExportDOT writes the blocks graph of the ForkDB in Graphviz DOT format, one node per
+// block labeled `num:shortID`. Blocks of the canonical segment are filled, the LIB
+// is bold and blocks on a side branch at or below the LIB, which can never become
+// the longest chain, are dashed and grayed out.
+func (f *ForkDB) ExportDOT(w io.Writer, opts DOTOptions) error {
+	f.linksLock.RLock()
+	type dotNode struct {
+		id   string
+		num  uint64
+		prev string
+	}
+
+	var nodes []dotNode
+	var highest uint64
+	for id, prev := range f.links {
+		num := f.nums[id]
+		if num > highest {
+			highest = num
+		}
+		nodes = append(nodes, dotNode{id: id, num: num, prev: prev})
+	}
+
+	headID := ""
+	if opts.Head != nil && !bstream.IsEmpty(opts.Head) {
+		headID = f.normalizeID(opts.Head.ID())
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].num == nodes[j].num {
+			return nodes[i].id < nodes[j].id
+		}
+		return nodes[i].num < nodes[j].num
+	})
+	if headID == "" && len(nodes) > 0 {
+		for _, node := range nodes {
+			if node.num == highest {
+				headID = node.id
+				break
+			}
+		}
+	}
+
+	canonical := make(map[string]bool)
+	for cur := headID; cur != "" && !canonical[cur]; cur = f.links[cur] {
+		canonical[cur] = true
+	}
+
+	libID := f.libRef.ID()
+	libNum := f.libRef.Num()
+	hasLIB := f.hasLIB()
+	f.linksLock.RUnlock()
+
+	lowest := uint64(0)
+	if opts.LastHeights != 0 && highest > opts.LastHeights {
+		lowest = highest - opts.LastHeights
+	}
+
+	included := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if node.num >= lowest {
+			included[node.id] = true
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph forkdb {")
+	fmt.Fprintln(out, "  rankdir=LR;")
+	fmt.Fprintln(out, "  node [shape=box];")
+
+	for _, node := range nodes {
+		if !included[node.id] {
+			continue
+		}
+
+		var attrs string
+		switch {
+		case canonical[node.id]:
+			attrs = `, style=filled, fillcolor=lightblue`
+		case hasLIB && node.num <= libNum:
+			attrs = `, style=dashed, color=gray, fontcolor=gray`
+		}
+		if hasLIB && node.id == libID {
+			attrs += `, penwidth=3, xlabel="LIB"`
+		}
+
+		fmt.Fprintf(out, "  %q [label=%q%s];\n", node.id, fmt.Sprintf("%d:%s", node.num, shortBlockID(node.id)), attrs)
+	}
+
+	for _, node := range nodes {
+		if !included[node.id] || !included[node.prev] {
+			continue
+		}
+		fmt.Fprintf(out, "  %q -> %q;\n", node.prev, node.id)
+	}
+
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+func shortBlockID(id string) string {
+	if len(id) <= dotShortIDLength {
+		return id
+	}
+	return id[len(id)-dotShortIDLength:]
+}
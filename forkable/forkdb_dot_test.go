@@ -0,0 +1,70 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestForkDB_ExportDOT(t *testing.T) {
+	//          /- 3c                     (stalled, below LIB)
+	// 1a <- 2a <- 3a <- 4a <- 5a <- 6a   (canonical)
+	//                      `- 5b <- 6b
+	fdb := NewForkDB()
+	fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+	fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+	fdb.AddLink(bRef("00000003c"), "00000002a", nil)
+	fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+	fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+	fdb.AddLink(bRef("00000005b"), "00000004a", nil)
+	fdb.AddLink(bRef("00000006a"), "00000005a", nil)
+	fdb.AddLink(bRef("00000006b"), "00000005b", nil)
+	fdb.InitLIB(bRef("00000004a"))
+
+	tests := []struct {
+		name   string
+		opts   DOTOptions
+		golden string
+	}{
+		{"all heights", DOTOptions{}, "forkdb_all.dot"},
+		{"explicit head and last heights", DOTOptions{Head: bRef("00000006b"), LastHeights: 2}, "forkdb_last_heights.dot"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, fdb.ExportDOT(buf, test.opts))
+
+			goldenFile := filepath.Join("testdata", test.golden)
+			if *updateGolden {
+				require.NoError(t, os.MkdirAll("testdata", 0755))
+				require.NoError(t, os.WriteFile(goldenFile, buf.Bytes(), 0644))
+			}
+
+			expected, err := os.ReadFile(goldenFile)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), buf.String())
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"github.com/streamingfast/bstream"
+)
+
+// chainIndex maps block numbers to block IDs for the ancestry of a single block,
+// the head of the last branch queried through BlockInCurrentChain. Every ID in
+// `byNum` with a number in [lowestNum, headNum] is reachable from the head by
+// following links, exactly like BlockInCurrentChain's walk would find it.
+//
+// Links of existing blocks never change (AddLink ignores re-adds), so the index
+// only needs to be trimmed on purge and reset when links are deleted or replaced.
+type chainIndex struct {
+	headID    string
+	headNum   uint64
+	lowestNum uint64
+	byNum     map[uint64]string
+}
+
+// lookup returns the block at `blockNum` on the indexed branch, `found` is false when
+// `blockNum` is not covered by the index, in which case the caller must walk the links.
+func (idx *chainIndex) lookup(blockNum uint64) (ref bstream.BlockRef, found bool) {
+	if blockNum < idx.lowestNum || blockNum > idx.headNum {
+		return nil, false
+	}
+
+	id, found := idx.byNum[blockNum]
+	if !found {
+		// Hole in the block numbers, the walk handles this special case
+		return nil, false
+	}
+	return bstream.NewBlockRef(id, blockNum), true
+}
+
+// chainIndexFor returns an index having `head` as its head, reusing the current one
+// when `head` extends it or forks from it. It returns nil when `head` is not connected
+// to the indexed branch and is below its head, keeping the index of the longest branch
+// around. Must be called while holding the linksLock.
+func (f *ForkDB) chainIndexFor(head bstream.BlockRef) *chainIndex {
+	idx := f.chainIdx
+	if idx == nil {
+		idx = &chainIndex{byNum: make(map[uint64]string)}
+		f.indexAncestry(idx, head)
+		f.chainIdx = idx
+		return idx
+	}
+
+	if idx.headID == head.ID() && idx.headNum == head.Num() {
+		return idx
+	}
+
+	// Walk back from `head` until reaching a block of the indexed branch, at most
+	// the fork depth in the usual case (a single step when the chain grows linearly)
+	type entry struct {
+		id  string
+		num uint64
+	}
+	path := []entry{{head.ID(), head.Num()}}
+	cur, curNum := head.ID(), head.Num()
+	for {
+		if id, found := idx.byNum[curNum]; found && id == cur && curNum >= idx.lowestNum {
+			path = path[:len(path)-1]
+			break
+		}
+
+		prev := f.links[cur]
+		prevNum, found := f.nums[prev]
+		if !found || prevNum >= curNum {
+			if head.Num() < idx.headNum {
+				return nil
+			}
+
+			// Not connected to the indexed branch, rebuild from scratch
+			idx = &chainIndex{byNum: make(map[uint64]string, len(idx.byNum))}
+			f.indexAncestry(idx, head)
+			f.chainIdx = idx
+			return idx
+		}
+
+		path = append(path, entry{prev, prevNum})
+		cur, curNum = prev, prevNum
+	}
+
+	// `cur` is the junction, drop the indexed blocks above it and add the new branch
+	for num := curNum + 1; num <= idx.headNum; num++ {
+		delete(idx.byNum, num)
+	}
+	for _, e := range path {
+		idx.byNum[e.num] = e.id
+	}
+	idx.headID = head.ID()
+	idx.headNum = head.Num()
+
+	return idx
+}
+
+// indexAncestry fills `idx` with the ancestry of `head`, following the same rules
+// as BlockInCurrentChain's walk.
+func (f *ForkDB) indexAncestry(idx *chainIndex, head bstream.BlockRef) {
+	idx.headID = head.ID()
+	idx.headNum = head.Num()
+	idx.byNum[head.Num()] = head.ID()
+
+	cur, curNum := head.ID(), head.Num()
+	for {
+		prev := f.links[cur]
+		prevNum, found := f.nums[prev]
+		if !found || prevNum >= curNum {
+			break
+		}
+
+		idx.byNum[prevNum] = prev
+		cur, curNum = prev, prevNum
+	}
+	idx.lowestNum = curNum
+}
+
+// trimChainIndex removes from the index the blocks that are not in `nums` anymore,
+// must be called while holding the linksLock.
+func (f *ForkDB) trimChainIndex() {
+	idx := f.chainIdx
+	if idx == nil {
+		return
+	}
+
+	removed := false
+	var highestRemoved uint64
+	for num, id := range idx.byNum {
+		if _, found := f.nums[id]; !found {
+			removed = true
+			if num > highestRemoved {
+				highestRemoved = num
+			}
+		}
+	}
+	if !removed {
+		return
+	}
+
+	if highestRemoved >= idx.headNum {
+		f.chainIdx = nil
+		return
+	}
+
+	for num := range idx.byNum {
+		if num <= highestRemoved {
+			delete(idx.byNum, num)
+		}
+	}
+	if idx.lowestNum <= highestRemoved {
+		idx.lowestNum = highestRemoved + 1
+	}
+}
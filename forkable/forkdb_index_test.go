@@ -0,0 +1,107 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockInCurrentChain_Forks(t *testing.T) {
+	//                /- 4b <- 5b <- 6b
+	// 1a <- 2a <- 3a <- 4a <- 5a
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000001a"))
+	fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+	fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+	fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+	fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+	fdb.AddLink(bRef("00000004b"), "00000003a", nil)
+	fdb.AddLink(bRef("00000005b"), "00000004b", nil)
+	fdb.AddLink(bRef("00000006b"), "00000005b", nil)
+
+	// Alternates between branches so the index is spliced back and forth
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "00000004a", fdb.BlockInCurrentChain(bRef("00000005a"), 4).ID())
+		assert.Equal(t, "00000004b", fdb.BlockInCurrentChain(bRef("00000006b"), 4).ID())
+		assert.Equal(t, "00000003a", fdb.BlockInCurrentChain(bRef("00000006b"), 3).ID())
+		assert.Equal(t, "00000005a", fdb.BlockInCurrentChain(bRef("00000005a"), 5).ID())
+		assert.Equal(t, "00000005b", fdb.BlockInCurrentChain(bRef("00000006b"), 5).ID())
+		assert.Equal(t, "00000001a", fdb.BlockInCurrentChain(bRef("00000005a"), 1).ID())
+	}
+
+	fdb.AddLink(bRef("00000006a"), "00000005a", nil)
+	assert.Equal(t, "00000004a", fdb.BlockInCurrentChain(bRef("00000006a"), 4).ID())
+
+	fdb.MoveLIB(bRef("00000003a"))
+	fdb.PurgeBeforeLIB(0)
+	assert.Equal(t, "", fdb.BlockInCurrentChain(bRef("00000006a"), 2).ID())
+	assert.Equal(t, "00000003a", fdb.BlockInCurrentChain(bRef("00000006a"), 3).ID())
+	assert.Equal(t, "00000005b", fdb.BlockInCurrentChain(bRef("00000006b"), 5).ID())
+}
+
+func TestBlockInCurrentChain_MatchesWalk(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000001a"))
+
+	var refs []bstream.BlockRef
+	refs = append(refs, bRef("00000001a"))
+
+	for i := 0; i < 2000; i++ {
+		switch op := random.Intn(100); {
+		case op < 70:
+			// Mostly extend the latest block, sometimes fork from a recent one
+			parent := refs[len(refs)-1]
+			if random.Intn(5) == 0 {
+				parent = refs[len(refs)-1-random.Intn(min(len(refs), 10))]
+			}
+
+			ref := bstream.NewBlockRef(fmt.Sprintf("%08x%03d", parent.Num()+1, i), parent.Num()+1)
+			fdb.AddLink(ref, parent.ID(), nil)
+			refs = append(refs, ref)
+		case op < 72 && len(refs) > 20:
+			lib := refs[len(refs)-20]
+			if _, found := fdb.links[lib.ID()]; found {
+				fdb.MoveLIB(lib)
+				fdb.PurgeBeforeLIB(random.Intn(3))
+			}
+		case op < 73:
+			fdb.DeleteLink(refs[random.Intn(len(refs))].ID())
+		default:
+			start := refs[len(refs)-1-random.Intn(min(len(refs), 30))]
+			var num uint64
+			if start.Num() > 0 {
+				num = start.Num() - uint64(random.Intn(int(min(start.Num(), 40))))
+			}
+
+			expected := start
+			if start.Num() != num {
+				fdb.linksLock.Lock()
+				expected = fdb.walkBlockInCurrentChain(start, num)
+				fdb.linksLock.Unlock()
+			}
+
+			actual := fdb.BlockInCurrentChain(start, num)
+			require.Equal(t, expected.String(), actual.String(), "from %s at num %d", start, num)
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package forkable
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
@@ -22,6 +23,7 @@ import (
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func TestAddLinkSimple(t *testing.T) {
@@ -580,3 +582,456 @@ func TestLIBID(t *testing.T) {
 
 	assert.Equal(t, map[string]string{"00000003a": "00000002a", "00000002a": "00000001a"}, fdb.links)
 }
+
+func TestMoveLIB_TruncatedBranches(t *testing.T) {
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000002a"))
+
+	//          /- 4b <- 5b
+	// 2a <- 3a <- 4a <- 5a <- 6a
+	//    `- 3c       `- 5d
+	fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+	fdb.AddLink(bRef("00000003c"), "00000002a", nil)
+	fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+	fdb.AddLink(bRef("00000004b"), "00000003a", nil)
+	fdb.AddLink(bRef("00000005b"), "00000004b", nil)
+	fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+	fdb.AddLink(bRef("00000005d"), "00000004a", nil)
+	fdb.AddLink(bRef("00000006a"), "00000005a", nil)
+
+	branches := fdb.MoveLIB(bRef("00000004a"))
+	require.Len(t, branches, 2)
+	assert.Equal(t, []string{"00000003c"}, blockIDs(branches[0]))
+	assert.Equal(t, []string{"00000004b", "00000005b"}, blockIDs(branches[1]))
+
+	// Branch forking at 4a (the previous LIB) is now truncated, not the others again
+	branches = fdb.MoveLIB(bRef("00000005a"))
+	require.Len(t, branches, 1)
+	assert.Equal(t, []string{"00000005d"}, blockIDs(branches[0]))
+
+	assert.Len(t, fdb.MoveLIB(bRef("00000005a")), 0)
+}
+
+func TestPurgeBeforeLIB_ReturnsEvicted(t *testing.T) {
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000001a"))
+	fdb.AddLink(bRef("00000002a"), "00000001a", "2a")
+	fdb.AddLink(bRef("00000003a"), "00000002a", "3a")
+	fdb.AddLink(bRef("00000003b"), "00000002a", "3b")
+	fdb.AddLink(bRef("00000004b"), "00000003b", "4b")
+	fdb.AddLink(bRef("00000004a"), "00000003a", "4a")
+	fdb.AddLink(bRef("00000005a"), "00000004a", "5a")
+	fdb.AddLink(bRef("00000006a"), "00000005a", "6a")
+
+	fdb.MoveLIB(bRef("00000005a"))
+	purged := fdb.PurgeBeforeLIB(1)
+
+	ids := blockIDs(purged)
+	sort.Strings(ids)
+	assert.Equal(t, []string{"00000002a", "00000003a", "00000003b"}, ids)
+	for _, blk := range purged {
+		assert.Equal(t, blk.BlockID[7:], blk.Object)
+	}
+
+	purged = fdb.PurgeBeforeLIB(0)
+	ids = blockIDs(purged)
+	sort.Strings(ids)
+	assert.Equal(t, []string{"00000004a", "00000004b"}, ids)
+
+	assert.Len(t, fdb.PurgeBeforeLIB(0), 0)
+	assert.Len(t, fdb.links, 2)
+}
+
+func blockIDs(blocks []*Block) (out []string) {
+	for _, blk := range blocks {
+		out = append(out, blk.BlockID)
+	}
+	return
+}
+
+func TestForkDB_Stats(t *testing.T) {
+	assert.Equal(t, ForkDBStats{}, NewForkDB().Stats())
+
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000004a"))
+
+	//                /- 5b <- 6b
+	// 2a <- 3a <- 4a <- 5a <- 6a <- 7a
+	//          `- 4c
+	fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+	fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+	fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+	fdb.AddLink(bRef("00000004c"), "00000003a", nil)
+	fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+	fdb.AddLink(bRef("00000005b"), "00000004a", nil)
+	fdb.AddLink(bRef("00000006b"), "00000005b", nil)
+	fdb.AddLink(bRef("00000006a"), "00000005a", nil)
+	fdb.AddLink(bRef("00000007a"), "00000006a", nil)
+
+	assert.Equal(t, ForkDBStats{
+		LinkCount:       9,
+		BranchCount:     3,
+		LowestBlockNum:  2,
+		HighestBlockNum: 7,
+		HeightSpan:      5,
+		BlocksBelowLIB:  2,
+	}, fdb.Stats())
+}
+
+func payloadBlock(id string, size int) *pbbstream.Block {
+	blk := bstream.TestBlock(id, "")
+	blk.Payload.Value = make([]byte, size)
+	return blk
+}
+
+func TestForkDB_PayloadBytes(t *testing.T) {
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000001a"))
+
+	block4a := payloadBlock("00000004a", 300)
+	fdb.AddLink(bRef("00000002a"), "00000001a", &ForkableBlock{Block: payloadBlock("00000002a", 100)})
+	fdb.AddLink(bRef("00000003a"), "00000002a", &ForkableBlock{Block: payloadBlock("00000003a", 200)})
+	fdb.AddLink(bRef("00000004a"), "00000003a", &ForkableBlock{Block: block4a})
+	fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+	fdb.AddLink(bRef("00000006a"), "00000005a", "not sized")
+	assert.Equal(t, 600, fdb.PayloadBytes())
+
+	fdb.AddLink(bRef("00000003a"), "00000002a", &ForkableBlock{Block: payloadBlock("00000003a", 999)})
+	assert.Equal(t, 600, fdb.Stats().PayloadBytes, "an existing link is not replaced")
+
+	// the size taken when the block was added is removed
+	block4a.Payload.Value = nil
+	fdb.DeleteLink("00000004a")
+	assert.Equal(t, 300, fdb.Stats().PayloadBytes)
+	fdb.DeleteLink("00000004a")
+	assert.Equal(t, 300, fdb.Stats().PayloadBytes)
+
+	fdb.MoveLIB(bRef("00000003a"))
+	assert.Len(t, fdb.PurgeBeforeLIB(0), 1)
+	assert.Equal(t, 200, fdb.Stats().PayloadBytes)
+
+	fdb.MoveLIB(bRef("00000007a"))
+	fdb.PurgeBeforeLIB(0)
+	assert.Equal(t, ForkDBStats{}, fdb.Stats())
+
+	// the blocks deserialized are accounted
+	withBlocks := NewForkDB()
+	withBlocks.AddLink(bRef("00000002a"), "00000001a", payloadBlock("00000002a", 100))
+	withBlocks.AddLink(bRef("00000003a"), "00000002a", payloadBlock("00000003a", 200))
+	serialized, err := withBlocks.Serialize()
+	require.NoError(t, err)
+
+	deserialized := NewForkDB()
+	require.NoError(t, deserialized.Deserialize(serialized, nil))
+	assert.Equal(t, 300, deserialized.PayloadBytes())
+	deserialized.DeleteLink("00000002a")
+	assert.Equal(t, 200, deserialized.PayloadBytes())
+}
+
+func TestForkDB_IterateAncestors(t *testing.T) {
+	newDB := func(withLIB bool) *ForkDB {
+		fdb := NewForkDB()
+		fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+		fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+		fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+		fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+		// 00000006a is missing
+		fdb.AddLink(bRef("00000007a"), "00000006a", nil)
+		fdb.AddLink(bRef("00000008a"), "00000007a", nil)
+		if withLIB {
+			fdb.InitLIB(bRef("00000003a"))
+		}
+		return fdb
+	}
+
+	tests := []struct {
+		name        string
+		withLIB     bool
+		fromID      string
+		stopAt      string
+		expectedIDs []string
+		expectedErr error
+	}{
+		{"stops after lib", true, "00000005a", "", []string{"00000005a", "00000004a", "00000003a"}, nil},
+		{"stops at root without lib", false, "00000005a", "", []string{"00000005a", "00000004a", "00000003a", "00000002a"}, nil},
+		{"stopped by callback", true, "00000005a", "00000004a", []string{"00000005a", "00000004a"}, nil},
+		{"broken link mid-walk", true, "00000008a", "", []string{"00000008a", "00000007a"}, ErrLinkMissing{ID: "00000006a"}},
+		{"unknown from block", true, "00000009a", "", nil, ErrLinkMissing{ID: "00000009a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ids []string
+			err := newDB(test.withLIB).IterateAncestors(test.fromID, func(blk *Block) bool {
+				ids = append(ids, blk.BlockID)
+				return blk.BlockID == test.stopAt
+			})
+
+			if test.expectedErr != nil {
+				var linkMissing ErrLinkMissing
+				require.ErrorAs(t, err, &linkMissing)
+				assert.Equal(t, test.expectedErr, linkMissing)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.expectedIDs, ids)
+		})
+	}
+}
+
+func TestForkDB_MarshalProto(t *testing.T) {
+	//                /- 4b <- 5b
+	// 1a <- 2a <- 3a <- 4a <- 5a <- 6a
+	//          `- 3c
+	newDB := func() *ForkDB {
+		fdb := NewForkDB()
+		fdb.InitLIB(bRef("00000002a"))
+		for _, link := range [][2]string{
+			{"00000002a", "00000001a"},
+			{"00000003a", "00000002a"},
+			{"00000003c", "00000002a"},
+			{"00000004a", "00000003a"},
+			{"00000004b", "00000003a"},
+			{"00000005a", "00000004a"},
+			{"00000005b", "00000004b"},
+			{"00000006a", "00000005a"},
+		} {
+			fdb.AddLink(bRef(link[0]), link[1], "obj-"+link[0])
+		}
+		return fdb
+	}
+
+	marshal := func(blockID string, obj interface{}) ([]byte, error) {
+		return []byte(obj.(string)), nil
+	}
+	unmarshal := func(blockID string, data []byte) (interface{}, error) {
+		return string(data), nil
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		fdb := newDB()
+		data, err := fdb.MarshalProto(marshal)
+		require.NoError(t, err)
+
+		// Simulates a field added by a newer version
+		data = protowire.AppendTag(data, 100, protowire.BytesType)
+		data = protowire.AppendBytes(data, []byte("future"))
+
+		restored := NewForkDB()
+		require.NoError(t, restored.UnmarshalProto(data, unmarshal))
+
+		assert.Equal(t, fdb.links, restored.links)
+		assert.Equal(t, fdb.nums, restored.nums)
+		assert.Equal(t, fdb.objects, restored.objects)
+		assert.Equal(t, fdb.libRef.String(), restored.libRef.String())
+	})
+
+	t.Run("without object marshaler", func(t *testing.T) {
+		data, err := newDB().MarshalProto(nil)
+		require.NoError(t, err)
+
+		restored := NewForkDB()
+		require.NoError(t, restored.UnmarshalProto(data, unmarshal))
+		require.NotNil(t, restored.BlockForID("00000005b"))
+		assert.Nil(t, restored.BlockForID("00000005b").Object)
+	})
+
+	t.Run("restored before move lib", func(t *testing.T) {
+		fdb := newDB()
+		data, err := fdb.MarshalProto(marshal)
+		require.NoError(t, err)
+
+		restored := NewForkDB()
+		require.NoError(t, restored.UnmarshalProto(data, unmarshal))
+
+		fdb.MoveLIB(bRef("00000004a"))
+		restored.MoveLIB(bRef("00000004a"))
+
+		expected, expectedReachLIB := fdb.CompleteSegment(bRef("00000006a"))
+		seg, reachLIB := restored.CompleteSegment(bRef("00000006a"))
+		assert.True(t, reachLIB)
+		assert.Equal(t, expectedReachLIB, reachLIB)
+		assert.Equal(t, expected, seg)
+		assert.Equal(t, []string{"00000002a", "00000003a", "00000004a", "00000005a", "00000006a"}, blockIDs(seg))
+		assert.Equal(t, "obj-00000006a", seg[len(seg)-1].Object)
+	})
+}
+
+func TestForkDB_ConcurrentReaders(t *testing.T) {
+	p := New(nullHandler, WithExclusiveLIB(bRef("00000001a")), WithKeptFinalBlocks(5))
+	fdb := p.forkDB
+
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			libNum := fdb.LIBNum()
+			if fdb.HasLIB() {
+				fdb.ReversibleSegment(bRefInSegment(libNum+3, "a"))
+			}
+			fdb.CompleteSegment(bRefInSegment(libNum+1, "a"))
+			fdb.BlockForID(fdb.LIBID())
+			fdb.BlockInCurrentChain(bRefInSegment(libNum+2, "a"), libNum)
+			fdb.Stats()
+		}
+	}()
+
+	for i := uint64(2); i < 500; i++ {
+		libNum := uint64(1)
+		if i > 10 {
+			libNum = i - 10
+		}
+		blk := tb(bRefInSegment(i, "a").ID(), bRefInSegment(i-1, "a").ID(), libNum)
+		require.NoError(t, p.ProcessBlock(blk, nil))
+
+		if i%7 == 0 {
+			// A competing branch that quickly gets orphaned
+			fork := tb(bRefInSegment(i, "b").ID(), bRefInSegment(i-1, "a").ID(), libNum)
+			require.NoError(t, p.ProcessBlock(fork, nil))
+		}
+	}
+
+	close(done)
+	<-readerDone
+
+	assert.Equal(t, uint64(489), fdb.LIBNum())
+}
+
+func TestAddLinkStrict(t *testing.T) {
+	tests := []struct {
+		name           string
+		ref            bstream.BlockRef
+		previousID     string
+		expectedErrMsg string
+	}{
+		{"matching re-add", bRef("00000003a"), "00000002a", ""},
+		{"differing previous", bRef("00000003a"), "00000002b", `conflicting link: block #3 (00000003a) with previous "00000002b", already linked as block #3 (00000003a) with previous "00000002a"`},
+		{"differing num", bstream.NewBlockRef("00000003a", 4), "00000002a", `conflicting link: block #4 (00000003a) with previous "00000002a", already linked as block #3 (00000003a) with previous "00000002a"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fdb := NewForkDB()
+			fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+			exists, _, err := fdb.AddLinkStrict(bRef("00000003a"), "00000002a", "first")
+			require.NoError(t, err)
+			require.False(t, exists)
+
+			exists, seenPrevious, err := fdb.AddLinkStrict(test.ref, test.previousID, "second")
+			assert.True(t, exists)
+			if test.expectedErrMsg == "" {
+				require.NoError(t, err)
+				assert.True(t, seenPrevious)
+			} else {
+				require.ErrorIs(t, err, ErrConflictingLink)
+				assert.Equal(t, test.expectedErrMsg, err.Error())
+			}
+
+			// First version is always kept
+			blk := fdb.BlockForID("00000003a")
+			assert.Equal(t, "00000002a", blk.PreviousBlockID)
+			assert.Equal(t, uint64(3), blk.BlockNum)
+			assert.Equal(t, "first", blk.Object)
+
+			// Non-strict path ignores the conflict
+			exists, _ = fdb.AddLink(test.ref, test.previousID, "third")
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestChainSwitchSegments_Aborted(t *testing.T) {
+	// 1a <- 2a <- 3a <- 4a        (old head 4a)
+	// 8b <- 9b <- 10b             (disconnected, 7b unknown)
+	// 11c <- 12c <- 13c <- 11c    (cycle)
+	fdb := NewForkDB()
+	fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+	fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+	fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+	fdb.AddLink(bRef("00000008b"), "00000007b", nil)
+	fdb.AddLink(bRef("00000009b"), "00000008b", nil)
+	fdb.AddLink(bRef("0000000ab"), "00000009b", nil)
+	fdb.AddLink(bRef("0000000bc"), "0000000dc", nil)
+	fdb.AddLink(bRef("0000000cc"), "0000000bc", nil)
+	fdb.AddLink(bRef("0000000dc"), "0000000cc", nil)
+
+	t.Run("disconnected branches", func(t *testing.T) {
+		undo, redo, junction, err := fdb.ChainSwitchSegmentsWithMaxDepth("00000004a", "0000000ab", 0)
+		require.NoError(t, err)
+		assert.Nil(t, undo)
+		assert.Nil(t, redo)
+		assert.Equal(t, "", junction)
+	})
+
+	t.Run("disconnected branches over max depth", func(t *testing.T) {
+		_, _, _, err := fdb.ChainSwitchSegmentsWithMaxDepth("00000004a", "0000000ab", 2)
+		assert.Equal(t, ErrChainSwitchAborted{OldHeadBlockID: "00000004a", NewHeadsPreviousID: "0000000ab", Depth: 2}, err)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, _, _, err := fdb.ChainSwitchSegmentsWithMaxDepth("00000004a", "0000000dc", 0)
+		assert.Equal(t, ErrChainSwitchAborted{OldHeadBlockID: "00000004a", NewHeadsPreviousID: "0000000dc", Depth: 3, Cycle: true}, err)
+		assert.EqualError(t, err, `chain switch from old head "00000004a" to new head's previous "0000000dc" aborted after 3 blocks: cycle detected`)
+
+		undo, redo, junction := fdb.ChainSwitchSegments("0000000dc", "00000004a")
+		assert.Nil(t, undo)
+		assert.Nil(t, redo)
+		assert.Equal(t, "", junction)
+	})
+}
+
+func TestCommonAncestor(t *testing.T) {
+	//                /- 4b <- 5b
+	// 1a <- 2a <- 3a <- 4a <- 5a <- 6a
+	//          `- 3c
+	// 8d <- 9d         (disjoint, 7d unknown)
+	fdb := NewForkDB()
+	fdb.InitLIB(bRef("00000001a"))
+	fdb.AddLink(bRef("00000002a"), "00000001a", nil)
+	fdb.AddLink(bRef("00000003a"), "00000002a", nil)
+	fdb.AddLink(bRef("00000003c"), "00000002a", nil)
+	fdb.AddLink(bRef("00000004a"), "00000003a", nil)
+	fdb.AddLink(bRef("00000004b"), "00000003a", nil)
+	fdb.AddLink(bRef("00000005a"), "00000004a", nil)
+	fdb.AddLink(bRef("00000005b"), "00000004b", nil)
+	fdb.AddLink(bRef("00000006a"), "00000005a", nil)
+	fdb.AddLink(bRef("00000008d"), "00000007d", nil)
+	fdb.AddLink(bRef("00000009d"), "00000008d", nil)
+
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"same block", "00000004a", "00000004a", "00000004a"},
+		{"same branch", "00000006a", "00000003a", "00000003a"},
+		{"same branch reversed", "00000002a", "00000005a", "00000002a"},
+		{"same branch down to root", "00000006a", "00000001a", "00000001a"},
+		{"sibling branches", "00000006a", "00000005b", "00000003a"},
+		{"sibling branches same height", "00000004a", "00000004b", "00000003a"},
+		{"sibling branches far apart", "00000003c", "00000005b", "00000002a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ancestor, err := fdb.CommonAncestor(bRef(test.a), bRef(test.b))
+			require.NoError(t, err)
+			assert.Equal(t, bRef(test.expected).String(), ancestor.String())
+		})
+	}
+
+	t.Run("disjoint graphs", func(t *testing.T) {
+		_, err := fdb.CommonAncestor(bRef("00000006a"), bRef("00000009d"))
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = fdb.CommonAncestor(bRef("00000006a"), bRef("00000009z"))
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
@@ -42,6 +42,15 @@ func WithFailOnUnlinkableBlocks(count int, gracePeriod time.Duration) Option {
 	}
 }
 
+// WithFailOnConflictingBlocks makes ProcessBlock return an error wrapping ErrConflictingLink
+// when a block already known is received again with a different parent or block number. By
+// default, such blocks are logged and ignored like any other already seen block.
+func WithFailOnConflictingBlocks() Option {
+	return func(f *Forkable) {
+		f.failOnConflictingBlocks = true
+	}
+}
+
 func WithInclusiveLIB(irreversibleBlock bstream.BlockRef) Option {
 	return func(f *Forkable) {
 		f.includeInitialLIB = true
@@ -63,18 +72,72 @@ func WithFilters(steps bstream.StepType) Option {
 	}
 }
 
+// WithSkippedBlockCallback registers a callback invoked every time the handler returns
+// `bstream.ErrSkipBlock` for a block. Skipped blocks are considered as processed.
+func WithSkippedBlockCallback(callback func(block bstream.BlockRef, step bstream.StepType)) Option {
+	return func(f *Forkable) {
+		f.onSkippedBlock = callback
+	}
+}
+
+// WithChainView attaches a read-only view of the ForkDB to every ForkableObject sent
+// to the handler, see `ForkableObject.ChainView`. The view is a snapshot of the ForkDB
+// links (no objects) taken at most once per ForkDB change.
+func WithChainView() Option {
+	return func(f *Forkable) {
+		f.withChainView = true
+	}
+}
+
+// WithBranchOrphanedCallback registers a callback invoked for each side branch that
+// can never become the longest chain anymore after the LIB moved. Blocks of those
+// branches are the ones sent as `StepStalled` to the handler.
+func WithBranchOrphanedCallback(callback BranchOrphanedFunc) Option {
+	return func(f *Forkable) {
+		f.forkDB.onBranchOrphaned = callback
+	}
+}
+
 func HoldBlocksUntilLIB() Option {
 	return func(f *Forkable) {
 		f.holdBlocksUntilLIB = true
 	}
 }
 
+// WithFirstStreamableBlock is meant for chains whose history doesn't start at block 0/1 (pruned
+// genesis for example). When no LIB is known, the first block at or above `num` is treated as
+// the initial inclusive irreversible block regardless of its LibNum, and blocks before it are
+// ignored (they don't count towards the unlinkable blocks thresholds).
+func WithFirstStreamableBlock(num uint64) Option {
+	return func(f *Forkable) {
+		f.firstStreamableBlock = &num
+	}
+}
+
 func WithKeptFinalBlocks(count int) Option {
 	return func(f *Forkable) {
 		f.keptFinalBlocks = count
 	}
 }
 
+// WithDeterministicTieBreak makes the choice of head deterministic when a block arrives at the
+// same height as the current head: `cmp(candidate, head)` returning true makes the candidate the
+// new head (undoing the current one). Without this option, the first block seen at a given height
+// wins. A nil `cmp` defaults to `LexicographicTieBreak`.
+func WithDeterministicTieBreak(cmp func(a, b bstream.BlockRef) bool) Option {
+	return func(f *Forkable) {
+		if cmp == nil {
+			cmp = LexicographicTieBreak
+		}
+		f.tieBreak = cmp
+	}
+}
+
+// LexicographicTieBreak prefers the block with the lexicographically smaller ID.
+func LexicographicTieBreak(a, b bstream.BlockRef) bool {
+	return a.ID() < b.ID()
+}
+
 func EnsureBlockFlows(blockRef bstream.BlockRef) Option {
 	return func(f *Forkable) {
 		f.ensureBlockFlows = blockRef
@@ -93,3 +156,35 @@ func EnsureAllBlocksTriggerLongestChain() Option {
 		f.ensureAllBlocksTriggerLongestChain = true
 	}
 }
+
+// WithBlockIDNormalizer normalizes the ID and parent ID of every block received
+// before it's linked in the ForkDB, as well as the block IDs of cursors received when
+// resolving blocks. Since incoming blocks are normalized, emitted cursors contain
+// normalized IDs too. See `NormalizeStarknetFeltBlockID` for a ready-made normalizer.
+func WithBlockIDNormalizer(normalizer func(string) string) Option {
+	return func(f *Forkable) {
+		f.blockIDNormalizer = normalizer
+	}
+}
+
+// WithBlockPool releases the blocks to `pool` once they are purged from the
+// ForkDB, `WithKeptFinalBlocks` blocks behind the LIB. It is the way to recycle
+// the blocks of a bstream.FileSource feeding the Forkable, which must not release
+// them itself (see bstream.FileSourceWithBlockPool). The handler must not retain
+// the blocks it receives past their purge.
+func WithBlockPool(pool *bstream.BlockPool) Option {
+	return func(f *Forkable) {
+		f.blockPool = pool
+	}
+}
+
+// WithHeadWatchdog starts a background check when the first block is processed that
+// calls `onStall` every `maxIdle` period during which no new head block was sent
+// to the handler. When `onStall` returns an error, the watchdog stops, the error is returned
+// by the next `ProcessBlock` call and the owner configured through `BindOwner` (if any) is shut down
+// with it. The watchdog stops when the owner terminates or the Forkable is closed, see Close.
+func WithHeadWatchdog(maxIdle time.Duration, onStall HeadStallFunc) Option {
+	return func(f *Forkable) {
+		f.headWatchdog = newHeadWatchdog(maxIdle, onStall)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+type HeadStallFunc func(lastHead bstream.BlockRef, idleFor time.Duration) error
+
+// headWatchdog periodically checks that a new head was sent by the Forkable and
+// calls `onStall` every `maxIdle` period during which no new head was sent.
+type headWatchdog struct {
+	maxIdle time.Duration
+	onStall HeadStallFunc
+
+	// now and newTicker are overridden in tests to control time
+	now       func() time.Time
+	newTicker func(d time.Duration) (ticks <-chan time.Time, stop func())
+
+	lock        sync.Mutex
+	started     bool
+	stopped     bool
+	lastHead    bstream.BlockRef
+	lastHeadAt  time.Time
+	lastStallAt time.Time
+	err         error
+	owner       bstream.Shutterer
+
+	done chan struct{}
+
+	logger *zap.Logger
+}
+
+func newHeadWatchdog(maxIdle time.Duration, onStall HeadStallFunc) *headWatchdog {
+	return &headWatchdog{
+		maxIdle: maxIdle,
+		onStall: onStall,
+		now:     time.Now,
+		newTicker: func(d time.Duration) (<-chan time.Time, func()) {
+			ticker := time.NewTicker(d)
+			return ticker.C, ticker.Stop
+		},
+		lastHead: bstream.BlockRefEmpty,
+		done:     make(chan struct{}),
+		logger:   zlog,
+	}
+}
+
+// start launches the background check loop, calling it more than once is a no-op
+func (w *headWatchdog) start() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.started || w.stopped {
+		return
+	}
+	w.started = true
+	w.lastHeadAt = w.now()
+
+	checkInterval := w.maxIdle / 4
+	if checkInterval <= 0 {
+		checkInterval = w.maxIdle
+	}
+
+	ticks, stopTicker := w.newTicker(checkInterval)
+	go func() {
+		defer stopTicker()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticks:
+				if !w.check() {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// check returns false when the watchdog must stop
+func (w *headWatchdog) check() bool {
+	w.lock.Lock()
+	now := w.now()
+	idleFor := now.Sub(w.lastHeadAt)
+	if idleFor < w.maxIdle || now.Sub(w.lastStallAt) < w.maxIdle {
+		w.lock.Unlock()
+		return true
+	}
+	w.lastStallAt = now
+	lastHead := w.lastHead
+	w.lock.Unlock()
+
+	w.logger.Warn("forkable head stalled", zap.Stringer("last_head", lastHead), zap.Duration("idle_for", idleFor))
+	err := w.onStall(lastHead, idleFor)
+	if err == nil {
+		return true
+	}
+
+	err = fmt.Errorf("head stalled at %s for %s: %w", lastHead, idleFor, err)
+
+	w.lock.Lock()
+	w.err = err
+	owner := w.owner
+	w.lock.Unlock()
+
+	w.stop()
+	if owner != nil {
+		owner.Shutdown(err)
+	}
+	return false
+}
+
+func (w *headWatchdog) headSent(head bstream.BlockRef) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.lastHead = head
+	w.lastHeadAt = w.now()
+	w.lastStallAt = time.Time{}
+}
+
+func (w *headWatchdog) bindOwner(owner bstream.Shutterer) {
+	w.lock.Lock()
+	w.owner = owner
+	w.lock.Unlock()
+
+	owner.OnTerminating(func(_ error) {
+		w.stop()
+	})
+}
+
+func (w *headWatchdog) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+	close(w.done)
+}
+
+func (w *headWatchdog) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.err
+}
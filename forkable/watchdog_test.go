@@ -0,0 +1,158 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forkable
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type stallCall struct {
+	lastHead bstream.BlockRef
+	idleFor  time.Duration
+}
+
+func TestForkable_HeadWatchdog(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+
+	var stallErr error
+	stalls := make(chan stallCall, 10)
+
+	p := New(nullHandler, WithExclusiveLIB(bRef("00000001a")), WithHeadWatchdog(time.Minute, func(lastHead bstream.BlockRef, idleFor time.Duration) error {
+		stalls <- stallCall{lastHead, idleFor}
+		return stallErr
+	}))
+	p.headWatchdog.now = clock.Now
+	// Ticks are simulated by calling `check` directly, the background loop never ticks
+	p.headWatchdog.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		return nil, func() {}
+	}
+	tick := func() bool {
+		return p.headWatchdog.check()
+	}
+
+	owner := shutter.New()
+	p.BindOwner(owner)
+
+	require.NoError(t, p.ProcessBlock(bTestBlock("00000002a", "00000001a"), nil))
+
+	clock.Advance(30 * time.Second)
+	assert.True(t, tick())
+	assert.Len(t, stalls, 0)
+
+	clock.Advance(31 * time.Second)
+	assert.True(t, tick())
+	require.Len(t, stalls, 1)
+	call := <-stalls
+	assert.Equal(t, "00000002a", call.lastHead.ID())
+	assert.Equal(t, 61*time.Second, call.idleFor)
+
+	// Still stalled but the callback was just invoked
+	assert.True(t, tick())
+	assert.Len(t, stalls, 0)
+
+	// Blocks resume, the idle period is reset
+	require.NoError(t, p.ProcessBlock(bTestBlock("00000003a", "00000002a"), nil))
+	clock.Advance(45 * time.Second)
+	assert.True(t, tick())
+	assert.Len(t, stalls, 0)
+
+	stallErr = errors.New("no more blocks")
+	clock.Advance(30 * time.Second)
+	assert.False(t, tick())
+	assert.True(t, owner.IsTerminating())
+
+	require.Len(t, stalls, 1)
+	call = <-stalls
+	assert.Equal(t, "00000003a", call.lastHead.ID())
+	assert.Equal(t, 75*time.Second, call.idleFor)
+	assert.ErrorIs(t, owner.Err(), stallErr)
+
+	err := p.ProcessBlock(bTestBlock("00000004a", "00000003a"), nil)
+	assert.ErrorIs(t, err, stallErr)
+}
+
+func TestForkable_HeadWatchdog_StopsWithOwner(t *testing.T) {
+	ticks := make(chan time.Time)
+	stopped := make(chan struct{})
+
+	p := New(nullHandler, WithExclusiveLIB(bRef("00000001a")), WithHeadWatchdog(time.Minute, func(bstream.BlockRef, time.Duration) error {
+		return nil
+	}))
+	p.headWatchdog.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() { close(stopped) }
+	}
+
+	owner := shutter.New()
+	p.BindOwner(owner)
+
+	require.NoError(t, p.ProcessBlock(bTestBlock("00000002a", "00000001a"), nil))
+	owner.Shutdown(nil)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not stop when owner terminated")
+	}
+}
+
+func TestForkable_HeadWatchdog_StopsOnClose(t *testing.T) {
+	ticks := make(chan time.Time)
+	stopped := make(chan struct{})
+
+	p := New(nullHandler, WithExclusiveLIB(bRef("00000001a")), WithHeadWatchdog(time.Minute, func(bstream.BlockRef, time.Duration) error {
+		return nil
+	}))
+	p.headWatchdog.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() { close(stopped) }
+	}
+
+	require.NoError(t, p.ProcessBlock(bTestBlock("00000002a", "00000001a"), nil))
+	p.Close()
+	p.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not stop when the forkable was closed")
+	}
+
+	// the watchdog is not started again
+	require.NoError(t, p.ProcessBlock(bTestBlock("00000003a", "00000002a"), nil))
+}
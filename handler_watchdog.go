@@ -0,0 +1,75 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"sync"
+	"time"
+)
+
+// handlerWatchdog calls onStall when a handler call lasts more than `timeout`,
+// then again every `timeout` until it returns. A single timer is reset for
+// each call.
+type handlerWatchdog struct {
+	timeout time.Duration
+	onStall func(block BlockRef, stuckFor time.Duration)
+
+	lock    sync.Mutex
+	timer   *time.Timer
+	block   BlockRef
+	started time.Time
+}
+
+func newHandlerWatchdog(timeout time.Duration, onStall func(block BlockRef, stuckFor time.Duration)) *handlerWatchdog {
+	w := &handlerWatchdog{
+		timeout: timeout,
+		onStall: onStall,
+	}
+	w.timer = time.AfterFunc(timeout, w.expired)
+	w.timer.Stop()
+	return w
+}
+
+// start watches the handler call processing `block`
+func (w *handlerWatchdog) start(block BlockRef) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.block = block
+	w.started = time.Now()
+	w.timer.Reset(w.timeout)
+}
+
+// stop is called once the handler call returned
+func (w *handlerWatchdog) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.timer.Stop()
+	w.block = nil
+}
+
+func (w *handlerWatchdog) expired() {
+	w.lock.Lock()
+	block := w.block
+	stuckFor := time.Since(w.started)
+	if block == nil || stuckFor < w.timeout {
+		// the call returned, or a new one started, while the timer fired
+		w.lock.Unlock()
+		return
+	}
+	w.timer.Reset(w.timeout)
+	w.lock.Unlock()
+
+	w.onStall(block, stuckFor)
+}
@@ -74,6 +74,7 @@ func NewForkableHub(liveSourceFactory bstream.SourceFactory, oneBlocksSourceFact
 	for _, opt := range extraForkableOptions {
 		opt(hub.forkable)
 	}
+	hub.forkable.BindOwner(hub)
 
 	hub.OnTerminating(func(err error) {
 		for _, sub := range hub.subscribers {
@@ -217,6 +218,11 @@ func (h *ForkableHub) SourceThroughCursor(startBlock uint64, cursor *bstream.Cur
 		return nil
 	}
 
+	if cursor.IsEmpty() {
+		zlog.Debug("error getting source_through_cursor", zap.Error(bstream.ErrEmptyCursor))
+		return nil
+	}
+
 	// cursor has already passed, ignoring it
 	if cursor.Block.Num() < startBlock {
 		return h.SourceFromBlockNum(startBlock, handler)
@@ -61,7 +61,7 @@ func NewJoiningSource(
 	cursor *Cursor,
 	cursorIsTarget bool,
 	logger *zap.Logger) *JoiningSource {
-	logger.Info("creating new joining source", zap.Stringer("cursor", cursor), zap.Uint64("start_block_num", startBlockNum))
+	logger.Info("creating new joining source", zap.Object("cursor", cursor), zap.Uint64("start_block_num", startBlockNum))
 
 	s := &JoiningSource{
 		Shutter:           shutter.New(),
@@ -117,7 +117,8 @@ func (s *JoiningSource) run() error {
 }
 
 func (s *JoiningSource) tryGetSource(handler Handler, factory ForkableSourceFactory) Source {
-	if s.cursor != nil {
+	// nil and empty cursors start from the block number
+	if !s.cursor.IsEmpty() {
 		if s.cursorIsTarget {
 			return factory.SourceThroughCursor(s.startBlockNum, s.cursor, handler)
 		}
@@ -132,7 +133,7 @@ func (s *JoiningSource) fileSourceHandler(blk *pbbstream.Block, obj interface{})
 	}
 
 	if blk.Number >= s.lowestLiveBlockNum {
-		if s.cursorIsTarget {
+		if s.cursorIsTarget && !s.cursor.IsEmpty() {
 			if src := s.liveSourceFactory.SourceThroughCursor(blk.Number, s.cursor, s.handler); src != nil {
 				s.liveSource = src
 				return stopSourceOnJoin
@@ -0,0 +1,184 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// localDownloadAttempts is the amount of times the download of a blocks
+// archive to a local file is attempted, each attempt resumes the previous one
+const localDownloadAttempts = 5
+
+// localBuffer downloads blocks archives to temporary files in `dir`, the
+// downloads wait while the files on disk use `maxBytes` or more.
+type localBuffer struct {
+	dir      string
+	maxBytes int64
+
+	lock    sync.Mutex
+	used    int64
+	changed chan struct{}
+}
+
+func newLocalBuffer(dir string, maxBytes int64) *localBuffer {
+	return &localBuffer{
+		dir:      dir,
+		maxBytes: maxBytes,
+		changed:  make(chan struct{}),
+	}
+}
+
+func (b *localBuffer) usedBytes() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.used
+}
+
+// waitForSpace returns once the files on disk use less than maxBytes
+func (b *localBuffer) waitForSpace(ctx context.Context) error {
+	for {
+		b.lock.Lock()
+		if b.used < b.maxBytes {
+			b.lock.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (b *localBuffer) add(n int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.used += n
+	if n < 0 {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+}
+
+// download writes the blocks archive `filename` to a temporary file and returns
+// it positioned at its start, the file is deleted when closed. A download that
+// fails partway through is resumed by skipping the bytes already written.
+func (s *FileSource) download(blocksStore dstore.Store, filename string) (io.ReadCloser, error) {
+	buffer := s.localBuffer
+	if err := buffer.waitForSpace(s.ctx); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(buffer.dir, "bundle-"+filepath.Base(filename)+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating local file: %w", err)
+	}
+	tempFile := &tempFile{file: file, buffer: buffer}
+
+	var written int64
+	var backoff *retryBackoff
+	for attempt := 1; ; attempt++ {
+		err = s.downloadFrom(blocksStore, filename, tempFile, &written)
+		if err == nil {
+			break
+		}
+		if attempt >= localDownloadAttempts || s.ctx.Err() != nil {
+			tempFile.Close()
+			return nil, fmt.Errorf("downloading after %d attempts: %w", attempt, err)
+		}
+
+		if backoff == nil {
+			backoff = s.retryBackoff.clone()
+		}
+		delay := backoff.next()
+		s.logger.Warn("downloading blocks file failed, resuming", zap.String("filename", filename), zap.Int64("written", written), zap.Int("attempt", attempt), zap.Duration("retry_delay", delay), zap.Error(err))
+
+		select {
+		case <-s.ctx.Done():
+			tempFile.Close()
+			return nil, s.ctx.Err()
+		case <-s.after(delay):
+		}
+	}
+
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		tempFile.Close()
+		return nil, fmt.Errorf("rewinding local file: %w", err)
+	}
+	return tempFile, nil
+}
+
+// downloadFrom appends the content of `filename` after its first `written`
+// bytes to `file`, updating `written` as it goes
+func (s *FileSource) downloadFrom(blocksStore dstore.Store, filename string, file *tempFile, written *int64) error {
+	reader, err := s.openObject(blocksStore, filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if _, err := io.CopyN(io.Discard, reader, *written); err != nil {
+		return fmt.Errorf("skipping the %d bytes already downloaded: %w", *written, err)
+	}
+
+	n, err := io.Copy(file, reader)
+	*written += n
+	return err
+}
+
+// tempFile is a local copy of a blocks archive accounted in its localBuffer,
+// it is deleted when closed
+type tempFile struct {
+	file   *os.File
+	buffer *localBuffer
+	size   int64
+}
+
+func (f *tempFile) Read(p []byte) (int, error) {
+	return f.file.Read(p)
+}
+
+func (f *tempFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	f.buffer.add(int64(n))
+	return n, err
+}
+
+func (f *tempFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *tempFile) Close() error {
+	err := f.file.Close()
+	if removeErr := os.Remove(f.file.Name()); err == nil {
+		err = removeErr
+	}
+	f.buffer.add(-f.size)
+	f.size = 0
+	return err
+}
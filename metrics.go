@@ -23,7 +23,10 @@ var Metrics = dmetrics.NewSet(dmetrics.PrefixNameWith("bstream"))
 
 func WithHeadMetrics(h Handler, blkNum *dmetrics.HeadBlockNum, blkDrift *dmetrics.HeadTimeDrift) Handler {
 	return HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
-		blkDrift.SetBlockTime(blk.Time())
+		// the drift of a block without time would be the time since the epoch
+		if blk.HasTime() {
+			blkDrift.SetBlockTime(blk.Time())
+		}
 		blkNum.SetUint64(blk.Number)
 		return h.ProcessBlock(blk, obj)
 	})
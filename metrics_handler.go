@@ -0,0 +1,161 @@
+package bstream
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// HandlerMetrics receives the blocks processed by a MetricsHandler, it can be
+// called from many goroutines so implementations must be safe for concurrent use.
+type HandlerMetrics interface {
+	// ObserveBlock is called for each block once processed by the next handler,
+	// with the step of its object (0 when it does not implement Stepable), the
+	// time spent in the next handler and the error it returned
+	ObserveBlock(num uint64, step StepType, processingTime time.Duration, err error)
+}
+
+// MetricsHandler reports the blocks processed by the next handler to a
+// HandlerMetrics, see NewMetricsHandler
+type MetricsHandler struct {
+	next Handler
+	rec  HandlerMetrics
+
+	now func() time.Time
+}
+
+// NewMetricsHandler gives the blocks to `next`, reporting each one to `rec`
+// with the time `next` took to process it and its error, which is returned
+// unchanged. The time of the handlers down the chain is part of it.
+func NewMetricsHandler(next Handler, rec HandlerMetrics) *MetricsHandler {
+	return &MetricsHandler{
+		next: next,
+		rec:  rec,
+		now:  time.Now,
+	}
+}
+
+func (h *MetricsHandler) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
+	var step StepType
+	if stepable, ok := obj.(Stepable); ok {
+		step = stepable.Step()
+	}
+
+	start := h.now()
+	err := h.next.ProcessBlock(blk, obj)
+	h.rec.ObserveBlock(blk.Number, step, h.now().Sub(start), err)
+	return err
+}
+
+// handlerLatencyBounds are the upper bounds of the buckets of the latency
+// histogram of AtomicHandlerMetrics, a last bucket holds the slower blocks
+var handlerLatencyBounds = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// LatencyBucket is a bucket of the latency histogram of AtomicHandlerMetrics,
+// counting the blocks processed in more than the bound of the previous bucket
+// and at most UpperBound
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// AtomicHandlerMetrics is an in-memory HandlerMetrics, its zero value is ready to use
+type AtomicHandlerMetrics struct {
+	blocks         int64
+	errors         int64
+	processingTime int64
+	latencies      [len(handlerLatencyBounds) + 1]int64
+
+	lock         sync.Mutex
+	errorsByStep map[StepType]int64
+	lastErrorNum uint64
+	lastError    error
+}
+
+func (m *AtomicHandlerMetrics) ObserveBlock(num uint64, step StepType, processingTime time.Duration, err error) {
+	atomic.AddInt64(&m.blocks, 1)
+	atomic.AddInt64(&m.processingTime, int64(processingTime))
+
+	bucket := len(handlerLatencyBounds)
+	for i, bound := range handlerLatencyBounds {
+		if processingTime <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&m.latencies[bucket], 1)
+
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&m.errors, 1)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.errorsByStep == nil {
+		m.errorsByStep = make(map[StepType]int64)
+	}
+	m.errorsByStep[step]++
+	m.lastErrorNum = num
+	m.lastError = err
+}
+
+// TotalBlocks returns the amount of blocks processed, failed ones included
+func (m *AtomicHandlerMetrics) TotalBlocks() int64 {
+	return atomic.LoadInt64(&m.blocks)
+}
+
+// TotalErrors returns the amount of blocks whose processing failed
+func (m *AtomicHandlerMetrics) TotalErrors() int64 {
+	return atomic.LoadInt64(&m.errors)
+}
+
+// ErrorsByStep returns the amount of failed blocks of each step, the blocks
+// without step being under 0
+func (m *AtomicHandlerMetrics) ErrorsByStep() map[StepType]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	out := make(map[StepType]int64, len(m.errorsByStep))
+	for step, count := range m.errorsByStep {
+		out[step] = count
+	}
+	return out
+}
+
+// LastError returns the last error observed and the number of its block, nil
+// when no processing failed
+func (m *AtomicHandlerMetrics) LastError() (blockNum uint64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lastErrorNum, m.lastError
+}
+
+func (m *AtomicHandlerMetrics) TotalProcessingTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.processingTime))
+}
+
+// LatencyHistogram returns the amount of blocks by processing time, the last
+// bucket, of bound math.MaxInt64, holding the ones slower than 5 seconds
+func (m *AtomicHandlerMetrics) LatencyHistogram() []LatencyBucket {
+	out := make([]LatencyBucket, len(m.latencies))
+	for i := range m.latencies {
+		out[i].UpperBound = time.Duration(math.MaxInt64)
+		if i < len(handlerLatencyBounds) {
+			out[i].UpperBound = handlerLatencyBounds[i]
+		}
+		out[i].Count = atomic.LoadInt64(&m.latencies[i])
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package bstream
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	errUndo := errors.New("undo not supported")
+	durations := map[uint64]time.Duration{
+		1: 500 * time.Microsecond,
+		2: 3 * time.Millisecond,
+		3: 3 * time.Millisecond,
+		4: 200 * time.Millisecond,
+		5: 10 * time.Second,
+	}
+
+	clock := time.Unix(0, 0)
+	metrics := &AtomicHandlerMetrics{}
+	h := NewMetricsHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		clock = clock.Add(durations[blk.Number])
+		if stepable, ok := obj.(Stepable); ok && stepable.Step() == StepUndo {
+			return errUndo
+		}
+		return nil
+	}), metrics)
+	h.now = func() time.Time { return clock }
+
+	blocks := linearTestBlocks(1, 5)
+	require.NoError(t, h.ProcessBlock(blocks[0], testStep(StepNew)))
+	require.NoError(t, h.ProcessBlock(blocks[1], testStep(StepNewIrreversible)))
+	require.ErrorIs(t, h.ProcessBlock(blocks[2], testStep(StepUndo)), errUndo)
+	require.NoError(t, h.ProcessBlock(blocks[3], nil))
+	require.ErrorIs(t, h.ProcessBlock(blocks[4], testStep(StepUndo)), errUndo)
+
+	assert.Equal(t, int64(5), metrics.TotalBlocks())
+	assert.Equal(t, int64(2), metrics.TotalErrors())
+	assert.Equal(t, map[StepType]int64{StepUndo: 2}, metrics.ErrorsByStep())
+	assert.Equal(t, 10*time.Second+206500*time.Microsecond, metrics.TotalProcessingTime())
+
+	blockNum, err := metrics.LastError()
+	assert.Equal(t, uint64(5), blockNum)
+	assert.Equal(t, errUndo, err)
+
+	assert.Equal(t, []LatencyBucket{
+		{UpperBound: time.Millisecond, Count: 1},
+		{UpperBound: 5 * time.Millisecond, Count: 2},
+		{UpperBound: 10 * time.Millisecond},
+		{UpperBound: 50 * time.Millisecond},
+		{UpperBound: 100 * time.Millisecond},
+		{UpperBound: 500 * time.Millisecond, Count: 1},
+		{UpperBound: time.Second},
+		{UpperBound: 5 * time.Second},
+		{UpperBound: time.Duration(math.MaxInt64), Count: 1},
+	}, metrics.LatencyHistogram())
+}
+
+func TestAtomicHandlerMetrics_Zero(t *testing.T) {
+	metrics := &AtomicHandlerMetrics{}
+	blockNum, err := metrics.LastError()
+	assert.Equal(t, uint64(0), blockNum)
+	assert.NoError(t, err)
+	assert.Empty(t, metrics.ErrorsByStep())
+	assert.Len(t, metrics.LatencyHistogram(), len(handlerLatencyBounds)+1)
+}
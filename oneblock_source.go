@@ -1,10 +1,8 @@
 package bstream
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/streamingfast/dstore"
@@ -19,6 +17,7 @@ type oneBlocksSource struct {
 	handler       Handler
 	ctx           context.Context
 	skipperFunc   func(idSuffix string) bool
+	codec         BlockCodec
 }
 
 type OneBlocksSourceOption func(*oneBlocksSource)
@@ -30,6 +29,14 @@ func OneBlocksSourceWithSkipperFunc(f func(string) bool) OneBlocksSourceOption {
 	}
 }
 
+// OneBlocksSourceWithBlockCodec decodes the one-block files with the reader
+// factory of `codec` instead of DBinBlockReaderFactory
+func OneBlocksSourceWithBlockCodec(codec BlockCodec) OneBlocksSourceOption {
+	return func(s *oneBlocksSource) {
+		s.codec = codec
+	}
+}
+
 func NewOneBlocksSource(
 	lowestBlockNum uint64,
 	store dstore.Store,
@@ -82,15 +89,9 @@ func (s *oneBlocksSource) run() error {
 			return err
 		}
 
-		reader := bytes.NewReader(data)
-
-		blockReader, err := NewDBinBlockReader(reader)
+		blk, err := decodeOneblockfileData(s.codec.readerFactory(), data)
 		if err != nil {
-			return fmt.Errorf("unable to create block reader: %w", err)
-		}
-		blk, err := blockReader.Read()
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("block reader failed: %w", err)
+			return err
 		}
 
 		if err := s.handler.ProcessBlock(blk, nil); err != nil {
@@ -31,9 +31,9 @@ import (
 
 type OneBlockDownloaderFunc = func(ctx context.Context, oneBlockFile *OneBlockFile) (data []byte, err error)
 
-func decodeOneblockfileData(data []byte) (*pbbstream.Block, error) {
+func decodeOneblockfileData(readerFactory BlockReaderFactory, data []byte) (*pbbstream.Block, error) {
 	reader := bytes.NewReader(data)
-	blockReader, err := NewDBinBlockReader(reader)
+	blockReader, err := readerFactory.New(reader)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create block reader: %w", err)
 	}
@@ -0,0 +1,107 @@
+package bstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// PacedHandler delays the blocks given to its next handler, to replay the
+// history at a chosen speed rather than as fast as the store allows, for demos
+// and soak tests. See NewThrottledHandler and NewRealtimePaceHandler.
+//
+// Close interrupts its waits, the source being shut down: the block waited for
+// is not given to the next handler and ProcessBlock returns context.Canceled,
+// from then on too. The blocks are processed in turn, ProcessBlock is not safe
+// for concurrent use.
+type PacedHandler struct {
+	next Handler
+	// minInterval is the time between two blocks, when not paced by their timestamps
+	minInterval time.Duration
+	// speedup paces the blocks by their timestamps when set
+	speedup float64
+
+	lastSent      time.Time
+	lastBlockTime time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// now and after are overridden in tests to control time
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+}
+
+// NewThrottledHandler gives the blocks to `next` at least `minInterval` apart
+func NewThrottledHandler(next Handler, minInterval time.Duration) *PacedHandler {
+	return newPacedHandler(next, minInterval, 0)
+}
+
+// NewRealtimePaceHandler gives the blocks to `next` no faster than the gap
+// between their timestamps divided by `speedup`: 1 replays the blocks at the
+// pace they were produced, 10 ten times faster. The blocks without timestamp,
+// or older than the previous one, are not delayed. A speedup of 0 or less is 1.
+func NewRealtimePaceHandler(next Handler, speedup float64) *PacedHandler {
+	if speedup <= 0 {
+		speedup = 1
+	}
+	return newPacedHandler(next, 0, speedup)
+}
+
+func newPacedHandler(next Handler, minInterval time.Duration, speedup float64) *PacedHandler {
+	return &PacedHandler{
+		next:        next,
+		minInterval: minInterval,
+		speedup:     speedup,
+		done:        make(chan struct{}),
+		now:         time.Now,
+		after:       time.After,
+	}
+}
+
+func (h *PacedHandler) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
+	if !h.lastSent.IsZero() {
+		if wait := h.lastSent.Add(h.interval(blk)).Sub(h.now()); wait > 0 {
+			select {
+			case <-h.done:
+				return context.Canceled
+			case <-h.after(wait):
+			}
+		}
+	}
+	select {
+	case <-h.done:
+		return context.Canceled
+	default:
+	}
+
+	h.lastSent = h.now()
+	if blk.HasTime() {
+		h.lastBlockTime = blk.Time()
+	}
+	return h.next.ProcessBlock(blk, obj)
+}
+
+// interval returns the time to leave between the previous block and `blk`
+func (h *PacedHandler) interval(blk *pbbstream.Block) time.Duration {
+	if h.speedup == 0 {
+		return h.minInterval
+	}
+	if !blk.HasTime() || h.lastBlockTime.IsZero() {
+		return 0
+	}
+	gap := blk.Time().Sub(h.lastBlockTime)
+	if gap <= 0 {
+		return 0
+	}
+	return time.Duration(float64(gap) / h.speedup)
+}
+
+// Close interrupts the current and future waits, see PacedHandler
+func (h *PacedHandler) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+}
@@ -0,0 +1,89 @@
+package bstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottledHandler(t *testing.T) {
+	var sentAt []time.Time
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	h := NewThrottledHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		sentAt = append(sentAt, clock.Now())
+		return nil
+	}), time.Second)
+	h.now, h.after = clock.Now, clock.After
+
+	start := clock.Now()
+	for _, blk := range linearTestBlocks(1, 4) {
+		require.NoError(t, h.ProcessBlock(blk, nil))
+		if blk.Number == 2 {
+			// the handler took some time already
+			clock.now = clock.now.Add(400 * time.Millisecond)
+		}
+		if blk.Number == 3 {
+			clock.now = clock.now.Add(3 * time.Second)
+		}
+	}
+
+	assert.Equal(t, []time.Duration{time.Second, 600 * time.Millisecond}, clock.Waits())
+	assert.Equal(t, []time.Time{start, start.Add(time.Second), start.Add(2 * time.Second), start.Add(5 * time.Second)}, sentAt)
+}
+
+func TestRealtimePaceHandler(t *testing.T) {
+	blockTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	blocks := []*pbbstream.Block{
+		TestBlockWithTimestamp("00000001a", "00000000a", blockTime),
+		TestBlockWithTimestamp("00000002a", "00000001a", blockTime.Add(6*time.Second)),
+		TestBlockWithTimestamp("00000003a", "00000002a", blockTime.Add(18*time.Second)),
+		// without timestamp, then older than the previous one
+		TestBlockWithNumbers("00000004a", "00000003a", 4, 3),
+		TestBlockWithTimestamp("00000005a", "00000004a", blockTime.Add(12*time.Second)),
+		TestBlockWithTimestamp("00000006a", "00000005a", blockTime.Add(24*time.Second)),
+	}
+
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var sent []uint64
+	h := NewRealtimePaceHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		sent = append(sent, blk.Number)
+		return nil
+	}), 3)
+	h.now, h.after = clock.Now, clock.After
+
+	for _, blk := range blocks {
+		require.NoError(t, h.ProcessBlock(blk, nil))
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, sent)
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 4 * time.Second}, clock.Waits())
+}
+
+func TestPacedHandler_Close(t *testing.T) {
+	sent := 0
+	h := NewThrottledHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		sent++
+		return nil
+	}), time.Hour)
+
+	require.NoError(t, h.ProcessBlock(TestBlockWithNumbers("01a", "00a", 1, 0), nil))
+
+	done := make(chan error)
+	go func() {
+		done <- h.ProcessBlock(TestBlockWithNumbers("02a", "01a", 2, 1), nil)
+	}()
+	h.Close()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("the wait was not interrupted")
+	}
+
+	assert.Equal(t, context.Canceled, h.ProcessBlock(TestBlockWithNumbers("03a", "02a", 3, 2), nil))
+	assert.Equal(t, 1, sent)
+	h.Close()
+}
@@ -27,6 +27,7 @@ function main() {
   cd "$ROOT/pb" &> /dev/null
 
   generate "sf/bstream/v1/bstream.proto"
+  generate "sf/bstream/cursor/v1/cursor.proto"
 
   echo "generate.sh - `date` - `whoami`" > ./last_generate.txt
   echo "streamingfast/proto revision: `GIT_DIR=$ROOT/.git git rev-parse HEAD`" >> ./last_generate.txt
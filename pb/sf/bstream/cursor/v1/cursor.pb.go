@@ -0,0 +1,341 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.0
+// 	protoc        (unknown)
+// source: sf/bstream/cursor/v1/cursor.proto
+
+package pbcursor
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Step has the values of the bstream step types a cursor can be at
+type Step int32
+
+const (
+	Step_STEP_NONE             Step = 0
+	Step_STEP_NEW              Step = 1
+	Step_STEP_UNDO             Step = 2
+	Step_STEP_IRREVERSIBLE     Step = 16
+	Step_STEP_NEW_IRREVERSIBLE Step = 17
+	Step_STEP_STALLED          Step = 32
+)
+
+// Enum value maps for Step.
+var (
+	Step_name = map[int32]string{
+		0:  "STEP_NONE",
+		1:  "STEP_NEW",
+		2:  "STEP_UNDO",
+		16: "STEP_IRREVERSIBLE",
+		17: "STEP_NEW_IRREVERSIBLE",
+		32: "STEP_STALLED",
+	}
+	Step_value = map[string]int32{
+		"STEP_NONE":             0,
+		"STEP_NEW":              1,
+		"STEP_UNDO":             2,
+		"STEP_IRREVERSIBLE":     16,
+		"STEP_NEW_IRREVERSIBLE": 17,
+		"STEP_STALLED":          32,
+	}
+)
+
+func (x Step) Enum() *Step {
+	p := new(Step)
+	*p = x
+	return p
+}
+
+func (x Step) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Step) Descriptor() protoreflect.EnumDescriptor {
+	return file_sf_bstream_cursor_v1_cursor_proto_enumTypes[0].Descriptor()
+}
+
+func (Step) Type() protoreflect.EnumType {
+	return &file_sf_bstream_cursor_v1_cursor_proto_enumTypes[0]
+}
+
+func (x Step) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Step.Descriptor instead.
+func (Step) EnumDescriptor() ([]byte, []int) {
+	return file_sf_bstream_cursor_v1_cursor_proto_rawDescGZIP(), []int{0}
+}
+
+// Cursor is the structured form of the bstream cursor, for the services routing
+// on its block numbers without decoding the opaque string
+type Cursor struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Step      Step      `protobuf:"varint,1,opt,name=step,proto3,enum=sf.bstream.cursor.v1.Step" json:"step,omitempty"`
+	Block     *BlockRef `protobuf:"bytes,2,opt,name=block,proto3" json:"block,omitempty"`
+	HeadBlock *BlockRef `protobuf:"bytes,3,opt,name=head_block,json=headBlock,proto3" json:"head_block,omitempty"`
+	Lib       *BlockRef `protobuf:"bytes,4,opt,name=lib,proto3" json:"lib,omitempty"`
+	// chain_id identifies the chain of the cursor, it is not part of the bstream
+	// cursor and is left to the services streaming many chains
+	ChainId string `protobuf:"bytes,5,opt,name=chain_id,json=chainId,proto3" json:"chain_id,omitempty"`
+}
+
+func (x *Cursor) Reset() {
+	*x = Cursor{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sf_bstream_cursor_v1_cursor_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Cursor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cursor) ProtoMessage() {}
+
+func (x *Cursor) ProtoReflect() protoreflect.Message {
+	mi := &file_sf_bstream_cursor_v1_cursor_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cursor.ProtoReflect.Descriptor instead.
+func (*Cursor) Descriptor() ([]byte, []int) {
+	return file_sf_bstream_cursor_v1_cursor_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Cursor) GetStep() Step {
+	if x != nil {
+		return x.Step
+	}
+	return Step_STEP_NONE
+}
+
+func (x *Cursor) GetBlock() *BlockRef {
+	if x != nil {
+		return x.Block
+	}
+	return nil
+}
+
+func (x *Cursor) GetHeadBlock() *BlockRef {
+	if x != nil {
+		return x.HeadBlock
+	}
+	return nil
+}
+
+func (x *Cursor) GetLib() *BlockRef {
+	if x != nil {
+		return x.Lib
+	}
+	return nil
+}
+
+func (x *Cursor) GetChainId() string {
+	if x != nil {
+		return x.ChainId
+	}
+	return ""
+}
+
+type BlockRef struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Num uint64 `protobuf:"varint,1,opt,name=num,proto3" json:"num,omitempty"`
+	Id  string `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *BlockRef) Reset() {
+	*x = BlockRef{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sf_bstream_cursor_v1_cursor_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockRef) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockRef) ProtoMessage() {}
+
+func (x *BlockRef) ProtoReflect() protoreflect.Message {
+	mi := &file_sf_bstream_cursor_v1_cursor_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockRef.ProtoReflect.Descriptor instead.
+func (*BlockRef) Descriptor() ([]byte, []int) {
+	return file_sf_bstream_cursor_v1_cursor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *BlockRef) GetNum() uint64 {
+	if x != nil {
+		return x.Num
+	}
+	return 0
+}
+
+func (x *BlockRef) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+var File_sf_bstream_cursor_v1_cursor_proto protoreflect.FileDescriptor
+
+var file_sf_bstream_cursor_v1_cursor_proto_rawDesc = []byte{
+	0x0a, 0x21, 0x73, 0x66, 0x2f, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x2f, 0x63, 0x75, 0x72,
+	0x73, 0x6f, 0x72, 0x2f, 0x76, 0x31, 0x2f, 0x63, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x14, 0x73, 0x66, 0x2e, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x2e,
+	0x63, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x2e, 0x76, 0x31, 0x22, 0xfa, 0x01, 0x0a, 0x06, 0x43, 0x75,
+	0x72, 0x73, 0x6f, 0x72, 0x12, 0x2e, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x1a, 0x2e, 0x73, 0x66, 0x2e, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x2e,
+	0x63, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x65, 0x70, 0x52, 0x04,
+	0x73, 0x74, 0x65, 0x70, 0x12, 0x34, 0x0a, 0x05, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x73, 0x66, 0x2e, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x2e, 0x63, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b,
+	0x52, 0x65, 0x66, 0x52, 0x05, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x3d, 0x0a, 0x0a, 0x68, 0x65,
+	0x61, 0x64, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e,
+	0x2e, 0x73, 0x66, 0x2e, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x2e, 0x63, 0x75, 0x72, 0x73,
+	0x6f, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x66, 0x52, 0x09,
+	0x68, 0x65, 0x61, 0x64, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x30, 0x0a, 0x03, 0x6c, 0x69, 0x62,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x73, 0x66, 0x2e, 0x62, 0x73, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x2e, 0x63, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x42, 0x6c,
+	0x6f, 0x63, 0x6b, 0x52, 0x65, 0x66, 0x52, 0x03, 0x6c, 0x69, 0x62, 0x12, 0x19, 0x0a, 0x08, 0x63,
+	0x68, 0x61, 0x69, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63,
+	0x68, 0x61, 0x69, 0x6e, 0x49, 0x64, 0x22, 0x2c, 0x0a, 0x08, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x52,
+	0x65, 0x66, 0x12, 0x10, 0x0a, 0x03, 0x6e, 0x75, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x03, 0x6e, 0x75, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x02, 0x69, 0x64, 0x2a, 0x76, 0x0a, 0x04, 0x53, 0x74, 0x65, 0x70, 0x12, 0x0d, 0x0a, 0x09,
+	0x53, 0x54, 0x45, 0x50, 0x5f, 0x4e, 0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x53,
+	0x54, 0x45, 0x50, 0x5f, 0x4e, 0x45, 0x57, 0x10, 0x01, 0x12, 0x0d, 0x0a, 0x09, 0x53, 0x54, 0x45,
+	0x50, 0x5f, 0x55, 0x4e, 0x44, 0x4f, 0x10, 0x02, 0x12, 0x15, 0x0a, 0x11, 0x53, 0x54, 0x45, 0x50,
+	0x5f, 0x49, 0x52, 0x52, 0x45, 0x56, 0x45, 0x52, 0x53, 0x49, 0x42, 0x4c, 0x45, 0x10, 0x10, 0x12,
+	0x19, 0x0a, 0x15, 0x53, 0x54, 0x45, 0x50, 0x5f, 0x4e, 0x45, 0x57, 0x5f, 0x49, 0x52, 0x52, 0x45,
+	0x56, 0x45, 0x52, 0x53, 0x49, 0x42, 0x4c, 0x45, 0x10, 0x11, 0x12, 0x10, 0x0a, 0x0c, 0x53, 0x54,
+	0x45, 0x50, 0x5f, 0x53, 0x54, 0x41, 0x4c, 0x4c, 0x45, 0x44, 0x10, 0x20, 0x42, 0x43, 0x5a, 0x41,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x69, 0x6e, 0x67, 0x66, 0x61, 0x73, 0x74, 0x2f, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x2f, 0x70, 0x62, 0x2f, 0x73, 0x66, 0x2f, 0x62, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x2f, 0x63,
+	0x75, 0x72, 0x73, 0x6f, 0x72, 0x2f, 0x76, 0x31, 0x3b, 0x70, 0x62, 0x63, 0x75, 0x72, 0x73, 0x6f,
+	0x72, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_sf_bstream_cursor_v1_cursor_proto_rawDescOnce sync.Once
+	file_sf_bstream_cursor_v1_cursor_proto_rawDescData = file_sf_bstream_cursor_v1_cursor_proto_rawDesc
+)
+
+func file_sf_bstream_cursor_v1_cursor_proto_rawDescGZIP() []byte {
+	file_sf_bstream_cursor_v1_cursor_proto_rawDescOnce.Do(func() {
+		file_sf_bstream_cursor_v1_cursor_proto_rawDescData = protoimpl.X.CompressGZIP(file_sf_bstream_cursor_v1_cursor_proto_rawDescData)
+	})
+	return file_sf_bstream_cursor_v1_cursor_proto_rawDescData
+}
+
+var file_sf_bstream_cursor_v1_cursor_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_sf_bstream_cursor_v1_cursor_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_sf_bstream_cursor_v1_cursor_proto_goTypes = []interface{}{
+	(Step)(0),        // 0: sf.bstream.cursor.v1.Step
+	(*Cursor)(nil),   // 1: sf.bstream.cursor.v1.Cursor
+	(*BlockRef)(nil), // 2: sf.bstream.cursor.v1.BlockRef
+}
+var file_sf_bstream_cursor_v1_cursor_proto_depIdxs = []int32{
+	0, // 0: sf.bstream.cursor.v1.Cursor.step:type_name -> sf.bstream.cursor.v1.Step
+	2, // 1: sf.bstream.cursor.v1.Cursor.block:type_name -> sf.bstream.cursor.v1.BlockRef
+	2, // 2: sf.bstream.cursor.v1.Cursor.head_block:type_name -> sf.bstream.cursor.v1.BlockRef
+	2, // 3: sf.bstream.cursor.v1.Cursor.lib:type_name -> sf.bstream.cursor.v1.BlockRef
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_sf_bstream_cursor_v1_cursor_proto_init() }
+func file_sf_bstream_cursor_v1_cursor_proto_init() {
+	if File_sf_bstream_cursor_v1_cursor_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_sf_bstream_cursor_v1_cursor_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Cursor); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_sf_bstream_cursor_v1_cursor_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlockRef); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_sf_bstream_cursor_v1_cursor_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_sf_bstream_cursor_v1_cursor_proto_goTypes,
+		DependencyIndexes: file_sf_bstream_cursor_v1_cursor_proto_depIdxs,
+		EnumInfos:         file_sf_bstream_cursor_v1_cursor_proto_enumTypes,
+		MessageInfos:      file_sf_bstream_cursor_v1_cursor_proto_msgTypes,
+	}.Build()
+	File_sf_bstream_cursor_v1_cursor_proto = out.File
+	file_sf_bstream_cursor_v1_cursor_proto_rawDesc = nil
+	file_sf_bstream_cursor_v1_cursor_proto_goTypes = nil
+	file_sf_bstream_cursor_v1_cursor_proto_depIdxs = nil
+}
@@ -3,6 +3,9 @@ package pbbstream
 import (
 	"fmt"
 	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func (b *Block) Time() time.Time {
@@ -15,6 +18,29 @@ func (b *Block) Time() time.Time {
 
 	return b.Timestamp.AsTime()
 }
+
+// HasTime tells if the block has a timestamp, a valid one other than the zero
+// time or the Unix epoch, which producers write when they do not know the time
+func (b *Block) HasTime() bool {
+	if b == nil || b.Timestamp.CheckValid() != nil {
+		return false
+	}
+	t := b.Timestamp.AsTime()
+	return !t.IsZero() && t.Unix() != 0
+}
+
+// Age returns the time elapsed between the timestamp of the block and `now`,
+// negative when the block is timestamped in the future. It is 0 when the block
+// has no time, see HasTime.
+func (b *Block) Age(now time.Time) time.Duration {
+	if !b.HasTime() {
+		return 0
+	}
+	return now.Sub(b.Time())
+}
+
+// AsRef returns the reference of the block by value, so that it does not
+// allocate and always reflects the current ID and number of the block.
 func (b *Block) AsRef() BasicBlockRef {
 	if b == nil {
 		return BasicBlockRef{"", 0}
@@ -29,6 +55,37 @@ func (b *Block) PreviousRef() *BasicBlockRef {
 	return &BasicBlockRef{b.ParentId, b.ParentNum}
 }
 
+// Clone returns a deep copy of the block, payload bytes and metadata included,
+// for a handler modifying the block: the block it receives is shared with the
+// other handlers and can be retained, by a ForkDB for example (see the README).
+func (b *Block) Clone() *Block {
+	if b == nil {
+		return nil
+	}
+	return proto.Clone(b).(*Block)
+}
+
+// CloneRef returns a copy of the block without payload, with only the fields
+// identifying it in the chain: number, ID, parent, LIB, head and timestamp.
+func (b *Block) CloneRef() *Block {
+	if b == nil {
+		return nil
+	}
+
+	out := &Block{
+		Number:    b.Number,
+		Id:        b.Id,
+		ParentId:  b.ParentId,
+		ParentNum: b.ParentNum,
+		LibNum:    b.LibNum,
+		HeadNum:   b.HeadNum,
+	}
+	if b.Timestamp != nil {
+		out.Timestamp = &timestamppb.Timestamp{Seconds: b.Timestamp.Seconds, Nanos: b.Timestamp.Nanos}
+	}
+	return out
+}
+
 func (b *Block) ToBlocKMeta() *BlockMeta {
 	if b == nil {
 		return nil
@@ -0,0 +1,200 @@
+package pbbstream
+
+import (
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MetadataFieldNumber is the field of the encoded Block holding its metadata, as
+// a map<string, string> would be. It is not declared in the Block message, the
+// metadata is kept in the unknown fields of the block, where decoding puts it.
+const MetadataFieldNumber protowire.Number = 12
+
+// metaLock guards the metadata held in the unknown fields of the blocks
+var metaLock sync.RWMutex
+
+// SetMeta annotates the block with `value` under `key`. The metadata of a block
+// is not consensus data, it is local to the process: held by the block, it
+// follows it through the sources and handlers and is copied by Clone, not by
+// CloneRef. It is encoded with the block (see MetadataFieldNumber), so it is
+// part of its proto.Equal equality, but the writers only write it when asked to.
+//
+// The metadata accessors are safe for concurrent use, but not with the encoding
+// of the block: the handlers sharing a block must not annotate it while another
+// one writes it.
+func (b *Block) SetMeta(key, value string) {
+	metaLock.Lock()
+	defer metaLock.Unlock()
+
+	meta := b.parseMetadata()
+	if meta == nil {
+		meta = make(map[string]string, 1)
+	}
+	meta[key] = value
+	b.setMetadata(meta)
+}
+
+// GetMeta returns the metadata of the block under `key`, see SetMeta
+func (b *Block) GetMeta(key string) (value string, found bool) {
+	metaLock.RLock()
+	defer metaLock.RUnlock()
+	value, found = b.parseMetadata()[key]
+	return
+}
+
+// Metadata returns a copy of the metadata of the block, nil when it has none
+func (b *Block) Metadata() map[string]string {
+	metaLock.RLock()
+	defer metaLock.RUnlock()
+	return b.parseMetadata()
+}
+
+// ResetMeta drops the metadata of the block
+func (b *Block) ResetMeta() {
+	metaLock.Lock()
+	defer metaLock.Unlock()
+	b.setMetadata(nil)
+}
+
+// parseMetadata returns the metadata in the unknown fields of the block, nil
+// when it has none. The entries which cannot be parsed are ignored.
+func (b *Block) parseMetadata() map[string]string {
+	if b == nil {
+		return nil
+	}
+
+	var meta map[string]string
+	unknown := b.ProtoReflect().GetUnknown()
+	for len(unknown) > 0 {
+		num, typ, n := protowire.ConsumeField(unknown)
+		if n < 0 {
+			return meta
+		}
+		if num == MetadataFieldNumber && typ == protowire.BytesType {
+			_, _, tagLength := protowire.ConsumeTag(unknown)
+			entry, _ := protowire.ConsumeBytes(unknown[tagLength:])
+			if key, value, err := parseMetaEntry(entry); err == nil {
+				if meta == nil {
+					meta = make(map[string]string)
+				}
+				meta[key] = value
+			}
+		}
+		unknown = unknown[n:]
+	}
+	return meta
+}
+
+// setMetadata replaces the metadata in the unknown fields of the block by
+// `meta`, in the order of the keys
+func (b *Block) setMetadata(meta map[string]string) {
+	rest := TrimMetadata(b.ProtoReflect().GetUnknown())
+	if len(meta) == 0 {
+		b.ProtoReflect().SetUnknown(rest)
+		return
+	}
+
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	unknown := append([]byte(nil), rest...)
+	for _, key := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, meta[key])
+
+		unknown = protowire.AppendTag(unknown, MetadataFieldNumber, protowire.BytesType)
+		unknown = protowire.AppendBytes(unknown, entry)
+	}
+	b.ProtoReflect().SetUnknown(unknown)
+}
+
+// TrimMetadata returns `encoded`, an encoded block or its unknown fields,
+// without the metadata entries (see MetadataFieldNumber). It is returned as is
+// when it has none, or when it cannot be parsed.
+func TrimMetadata(encoded []byte) []byte {
+	var out []byte
+	found := false
+	for rest := encoded; len(rest) > 0; {
+		num, typ, n := protowire.ConsumeField(rest)
+		if n < 0 {
+			return encoded
+		}
+		if num == MetadataFieldNumber && typ == protowire.BytesType {
+			if !found {
+				out = append(make([]byte, 0, len(encoded)), encoded[:len(encoded)-len(rest)]...)
+				found = true
+			}
+		} else if found {
+			out = append(out, rest[:n]...)
+		}
+		rest = rest[n:]
+	}
+	if !found {
+		return encoded
+	}
+	return out
+}
+
+// UnknownFields returns a copy of the encoded fields of the block unknown to this
+// version of the protobuf definition, for diagnostics, the metadata excluded.
+// They are kept by the readers and written back by the writers, except for the
+// header only blocks which drop them.
+func (b *Block) UnknownFields() []byte {
+	if b == nil {
+		return nil
+	}
+	unknown := TrimMetadata(b.ProtoReflect().GetUnknown())
+	if len(unknown) == 0 {
+		return nil
+	}
+	return append([]byte(nil), unknown...)
+}
+
+// SetMetaEntry sets the metadata of an encoded map entry, see MetadataFieldNumber
+func (b *Block) SetMetaEntry(entry []byte) error {
+	key, value, err := parseMetaEntry(entry)
+	if err != nil {
+		return err
+	}
+	b.SetMeta(key, value)
+	return nil
+}
+
+func parseMetaEntry(entry []byte) (key, value string, err error) {
+	for len(entry) > 0 {
+		num, typ, n := protowire.ConsumeTag(entry)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		entry = entry[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, entry)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			entry = entry[n:]
+			continue
+		}
+
+		field, n := protowire.ConsumeString(entry)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		entry = entry[n:]
+		switch num {
+		case 1:
+			key = field
+		case 2:
+			value = field
+		}
+	}
+	return key, value, nil
+}
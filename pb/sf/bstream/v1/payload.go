@@ -0,0 +1,98 @@
+package pbbstream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// HeaderOnlyTypeURL is the type of the payload of the blocks read without their
+// payload, see IsHeaderOnly
+const HeaderOnlyTypeURL = "type.googleapis.com/sf.bstream.v1.HeaderOnly"
+
+// ErrHeaderOnly is returned when decoding the payload of a block read without it
+var ErrHeaderOnly = errors.New("block read without its payload (header only)")
+
+type decodedPayload struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+// PayloadBytes returns the encoded payload of the block, the legacy payload
+// buffer for the blocks read without the conversion of the readers. The bytes
+// are not copied, they must not be modified. It is empty for the header only blocks.
+//
+// There is nothing to cache: the payload of a block is never compressed on its
+// own, the blocks archives are decompressed as a whole when they are read (see
+// bstream.FileSourceWithCompression), so the bytes are returned as they are.
+func (b *Block) PayloadBytes() []byte {
+	if b.Payload == nil {
+		return b.PayloadBuffer
+	}
+	return b.Payload.Value
+}
+
+// PayloadSize returns the size in bytes of the encoded payload of the block, 0
+// for the header only blocks. It does not account for its decoded payload.
+func (b *Block) PayloadSize() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.PayloadBytes())
+}
+
+// IsHeaderOnly tells if the block was read without its payload, only with the
+// fields identifying it in the chain (number, ID, parent, LIB, head and timestamp)
+func (b *Block) IsHeaderOnly() bool {
+	return b.Payload != nil && b.Payload.TypeUrl == HeaderOnlyTypeURL
+}
+
+// DecodedBlock is a block whose payload is decoded once, for the handlers
+// sharing it: given as the object of the block, from a PreprocessFunc for
+// example, it is kept with the block by the Forkable and the handlers get it
+// from the WrappedObject of their ForkableObject. Its zero value, or a literal
+// like `&DecodedBlock{Block: blk}`, is ready to use.
+type DecodedBlock struct {
+	*Block
+
+	payload atomic.Pointer[decodedPayload]
+}
+
+func NewDecodedBlock(blk *Block) *DecodedBlock {
+	return &DecodedBlock{Block: blk}
+}
+
+// currentPayload returns the decoded payload, set on the first call
+// for the blocks built without NewDecodedBlock
+func (b *DecodedBlock) currentPayload() *decodedPayload {
+	if payload := b.payload.Load(); payload != nil {
+		return payload
+	}
+	b.payload.CompareAndSwap(nil, &decodedPayload{})
+	return b.payload.Load()
+}
+
+// Decoded returns the payload of the block decoded by `decoder`, which is called
+// once: the following calls return the value or error of the first one,
+// whatever their decoder, until ReleasePayload is called. It is safe for
+// concurrent use.
+//
+// It returns ErrHeaderOnly for the blocks read without their payload.
+func (b *DecodedBlock) Decoded(decoder func([]byte) (interface{}, error)) (interface{}, error) {
+	if b.IsHeaderOnly() {
+		return nil, ErrHeaderOnly
+	}
+	payload := b.currentPayload()
+	payload.once.Do(func() {
+		payload.value, payload.err = decoder(b.PayloadBytes())
+	})
+	return payload.value, payload.err
+}
+
+// ReleasePayload drops the decoded payload, for the blocks retained a long time,
+// like the ones of a ForkDB, not to keep it in memory. The next call to Decoded
+// decodes the payload again.
+func (b *DecodedBlock) ReleasePayload() {
+	b.payload.Store(&decodedPayload{})
+}
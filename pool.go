@@ -0,0 +1,91 @@
+package bstream
+
+import (
+	"sync"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// BlockPool recycles the blocks read from the blocks files, so that replaying many
+// blocks does not allocate a new block (with its payload and timestamp messages)
+// for each of them. The bytes of the payload are not recycled, the protobuf
+// decoding always allocates them.
+//
+// A block released to the pool is reused by a later Get, it must not be retained
+// by anything once released: see FileSourceWithBlockPool and forkable.WithBlockPool
+// for who releases the blocks.
+type BlockPool struct {
+	blocks sync.Pool
+}
+
+func NewBlockPool() *BlockPool {
+	return &BlockPool{}
+}
+
+// Get returns an empty block, a released one when there is any
+func (p *BlockPool) Get() *pbbstream.Block {
+	blk := p.get()
+	blk.Payload = nil
+	blk.Timestamp = nil
+	return blk
+}
+
+// get returns an empty block, keeping the empty payload and timestamp messages
+// of a released block for the decoding to reuse them
+func (p *BlockPool) get() *pbbstream.Block {
+	if blk, ok := p.blocks.Get().(*pbbstream.Block); ok {
+		return blk
+	}
+	return &pbbstream.Block{}
+}
+
+// Release gives `blk` back to the pool, its metadata is dropped too. A nil
+// block is ignored.
+func (p *BlockPool) Release(blk *pbbstream.Block) {
+	if blk == nil {
+		return
+	}
+
+	payload, timestamp := blk.Payload, blk.Timestamp
+	blk.Reset()
+	if payload != nil {
+		payload.Reset()
+		blk.Payload = payload
+	}
+	if timestamp != nil {
+		timestamp.Reset()
+		blk.Timestamp = timestamp
+	}
+	p.blocks.Put(blk)
+}
+
+// decode decodes `message` in a block of the pool, like proto.Unmarshal would in
+// a new block, except for a block without timestamp which gets an empty one
+func (p *BlockPool) decode(message []byte) (*pbbstream.Block, error) {
+	blk := p.get()
+	if blk.Payload == nil {
+		blk.Payload = &anypb.Any{}
+	}
+	if blk.Timestamp == nil {
+		blk.Timestamp = &timestamppb.Timestamp{}
+	}
+
+	// merging keeps the payload and timestamp messages, which are filled instead of allocated
+	if err := (proto.UnmarshalOptions{Merge: true}).Unmarshal(message, blk); err != nil {
+		p.Release(blk)
+		return nil, err
+	}
+	if blk.Payload.TypeUrl == "" && len(blk.Payload.Value) == 0 {
+		// legacy blocks have no payload, supportLegacy looks for the nil one
+		blk.Payload = nil
+	}
+	return blk, nil
+}
+
+// PooledDBinBlockReaderFactory is DBinBlockReaderFactory with the blocks taken from `pool`
+func PooledDBinBlockReaderFactory(pool *BlockPool) BlockReaderFactory {
+	return dbinBlockReaderFactory{pool: pool}
+}
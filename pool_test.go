@@ -0,0 +1,115 @@
+package bstream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestBlockPool_Reader(t *testing.T) {
+	legacy := &pbbstream.Block{Id: "3a", Number: 3, ParentId: "2a", PayloadKind: pbbstream.Protocol_ETH, PayloadBuffer: []byte{0x01}}
+	data := testBlocks(
+		TestBlockWithTimestamp("1a", "00", time.Unix(1700000000, 0)),
+		TestBlockWithNumbers("2a", "1a", 2, 1),
+		legacy,
+	)
+
+	read := func(factory BlockReaderFactory, release func(*pbbstream.Block)) (out []*pbbstream.Block, pointers map[*pbbstream.Block]bool) {
+		pointers = make(map[*pbbstream.Block]bool)
+		reader, err := factory.New(bytes.NewReader(data))
+		require.NoError(t, err)
+		for {
+			blk, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			require.NoError(t, err)
+			pointers[blk] = true
+			out = append(out, proto.Clone(blk).(*pbbstream.Block))
+			release(blk)
+		}
+	}
+
+	expected, _ := read(DBinBlockReaderFactory, func(*pbbstream.Block) {})
+	pool := NewBlockPool()
+	actual, pointers := read(PooledDBinBlockReaderFactory(pool), pool.Release)
+
+	require.Len(t, actual, 3)
+	for i := range expected {
+		if expected[i].Timestamp == nil {
+			// blocks without timestamp get an empty one from the pool
+			expected[i].Timestamp = &timestamppb.Timestamp{}
+		}
+		assert.True(t, proto.Equal(expected[i], actual[i]), "block %d: expected %s, got %s", i, expected[i], actual[i])
+	}
+	assert.Less(t, len(pointers), 3, "blocks are reused")
+}
+
+func TestBlockPool_Release(t *testing.T) {
+	pool := NewBlockPool()
+	pool.Release(nil)
+
+	blk := TestBlockWithTimestamp("1a", "00", time.Unix(1700000000, 0))
+	pool.Release(blk)
+	assert.Equal(t, "", blk.Id)
+	assert.Equal(t, int64(0), blk.Timestamp.GetSeconds())
+
+	got := pool.Get()
+	assert.Nil(t, got.Payload)
+	assert.Nil(t, got.Timestamp)
+	assert.Equal(t, "", got.Id)
+}
+
+// BenchmarkBlockPool_Replay reads and releases the blocks of a 100k-block replay
+func BenchmarkBlockPool_Replay(b *testing.B) {
+	const blockCount = 100_000
+
+	buf := &bytes.Buffer{}
+	writer, err := NewDBinBlockWriter(buf)
+	require.NoError(b, err)
+	prevID := "00"
+	for num := uint64(1); num <= blockCount; num++ {
+		blk := TestBlockWithTimestamp(fmt.Sprintf("%08xa", num), prevID, time.Unix(1700000000+int64(num), 0))
+		blk.Number = num
+		blk.Payload.Value = bytes.Repeat([]byte{0x01}, 256)
+		require.NoError(b, writer.Write(blk))
+		prevID = blk.Id
+	}
+	data := buf.Bytes()
+
+	replay := func(b *testing.B, factory BlockReaderFactory, release func(*pbbstream.Block)) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reader, err := factory.New(bytes.NewReader(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+			for {
+				blk, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				release(blk)
+			}
+		}
+	}
+
+	b.Run("new blocks", func(b *testing.B) {
+		replay(b, DBinBlockReaderFactory, func(*pbbstream.Block) {})
+	})
+	b.Run("pooled blocks", func(b *testing.B) {
+		pool := NewBlockPool()
+		replay(b, PooledDBinBlockReaderFactory(pool), pool.Release)
+	})
+}
@@ -74,6 +74,9 @@ func (fobj *preprocessedForkableObject) WrappedObject() interface{} {
 }
 
 func (fobj *preprocessedForkableObject) Cursor() *Cursor {
+	if fobj.cursor == nil {
+		return EmptyCursor
+	}
 	return fobj.cursor
 }
 
@@ -82,7 +85,7 @@ func (fobj *preprocessedForkableObject) ReorgJunctionBlock() BlockRef {
 }
 
 func (fobj *preprocessedForkableObject) FinalBlockHeight() uint64 {
-	if fobj.cursor.LIB == nil {
+	if fobj.cursor == nil || fobj.cursor.LIB == nil {
 		return 0
 	}
 	return fobj.cursor.LIB.Num()
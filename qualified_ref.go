@@ -0,0 +1,117 @@
+package bstream
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QualifiedBlockRef is a BlockRef qualified by the ID of the chain of the block,
+// for the tools handling the blocks of several networks, where a bare number
+// and ID is ambiguous. It is a BlockRef itself and, being comparable, can be
+// used as a map key. An empty chain ID matches the blocks of any chain, see
+// EqualsQualifiedBlockRefs.
+type QualifiedBlockRef struct {
+	chainID string
+	ref     BasicBlockRef
+}
+
+func NewQualifiedBlockRef(chainID, id string, num uint64) QualifiedBlockRef {
+	return QualifiedBlockRef{chainID: chainID, ref: NewBlockRef(id, num)}
+}
+
+// QualifyBlockRef returns `ref` qualified by `chainID`, a nil ref gives the
+// empty ref of the chain
+func QualifyBlockRef(chainID string, ref BlockRef) QualifiedBlockRef {
+	if ref == nil {
+		return QualifiedBlockRef{chainID: chainID}
+	}
+	return NewQualifiedBlockRef(chainID, ref.ID(), ref.Num())
+}
+
+// NewQualifiedBlockRefFromString parses a qualified block ref written in its
+// canonical form `<chain>#<num> (<id>)`, as returned by String(), in the compact
+// form `<chain>#<num>:<id>` or as `<chain>#<num>` which gives a ref without ID.
+// The chain is optional, it cannot contain `#` nor spaces: the ref without chain
+// matches any chain, the forms of NewBlockRefFromString are accepted for it.
+func NewQualifiedBlockRefFromString(s string) (QualifiedBlockRef, error) {
+	in := strings.TrimSpace(s)
+	chainID, rest, found := strings.Cut(in, "#")
+	if !found {
+		ref, err := NewBlockRefFromString(in)
+		if err != nil {
+			return QualifiedBlockRef{}, err
+		}
+		return QualifyBlockRef("", ref), nil
+	}
+	if i := strings.IndexAny(chainID, " \t\r\n"); i != -1 {
+		return QualifiedBlockRef{}, fmt.Errorf("invalid qualified block ref %q: invalid character %q in chain id", s, chainID[i])
+	}
+
+	if strings.Contains(rest, "(") {
+		// the canonical form of the ref
+		rest = "#" + rest
+	}
+	ref, err := NewBlockRefFromString(rest)
+	if err != nil {
+		return QualifiedBlockRef{}, fmt.Errorf("invalid qualified block ref %q: %w", s, err)
+	}
+	return QualifyBlockRef(chainID, ref), nil
+}
+
+func (r QualifiedBlockRef) ID() string {
+	return r.ref.id
+}
+
+func (r QualifiedBlockRef) Num() uint64 {
+	return r.ref.num
+}
+
+// ChainID returns the ID of the chain of the block, empty for any chain
+func (r QualifiedBlockRef) ChainID() string {
+	return r.chainID
+}
+
+// BlockRef returns the ref without its chain
+func (r QualifiedBlockRef) BlockRef() BasicBlockRef {
+	return r.ref
+}
+
+// String returns the ref in the form `<chain>#<num> (<id>)`, `<chain>#<num>`
+// without ID. Without chain, it is the form of the BlockRef `#<num> (<id>)`.
+func (r QualifiedBlockRef) String() string {
+	if r.ref.id == "" {
+		return fmt.Sprintf("%s#%d", r.chainID, r.ref.num)
+	}
+	return fmt.Sprintf("%s#%d (%s)", r.chainID, r.ref.num, r.ref.id)
+}
+
+// Matches returns whether `other` is the same block, of the same chain unless
+// one of them has no chain ID, see EqualsQualifiedBlockRefs
+func (r QualifiedBlockRef) Matches(other BlockRef) bool {
+	return EqualsQualifiedBlockRefs(r, other)
+}
+
+// EqualsQualifiedBlockRefs is EqualsBlockRefs also comparing the chains of the
+// QualifiedBlockRef: the refs of two different chains are not equal, an empty
+// chain ID or a BlockRef without chain matching any chain.
+func EqualsQualifiedBlockRefs(left, right BlockRef) bool {
+	if leftChain, rightChain := blockRefChainID(left), blockRefChainID(right); leftChain != "" && rightChain != "" && leftChain != rightChain {
+		return false
+	}
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	return left.Num() == right.Num() && left.ID() == right.ID()
+}
+
+func blockRefChainID(ref BlockRef) string {
+	switch qualified := ref.(type) {
+	case QualifiedBlockRef:
+		return qualified.chainID
+	case *QualifiedBlockRef:
+		if qualified != nil {
+			return qualified.chainID
+		}
+	}
+	return ""
+}
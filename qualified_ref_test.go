@@ -0,0 +1,103 @@
+package bstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQualifiedBlockRef(t *testing.T) {
+	ref := NewQualifiedBlockRef("sepolia", "05a", 5)
+	assert.Equal(t, "sepolia", ref.ChainID())
+	assert.Equal(t, "05a", ref.ID())
+	assert.Equal(t, uint64(5), ref.Num())
+	assert.Equal(t, NewBlockRef("05a", 5), ref.BlockRef())
+	assert.Equal(t, "sepolia#5 (05a)", ref.String())
+
+	var blockRef BlockRef = ref
+	assert.Equal(t, ref, QualifyBlockRef("sepolia", blockRef))
+	assert.Equal(t, "#5 (05a)", QualifyBlockRef("", NewBlockRef("05a", 5)).String(), "the form of the BlockRef")
+	assert.Equal(t, "sepolia#5", QualifyBlockRef("sepolia", NewBlockRef("", 5)).String())
+	assert.Equal(t, "mainnet#0", QualifyBlockRef("mainnet", nil).String())
+}
+
+func TestEqualsQualifiedBlockRefs(t *testing.T) {
+	mainnet := NewQualifiedBlockRef("mainnet", "05a", 5)
+
+	tests := []struct {
+		name     string
+		left     BlockRef
+		right    BlockRef
+		expected bool
+	}{
+		{name: "same chain", left: mainnet, right: NewQualifiedBlockRef("mainnet", "05a", 5), expected: true},
+		{name: "pointer", left: mainnet, right: &mainnet, expected: true},
+		{name: "other chain", left: mainnet, right: NewQualifiedBlockRef("sepolia", "05a", 5)},
+		{name: "other block", left: mainnet, right: NewQualifiedBlockRef("mainnet", "05b", 5)},
+		{name: "empty chain is a wildcard", left: mainnet, right: NewQualifiedBlockRef("", "05a", 5), expected: true},
+		{name: "bare block ref is a wildcard", left: NewBlockRef("05a", 5), right: mainnet, expected: true},
+		{name: "bare block ref of another block", left: NewBlockRef("05a", 6), right: mainnet},
+		{name: "nil", left: mainnet},
+		{name: "both nil", expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, EqualsQualifiedBlockRefs(test.left, test.right))
+			assert.Equal(t, test.expected, EqualsQualifiedBlockRefs(test.right, test.left))
+		})
+	}
+	assert.True(t, mainnet.Matches(NewBlockRef("05a", 5)))
+}
+
+func TestQualifiedBlockRef_MapKey(t *testing.T) {
+	seen := map[QualifiedBlockRef]int{}
+	seen[NewQualifiedBlockRef("mainnet", "05a", 5)]++
+	seen[NewQualifiedBlockRef("sepolia", "05a", 5)]++
+	seen[QualifyBlockRef("mainnet", NewBlockRef("05a", 5))]++
+
+	assert.Equal(t, map[QualifiedBlockRef]int{
+		NewQualifiedBlockRef("mainnet", "05a", 5): 2,
+		NewQualifiedBlockRef("sepolia", "05a", 5): 1,
+	}, seen)
+}
+
+func TestNewQualifiedBlockRefFromString(t *testing.T) {
+	tests := []struct {
+		in          string
+		expected    QualifiedBlockRef
+		expectedErr string
+	}{
+		{in: "mainnet#123 (abcdef)", expected: NewQualifiedBlockRef("mainnet", "abcdef", 123)},
+		{in: "mainnet#123:abcdef", expected: NewQualifiedBlockRef("mainnet", "abcdef", 123)},
+		{in: " mainnet#123\n", expected: NewQualifiedBlockRef("mainnet", "", 123)},
+		{in: "#123 (abcdef)", expected: NewQualifiedBlockRef("", "abcdef", 123)},
+		{in: "#123", expected: NewQualifiedBlockRef("", "", 123)},
+		{in: "123:abcdef", expected: NewQualifiedBlockRef("", "abcdef", 123)},
+		{in: "Block <empty>", expected: NewQualifiedBlockRef("", "", 0)},
+
+		{in: "main net#123 (abcdef)", expectedErr: `invalid character ' ' in chain id`},
+		{in: "mainnet#123 (ab cd)", expectedErr: `invalid character ' ' in block id`},
+		{in: "mainnet#", expectedErr: "missing block number"},
+		{in: "mainnet#123 ()", expectedErr: "empty block id"},
+		{in: "mainnet#abc", expectedErr: `block number "abc" is not a decimal number`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ref, err := NewQualifiedBlockRefFromString(test.in)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ref)
+
+			parsed, err := NewQualifiedBlockRefFromString(ref.String())
+			require.NoError(t, err, "round trip of %q", ref.String())
+			assert.Equal(t, ref, parsed)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a rate limiter refilled with `rate` tokens per second up to
+// `burst` tokens. Tokens are reserved before waiting so that concurrent callers
+// are paced as a whole.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	// now is overridden in tests to control time
+	now func() time.Time
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		now:    time.Now,
+		tokens: burst,
+	}
+}
+
+// reserve takes `n` tokens and returns how long to wait before using them
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait takes `n` tokens, waiting until they are available. It returns false if
+// `done` is closed before.
+func (b *tokenBucket) wait(n float64, done <-chan struct{}, after func(d time.Duration) <-chan time.Time) bool {
+	delay := b.reserve(n)
+	if delay <= 0 {
+		return true
+	}
+
+	select {
+	case <-done:
+		return false
+	case <-after(delay):
+		return true
+	}
+}
+
+// limitedReader paces the reads of a blocks archive with the source's bytes limiter
+type limitedReader struct {
+	io.Reader
+	limiter *tokenBucket
+	source  *FileSource
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && !r.limiter.wait(float64(n), r.source.Terminating(), r.source.after) {
+		return n, context.Canceled
+	}
+	return n, err
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bstream
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is advanced by the waits it is asked for
+type fakeClock struct {
+	lock  sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+		c.waits = append(c.waits, d)
+	}
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+func (c *fakeClock) Waits() []time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	bucket := newTokenBucket(10, 2)
+	bucket.now = clock.Now
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, bucket.wait(1, nil, clock.After))
+	}
+	// the burst is used first
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}, clock.Waits())
+
+	// refilled up to the burst only
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), bucket.reserve(2))
+	assert.Equal(t, 100*time.Millisecond, bucket.reserve(1))
+	// reserved tokens are accounted for the next callers
+	assert.Equal(t, 200*time.Millisecond, bucket.reserve(1))
+}
+
+func TestTokenBucket_WaitInterrupted(t *testing.T) {
+	bucket := newTokenBucket(0.001, 1)
+	assert.True(t, bucket.wait(1, nil, time.After))
+
+	done := make(chan struct{})
+	close(done)
+	assert.False(t, bucket.wait(1, done, time.After))
+}
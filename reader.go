@@ -15,6 +15,8 @@
 package bstream
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -22,20 +24,163 @@ import (
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
 
 	"github.com/streamingfast/dbin"
+	"google.golang.org/protobuf/encoding/protowire"
 	proto "google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// BlockReader reads the blocks of a blocks file in turn, returning io.EOF after the last one.
+type BlockReader interface {
+	Read() (*pbbstream.Block, error)
+}
+
+// BlockReaderFactory creates the BlockReader decoding the content of a blocks file.
+type BlockReaderFactory interface {
+	New(reader io.Reader) (BlockReader, error)
+}
+
+type BlockReaderFactoryFunc func(reader io.Reader) (BlockReader, error)
+
+func (f BlockReaderFactoryFunc) New(reader io.Reader) (BlockReader, error) {
+	return f(reader)
+}
+
+// SeekingBlockReaderFactory is a BlockReaderFactory which can start reading a
+// blocks file at a given block, the FileSource uses it to skip the blocks it
+// would not send when the blocks file is seekable.
+type SeekingBlockReaderFactory interface {
+	BlockReaderFactory
+	NewFrom(reader io.Reader, fromBlockNum uint64) (BlockReader, error)
+}
+
+// DBinBlockReaderFactory creates DBinBlockReader instances, it is used when no
+// other BlockReaderFactory is given. It is a SeekingBlockReaderFactory. The
+// blocks files are given to the factory registered for their header instead
+// when there are any, see RegisterBlockReaderFactory.
+var DBinBlockReaderFactory BlockReaderFactory = dbinBlockReaderFactory{dispatch: true}
+
+// HeaderOnlyDBinBlockReaderFactory creates DBinBlockReader instances reading the
+// blocks with ReadHeader, see FileSourceWithHeaderOnly
+var HeaderOnlyDBinBlockReaderFactory BlockReaderFactory = dbinBlockReaderFactory{headerOnly: true}
+
+type dbinBlockReaderFactory struct {
+	pool       *BlockPool
+	headerOnly bool
+	// dispatch reads the files with the registered factories, see RegisterBlockReaderFactory
+	dispatch bool
+}
+
+// registered returns the factory registered for the file read by `reader`, with
+// the reader to give it, nil to read the file with `f`
+func (f dbinBlockReaderFactory) registered(reader io.Reader) (BlockReaderFactory, io.Reader, error) {
+	if !f.dispatch {
+		return nil, reader, nil
+	}
+	registered, reader, err := registeredBlockReaderFactory(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if registered == DBinBlockReaderFactory {
+		return nil, reader, nil
+	}
+	return registered, reader, nil
+}
+
+func (f dbinBlockReaderFactory) New(reader io.Reader) (BlockReader, error) {
+	registered, reader, err := f.registered(reader)
+	if err != nil {
+		return nil, err
+	}
+	if registered != nil {
+		return registered.New(reader)
+	}
+
+	out, err := NewDBinBlockReaderWithPool(reader, f.pool)
+	if err != nil {
+		return nil, err
+	}
+	out.headerOnly = f.headerOnly
+	return out, nil
+}
+
+func (f dbinBlockReaderFactory) NewFrom(reader io.Reader, fromBlockNum uint64) (BlockReader, error) {
+	registered, reader, err := f.registered(reader)
+	if err != nil {
+		return nil, err
+	}
+	if seeking, ok := registered.(SeekingBlockReaderFactory); ok {
+		return seeking.NewFrom(reader, fromBlockNum)
+	}
+	if registered != nil {
+		return registered.New(reader)
+	}
+
+	out, err := newSeekableBlockReader(reader, fromBlockNum, f.pool)
+	if err != nil {
+		return nil, err
+	}
+	out.headerOnly = f.headerOnly
+	return out, nil
+}
+
 // DBinBlockReader reads the dbin format where each element is assumed to be a `Block`.
 type DBinBlockReader struct {
 	src    *dbin.Reader
 	Header *dbin.Header
+
+	// pool provides the blocks when set, see NewDBinBlockReaderWithPool
+	pool *BlockPool
+	// headerOnly makes Read read the blocks with ReadHeader
+	headerOnly bool
+
+	// offset is where the next message starts in the file
+	offset                int64
+	tolerateTruncatedTail bool
+	truncatedTail         *TruncatedTailError
+}
+
+// TruncatedTailError is returned when a blocks file ends in the middle of its
+// last message, like the files of a merger which crashed while writing them
+type TruncatedTailError struct {
+	// Offset is where the truncated message starts in the file
+	Offset int64
+	Err    error
+}
+
+func (e *TruncatedTailError) Error() string {
+	return fmt.Sprintf("blocks file truncated in its last message at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *TruncatedTailError) Unwrap() error {
+	return e.Err
+}
+
+// TruncatedTailTolerant is implemented by the BlockReaders able to end the blocks
+// of a file truncated in its last message, see FileSourceWithTolerateTruncatedTail
+type TruncatedTailTolerant interface {
+	// TolerateTruncatedTail makes the reader return io.EOF instead of a
+	// TruncatedTailError, after the complete blocks of the file
+	TolerateTruncatedTail()
+	// TruncatedTail returns the truncation tolerated, nil if there was none
+	TruncatedTail() *TruncatedTailError
 }
 
 func NewDBinBlockReader(reader io.Reader) (out *DBinBlockReader, err error) {
 	return NewDBinBlockReaderWithValidation(reader, nil)
 }
 
+// NewDBinBlockReaderWithPool returns a DBinBlockReader decoding the blocks in
+// blocks taken from `pool`, the reader of the blocks releases them once done
+func NewDBinBlockReaderWithPool(reader io.Reader, pool *BlockPool) (out *DBinBlockReader, err error) {
+	out, err = NewDBinBlockReaderWithValidation(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	out.pool = pool
+	return out, nil
+}
+
 func NewDBinBlockReaderWithValidation(reader io.Reader, validateHeaderFunc func(contentType string) error) (out *DBinBlockReader, err error) {
 	dbinReader := dbin.NewReader(reader)
 	header, err := dbinReader.ReadHeader()
@@ -53,22 +198,53 @@ func NewDBinBlockReaderWithValidation(reader io.Reader, validateHeaderFunc func(
 	return &DBinBlockReader{
 		src:    dbinReader,
 		Header: header,
+		offset: int64(len(header.RawBytes)),
 	}, nil
 }
 
+// Read reads the next block, verifying the checksum of the blocks written with
+// one (see DBinBlockWriterWithChecksum): a block not matching it is returned
+// in a ChecksumError.
 func (l *DBinBlockReader) Read() (*pbbstream.Block, error) {
+	if l.headerOnly {
+		return l.ReadHeader()
+	}
+
 	return readMessage(l, func(message []byte) (*pbbstream.Block, error) {
-		blk := new(pbbstream.Block)
+		body, checksum, checked := splitChecksum(message)
+		blk, err := l.decode(body)
+		if checked {
+			offset := l.offset - 4 - int64(len(message))
+			if err := verifyChecksum(body, checksum, offset, blk); err != nil {
+				return nil, err
+			}
+		}
+		return blk, err
+	})
+}
+
+func (l *DBinBlockReader) decode(message []byte) (*pbbstream.Block, error) {
+	var blk *pbbstream.Block
+	if l.pool != nil {
+		var err error
+		if blk, err = l.pool.decode(message); err != nil {
+			return nil, fmt.Errorf("unable to read block proto: %s", err)
+		}
+	} else {
+		blk = new(pbbstream.Block)
 		if err := proto.Unmarshal(message, blk); err != nil {
 			return nil, fmt.Errorf("unable to read block proto: %s", err)
 		}
+	}
 
-		if err := supportLegacy(blk); err != nil {
-			return nil, fmt.Errorf("support legacy block: %s", err)
-		}
+	if err := supportLegacy(blk); err != nil {
+		return nil, fmt.Errorf("support legacy block: %s", err)
+	}
+	if err := CheckBlockID(blk); err != nil {
+		return nil, err
+	}
 
-		return blk, nil
-	})
+	return blk, nil
 }
 
 // ReadAsBlockMeta reads the next message as a BlockMeta instead of as a Block leading
@@ -91,19 +267,205 @@ func (l *DBinBlockReader) ReadAsBlockMeta() (*pbbstream.BlockMeta, error) {
 	})
 }
 
-func readMessage[T any](reader *DBinBlockReader, decoder func(message []byte) (T, error)) (out T, err error) {
-	message, err := reader.src.ReadMessage()
-	if len(message) > 0 {
-		return decoder(message)
+// ReadHeader reads the next block without its payload, whose bytes are skipped
+// without being read in memory: only the number, ID, parent, LIB, head,
+// timestamp and metadata of the block are set. The payload accessors of the block return
+// pbbstream.ErrHeaderOnly. The checksum of the block is not verified.
+func (l *DBinBlockReader) ReadHeader() (*pbbstream.Block, error) {
+	length, err := l.nextLength()
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		// the end of the blocks, the index footer follows
+		return nil, io.EOF
+	}
+
+	message := &io.LimitedReader{R: l.src, N: int64(length)}
+	blk, err := readBlockHeader(&byteReader{Reader: message})
+	if message.N > 0 && (err == nil || errors.Is(err, io.ErrUnexpectedEOF)) {
+		// the file ended before the message, the fields read so far can be complete
+		return nil, l.truncated(fmt.Errorf("incomplete message, got %d of %d bytes", int64(length)-message.N, length))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read block header: %s", err)
+	}
+	l.offset += 4 + int64(length)
+	if err := CheckBlockID(blk); err != nil {
+		return nil, err
+	}
+	return blk, nil
+}
+
+// readBlockHeader decodes the fields of the encoded pbbstream.Block of `reader`
+// except the payload ones, which are discarded
+func readBlockHeader(reader *byteReader) (*pbbstream.Block, error) {
+	blk := &pbbstream.Block{Payload: &anypb.Any{TypeUrl: pbbstream.HeaderOnlyTypeURL}}
+	legacy := true
+	for {
+		tag, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		num, typ := protowire.Number(tag>>3), protowire.Type(tag&7)
+
+		switch typ {
+		case protowire.VarintType:
+			value, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			switch num {
+			case 1:
+				blk.Number = value
+			case 5:
+				blk.LibNum = value
+			case 6:
+				blk.PayloadKind = pbbstream.Protocol(value)
+			case 7:
+				blk.PayloadVersion = int32(value)
+			case 9:
+				blk.HeadNum = value
+			case 10:
+				blk.ParentNum = value
+			}
+		case protowire.BytesType:
+			length, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if num == 8 || num == 11 {
+				// the payload is skipped, without being read in memory
+				legacy = legacy && num != 11
+				if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+					return nil, unexpectedEOF(err)
+				}
+				continue
+			}
+			value := make([]byte, length)
+			if _, err := io.ReadFull(reader, value); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			switch num {
+			case 2:
+				blk.Id = string(value)
+			case 3:
+				blk.ParentId = string(value)
+			case 4:
+				blk.Timestamp = &timestamppb.Timestamp{}
+				if err := proto.Unmarshal(value, blk.Timestamp); err != nil {
+					return nil, fmt.Errorf("timestamp: %w", err)
+				}
+			case pbbstream.MetadataFieldNumber:
+				if err := blk.SetMetaEntry(value); err != nil {
+					return nil, fmt.Errorf("metadata: %w", err)
+				}
+			}
+		case protowire.Fixed32Type:
+			if _, err := io.CopyN(io.Discard, reader, 4); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		case protowire.Fixed64Type:
+			if _, err := io.CopyN(io.Discard, reader, 8); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d of field %d", typ, num)
+		}
 	}
 
+	// like supportLegacy, the blocks without payload message have no parent number
+	if legacy && blk.Number > GetProtocolFirstStreamableBlock {
+		blk.ParentNum = blk.Number - 1
+	}
+	return blk, nil
+}
+
+func unexpectedEOF(err error) error {
 	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// byteReader reads the varints of a protobuf message from a reader, byte by byte
+type byteReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(r.Reader, r.buf[:]); err != nil {
+		return 0, err
+	}
+	return r.buf[0], nil
+}
+
+func readMessage[T any](reader *DBinBlockReader, decoder func(message []byte) (T, error)) (out T, err error) {
+	length, err := reader.nextLength()
+	if err != nil {
 		return out, err
 	}
+	// an empty message ends the blocks, the index footer follows (see NewDBinBlockWriterWithIndex)
+	if length == 0 {
+		return out, io.EOF
+	}
 
-	// In all other cases, we are in an error path
-	return out, fmt.Errorf("failed reading next dbin message: %s", err)
+	message := make([]byte, length)
+	if n, err := io.ReadFull(reader.src, message); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return out, reader.truncated(fmt.Errorf("incomplete message, got %d of %d bytes", n, length))
+		}
+		return out, fmt.Errorf("failed reading next dbin message: %s", err)
+	}
+	reader.offset += 4 + int64(length)
+
+	return decoder(message)
+}
+
+// nextLength reads the length of the next dbin message, it returns io.EOF at
+// the end of the file
+func (l *DBinBlockReader) nextLength() (uint32, error) {
+	var lengthBytes [4]byte
+	n, err := io.ReadFull(l.src, lengthBytes[:])
+	if err == io.EOF {
+		return 0, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		return 0, l.truncated(fmt.Errorf("incomplete message length, got %d of 4 bytes", n))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed reading next dbin message: %s", err)
+	}
+	return binary.BigEndian.Uint32(lengthBytes[:]), nil
+}
+
+// truncated returns the error of the file ending in the message at the current
+// offset, io.EOF when the truncation is tolerated
+func (l *DBinBlockReader) truncated(err error) error {
+	truncation := &TruncatedTailError{Offset: l.offset, Err: err}
+	if !l.tolerateTruncatedTail {
+		return fmt.Errorf("failed reading next dbin message: %w", truncation)
+	}
+	l.truncatedTail = truncation
+	return io.EOF
+}
+
+// TolerateTruncatedTail ends the blocks with io.EOF when the file is cut in its
+// last message, the blocks before it being complete. The truncation is given
+// by TruncatedTail. A message which is read in full but cannot be decoded is
+// still an error, wherever it is in the file.
+func (l *DBinBlockReader) TolerateTruncatedTail() {
+	l.tolerateTruncatedTail = true
+}
 
+// TruncatedTail returns the truncation of the file once the reader reached it,
+// nil if there was none, see TolerateTruncatedTail
+func (l *DBinBlockReader) TruncatedTail() *TruncatedTailError {
+	return l.truncatedTail
 }
 
 func supportLegacy(b *pbbstream.Block) error {
@@ -0,0 +1,281 @@
+package bstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// messageOffsets returns the offsets of the dbin messages of the blocks file `content`
+func messageOffsets(t *testing.T, content []byte) (out []int64) {
+	reader, err := NewDBinBlockReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	for {
+		out = append(out, reader.offset)
+		if _, err := reader.Read(); err == io.EOF {
+			return out[:len(out)-1]
+		}
+		require.NoError(t, err)
+	}
+}
+
+func TestDBinBlockReader_TruncatedTail(t *testing.T) {
+	content := testBlocks(linearTestBlocks(1, 3)...)
+	offsets := messageOffsets(t, content)
+	require.Len(t, offsets, 3)
+	lastStart := offsets[2]
+
+	tests := []struct {
+		name           string
+		length         int64
+		expectedBlocks []uint64
+		expectedErr    string
+	}{
+		{name: "complete", length: int64(len(content)), expectedBlocks: []uint64{1, 2, 3}},
+		{name: "at a message boundary", length: lastStart, expectedBlocks: []uint64{1, 2}},
+		{name: "in the message length", length: lastStart + 2, expectedBlocks: []uint64{1, 2}, expectedErr: "incomplete message length, got 2 of 4 bytes"},
+		{name: "after the message length", length: lastStart + 4, expectedBlocks: []uint64{1, 2}, expectedErr: "incomplete message"},
+		{name: "in the message", length: lastStart + 10, expectedBlocks: []uint64{1, 2}, expectedErr: "incomplete message, got 6 of"},
+		{name: "last byte missing", length: int64(len(content)) - 1, expectedBlocks: []uint64{1, 2}, expectedErr: "incomplete message"},
+	}
+
+	for _, test := range tests {
+		for _, headerOnly := range []bool{false, true} {
+			name := test.name
+			if headerOnly {
+				name += ", header only"
+			}
+			t.Run(name, func(t *testing.T) {
+				newReader := func() *DBinBlockReader {
+					reader, err := NewDBinBlockReader(bytes.NewReader(content[:test.length]))
+					require.NoError(t, err)
+					reader.headerOnly = headerOnly
+					return reader
+				}
+
+				reader := newReader()
+				var blocks []uint64
+				var err error
+				for {
+					var blk *pbbstream.Block
+					if blk, err = reader.Read(); err != nil {
+						break
+					}
+					blocks = append(blocks, blk.Number)
+				}
+				assert.Equal(t, test.expectedBlocks, blocks)
+				if test.expectedErr == "" {
+					assert.Equal(t, io.EOF, err)
+				} else {
+					var truncation *TruncatedTailError
+					require.True(t, errors.As(err, &truncation), "got error %v", err)
+					assert.Equal(t, lastStart, truncation.Offset)
+					assert.Contains(t, err.Error(), test.expectedErr)
+				}
+
+				tolerant := newReader()
+				tolerant.TolerateTruncatedTail()
+				assert.Equal(t, test.expectedBlocks, readBlockNums(t, tolerant))
+				if test.expectedErr == "" {
+					assert.Nil(t, tolerant.TruncatedTail())
+				} else {
+					require.NotNil(t, tolerant.TruncatedTail())
+					assert.Equal(t, lastStart, tolerant.TruncatedTail().Offset)
+				}
+			})
+		}
+	}
+}
+
+func TestDBinBlockReader_TruncatedTail_CorruptMessage(t *testing.T) {
+	content := testBlocks(linearTestBlocks(1, 3)...)
+	offsets := messageOffsets(t, content)
+	// a complete message which cannot be decoded, it is not a truncation
+	content[offsets[1]+4] = 0xff
+
+	reader, err := NewDBinBlockReader(bytes.NewReader(content[:len(content)-1]))
+	require.NoError(t, err)
+	reader.TolerateTruncatedTail()
+
+	_, err = reader.Read()
+	require.NoError(t, err)
+	_, err = reader.Read()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to read block proto")
+	assert.Nil(t, reader.TruncatedTail())
+}
+
+func TestFileSource_TolerateTruncatedTail(t *testing.T) {
+	truncatedBundle := func() []byte {
+		// starting at the genesis block, so that the bundle validation expects
+		// all the blocks of the bundle without overriding GetProtocolFirstStreamableBlock
+		content := testBlocks(linearTestBlocks(0, 5)...)
+		return content[:len(content)-3]
+	}
+
+	tests := []struct {
+		name             string
+		options          []FileSourceOption
+		expectedReceived []uint64
+		expectedErr      string
+	}{
+		{
+			name:        "not tolerated",
+			options:     []FileSourceOption{FileSourceWithOpenRetries(1)},
+			expectedErr: "blocks file truncated in its last message at offset",
+		},
+		{
+			name:             "tolerated, the hole breaks the chain",
+			options:          []FileSourceOption{FileSourceWithTolerateTruncatedTail()},
+			expectedReceived: []uint64{0, 1, 2, 3, 4},
+			expectedErr:      `found non-sequential blocks in merged blocks file ("#100 (100a)" has previousID "99a" and does not follow "04a")`,
+		},
+		{
+			name:             "tolerated, failing the bundle validation",
+			options:          []FileSourceOption{FileSourceWithTolerateTruncatedTail(), FileSourceWithBundleValidation()},
+			expectedReceived: []uint64{0, 1, 2, 3, 4},
+			expectedErr:      `incomplete merged blocks file "0000000000": missing blocks in range [5, 99] (blocks file truncated in its last message at offset`,
+		},
+		{
+			name:             "tolerated, within the error budget",
+			options:          []FileSourceOption{FileSourceWithTolerateTruncatedTail(), FileSourceWithBundleValidation(), FileSourceWithErrorBudget(1, nil)},
+			expectedReceived: []uint64{0, 1, 2, 3, 4, 100},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := dstore.NewMockStore(nil)
+			bs.SetFile(base(0), truncatedBundle())
+			bs.SetFile(base(100), testBlocks(TestBlockWithNumbers("100a", "99a", 100, 99)))
+
+			var received []uint64
+			handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				received = append(received, blk.Number)
+				if blk.Number == 100 {
+					return errDone
+				}
+				return nil
+			})
+			fs := NewFileSource(bs, 0, handler, zlog, test.options...)
+			fired := make(chan time.Time)
+			close(fired)
+			fs.after = func(d time.Duration) <-chan time.Time { return fired }
+
+			testDone := make(chan struct{})
+			go func() {
+				fs.Run()
+				close(testDone)
+			}()
+			select {
+			case <-testDone:
+			case <-time.After(time.Second):
+				t.Fatal("Test timeout")
+			}
+
+			if test.expectedReceived != nil {
+				assert.Equal(t, test.expectedReceived, received)
+			}
+			assert.NotContains(t, received, uint64(5))
+			if test.expectedErr == "" {
+				assert.Equal(t, errDone, fs.Err())
+				return
+			}
+			require.Error(t, fs.Err())
+			assert.Contains(t, fs.Err().Error(), test.expectedErr)
+		})
+	}
+}
+
+// decoderBlockReader sets the name of the factory which created it in the metadata of the blocks
+type decoderBlockReader struct {
+	BlockReader
+	name string
+}
+
+func (r *decoderBlockReader) Read() (*pbbstream.Block, error) {
+	blk, err := r.BlockReader.Read()
+	if blk != nil {
+		blk.SetMeta("decoder", r.name)
+	}
+	return blk, err
+}
+
+func TestRegisterBlockReaderFactory(t *testing.T) {
+	defer func(prev uint64) { GetProtocolFirstStreamableBlock = prev }(GetProtocolFirstStreamableBlock)
+	GetProtocolFirstStreamableBlock = 1
+
+	const v1, v2, v3 = "type.googleapis.com/sf.test.v1.Block", "type.googleapis.com/sf.test.v2.Block", "type.googleapis.com/sf.test.v3.Block"
+	bundle := func(typeURL string, from, to uint64) []byte {
+		blocks := linearTestBlocks(from, to)
+		for _, blk := range blocks {
+			blk.Payload.TypeUrl = typeURL
+		}
+		return testBlocks(blocks...)
+	}
+	decoder := func(name string) BlockReaderFactory {
+		return BlockReaderFactoryFunc(func(reader io.Reader) (BlockReader, error) {
+			blockReader, err := NewDBinBlockReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			return &decoderBlockReader{BlockReader: blockReader, name: name}, nil
+		})
+	}
+
+	header, err := NewDBinBlockReader(bytes.NewReader(bundle(v1, 1, 1)))
+	require.NoError(t, err)
+	assert.Equal(t, v1, header.Header.ContentType, "the header has the type URL of the payload")
+
+	readDecoders := func(content []byte) (out []string) {
+		// a reader which is not a seeker, the header is read again from memory
+		reader, err := DBinBlockReaderFactory.New(io.MultiReader(bytes.NewReader(content)))
+		require.NoError(t, err)
+		for {
+			blk, err := reader.Read()
+			if err == io.EOF {
+				return out
+			}
+			require.NoError(t, err)
+			decoder, _ := blk.GetMeta("decoder")
+			out = append(out, decoder)
+		}
+	}
+	assert.Equal(t, []string{"", ""}, readDecoders(bundle(v1, 1, 2)), "nothing registered")
+
+	RegisterBlockReaderFactory(v1, 1, decoder("v1"))
+	RegisterBlockReaderFactory(v2, 1, decoder("v2"))
+	defer RegisterBlockReaderFactory(v1, 1, nil)
+	defer RegisterBlockReaderFactory(v2, 1, nil)
+
+	assert.Equal(t, []string{"v1", "v1"}, readDecoders(bundle(v1, 1, 2)))
+	assert.Equal(t, []string{"v2", "v2"}, readDecoders(bundle(v2, 1, 2)))
+
+	_, err = DBinBlockReaderFactory.New(bytes.NewReader(bundle(v3, 1, 2)))
+	require.ErrorIs(t, err, ErrNoBlockReaderFactory)
+	assert.Contains(t, err.Error(), `content type "type.googleapis.com/sf.test.v3.Block" and version 1`)
+
+	// a store upgraded at block 10
+	store := dstore.NewMockStore(nil)
+	store.SetFile(base(0), bundle(v1, 1, 9))
+	store.SetFile(base(10), bundle(v2, 10, 19))
+
+	decoders := map[uint64]string{}
+	handler := HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		decoders[blk.Number], _ = blk.GetMeta("decoder")
+		return nil
+	})
+	fs := NewFileSource(store, 1, handler, zlog, FileSourceWithBundleSize(10), FileSourceWithStopBlock(19))
+	fs.Run()
+	assert.ErrorIs(t, fs.Err(), ErrStopBlockReached)
+	require.Len(t, decoders, 19)
+	assert.Equal(t, "v1", decoders[9])
+	assert.Equal(t, "v2", decoders[10])
+}
@@ -1,5 +1,15 @@
 package bstream
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/streamingfast/dbin"
+)
+
 // bstreams.NewDBinBlockReader
 // var GetBlockReaderFactory BlockReaderFactory
 // bstream.NewDBinBlockWriter
@@ -11,6 +21,78 @@ var NormalizeBlockID = func(in string) string { // some chains have block IDs th
 	return in
 }
 
+// ValidateBlockID rejects the blocks with an invalid ID when read from the blocks
+// files and when processed by a Forkable, see CheckBlockID. It is nil by default,
+// which skips the validation, chains register theirs (StarknetBlockIDValidator for
+// example). Tests using synthetic IDs call SkipBlockIDValidation.
+var ValidateBlockID BlockIDValidator
+
+// ErrNoBlockReaderFactory is returned by DBinBlockReaderFactory for a blocks file
+// whose header has no factory registered, see RegisterBlockReaderFactory
+var ErrNoBlockReaderFactory = errors.New("no block reader factory registered")
+
+type blockReaderFactoryKey struct {
+	contentType string
+	version     int32
+}
+
+var blockReaderFactoriesLock sync.RWMutex
+var blockReaderFactories = map[blockReaderFactoryKey]BlockReaderFactory{}
+
+// RegisterBlockReaderFactory makes DBinBlockReaderFactory decode the blocks files
+// with `f` when their dbin header has `contentType` (the type URL of the payload
+// of the blocks, see DBinBlockWriter) and `version` (the version of the dbin
+// format, 1 for the files written by DBinBlockWriter), for a store with the
+// files of different payloads, before and after a chain upgrade for example.
+//
+// Once a factory is registered, the files with another content type or version
+// cannot be read by DBinBlockReaderFactory: it returns ErrNoBlockReaderFactory.
+// Registering DBinBlockReaderFactory itself reads the files as when nothing is
+// registered, a nil factory removes the registration.
+func RegisterBlockReaderFactory(contentType string, version int32, f BlockReaderFactory) {
+	blockReaderFactoriesLock.Lock()
+	defer blockReaderFactoriesLock.Unlock()
+
+	key := blockReaderFactoryKey{contentType: contentType, version: version}
+	if f == nil {
+		delete(blockReaderFactories, key)
+		return
+	}
+	blockReaderFactories[key] = f
+}
+
+// registeredBlockReaderFactory returns the factory registered for the header of
+// the blocks file read by `reader`, with a reader of the whole file for it, nil
+// when no factory is registered at all.
+func registeredBlockReaderFactory(reader io.Reader) (BlockReaderFactory, io.Reader, error) {
+	blockReaderFactoriesLock.RLock()
+	empty := len(blockReaderFactories) == 0
+	blockReaderFactoriesLock.RUnlock()
+	if empty {
+		return nil, reader, nil
+	}
+
+	header, err := dbin.NewReader(reader).ReadHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read file header: %s", err)
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, fmt.Errorf("seeking back to the file header: %w", err)
+		}
+	} else {
+		reader = io.MultiReader(bytes.NewReader(header.RawBytes), reader)
+	}
+
+	blockReaderFactoriesLock.RLock()
+	f, found := blockReaderFactories[blockReaderFactoryKey{contentType: header.ContentType, version: int32(header.Version)}]
+	blockReaderFactoriesLock.RUnlock()
+	if !found {
+		return nil, nil, fmt.Errorf("%w for content type %q and version %d", ErrNoBlockReaderFactory, header.ContentType, header.Version)
+	}
+	return f, reader, nil
+}
+
 func ValidateRegistry() error {
 
 	//if GetBlockReaderFactory == nil {
@@ -0,0 +1,118 @@
+package bstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"go.uber.org/zap"
+)
+
+// RetryHandler processes the blocks with its next handler, calling it again on
+// the same block and object when it fails with a retriable error, for the
+// transient failures of the consumers (a database timeout for example) which
+// would otherwise shut the source down. See NewRetryHandler.
+//
+// Close interrupts its waits between attempts, the source being shut down: the
+// block is not given to the next handler again and ProcessBlock returns
+// context.Canceled, from then on too when an attempt fails.
+type RetryHandler struct {
+	next      Handler
+	attempts  int
+	backoff   time.Duration
+	retriable func(error) bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// after is overridden in tests
+	after func(d time.Duration) <-chan time.Time
+}
+
+// NewRetryHandler calls `next` up to `attempts` times on a block, waiting
+// `backoff` between attempts, as long as `retriable` returns true for its error
+// (nil retries all the errors). It gives up with the error of the last attempt,
+// wrapped with the block and the amount of attempts when there was more than one.
+// The errors marked with NonRetriable are never retried.
+//
+// The next handler is given the same block again: a handler modifying it must
+// work on a copy (see the README). It is safe on either side of a
+// forkable.Forkable: downstream, it retries a step of the block; upstream, the
+// failures of the handler of the Forkable are NonRetriable, the Forkable having
+// already recorded the block.
+func NewRetryHandler(next Handler, attempts int, backoff time.Duration, retriable func(error) bool) *RetryHandler {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &RetryHandler{
+		next:      next,
+		attempts:  attempts,
+		backoff:   backoff,
+		retriable: retriable,
+		done:      make(chan struct{}),
+		after:     time.After,
+	}
+}
+
+func (h *RetryHandler) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
+	for attempt := 1; ; attempt++ {
+		err := h.next.ProcessBlock(blk, obj)
+		if err == nil {
+			return nil
+		}
+		if attempt >= h.attempts || !h.isRetriable(err) {
+			if attempt > 1 {
+				return fmt.Errorf("process block %s: after %d attempts: %w", blk.AsRef(), attempt, err)
+			}
+			return err
+		}
+
+		zlog.Warn("processing block failed, retrying", zap.Stringer("block", blk.AsRef()), zap.Int("attempt", attempt), zap.Duration("retry_delay", h.backoff), zap.Error(err))
+		select {
+		case <-h.done:
+			return context.Canceled
+		case <-h.after(h.backoff):
+		}
+	}
+}
+
+// Close interrupts the current and future waits between attempts, see RetryHandler
+func (h *RetryHandler) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+func (h *RetryHandler) isRetriable(err error) bool {
+	var nonRetriable *nonRetriableError
+	if errors.As(err, &nonRetriable) {
+		return false
+	}
+	return h.retriable == nil || h.retriable(err)
+}
+
+// NonRetriable marks `err` so that a RetryHandler gives up on it, whatever its
+// retriable function, for the handlers which must not be given the same block
+// again. The error is unchanged otherwise, errors.Is and errors.As see through
+// the mark. It returns nil for a nil error.
+func NonRetriable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetriableError{err: err}
+}
+
+type nonRetriableError struct {
+	err error
+}
+
+func (e *nonRetriableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetriableError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,94 @@
+package bstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryHandler(t *testing.T) {
+	errTimeout := errors.New("database timeout")
+	errInvalid := errors.New("invalid block")
+	retriable := func(err error) bool { return errors.Is(err, errTimeout) }
+
+	tests := []struct {
+		name          string
+		failures      []error
+		expectedCalls int
+		expectedErr   string
+	}{
+		{name: "succeeds", expectedCalls: 1},
+		{name: "fails twice then succeeds", failures: []error{errTimeout, errTimeout}, expectedCalls: 3},
+		{name: "fails permanently", failures: []error{errTimeout, errTimeout, errTimeout, errTimeout}, expectedCalls: 3, expectedErr: "process block #5 (05a): after 3 attempts: database timeout"},
+		{name: "not retriable", failures: []error{errInvalid}, expectedCalls: 1, expectedErr: "invalid block"},
+		{name: "not retriable after a retry", failures: []error{errTimeout, errInvalid}, expectedCalls: 2, expectedErr: "process block #5 (05a): after 2 attempts: invalid block"},
+		{name: "marked non retriable", failures: []error{NonRetriable(errTimeout)}, expectedCalls: 1, expectedErr: "database timeout"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blk := TestBlockWithNumbers("05a", "04a", 5, 4)
+			calls := 0
+			next := HandlerFunc(func(received *pbbstream.Block, obj interface{}) error {
+				assert.Same(t, blk, received)
+				assert.Equal(t, "obj", obj)
+				calls++
+				if calls <= len(test.failures) {
+					return test.failures[calls-1]
+				}
+				return nil
+			})
+
+			var waited []time.Duration
+			h := NewRetryHandler(next, 3, time.Second, retriable)
+			h.after = func(d time.Duration) <-chan time.Time {
+				waited = append(waited, d)
+				fired := make(chan time.Time)
+				close(fired)
+				return fired
+			}
+
+			err := h.ProcessBlock(blk, "obj")
+			assert.Equal(t, test.expectedCalls, calls)
+			assert.Len(t, waited, test.expectedCalls-1)
+			if test.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, test.expectedErr, err.Error())
+			assert.ErrorIs(t, err, test.failures[calls-1])
+		})
+	}
+}
+
+func TestRetryHandler_Close(t *testing.T) {
+	errTimeout := errors.New("database timeout")
+	calls := 0
+	h := NewRetryHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		calls++
+		return errTimeout
+	}), 3, time.Hour, nil)
+
+	done := make(chan error)
+	go func() {
+		done <- h.ProcessBlock(TestBlockWithNumbers("05a", "04a", 5, 4), nil)
+	}()
+	h.Close()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("the wait was not interrupted")
+	}
+	assert.Equal(t, 1, calls)
+
+	assert.Equal(t, context.Canceled, h.ProcessBlock(TestBlockWithNumbers("06a", "05a", 6, 5), nil))
+	assert.Equal(t, 2, calls)
+	h.Close()
+}
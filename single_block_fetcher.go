@@ -16,6 +16,18 @@ func FetchBlockFromOneBlockStore(
 	num uint64,
 	id string,
 	store dstore.Store,
+) (*pbbstream.Block, error) {
+	return FetchBlockFromOneBlockStoreWithCodec(ctx, num, id, store, BlockCodec{})
+}
+
+// FetchBlockFromOneBlockStoreWithCodec is FetchBlockFromOneBlockStore decoding
+// the one-block file with the reader factory of `codec`
+func FetchBlockFromOneBlockStoreWithCodec(
+	ctx context.Context,
+	num uint64,
+	id string,
+	store dstore.Store,
+	codec BlockCodec,
 ) (*pbbstream.Block, error) {
 	if obfs, err := listOneBlocks(ctx, num, num+1, store); err == nil {
 		canonicalID := NormalizeBlockID(id)
@@ -25,7 +37,7 @@ func FetchBlockFromOneBlockStore(
 				if err != nil {
 					return nil, err
 				}
-				return decodeOneblockfileData(data)
+				return decodeOneblockfileData(codec.readerFactory(), data)
 			}
 		}
 	}
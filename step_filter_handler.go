@@ -0,0 +1,104 @@
+package bstream
+
+import (
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// StepFilterHandler gives to the next handler only the blocks of the steps it
+// filters, see NewStepFilterHandler
+type StepFilterHandler struct {
+	steps StepType
+	next  Handler
+
+	dropUnstepable       bool
+	splitNewIrreversible bool
+}
+
+type StepFilterOption func(*StepFilterHandler)
+
+// StepFilterWithDropUnstepable drops the objects which do not implement
+// Stepable instead of passing them through
+func StepFilterWithDropUnstepable() StepFilterOption {
+	return func(h *StepFilterHandler) {
+		h.dropUnstepable = true
+	}
+}
+
+// StepFilterWithSplitNewIrreversible gives a StepNewIrreversible block twice,
+// as StepNew then as StepIrreversible, for the handlers which only understand
+// the simple steps. Each delivery is filtered on its own step, and the object
+// given is a ForkableObject keeping the cursor (with the step of the delivery),
+// the reorg junction block and the wrapped object of the original one.
+func StepFilterWithSplitNewIrreversible() StepFilterOption {
+	return func(h *StepFilterHandler) {
+		h.splitNewIrreversible = true
+	}
+}
+
+// NewStepFilterHandler gives the blocks to `next` when the step of their object
+// matches `steps` (see StepType.Matches), a StepNewIrreversible block matching
+// a filter on StepNew or on StepIrreversible. The objects which do not
+// implement Stepable are passed through, unless StepFilterWithDropUnstepable
+// is given.
+//
+// This is the filtering of forkable.WithFilters, for the handlers further down
+// the chain or fed by something else than a Forkable.
+func NewStepFilterHandler(steps StepType, next Handler, opts ...StepFilterOption) *StepFilterHandler {
+	h := &StepFilterHandler{
+		steps: steps,
+		next:  next,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *StepFilterHandler) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
+	stepable, ok := obj.(Stepable)
+	if !ok {
+		if h.dropUnstepable {
+			return nil
+		}
+		return h.next.ProcessBlock(blk, obj)
+	}
+
+	step := stepable.Step()
+	if !h.splitNewIrreversible || step != StepNewIrreversible {
+		if !h.steps.Matches(step) {
+			return nil
+		}
+		return h.next.ProcessBlock(blk, obj)
+	}
+
+	for _, part := range []StepType{StepNew, StepIrreversible} {
+		if !h.steps.Matches(part) {
+			continue
+		}
+		if err := h.next.ProcessBlock(blk, withStep(stepable, part)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withStep returns `obj` as a ForkableObject of step `step`, keeping its
+// cursor and wrapped object when it has them
+func withStep(obj Stepable, step StepType) ForkableObject {
+	out := &preprocessedForkableObject{
+		step:               step,
+		reorgJunctionBlock: obj.ReorgJunctionBlock(),
+		obj:                obj,
+	}
+	if wrapper, ok := obj.(ObjectWrapper); ok {
+		out.obj = wrapper.WrappedObject()
+	}
+	if cursorable, ok := obj.(Cursorable); ok {
+		if cursor := cursorable.Cursor(); cursor != nil && !cursor.IsEmpty() {
+			stepCursor := *cursor
+			stepCursor.Step = step
+			out.cursor = &stepCursor
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package bstream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStepFilterHandler_Unstepable(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []StepFilterOption
+		expected []string
+	}{
+		{name: "passed by default", expected: []string{"<nil>:01a", "new:02a"}},
+		{name: "dropped", opts: []StepFilterOption{StepFilterWithDropUnstepable()}, expected: []string{"new:02a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received []string
+			h := NewStepFilterHandler(StepNew, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+				var step interface{}
+				if stepable, ok := obj.(Stepable); ok {
+					step = stepable.Step()
+				}
+				received = append(received, fmt.Sprintf("%v:%s", step, blk.Id))
+				return nil
+			}), test.opts...)
+
+			blocks := linearTestBlocks(1, 2)
+			require.NoError(t, h.ProcessBlock(blocks[0], nil))
+			require.NoError(t, h.ProcessBlock(blocks[1], testStep(StepNew)))
+			assert.Equal(t, test.expected, received)
+		})
+	}
+}
+
+func TestStepFilterHandler_SplitFailure(t *testing.T) {
+	errStore := errors.New("store unavailable")
+	var received []StepType
+	h := NewStepFilterHandler(StepsAll, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, obj.(Stepable).Step())
+		return errStore
+	}), StepFilterWithSplitNewIrreversible())
+
+	err := h.ProcessBlock(TestBlock("00000001a", "00000000a"), testStep(StepNewIrreversible))
+	require.ErrorIs(t, err, errStore)
+	assert.Equal(t, []StepType{StepNew}, received)
+}
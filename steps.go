@@ -15,6 +15,7 @@
 package bstream
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -56,3 +57,27 @@ func (t StepType) String() string {
 	}
 	return strings.Join(el, ",")
 }
+
+var stepTypeNames = map[string]StepType{
+	"new":          StepNew,
+	"undo":         StepUndo,
+	"irreversible": StepIrreversible,
+	"stalled":      StepStalled,
+}
+
+// parseStepType is the reverse of StepType's String
+func parseStepType(in string) (StepType, error) {
+	if in == "none" {
+		return 0, nil
+	}
+
+	var out StepType
+	for _, name := range strings.Split(in, ",") {
+		step, found := stepTypeNames[name]
+		if !found {
+			return 0, fmt.Errorf("unknown step %q", name)
+		}
+		out |= step
+	}
+	return out, nil
+}
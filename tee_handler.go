@@ -0,0 +1,215 @@
+package bstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+)
+
+// TeeHandler writes each block it is given as a one-block file to a store
+// before passing it to the next handler, see NewTeeHandler
+type TeeHandler struct {
+	next     Handler
+	store    dstore.Store
+	nameFunc func(blk *pbbstream.Block, obj interface{}) string
+
+	writerFactory BlockWriterFactory
+	queueSize     int
+	onError       func(filename string, err error)
+
+	// ctx bounds the writes to the store and the waits for the queue
+	ctx   context.Context
+	queue chan teeObject
+	done  chan struct{}
+
+	lock sync.Mutex
+	err  error
+
+	// closeLock keeps the queue from being closed while blocks are queued
+	closeLock sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+// ErrTeeHandlerClosed is returned by the TeeHandler given blocks once closed
+var ErrTeeHandlerClosed = errors.New("tee handler closed")
+
+type teeObject struct {
+	filename string
+	content  []byte
+}
+
+type TeeHandlerOption func(*TeeHandler)
+
+// TeeHandlerWithQueueSize sets the amount of blocks waiting to be written to
+// the store before ProcessBlock waits for the writes, 100 by default
+func TeeHandlerWithQueueSize(size int) TeeHandlerOption {
+	return func(h *TeeHandler) {
+		h.queueSize = size
+	}
+}
+
+// TeeHandlerWithBlockWriterFactory encodes the one-block files with `factory`
+// instead of DBinBlockWriterFactory
+func TeeHandlerWithBlockWriterFactory(factory BlockWriterFactory) TeeHandlerOption {
+	return func(h *TeeHandler) {
+		h.writerFactory = factory
+	}
+}
+
+// TeeHandlerWithErrorCallback reports the failed writes to `onError`, from the
+// goroutine writing to the store, instead of failing the handler: the blocks
+// keep flowing to the next handler and the following ones are still written.
+func TeeHandlerWithErrorCallback(onError func(filename string, err error)) TeeHandlerOption {
+	return func(h *TeeHandler) {
+		h.onError = onError
+	}
+}
+
+// NewTeeHandler gives the blocks to `next` after having queued them to be
+// written to `store`, under the name returned by `nameFunc` (BlockFileName when
+// nil), as one-block files: an exact copy of what `next` received. The blocks
+// are encoded right away, the handlers down the chain being free to modify
+// them, and written in order by a single goroutine so the hot path only waits
+// for the store when the queue is full.
+//
+// A failed write is returned by the following ProcessBlock calls, without
+// giving the block to `next` nor writing it, unless TeeHandlerWithErrorCallback
+// is given. Close must be called to flush the queue.
+func NewTeeHandler(next Handler, store dstore.Store, nameFunc func(blk *pbbstream.Block, obj interface{}) string, opts ...TeeHandlerOption) *TeeHandler {
+	return NewTeeHandlerWithContext(context.Background(), next, store, nameFunc, opts...)
+}
+
+// NewTeeHandlerWithContext creates a TeeHandler writing to `store` with `ctx`:
+// canceling it aborts the writes in progress, fails the ones still queued and
+// interrupts ProcessBlock and Close when they wait for the store.
+func NewTeeHandlerWithContext(ctx context.Context, next Handler, store dstore.Store, nameFunc func(blk *pbbstream.Block, obj interface{}) string, opts ...TeeHandlerOption) *TeeHandler {
+	h := &TeeHandler{
+		next:          next,
+		store:         store,
+		nameFunc:      nameFunc,
+		writerFactory: DBinBlockWriterFactory,
+		queueSize:     100,
+		ctx:           ctx,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.nameFunc == nil {
+		h.nameFunc = func(blk *pbbstream.Block, _ interface{}) string { return BlockFileName(blk) }
+	}
+	if h.queueSize < 0 {
+		h.queueSize = 0
+	}
+	h.queue = make(chan teeObject, h.queueSize)
+
+	go h.run()
+	return h
+}
+
+// ProcessBlock returns ErrTeeHandlerClosed once the handler is closed
+func (h *TeeHandler) ProcessBlock(blk *pbbstream.Block, obj interface{}) error {
+	if err := h.Err(); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	writer, err := h.writerFactory.New(buf)
+	if err != nil {
+		return fmt.Errorf("unable to create block writer: %w", err)
+	}
+	if err := writer.Write(blk); err != nil {
+		return fmt.Errorf("writing block %s: %w", blk.AsRef(), err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("closing block writer: %w", err)
+	}
+
+	if err := h.enqueue(teeObject{filename: h.nameFunc(blk, obj), content: buf.Bytes()}); err != nil {
+		return err
+	}
+	return h.next.ProcessBlock(blk, obj)
+}
+
+func (h *TeeHandler) enqueue(object teeObject) error {
+	h.closeLock.RLock()
+	defer h.closeLock.RUnlock()
+	if h.closed {
+		return ErrTeeHandlerClosed
+	}
+	if err := h.ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-h.ctx.Done():
+		return h.ctx.Err()
+	case h.queue <- object:
+		return nil
+	}
+}
+
+// Err returns the first failed write, when no error callback is set
+func (h *TeeHandler) Err() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.err
+}
+
+// Close waits for the queued blocks to be written and returns the first failed
+// write, see Err, or the error of the context when it is canceled first. The
+// handler must not be given blocks anymore.
+func (h *TeeHandler) Close() error {
+	h.closeOnce.Do(func() {
+		h.closeLock.Lock()
+		defer h.closeLock.Unlock()
+		h.closed = true
+		close(h.queue)
+	})
+
+	select {
+	case <-h.done:
+		return h.Err()
+	case <-h.ctx.Done():
+	}
+	select {
+	case <-h.done:
+		return h.Err()
+	default:
+	}
+	if err := h.Err(); err != nil {
+		return err
+	}
+	return h.ctx.Err()
+}
+
+func (h *TeeHandler) run() {
+	defer close(h.done)
+
+	for object := range h.queue {
+		if h.Err() != nil {
+			continue
+		}
+
+		err := h.store.WriteObject(h.ctx, object.filename, bytes.NewReader(object.content))
+		if err == nil {
+			continue
+		}
+		if h.ctx.Err() != nil {
+			err = h.ctx.Err()
+		} else if h.onError != nil {
+			h.onError(object.filename, err)
+			continue
+		}
+
+		h.lock.Lock()
+		h.err = fmt.Errorf("writing %q to the tee store: %w", object.filename, err)
+		h.lock.Unlock()
+	}
+}
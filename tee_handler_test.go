@@ -0,0 +1,179 @@
+package bstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTeeStore returns a store recording the names of the objects written in
+// `written`, failing the writes of the names in `failing`
+func newTeeStore(written *[]string, failing map[string]error) *dstore.MockStore {
+	store := dstore.NewMockStore(nil)
+	store.WriteObjectFunc = func(ctx context.Context, base string, f io.Reader) error {
+		if err := failing[base]; err != nil {
+			return err
+		}
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		*written = append(*written, base)
+		store.SetFile(base, content)
+		return nil
+	}
+	return store
+}
+
+func teeName(blk *pbbstream.Block, obj interface{}) string {
+	return fmt.Sprintf("%s-%s", blk.Id, obj)
+}
+
+func TestTeeHandler(t *testing.T) {
+	var written []string
+	store := newTeeStore(&written, nil)
+
+	var received []string
+	h := NewTeeHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		received = append(received, blk.Id)
+		// the handlers down the chain can modify the block
+		blk.Payload = nil
+		return nil
+	}), store, teeName, TeeHandlerWithQueueSize(2))
+
+	blocks := linearTestBlocks(1, 5)
+	expected := make([]*pbbstream.Block, len(blocks))
+	for i, blk := range blocks {
+		expected[i] = blk.Clone()
+		require.NoError(t, h.ProcessBlock(blk, "new"))
+	}
+	require.NoError(t, h.Close())
+
+	assert.Equal(t, []string{"01a", "02a", "03a", "04a", "05a"}, received)
+	assert.Equal(t, []string{"01a-new", "02a-new", "03a-new", "04a-new", "05a-new"}, written)
+	for _, blk := range expected {
+		reader, err := store.OpenObject(context.Background(), teeName(blk, "new"))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		got, err := decodeOneblockfileData(DBinBlockReaderFactory, content)
+		require.NoError(t, err)
+		AssertBlocksEqual(t, blk, got)
+	}
+}
+
+func TestTeeHandler_WriteFailure(t *testing.T) {
+	errWrite := errors.New("bucket not found")
+	failing := map[string]error{"02a-new": errWrite}
+
+	t.Run("handler error", func(t *testing.T) {
+		var written, received []string
+		h := NewTeeHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+			received = append(received, blk.Id)
+			return nil
+		}), newTeeStore(&written, failing), teeName)
+
+		blocks := linearTestBlocks(1, 4)
+		for _, blk := range blocks[:3] {
+			require.NoError(t, h.ProcessBlock(blk, "new"))
+		}
+		err := h.Close()
+		require.ErrorIs(t, err, errWrite)
+		assert.Contains(t, err.Error(), `"02a-new"`)
+
+		// nothing is written nor sent after the failure
+		require.ErrorIs(t, h.ProcessBlock(blocks[3], "new"), errWrite)
+		assert.Equal(t, []string{"01a-new"}, written)
+		assert.Equal(t, []string{"01a", "02a", "03a"}, received)
+	})
+
+	t.Run("error callback", func(t *testing.T) {
+		var written, received, failed []string
+		h := NewTeeHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+			received = append(received, blk.Id)
+			return nil
+		}), newTeeStore(&written, failing), teeName, TeeHandlerWithErrorCallback(func(filename string, err error) {
+			assert.ErrorIs(t, err, errWrite)
+			failed = append(failed, filename)
+		}))
+
+		for _, blk := range linearTestBlocks(1, 4) {
+			require.NoError(t, h.ProcessBlock(blk, "new"))
+		}
+		require.NoError(t, h.Close())
+
+		assert.Equal(t, []string{"02a-new"}, failed)
+		assert.Equal(t, []string{"01a-new", "03a-new", "04a-new"}, written)
+		assert.Equal(t, []string{"01a", "02a", "03a", "04a"}, received)
+	})
+}
+
+func TestTeeHandler_Closed(t *testing.T) {
+	var written []string
+	h := NewTeeHandler(HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	}), newTeeStore(&written, nil), teeName)
+
+	blocks := linearTestBlocks(1, 50)
+	processed := make(chan error, len(blocks))
+	go func() {
+		for _, blk := range blocks {
+			processed <- h.ProcessBlock(blk, "new")
+		}
+		close(processed)
+	}()
+	require.NoError(t, h.Close())
+
+	accepted := 0
+	for err := range processed {
+		if err == nil {
+			accepted++
+			continue
+		}
+		require.ErrorIs(t, err, ErrTeeHandlerClosed)
+	}
+	// the blocks accepted before the handler was closed are all written
+	assert.Len(t, written, accepted)
+	assert.ErrorIs(t, h.ProcessBlock(blocks[0], "new"), ErrTeeHandlerClosed)
+}
+
+func TestTeeHandler_HungStore(t *testing.T) {
+	store := dstore.NewMockStore(nil)
+	store.WriteObjectFunc = func(ctx context.Context, base string, f io.Reader) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewTeeHandlerWithContext(ctx, HandlerFunc(func(blk *pbbstream.Block, obj interface{}) error {
+		return nil
+	}), store, teeName, TeeHandlerWithQueueSize(1))
+
+	blocks := linearTestBlocks(1, 3)
+	// the first block is being written, the second one is queued
+	require.NoError(t, h.ProcessBlock(blocks[0], "new"))
+	require.NoError(t, h.ProcessBlock(blocks[1], "new"))
+
+	processed := make(chan error)
+	go func() {
+		processed <- h.ProcessBlock(blocks[2], "new")
+	}()
+	cancel()
+	select {
+	case err := <-processed:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(10 * time.Second):
+		t.Fatal("ProcessBlock was not interrupted")
+	}
+
+	assert.ErrorIs(t, h.Close(), context.Canceled)
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -162,6 +163,14 @@ func (t *TestSource) Push(b *pbbstream.Block, obj interface{}) error {
 	return err
 }
 
+// SkipBlockIDValidation disables ValidateBlockID until the end of the test, for
+// the tests using synthetic block IDs like "00000003a"
+func SkipBlockIDValidation(t testing.TB) {
+	validate := ValidateBlockID
+	ValidateBlockID = nil
+	t.Cleanup(func() { ValidateBlockID = validate })
+}
+
 var testBlockDateLayout = "2006-01-02T15:04:05.000"
 
 type ParsableTestBlock struct {
@@ -318,6 +327,15 @@ func AssertBlockRefEqual(t *testing.T, expected, actual BlockRef) {
 	assert.Equal(t, expected.Num(), actual.Num())
 }
 
+// AssertBlocksEqual fails the test with the differences between the blocks, see DiffBlocks
+func AssertBlocksEqual(t testing.TB, want, got *pbbstream.Block) {
+	t.Helper()
+
+	if diff := DiffBlocks(want, got); len(diff) != 0 {
+		t.Errorf("blocks differ (want != got):\n  %s", strings.Join(diff, "\n  "))
+	}
+}
+
 func AssertProtoEqual(t *testing.T, expected, actual proto.Message) {
 	t.Helper()
 
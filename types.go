@@ -18,6 +18,8 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
 
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
 )
@@ -75,6 +77,64 @@ func NewBlockRefFromID(id string) BasicBlockRef {
 	return BasicBlockRef{id, uint64(binary.BigEndian.Uint32(bin))}
 }
 
+// NewBlockRefFromString parses a block ref written in its canonical form
+// `#<num> (<id>)`, as returned by String(), in the compact form `<num>:<id>` or
+// as a bare `<num>` which gives a ref without ID. The ID cannot be empty, nor
+// contain spaces, parentheses or colons. The empty ref is written `Block <empty>`
+// (or `Block <nil>`), it gives BlockRefEmpty.
+func NewBlockRefFromString(s string) (BlockRef, error) {
+	in := strings.TrimSpace(s)
+	if in == BlockRefEmpty.String() || in == "Block <nil>" {
+		return BlockRefEmpty, nil
+	}
+
+	var numPart, idPart string
+	switch {
+	case strings.HasPrefix(in, "#"):
+		var found bool
+		numPart, idPart, found = strings.Cut(in[1:], " (")
+		if !found || !strings.HasSuffix(idPart, ")") {
+			return nil, fmt.Errorf("invalid block ref %q: expected the form \"#<num> (<id>)\"", s)
+		}
+		idPart = strings.TrimSuffix(idPart, ")")
+	case strings.Contains(in, ":"):
+		numPart, idPart, _ = strings.Cut(in, ":")
+	default:
+		num, err := parseBlockRefNum(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block ref %q: %w", s, err)
+		}
+		return NewBlockRef("", num), nil
+	}
+
+	num, err := parseBlockRefNum(numPart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block ref %q: %w", s, err)
+	}
+	if idPart == "" {
+		return nil, fmt.Errorf("invalid block ref %q: empty block id, write a ref without id as its bare number", s)
+	}
+	if i := strings.IndexAny(idPart, " \t\r\n():"); i != -1 {
+		return nil, fmt.Errorf("invalid block ref %q: invalid character %q in block id", s, idPart[i])
+	}
+	return NewBlockRef(idPart, num), nil
+}
+
+func parseBlockRefNum(in string) (uint64, error) {
+	if in == "" {
+		return 0, fmt.Errorf("missing block number")
+	}
+	// with only digits, ParseUint can only fail on overflows
+	if strings.TrimLeft(in, "0123456789") != "" {
+		return 0, fmt.Errorf("block number %q is not a decimal number", in)
+	}
+	num, err := strconv.ParseUint(in, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("block number %q overflows uint64", in)
+	}
+	return num, nil
+}
+
 func (b BasicBlockRef) ID() string {
 	return b.id
 }
@@ -129,10 +189,13 @@ type wrappedObject struct {
 	obj                interface{}
 	cursor             *Cursor
 	reorgJunctionBlock BlockRef
+
+	// reversible is true for blocks read from one-block files, which have no cursor
+	reversible bool
 }
 
 func (w *wrappedObject) FinalBlockHeight() uint64 {
-	if w.cursor.LIB == nil {
+	if w.cursor == nil || w.cursor.LIB == nil {
 		return 0
 	}
 	return w.cursor.LIB.Num()
@@ -143,6 +206,13 @@ func (w *wrappedObject) ReorgJunctionBlock() BlockRef {
 }
 
 func (w *wrappedObject) Step() StepType {
+	if w.cursor == nil {
+		if w.reversible {
+			return StepNew
+		}
+		// blocks read from merged blocks files in reverse order have no cursor
+		return StepNewIrreversible
+	}
 	return w.cursor.Step
 }
 
@@ -150,6 +220,11 @@ func (w *wrappedObject) WrappedObject() interface{} {
 	return w.obj
 }
 
+// Cursor returns EmptyCursor for the blocks that have no cursor, like the ones
+// read in reverse order or from one-block files
 func (w *wrappedObject) Cursor() *Cursor {
+	if w.cursor == nil {
+		return EmptyCursor
+	}
 	return w.cursor
 }
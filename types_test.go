@@ -16,9 +16,11 @@ package bstream
 
 import (
 	"fmt"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBasicBlockRef(t *testing.T) {
@@ -61,3 +63,86 @@ func TestBasicBlockRefFromID(t *testing.T) {
 		})
 	}
 }
+
+func TestNewBlockRefFromString(t *testing.T) {
+	tests := []struct {
+		in          string
+		expected    BlockRef
+		expectedErr string
+	}{
+		{in: "#123 (abcdef)", expected: NewBlockRef("abcdef", 123)},
+		{in: "123:abcdef", expected: NewBlockRef("abcdef", 123)},
+		{in: "  123:abcdef\n", expected: NewBlockRef("abcdef", 123)},
+		{in: "123", expected: NewBlockRef("", 123)},
+		{in: "0", expected: NewBlockRef("", 0)},
+		{in: "#18446744073709551615 (ff)", expected: NewBlockRef("ff", 18446744073709551615)},
+		{in: "Block <empty>", expected: BlockRefEmpty},
+		{in: "Block <nil>", expected: BlockRefEmpty},
+
+		{in: "", expectedErr: "missing block number"},
+		{in: "#", expectedErr: `expected the form "#<num> (<id>)"`},
+		{in: "#123", expectedErr: `expected the form "#<num> (<id>)"`},
+		{in: "#123 (abc", expectedErr: `expected the form "#<num> (<id>)"`},
+		{in: "#123 ()", expectedErr: "empty block id"},
+		{in: "#123 (ab cd)", expectedErr: `invalid character ' ' in block id`},
+		{in: "#123 (ab)(cd)", expectedErr: `invalid character ')' in block id`},
+		{in: "# 123 (abcdef)", expectedErr: `block number " 123" is not a decimal number`},
+		{in: "123:", expectedErr: "empty block id"},
+		{in: ":abcdef", expectedErr: "missing block number"},
+		{in: "123:ab:cd", expectedErr: `invalid character ':' in block id`},
+		{in: "-1:abcdef", expectedErr: `block number "-1" is not a decimal number`},
+		{in: "+1", expectedErr: `block number "+1" is not a decimal number`},
+		{in: "1_000", expectedErr: `block number "1_000" is not a decimal number`},
+		{in: "0x10", expectedErr: `block number "0x10" is not a decimal number`},
+		{in: "18446744073709551616", expectedErr: `block number "18446744073709551616" overflows uint64`},
+		{in: "abcdef", expectedErr: `block number "abcdef" is not a decimal number`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			actual, err := NewBlockRefFromString(test.in)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), fmt.Sprintf("invalid block ref %q: ", test.in))
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, EqualsBlockRefs(test.expected, actual), "expected %s, got %s", test.expected, actual)
+		})
+	}
+}
+
+func TestNewBlockRefFromString_RoundTrip(t *testing.T) {
+	for _, ref := range []BlockRef{
+		NewBlockRef("abcdef", 123),
+		NewBlockRef("00000000a", 0),
+		NewBlockRef("ff", 18446744073709551615),
+		BlockRefEmpty,
+		TestBlockWithNumbers("0000000aa", "00000009a", 10, 9).AsRef(),
+	} {
+		parsed, err := NewBlockRefFromString(ref.String())
+		require.NoError(t, err, ref.String())
+		assert.Equal(t, ref.String(), parsed.String())
+		assert.True(t, EqualsBlockRefs(ref, parsed))
+	}
+}
+
+func FuzzNewBlockRefFromString(f *testing.F) {
+	for _, seed := range []string{"#123 (abcdef)", "123:abcdef", "123", "Block <empty>", "#123 ()", "18446744073709551616", "#1 (a) (b)", ":", "#"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		ref, err := NewBlockRefFromString(in)
+		if err != nil {
+			return
+		}
+		// a parsed ref is written in a form parsed back to the same ref
+		again, err := NewBlockRefFromString(ref.String())
+		if ref.ID() == "" {
+			again, err = NewBlockRefFromString(strconv.FormatUint(ref.Num(), 10))
+		}
+		require.NoError(t, err)
+		assert.True(t, EqualsBlockRefs(ref, again), "%q parsed as %s then %s", in, ref, again)
+	})
+}
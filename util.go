@@ -25,6 +25,15 @@ import (
 
 var ErrStopBlockReached = errors.New("stop block reached")
 
+// ErrEndOfStore is the error of a FileSource created with FileSourceWithTerminateOnEnd
+// once it sent the blocks of the last blocks archive of the store.
+var ErrEndOfStore = errors.New("end of store reached")
+
+// ErrSkipBlock can be returned by a Handler to signal that the block was skipped on
+// purpose. Components supporting it treat the block as successfully processed instead
+// of failing the stream, check it with `errors.Is`.
+var ErrSkipBlock = errors.New("skip block")
+
 // DoForProtocol extra the worker (a lambda) that will be invoked based on the
 // received `kind` parameter. If the mapping exists, the worker is invoked and
 // the error returned with the call. If the mapping does not exist, an error
@@ -24,20 +24,106 @@ import (
 	"github.com/streamingfast/dbin"
 )
 
+// BlockWriter writes the blocks of a blocks file in turn, Close completes the file
+// without closing the underlying writer.
+type BlockWriter interface {
+	Write(block *pbbstream.Block) error
+	Close() error
+}
+
+// BlockWriterFactory creates the BlockWriter encoding the content of a blocks file.
+type BlockWriterFactory interface {
+	New(writer io.Writer) (BlockWriter, error)
+}
+
+type BlockWriterFactoryFunc func(writer io.Writer) (BlockWriter, error)
+
+func (f BlockWriterFactoryFunc) New(writer io.Writer) (BlockWriter, error) {
+	return f(writer)
+}
+
+// DBinBlockWriterFactory creates DBinBlockWriter instances, writing uncompressed blocks files
+var DBinBlockWriterFactory BlockWriterFactory = BlockWriterFactoryFunc(func(writer io.Writer) (BlockWriter, error) {
+	return NewDBinBlockWriter(writer)
+})
+
+// IndexedDBinBlockWriterFactory creates the BlockWriter instances of NewDBinBlockWriterWithIndex
+var IndexedDBinBlockWriterFactory BlockWriterFactory = BlockWriterFactoryFunc(func(writer io.Writer) (BlockWriter, error) {
+	return NewDBinBlockWriterWithIndex(writer)
+})
+
+// CompressedBlockWriterFactory creates the BlockWriter instances of NewCompressedBlockWriter
+func CompressedBlockWriterFactory(compression BundleCompression, level int) BlockWriterFactory {
+	return BlockWriterFactoryFunc(func(writer io.Writer) (BlockWriter, error) {
+		return NewCompressedBlockWriter(writer, compression, level)
+	})
+}
+
 // DBinBlockWriter reads the dbin format where each element is assumed to be a `Block`.
 type DBinBlockWriter struct {
 	src              *dbin.Writer
 	hasWrittenHeader bool
+
+	// written counts the bytes written when the index footer is written, see
+	// NewDBinBlockWriterWithIndex
+	written *countingWriter
+	index   []blocksIndexEntry
+
+	// metadata writes the metadata of the blocks, see DBinBlockWriterWithMetadata
+	metadata bool
+	// checksum writes the checksum of the blocks, see DBinBlockWriterWithChecksum
+	checksum bool
+}
+
+type DBinBlockWriterOption func(*DBinBlockWriter)
+
+// DBinBlockWriterWithMetadata writes the metadata of the blocks (see
+// pbbstream.Block.SetMeta) with them, the readers set it back on the blocks
+// they read. Without it, the metadata of the blocks is not written.
+func DBinBlockWriterWithMetadata() DBinBlockWriterOption {
+	return func(w *DBinBlockWriter) {
+		w.metadata = true
+	}
+}
+
+// DBinBlockWriterWithChecksum ends the message of each block with the CRC32C of
+// the message (see ChecksumFieldNumber), which the readers verify: a block
+// corrupted in the file is reported with a ChecksumError instead of being read.
+func DBinBlockWriterWithChecksum() DBinBlockWriterOption {
+	return func(w *DBinBlockWriter) {
+		w.checksum = true
+	}
 }
 
 // NewDBinBlockWriter creates a new DBinBlockWriter that writes to 'dbin' format, the 'contentType'
 // must be 3 characters long perfectly, version should represent a version of the content.
-func NewDBinBlockWriter(writer io.Writer) (*DBinBlockWriter, error) {
+func NewDBinBlockWriter(writer io.Writer, options ...DBinBlockWriterOption) (*DBinBlockWriter, error) {
 	dbinWriter := dbin.NewWriter(writer)
 
-	return &DBinBlockWriter{
+	out := &DBinBlockWriter{
 		src: dbinWriter,
-	}, nil
+	}
+	for _, option := range options {
+		option(out)
+	}
+	return out, nil
+}
+
+// NewDBinBlockWriterWithIndex creates a DBinBlockWriter appending, on Close, an
+// index footer mapping the block numbers to the offset of their message, which
+// lets a SeekableBlockReader start at a given block. The footer follows an empty
+// message marking the end of the blocks, on which the readers of the previous
+// versions fail ("failed reading next dbin message") after the last block.
+func NewDBinBlockWriterWithIndex(writer io.Writer, options ...DBinBlockWriterOption) (*DBinBlockWriter, error) {
+	written := &countingWriter{Writer: writer}
+	out := &DBinBlockWriter{
+		src:     dbin.NewWriter(written),
+		written: written,
+	}
+	for _, option := range options {
+		option(out)
+	}
+	return out, nil
 }
 
 func (w *DBinBlockWriter) Write(block *pbbstream.Block) error {
@@ -53,6 +139,74 @@ func (w *DBinBlockWriter) Write(block *pbbstream.Block) error {
 	if err != nil {
 		return fmt.Errorf("unable to marshal proto block: %s", err)
 	}
+	if !w.metadata {
+		bytes = pbbstream.TrimMetadata(bytes)
+	}
+	if w.checksum {
+		bytes = appendChecksum(bytes)
+	}
 
+	if w.written != nil {
+		w.index = append(w.index, blocksIndexEntry{num: block.Number, offset: w.written.n})
+	}
 	return w.src.WriteMessage(bytes)
 }
+
+// Close writes the index footer of the writers created with an index, the other
+// writers do nothing, the blocks being written as they come
+func (w *DBinBlockWriter) Close() error {
+	if w.written == nil || !w.hasWrittenHeader {
+		return nil
+	}
+	if _, err := w.written.Write(encodeBlocksIndex(w.index)); err != nil {
+		return fmt.Errorf("unable to write blocks index: %s", err)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// CompressedBlockWriter is a DBinBlockWriter compressing the whole blocks file,
+// which the FileSource detects from its first bytes. Close must be called to
+// complete the file.
+type CompressedBlockWriter struct {
+	*DBinBlockWriter
+	compressor io.WriteCloser
+}
+
+// NewCompressedBlockWriter writes the blocks to `writer` compressed with
+// `compression`, gzip or zstd, at `level` in the levels of the codec (1-9 for
+// gzip, 1-22 for zstd), 0 being the default level of the codec.
+func NewCompressedBlockWriter(writer io.Writer, compression BundleCompression, level int, options ...DBinBlockWriterOption) (*CompressedBlockWriter, error) {
+	compressor, err := compressedWriter(writer, compression, level)
+	if err != nil {
+		return nil, err
+	}
+
+	dbinWriter, err := NewDBinBlockWriter(compressor, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompressedBlockWriter{
+		DBinBlockWriter: dbinWriter,
+		compressor:      compressor,
+	}, nil
+}
+
+// Close flushes the compressed blocks file, it does not close the underlying writer
+func (w *CompressedBlockWriter) Close() error {
+	if err := w.compressor.Close(); err != nil {
+		return fmt.Errorf("closing compressor: %w", err)
+	}
+	return nil
+}
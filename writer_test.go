@@ -20,7 +20,10 @@ import (
 	"time"
 
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -56,3 +59,78 @@ func TestBlockWriter(t *testing.T) {
 	AssertProtoEqual(t, blk1, readBlk1)
 
 }
+
+func TestBlockWriter_Metadata(t *testing.T) {
+	write := func(options ...DBinBlockWriterOption) []byte {
+		blk := TestBlockWithNumbers("00000002a", "00000001a", 2, 1)
+		blk.SetMeta("peer", "10.0.0.1")
+		blk.SetMeta("receipts", "verified")
+
+		buffer := &bytes.Buffer{}
+		writer, err := NewDBinBlockWriter(buffer, options...)
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(blk))
+		return buffer.Bytes()
+	}
+	read := func(factory BlockReaderFactory, content []byte) *pbbstream.Block {
+		reader, err := factory.New(bytes.NewReader(content))
+		require.NoError(t, err)
+		blk, err := reader.Read()
+		require.NoError(t, err)
+		return blk
+	}
+	expected := map[string]string{"peer": "10.0.0.1", "receipts": "verified"}
+
+	withMetadata := write(DBinBlockWriterWithMetadata())
+	for name, factory := range map[string]BlockReaderFactory{
+		"blocks":        DBinBlockReaderFactory,
+		"pooled blocks": PooledDBinBlockReaderFactory(NewBlockPool()),
+		"header only":   HeaderOnlyDBinBlockReaderFactory,
+	} {
+		blk := read(factory, withMetadata)
+		assert.Equal(t, expected, blk.Metadata(), name)
+		assert.Nil(t, blk.UnknownFields(), name)
+	}
+
+	blk := read(DBinBlockReaderFactory, write())
+	assert.Nil(t, blk.Metadata(), "not written by default")
+	withoutMetadata := read(DBinBlockReaderFactory, withMetadata)
+	withoutMetadata.ResetMeta()
+	AssertProtoEqual(t, withoutMetadata, blk)
+}
+
+func TestBlockWriter_UnknownFields(t *testing.T) {
+	var unknown []byte
+	unknown = protowire.AppendTag(unknown, 99, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "from a newer writer")
+
+	blk := TestBlockWithNumbers("00000002a", "00000001a", 2, 1)
+	encoded, err := proto.Marshal(blk)
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	writer, err := NewDBinBlockWriter(buffer)
+	require.NoError(t, err)
+	require.NoError(t, writer.src.WriteHeader(blk.Payload.TypeUrl))
+	require.NoError(t, writer.src.WriteMessage(append(encoded, unknown...)))
+	content := buffer.Bytes()
+
+	for name, factory := range map[string]BlockReaderFactory{
+		"blocks":        DBinBlockReaderFactory,
+		"pooled blocks": PooledDBinBlockReaderFactory(NewBlockPool()),
+	} {
+		reader, err := factory.New(bytes.NewReader(content))
+		require.NoError(t, err)
+		readBlk, err := reader.Read()
+		require.NoError(t, err)
+		assert.Equal(t, unknown, readBlk.UnknownFields(), name)
+
+		rewritten := &bytes.Buffer{}
+		writer, err := NewDBinBlockWriter(rewritten)
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(readBlk))
+		assert.True(t, bytes.Contains(rewritten.Bytes(), unknown), name)
+	}
+
+	assert.Nil(t, blk.UnknownFields())
+}